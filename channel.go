@@ -2,21 +2,32 @@ package EIM
 
 import (
 	"EIM/logger"
+	"EIM/metrics"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	frameIn  = metrics.FrameTotal.WithLabelValues(metrics.DirectionIn)
+	frameOut = metrics.FrameTotal.WithLabelValues(metrics.DirectionOut)
+)
+
 // ChannelImpl websocket的channel实现
 type ChannelImpl struct {
+	bytesIn  uint64 // 原子操作的字段放在最前面, 保证64位对齐
+	bytesOut uint64
 	sync.Mutex
 	id string
 	Conn
 	writechan chan []byte
+	wmu       sync.Mutex // writeloop与关闭连接时都会写帧, 避免帧交错
 	once      sync.Once
 	writewait time.Duration
 	readwait  time.Duration
 	closed    *Event
+	created   time.Time
 }
 
 // NewChannel 创建一个新channel
@@ -32,6 +43,7 @@ func NewChannel(id string, conn Conn) Channel {
 		writechan: make(chan []byte, 5),
 		writewait: time.Second * 10,
 		closed:    NewEvent(),
+		created:   time.Now(),
 	}
 
 	go func() {
@@ -55,6 +67,7 @@ func (ch *ChannelImpl) writeloop() error {
 			}
 			// 批量写
 			chanlen := len(ch.writechan)
+			metrics.WriteQueueDepth.Observe(float64(chanlen))
 			for i := 0; i < chanlen; i++ {
 				payload = <-ch.writechan
 				err = ch.WriteFrame(OpBinary, payload)
@@ -87,15 +100,31 @@ func (ch *ChannelImpl) Push(payload []byte) error {
 	return nil
 }
 
-// Close 关闭连接
+// Close 关闭连接, 同时关闭底层的Conn使Readloop退出
 func (ch *ChannelImpl) Close() error {
 	ch.once.Do(func() {
 		close(ch.writechan)
 		ch.closed.Fire()
+		_ = ch.Conn.Close()
 	})
 	return nil
 }
 
+// CloseWithReason 发送带原因码的OpClose帧后关闭连接, 实现ReasonCloser接口
+func (ch *ChannelImpl) CloseWithReason(code uint16, reason string) error {
+	_ = ch.WriteFrame(OpClose, CloseReason(code, reason))
+	return ch.Close()
+}
+
+// Stats 返回连接的统计信息
+func (ch *ChannelImpl) Stats() ChannelStats {
+	return ChannelStats{
+		ConnectedAt: ch.created,
+		BytesIn:     atomic.LoadUint64(&ch.bytesIn),
+		BytesOut:    atomic.LoadUint64(&ch.bytesOut),
+	}
+}
+
 // SetWriteWait 设置写超时
 func (ch *ChannelImpl) SetWriteWait(writewait time.Duration) {
 	if writewait == 0 {
@@ -114,7 +143,11 @@ func (ch *ChannelImpl) SetReadWait(readwait time.Duration) {
 
 // WriteFrame 重写Conn的WriteFrame方法(增加了重置写超时的逻辑)
 func (ch *ChannelImpl) WriteFrame(code OpCode, payload []byte) error {
+	ch.wmu.Lock()
+	defer ch.wmu.Unlock()
 	_ = ch.Conn.SetWriteDeadline(time.Now().Add(ch.writewait))
+	frameOut.Inc()
+	atomic.AddUint64(&ch.bytesOut, uint64(len(payload)))
 	return ch.Conn.WriteFrame(code, payload)
 }
 
@@ -133,8 +166,13 @@ func (ch *ChannelImpl) Readloop(lst MessageListener) error {
 
 		frame, err := ch.ReadFrame()
 		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) {
+				_ = ch.WriteFrame(OpClose, CloseReason(CloseMessageTooBig, err.Error()))
+			}
 			return err
 		}
+		frameIn.Inc()
+		atomic.AddUint64(&ch.bytesIn, uint64(len(frame.GetPayload())))
 		if frame.GetOpCode() == OpClose {
 			return errors.New("remote side close the channel")
 		}
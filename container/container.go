@@ -2,9 +2,12 @@ package container
 
 import (
 	"EIM"
+	"EIM/health"
 	"EIM/logger"
+	"EIM/metrics"
 	"EIM/naming"
 	"EIM/tcp"
+	"EIM/tracing"
 	"EIM/wire"
 	"EIM/wire/pkt"
 	"bytes"
@@ -18,6 +21,9 @@ import (
 	"sync/atomic"
 	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -46,6 +52,11 @@ type Container struct {
 	selector   Selector
 	dialer     EIM.Dialer
 	deps       map[string]struct{}
+	quit       context.CancelFunc
+	topics     EIM.Topics
+	registry   sync.WaitGroup // 注册与上报meta的协程, 注销前等待它们退出
+	report     func() map[string]string
+	interval   time.Duration
 }
 
 var log = logger.WithField("module", "container")
@@ -77,6 +88,26 @@ func Init(srv EIM.Server, deps ...string) error {
 	}
 	log.WithField("func", "Init").Infof("srv %s:%s - deps %v", srv.ServiceID(), srv.ServiceName(), deps)
 	c.srvClients = make(map[string]ClientMap, len(deps))
+	health.Add("container", Ready)
+	return nil
+}
+
+// Ready 检查容器是否就绪: server已开始监听, 并且每个依赖的服务至少有一个StateAdult的客户端
+func Ready(ctx context.Context) error {
+	if atomic.LoadUint32(&c.state) != stateStarted {
+		return errors.New("container is not started")
+	}
+	if !c.Srv.Listening() {
+		return errors.New("server is not listening")
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for dep := range c.deps {
+		clients, ok := c.srvClients[dep]
+		if !ok || len(clients.Services(KeyServiceState, StateAdult)) == 0 {
+			return fmt.Errorf("no available client of service %s", dep)
+		}
+	}
 	return nil
 }
 
@@ -88,10 +119,24 @@ func SetSelector(selector Selector) {
 	c.selector = selector
 }
 
+// SetTopics 设置网关上的主题订阅关系, 用于处理按主题推送的消息
+func SetTopics(topics EIM.Topics) {
+	c.topics = topics
+}
+
 func SetServiceNaming(nm naming.Naming) {
 	c.Naming = nm
 }
 
+// SetMetaReporter 注册成功后每隔interval把report返回的meta更新到注册信息中, 如网关的连接数, 退出时停止
+func SetMetaReporter(interval time.Duration, report func() map[string]string) {
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	c.interval = interval
+	c.report = report
+}
+
 // Start 启动容器
 func Start() error {
 	if c.Naming == nil {
@@ -117,12 +162,28 @@ func Start() error {
 			}
 		}(service)
 	}
-	// 3. 服务注册
+	// 3. 服务就绪后再注册
+	ctx, cancel := context.WithCancel(context.Background())
+	c.quit = cancel
 	if c.Srv.PublicAddress() != "" && c.Srv.PublicPort() != 0 {
-		err := c.Naming.Register(c.Srv)
-		if err != nil {
-			log.Errorln(err)
-		}
+		c.registry.Add(1)
+		go func() {
+			defer c.registry.Done()
+			err := health.WaitReady(ctx, time.Second)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			err = c.Naming.Register(c.Srv)
+			if err != nil {
+				log.Errorln(err)
+				return
+			}
+			log.Infof("service %s registered", c.Srv.ServiceID())
+			if c.report != nil {
+				reportMeta(ctx)
+			}
+		}()
 	}
 	// 4. 等待系统退出
 	c := make(chan os.Signal, 1)
@@ -140,6 +201,9 @@ func shutdown() error {
 		return errors.New("has closed")
 	}
 
+	c.quit()
+	// 等待注册与上报的协程退出, 避免注销之后又被注册回去
+	c.registry.Wait()
 	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
 	defer cancel()
 	// 1. 优雅退出服务器
@@ -160,10 +224,28 @@ func shutdown() error {
 	return nil
 }
 
+// reportMeta 定时更新注册信息中的meta, 直到ctx结束
+func reportMeta(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Naming.UpdateMeta(c.Srv.ServiceID(), c.report()); err != nil {
+			log.WithField("func", "reportMeta").Warn(err)
+		}
+	}
+}
+
 // ConnectToService 连接服务
 func ConnectToService(serviceName string) error {
 	clients := NewClients()
+	c.Lock()
 	c.srvClients[serviceName] = clients
+	c.Unlock()
 	// watch服务的新增
 	delay := time.Second * 10
 	err := c.Naming.Subscribe(serviceName, func(services []EIM.ServiceRegistration) {
@@ -279,8 +361,17 @@ func readLoop(cli EIM.Client) error {
 
 // Push 供上层业务调用, 用于将消息发送给网关
 func Push(server string, p *pkt.LogicPkt) error {
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), p), "container.push",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("dest_server", server), attribute.String("command", p.Command)))
+	tracing.Inject(ctx, p)
 	p.AddStringMeta(wire.MetaDestServer, server)
-	return c.Srv.Push(server, pkt.Marshal(p))
+	err := c.Srv.Push(server, pkt.Marshal(p))
+	if err != nil {
+		metrics.ContainerErrorTotal.WithLabelValues(server, metrics.OpPush).Inc()
+	}
+	tracing.EndSpan(span, err)
+	return err
 }
 
 // PushMessage 将从Client中收到的消息通过Server找到对应channel并发送到客户端
@@ -289,26 +380,96 @@ func PushMessage(p *pkt.LogicPkt) error {
 	if server != c.Srv.ServiceID() {
 		return fmt.Errorf("dest_server is incorrect, %s != %s", server, c.Srv.ServiceID())
 	}
-	channels, ok := p.GetMeta(wire.MetaDestChannels)
-	if !ok {
-		return fmt.Errorf("dest_channels is nil")
+	// 主题订阅的控制消息由网关自己处理
+	if p.Command == wire.CommandTopicSubscribe || p.Command == wire.CommandTopicUnsubscribe {
+		return handleTopic(p)
 	}
-
-	channelsIds := strings.Split(channels.(string), ",")
+	channelsIds, err := destChannels(p)
+	if err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), p), "container.push_message",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("command", p.Command), attribute.Int("channels", len(channelsIds))))
+	defer span.End()
 	p.DelMeta(wire.MetaDestServer)
 	p.DelMeta(wire.MetaDestChannels)
+	p.DelMeta(wire.MetaDestTopic)
+	p.DelMeta(wire.MetaExcludeChannel)
+	// trace上下文不下发给客户端
+	tracing.Strip(p)
 	payload := pkt.Marshal(p)
-	log.Debugf("Push to %v %v", channelsIds, p)
+	log.WithContext(ctx).Debugf("Push to %v %v", channelsIds, p)
 
 	for _, channel := range channelsIds {
 		err := c.Srv.Push(channel, payload)
 		if err != nil {
+			metrics.ContainerErrorTotal.WithLabelValues(c.Srv.ServiceName(), metrics.OpPushMessage).Inc()
 			log.Debug(err)
 		}
 	}
 	return nil
 }
 
+// destChannels 根据dest.channels或dest.topic得到消息的接收方
+func destChannels(p *pkt.LogicPkt) ([]string, error) {
+	if topic, ok := p.GetMeta(wire.MetaDestTopic); ok {
+		if c.topics == nil {
+			return nil, fmt.Errorf("topics is nil")
+		}
+		exclude, _ := p.GetMeta(wire.MetaExcludeChannel)
+		ids := c.topics.Channels(topic.(string))
+		for i, id := range ids {
+			if id == exclude {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		return ids, nil
+	}
+	channels, ok := p.GetMeta(wire.MetaDestChannels)
+	if !ok {
+		return nil, fmt.Errorf("dest_channels is nil")
+	}
+	if channels == wire.DestAllChannels {
+		return ChannelIDs(), nil
+	}
+	return strings.Split(channels.(string), ","), nil
+}
+
+// handleTopic 处理主题的订阅与取消订阅
+func handleTopic(p *pkt.LogicPkt) error {
+	if c.topics == nil {
+		return fmt.Errorf("topics is nil")
+	}
+	var req pkt.TopicReq
+	if err := p.ReadBody(&req); err != nil {
+		return err
+	}
+	for _, topic := range req.Topics {
+		if p.Command == wire.CommandTopicSubscribe {
+			c.topics.Subscribe(topic, req.ChannelIds...)
+		} else {
+			c.topics.Unsubscribe(topic, req.ChannelIds...)
+		}
+	}
+	return nil
+}
+
+// ChannelIDs 返回本服务上所有channel的id, 要求Server同时实现了ChannelMap
+func ChannelIDs() []string {
+	cm, ok := c.Srv.(EIM.ChannelMap)
+	if !ok {
+		return nil
+	}
+	all := cm.All()
+	ids := make([]string, len(all))
+	for i, ch := range all {
+		ids[i] = ch.ID()
+	}
+	return ids
+}
+
 // Forward 消息上行, 下游服务发送消息到上游服务
 func Forward(serviceName string, p *pkt.LogicPkt) error {
 	if p == nil {
@@ -324,21 +485,35 @@ func Forward(serviceName string, p *pkt.LogicPkt) error {
 }
 
 // ForwardWithSelector 可以指定一个Selector来推送消息到服务的指定节点
-func ForwardWithSelector(serviceName string, p *pkt.LogicPkt, selector Selector) error {
+func ForwardWithSelector(serviceName string, p *pkt.LogicPkt, selector Selector) (err error) {
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), p), "container.forward",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("service", serviceName), attribute.String("command", p.Command)))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
 	cli, err := lookup(serviceName, &p.Header, selector)
 	if err != nil {
+		metrics.ContainerErrorTotal.WithLabelValues(serviceName, metrics.OpForward).Inc()
 		return err
 	}
+	tracing.Inject(ctx, p)
 	// 加一个tag到packet中
 	p.AddStringMeta(wire.MetaDestServer, c.Srv.ServiceID())
-	log.Debugf("forward message to %v with %s", cli.ServiceID(), &p.Header)
+	log.WithContext(ctx).Debugf("forward message to %v with %s", cli.ServiceID(), &p.Header)
 
-	return cli.Send(pkt.Marshal(p))
+	err = cli.Send(pkt.Marshal(p))
+	if err != nil {
+		metrics.ContainerErrorTotal.WithLabelValues(serviceName, metrics.OpForward).Inc()
+	}
+	return err
 }
 
 // lookup 根据服务名查找一个可靠服务
 func lookup(serviceName string, header *pkt.Header, selector Selector) (EIM.Client, error) {
+	c.RLock()
 	clients, ok := c.srvClients[serviceName]
+	c.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("service %s not found", serviceName)
 	}
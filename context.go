@@ -2,8 +2,10 @@ package EIM
 
 import (
 	"EIM/logger"
+	"EIM/tracing"
 	"EIM/wire"
 	"EIM/wire/pkt"
+	"context"
 	"sync"
 
 	"google.golang.org/protobuf/proto"
@@ -17,6 +19,7 @@ type Session interface {
 	GetRemoteIP() string
 	GetApp() string
 	GetTags() []string
+	GetDevice() string
 }
 
 // Context 上下文
@@ -25,10 +28,14 @@ type Context interface {
 	SessionStorage
 	Header() *pkt.Header
 	Session() Session
+	Context() context.Context // 携带当前消息的trace上下文
 	ReadBody(val proto.Message) error
 	Resp(status pkt.Status, body proto.Message) error // 给消息发送方返回一条消息
 	RespWithError(status pkt.Status, err error) error
-	Dispatch(body proto.Message, recvs ...*Location) error
+	Dispatch(body proto.Message, recvs ...*Location) (*DispatchResult, error)
+	DispatchTopic(topic string, body proto.Message) (*DispatchResult, error) // 由网关推送给订阅了topic的channel
+	Subscribe(topics []string, locs ...*Location) error
+	Unsubscribe(topics []string, locs ...*Location) error
 	Next()
 }
 
@@ -46,6 +53,7 @@ type ContextImpl struct {
 	index    int
 	request  *pkt.LogicPkt
 	session  Session
+	ctx      context.Context
 }
 
 // BuildContext 返回一个ContextImp
@@ -83,6 +91,13 @@ func (c *ContextImpl) Session() Session {
 	return c.session
 }
 
+func (c *ContextImpl) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *ContextImpl) ReadBody(val proto.Message) error {
 	return c.request.ReadBody(val)
 }
@@ -93,11 +108,13 @@ func (c *ContextImpl) Resp(status pkt.Status, body proto.Message) error {
 	packet.Status = status
 	packet.WriteBody(body)
 	packet.Flag = pkt.Flag_Response
-	logger.Debugf("<-- Resp to %s command:%s  status: %v body: %s",
+	tracing.Inject(c.Context(), packet)
+	log := logger.WithContext(c.Context())
+	log.Debugf("<-- Resp to %s command:%s  status: %v body: %s",
 		c.Session().GetAccount(), &c.request.Header, status, body)
 	err := c.Push(c.Session().GetGateId(), []string{c.Session().GetChannelId()}, packet)
 	if err != nil {
-		logger.Error(err)
+		log.Error(err)
 	}
 	return err
 }
@@ -106,34 +123,146 @@ func (c *ContextImpl) RespWithError(status pkt.Status, err error) error {
 	return c.Resp(status, &pkt.ErrorResp{Message: err.Error()})
 }
 
-// Dispatch 消息转发
-func (c *ContextImpl) Dispatch(body proto.Message, recvs ...*Location) error {
+// Dispatch 消息转发, 按网关分组后并发推送, 返回的error为DispatchResult.Err()
+func (c *ContextImpl) Dispatch(body proto.Message, recvs ...*Location) (*DispatchResult, error) {
+	result := &DispatchResult{}
 	if len(recvs) == 0 {
-		return nil
+		return result, nil
 	}
-	packet := pkt.NewForm(&c.request.Header)
-	packet.Flag = pkt.Flag_Push
-	packet.WriteBody(body)
-	logger.Debugf("<-- Dispatch to %d users command:%s", len(recvs), &c.request.Header)
+	log := logger.WithContext(c.Context())
+	log.Debugf("<-- Dispatch to %d users command:%s", len(recvs), &c.request.Header)
 
-	group := make(map[string][]string)
+	group := make(map[string][]*Location)
 	for _, recv := range recvs {
 		if recv.ChannelId == c.request.GetChannelId() {
 			continue
 		}
-		if _, ok := group[recv.GateId]; !ok {
-			group[recv.GateId] = make([]string, 0)
+		group[recv.GateId] = append(group[recv.GateId], recv)
+		result.Total++
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for gateway, locs := range group {
+		// 每个分片使用单独的消息包, Push时会向其中追加meta
+		for start := 0; start < len(locs); start += DispatchChunkSize {
+			end := start + DispatchChunkSize
+			if end > len(locs) {
+				end = len(locs)
+			}
+			wg.Add(1)
+			go func(gateway string, locs []*Location) {
+				defer wg.Done()
+				ids := make([]string, len(locs))
+				for i, loc := range locs {
+					ids[i] = loc.ChannelId
+				}
+				err := c.Push(gateway, ids, c.newPushPacket(body))
+				if err == nil {
+					return
+				}
+				log.WithField("gateway", gateway).Error(err)
+				lock.Lock()
+				defer lock.Unlock()
+				if result.Errors == nil {
+					result.Errors = make(map[string]error)
+				}
+				result.Errors[gateway] = err
+				result.Failed = append(result.Failed, locs...)
+			}(gateway, locs[start:end])
 		}
-		group[recv.GateId] = append(group[recv.GateId], recv.ChannelId)
 	}
-	for gateway, ids := range group {
-		err := c.Push(gateway, ids, packet)
-		if err != nil {
-			logger.Error(err)
+	wg.Wait()
+	return result, result.Err()
+}
+
+// DispatchTopic 给每个网关推送一个消息包, 由网关推送给本地订阅了topic的channel, 消息发送方除外
+func (c *ContextImpl) DispatchTopic(topic string, body proto.Message) (*DispatchResult, error) {
+	td, ok := c.Dispatcher.(TopicDispatcher)
+	if !ok {
+		return nil, ErrTopicNotSupported
+	}
+	log := logger.WithContext(c.Context())
+	log.Debugf("<-- Dispatch to topic %s command:%s", topic, &c.request.Header)
+
+	gateways := td.Gateways()
+	result := &DispatchResult{Total: len(gateways)}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, gateway := range gateways {
+		wg.Add(1)
+		go func(gateway string) {
+			defer wg.Done()
+			packet := c.newPushPacket(body)
+			packet.AddStringMeta(wire.MetaExcludeChannel, c.request.GetChannelId())
+			err := td.PushTopic(gateway, topic, packet)
+			if err == nil {
+				return
+			}
+			log.WithField("gateway", gateway).Error(err)
+			lock.Lock()
+			defer lock.Unlock()
+			if result.Errors == nil {
+				result.Errors = make(map[string]error)
+			}
+			result.Errors[gateway] = err
+			result.Failed = append(result.Failed, &Location{GateId: gateway})
+		}(gateway)
+	}
+	wg.Wait()
+	return result, result.Err()
+}
+
+// Subscribe 将locs对应的channel订阅到topics
+func (c *ContextImpl) Subscribe(topics []string, locs ...*Location) error {
+	td, ok := c.Dispatcher.(TopicDispatcher)
+	if !ok {
+		return ErrTopicNotSupported
+	}
+	return c.eachGateway(locs, func(gateway string, channels []string) error {
+		return td.Subscribe(gateway, topics, channels)
+	})
+}
+
+// Unsubscribe 取消locs对应的channel对topics的订阅
+func (c *ContextImpl) Unsubscribe(topics []string, locs ...*Location) error {
+	td, ok := c.Dispatcher.(TopicDispatcher)
+	if !ok {
+		return ErrTopicNotSupported
+	}
+	return c.eachGateway(locs, func(gateway string, channels []string) error {
+		return td.Unsubscribe(gateway, topics, channels)
+	})
+}
+
+// eachGateway 按网关对locs分组后调用fn, 返回最后一个错误
+func (c *ContextImpl) eachGateway(locs []*Location, fn func(gateway string, channels []string) error) error {
+	group := make(map[string][]string)
+	for _, loc := range locs {
+		if loc == nil {
+			continue
 		}
-		return err
+		group[loc.GateId] = append(group[loc.GateId], loc.ChannelId)
 	}
-	return nil
+	var err error
+	for gateway, channels := range group {
+		if e := fn(gateway, channels); e != nil {
+			logger.WithContext(c.Context()).WithField("gateway", gateway).Warn(e)
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *ContextImpl) newPushPacket(body proto.Message) *pkt.LogicPkt {
+	packet := pkt.NewForm(&c.request.Header)
+	packet.Flag = pkt.Flag_Push
+	packet.WriteBody(body)
+	tracing.Inject(c.Context(), packet)
+	return packet
 }
 
 func (c *ContextImpl) reset() {
@@ -141,4 +270,5 @@ func (c *ContextImpl) reset() {
 	c.index = 0
 	c.request = nil
 	c.session = nil
+	c.ctx = nil
 }
@@ -0,0 +1,236 @@
+package EIM
+
+import (
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDispatcher 记录每个网关收到的channel, failGateways中的网关推送失败
+type fakeDispatcher struct {
+	sync.Mutex
+	failGateways map[string]bool
+	pushed       map[string][]string
+	packets      int
+}
+
+func (d *fakeDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.failGateways[gateway] {
+		return errors.New("connection refused")
+	}
+	if d.pushed == nil {
+		d.pushed = make(map[string][]string)
+	}
+	d.pushed[gateway] = append(d.pushed[gateway], channels...)
+	d.packets++
+	return nil
+}
+
+func newDispatchContext(d Dispatcher) *ContextImpl {
+	return &ContextImpl{
+		Dispatcher: d,
+		request:    pkt.New(wire.CommandChatGroupTalk, pkt.WithChannelId("gate1_sender")),
+	}
+}
+
+func locations(gateway string, n int) []*Location {
+	locs := make([]*Location, n)
+	for i := range locs {
+		locs[i] = &Location{ChannelId: fmt.Sprintf("%s_%d", gateway, i), GateId: gateway}
+	}
+	return locs
+}
+
+func TestDispatchAllGateways(t *testing.T) {
+	d := &fakeDispatcher{}
+	ctx := newDispatchContext(d)
+	recvs := append(locations("gate1", 3), locations("gate2", 2)...)
+	recvs = append(recvs, &Location{ChannelId: "gate1_sender", GateId: "gate1"})
+
+	result, err := ctx.Dispatch(&pkt.MessagePush{Body: "hello"}, recvs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 5 || len(result.Failed) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(d.pushed["gate1"]) != 3 || len(d.pushed["gate2"]) != 2 {
+		t.Fatalf("every gateway should receive the message, got %v", d.pushed)
+	}
+}
+
+func TestDispatchChunk(t *testing.T) {
+	size := DispatchChunkSize
+	DispatchChunkSize = 10
+	defer func() { DispatchChunkSize = size }()
+
+	d := &fakeDispatcher{}
+	ctx := newDispatchContext(d)
+	_, err := ctx.Dispatch(&pkt.MessagePush{Body: "hello"}, locations("gate1", 25)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.packets != 3 || len(d.pushed["gate1"]) != 25 {
+		t.Fatalf("packets = %d, channels = %d, want 3 packets and 25 channels", d.packets, len(d.pushed["gate1"]))
+	}
+}
+
+func TestDispatchFailed(t *testing.T) {
+	d := &fakeDispatcher{failGateways: map[string]bool{"gate2": true}}
+	ctx := newDispatchContext(d)
+	result, err := ctx.Dispatch(&pkt.MessagePush{Body: "hello"}, append(locations("gate1", 2), locations("gate2", 3)...)...)
+	if err == nil || !strings.Contains(err.Error(), "gate2") {
+		t.Fatalf("err = %v, want an error of gate2", err)
+	}
+	if len(result.Failed) != 3 || result.Errors["gate2"] == nil || len(result.Errors) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for _, loc := range result.Failed {
+		if loc.GateId != "gate2" {
+			t.Fatalf("unexpected failed location %v", loc)
+		}
+	}
+	if len(d.pushed["gate1"]) != 2 {
+		t.Fatalf("gate1 should still receive the message, got %v", d.pushed)
+	}
+}
+
+// fakeTopicDispatcher 模拟网关本地的主题订阅, 按订阅关系扇出
+type fakeTopicDispatcher struct {
+	fakeDispatcher
+	topics map[string]*TopicsImpl // gateway -> topics
+}
+
+func newFakeTopicDispatcher(topic string, locs []*Location) *fakeTopicDispatcher {
+	d := &fakeTopicDispatcher{topics: make(map[string]*TopicsImpl)}
+	for _, loc := range locs {
+		if d.topics[loc.GateId] == nil {
+			d.topics[loc.GateId] = NewTopics()
+		}
+		d.topics[loc.GateId].Subscribe(topic, loc.ChannelId)
+	}
+	return d
+}
+
+func (d *fakeTopicDispatcher) Gateways() []string {
+	gateways := make([]string, 0, len(d.topics))
+	for gateway := range d.topics {
+		gateways = append(gateways, gateway)
+	}
+	return gateways
+}
+
+func (d *fakeTopicDispatcher) PushTopic(gateway, topic string, p *pkt.LogicPkt) error {
+	exclude, _ := p.GetMeta(wire.MetaExcludeChannel)
+	var channels []string
+	for _, id := range d.topics[gateway].Channels(topic) {
+		if id != exclude {
+			channels = append(channels, id)
+		}
+	}
+	return d.Push(gateway, channels, p)
+}
+
+func (d *fakeTopicDispatcher) Subscribe(gateway string, topics []string, channels []string) error {
+	for _, topic := range topics {
+		d.topics[gateway].Subscribe(topic, channels...)
+	}
+	return nil
+}
+
+func (d *fakeTopicDispatcher) Unsubscribe(gateway string, topics []string, channels []string) error {
+	for _, topic := range topics {
+		d.topics[gateway].Unsubscribe(topic, channels...)
+	}
+	return nil
+}
+
+func TestDispatchTopic(t *testing.T) {
+	recvs := append(locations("gate1", 3), locations("gate2", 2)...)
+	recvs = append(recvs, &Location{ChannelId: "gate1_sender", GateId: "gate1"})
+	d := newFakeTopicDispatcher("group1", recvs)
+	ctx := newDispatchContext(d)
+
+	result, err := ctx.DispatchTopic("group1", &pkt.MessagePush{Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || d.packets != 2 {
+		t.Fatalf("one packet per gateway, got result %+v and %d packets", result, d.packets)
+	}
+	if len(d.pushed["gate1"]) != 3 || len(d.pushed["gate2"]) != 2 {
+		t.Fatalf("sender should be excluded, got %v", d.pushed)
+	}
+
+	err = ctx.Unsubscribe([]string{"group1"}, recvs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.pushed = nil
+	_, _ = ctx.DispatchTopic("group1", &pkt.MessagePush{Body: "hello"})
+	if len(d.pushed["gate1"]) != 2 {
+		t.Fatalf("unsubscribed channel should not receive the message, got %v", d.pushed)
+	}
+}
+
+func TestDispatchTopicNotSupported(t *testing.T) {
+	ctx := newDispatchContext(&fakeDispatcher{})
+	if _, err := ctx.DispatchTopic("group1", &pkt.MessagePush{}); err != ErrTopicNotSupported {
+		t.Fatalf("err = %v, want ErrTopicNotSupported", err)
+	}
+}
+
+// marshalDispatcher 模拟ServerDispatcher, 把接收方写入Meta后序列化
+type marshalDispatcher struct {
+	*fakeTopicDispatcher
+}
+
+func (d marshalDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	p.AddStringMeta(wire.MetaDestChannels, strings.Join(channels, ","))
+	_ = pkt.Marshal(p)
+	return nil
+}
+
+func (d marshalDispatcher) PushTopic(gateway, topic string, p *pkt.LogicPkt) error {
+	p.AddStringMeta(wire.MetaDestTopic, topic)
+	_ = pkt.Marshal(p)
+	// 网关本地查找订阅的channel
+	_ = d.topics[gateway].Channels(topic)
+	return nil
+}
+
+func benchLocations(gateways, members int) []*Location {
+	var locs []*Location
+	for i := 0; i < gateways; i++ {
+		locs = append(locs, locations(fmt.Sprintf("gate%d", i), members/gateways)...)
+	}
+	return locs
+}
+
+// BenchmarkDispatchGroup 原有方式: 服务端带上所有成员的channel推送
+func BenchmarkDispatchGroup(b *testing.B) {
+	locs := benchLocations(4, 10000)
+	ctx := newDispatchContext(marshalDispatcher{newFakeTopicDispatcher("group1", locs)})
+	body := &pkt.MessagePush{Body: "hello"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ctx.Dispatch(body, locs...)
+	}
+}
+
+// BenchmarkDispatchTopic 每个网关一个消息包, 由网关根据订阅关系扇出
+func BenchmarkDispatchTopic(b *testing.B) {
+	locs := benchLocations(4, 10000)
+	ctx := newDispatchContext(marshalDispatcher{newFakeTopicDispatcher("group1", locs)})
+	body := &pkt.MessagePush{Body: "hello"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ctx.DispatchTopic("group1", body)
+	}
+}
@@ -1,8 +1,53 @@
 package EIM
 
-import "EIM/wire/pkt"
+import (
+	"EIM/wire/pkt"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DispatchChunkSize 单个消息包中最多携带的channel数量, 超过时拆分成多个消息包推送给同一个网关
+var DispatchChunkSize = 1000
 
 // Dispatcher 消息分发器, 向网关gateway中的channels两个连接推送一条消息LogicPkt消息
 type Dispatcher interface {
 	Push(gateway string, channels []string, pkt *pkt.LogicPkt) error
 }
+
+// TopicDispatcher 支持按主题推送的分发器, 网关根据本地的订阅关系把消息推送给订阅了主题的channel
+type TopicDispatcher interface {
+	Dispatcher
+	Gateways() []string                                       // 当前连接的所有网关
+	PushTopic(gateway, topic string, pkt *pkt.LogicPkt) error // 推送给网关上订阅了topic的channel
+	Subscribe(gateway string, topics []string, channels []string) error
+	Unsubscribe(gateway string, topics []string, channels []string) error
+}
+
+// ErrTopicNotSupported 分发器没有实现TopicDispatcher
+var ErrTopicNotSupported = errors.New("dispatcher does not support topics")
+
+// DispatchResult 消息分发的结果
+type DispatchResult struct {
+	Total  int              // 需要推送的位置数量
+	Failed []*Location      // 推送失败的位置
+	Errors map[string]error // 推送失败的网关及其错误
+}
+
+// Err 汇总各个网关的错误, 全部成功时返回nil
+func (r *DispatchResult) Err() error {
+	if r == nil || len(r.Errors) == 0 {
+		return nil
+	}
+	gateways := make([]string, 0, len(r.Errors))
+	for gateway := range r.Errors {
+		gateways = append(gateways, gateway)
+	}
+	sort.Strings(gateways)
+	msgs := make([]string, len(gateways))
+	for i, gateway := range gateways {
+		msgs[i] = fmt.Sprintf("%s: %v", gateway, r.Errors[gateway])
+	}
+	return fmt.Errorf("dispatch %d/%d locations failed, %s", len(r.Failed), r.Total, strings.Join(msgs, "; "))
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"EIM/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultCheckTimeout 单次就绪检查的超时时间
+const DefaultCheckTimeout = time.Second * 3
+
+// Checker 检查一个依赖的状态, 返回nil表示正常
+type Checker func(ctx context.Context) error
+
+// Health 管理一组就绪检查
+type Health struct {
+	sync.RWMutex
+	names  []string
+	checks map[string]Checker
+}
+
+// Result 就绪检查的结果
+type Result struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// New 创建一个Health
+func New() *Health {
+	return &Health{
+		checks: make(map[string]Checker),
+	}
+}
+
+// 默认单例
+var h = New()
+
+// Default 返回默认的Health
+func Default() *Health {
+	return h
+}
+
+// Add 添加一个就绪检查, 同名的检查会被替换
+func (h *Health) Add(name string, check Checker) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Check 执行所有就绪检查, 任意一项失败时返回error
+func (h *Health) Check(ctx context.Context) (*Result, error) {
+	h.RLock()
+	names := make([]string, len(h.names))
+	copy(names, h.names)
+	checks := make(map[string]Checker, len(h.checks))
+	for k, v := range h.checks {
+		checks[k] = v
+	}
+	h.RUnlock()
+
+	result := &Result{Status: "ok", Checks: make(map[string]string, len(names))}
+	var failed error
+	for _, name := range names {
+		err := checks[name](ctx)
+		if err != nil {
+			result.Status = "unavailable"
+			result.Checks[name] = err.Error()
+			if failed == nil {
+				failed = fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+		result.Checks[name] = "ok"
+	}
+	return result, failed
+}
+
+// WaitReady 阻塞直到所有就绪检查通过或ctx结束
+func (h *Health) WaitReady(ctx context.Context, interval time.Duration) error {
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, DefaultCheckTimeout)
+		_, err := h.Check(checkCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %w", ctx.Err(), err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// LiveHandler 存活检查, 进程能响应请求即返回ok
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// ReadyHandler 就绪检查, 所有检查通过时返回200, 否则返回503
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), DefaultCheckTimeout)
+		defer cancel()
+		result, err := h.Check(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
+
+// Add 向默认的Health中添加一个就绪检查
+func Add(name string, check Checker) {
+	h.Add(name, check)
+}
+
+// WaitReady 等待默认的Health中所有检查通过
+func WaitReady(ctx context.Context, interval time.Duration) error {
+	return h.WaitReady(ctx, interval)
+}
+
+// Serve 在监控端口上提供/health(存活), /ready(就绪)与/metrics
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/health", h.LiveHandler())
+	mux.Handle("/ready", h.ReadyHandler())
+	mux.Handle("/metrics", metrics.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	h := New()
+	h.Add("redis", func(ctx context.Context) error { return nil })
+	h.Add("db", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	rec := httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+
+	h.Add("db", func(ctx context.Context) error { return nil })
+	rec = httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	h := New()
+	ready := time.Now().Add(time.Millisecond * 50)
+	h.Add("slow", func(ctx context.Context) error {
+		if time.Now().Before(ready) {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+	if err := h.WaitReady(context.Background(), time.Millisecond*10); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Add("never", func(ctx context.Context) error { return errors.New("not ready") })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := h.WaitReady(ctx, time.Millisecond*10); err == nil {
+		t.Fatal("WaitReady() expected error")
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "eim"
+
+// 帧的方向
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// 群缓存的查询结果
+const (
+	CacheLocal = "local"
+	CacheRedis = "redis"
+	CacheMiss  = "miss"
+)
+
+// 容器中出错的操作
+const (
+	OpForward     = "forward"
+	OpPush        = "push"
+	OpPushMessage = "push_message"
+)
+
+var (
+	// ChannelTotalGauge 每个服务ChannelMap中的连接数
+	ChannelTotalGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_total",
+		Help:      "number of channels in the ChannelMap of a server",
+	}, []string{"service_id", "service_name"})
+
+	// AcceptDuration 握手(Accept)的耗时
+	AcceptDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "accept_duration_seconds",
+		Help:      "time spent on accepting a connection, including login",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service_name", "result"})
+
+	// FrameTotal 读写的帧数
+	FrameTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frame_total",
+		Help:      "number of frames read from or written to channels",
+	}, []string{"direction"})
+
+	// WriteQueueDepth channel写队列中等待发送的消息数
+	WriteQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "channel_write_queue_depth",
+		Help:      "number of payloads waiting in the write queue of a channel",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50},
+	})
+
+	// HandlerDuration Router中每个指令的处理耗时
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "time spent on handling a command in the router",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	// ContainerErrorTotal 容器Forward/Push的错误数
+	ContainerErrorTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "container_error_total",
+		Help:      "number of errors of container forward and push",
+	}, []string{"service", "op"})
+
+	// DBDuration 数据库操作的耗时
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_duration_seconds",
+		Help:      "time spent on database operations",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"db", "operation"})
+
+	// GroupCacheTotal 群缓存的查询次数, result为local, redis或miss
+	GroupCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "group_cache_total",
+		Help:      "number of group cache lookups by result",
+	}, []string{"kind", "result"})
+)
+
+// Handler 返回Prometheus文本格式的http.Handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveAccept 记录一次握手的耗时与结果
+func ObserveAccept(serviceName string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	AcceptDuration.WithLabelValues(serviceName, result).Observe(time.Since(start).Seconds())
+}
@@ -14,6 +14,7 @@ import (
 const (
 	KeyProtocol  = "protocol"
 	KeyHealthURL = "health_url"
+	KeyLoad      = "load" // 网关当前的连接数
 )
 
 type Watch struct {
@@ -60,7 +61,8 @@ func (n *Naming) Register(service EIM.ServiceRegistration) error {
 	}
 	reg.Meta[KeyProtocol] = service.GetProtocol()
 
-	// 健康检查
+	// 健康检查, 指向/ready, 未就绪或退出中的服务不会被发现;
+	// 依赖短暂不可用时服务也会处于critical, 注销的等待时间要比较长, 否则恢复后不会重新注册
 	healthURL := service.GetMeta()[KeyHealthURL]
 	if healthURL != "" {
 		check := new(api.AgentServiceCheck)
@@ -68,7 +70,7 @@ func (n *Naming) Register(service EIM.ServiceRegistration) error {
 		check.HTTP = healthURL
 		check.Timeout = "1s"
 		check.Interval = "10s"
-		check.DeregisterCriticalServiceAfter = "20s"
+		check.DeregisterCriticalServiceAfter = "10m"
 		reg.Check = check
 	}
 	return n.cli.Agent().ServiceRegister(reg)
@@ -79,6 +81,34 @@ func (n *Naming) Deregister(serviceID string) error {
 	return n.cli.Agent().ServiceDeregister(serviceID)
 }
 
+// UpdateMeta 读取本地agent上的注册信息, 合并meta后重新提交, 已有的健康检查保持不变;
+// 服务已经注销时返回naming.ErrNotFound, 避免把退出中的服务重新注册回去
+func (n *Naming) UpdateMeta(serviceID string, meta map[string]string) error {
+	services, err := n.cli.Agent().Services()
+	if err != nil {
+		return err
+	}
+	svc, ok := services[serviceID]
+	if !ok {
+		return naming.ErrNotFound
+	}
+	merged := make(map[string]string, len(svc.Meta)+len(meta))
+	for k, v := range svc.Meta {
+		merged[k] = v
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	return n.cli.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:      svc.ID,
+		Name:    svc.Service,
+		Address: svc.Address,
+		Port:    svc.Port,
+		Tags:    svc.Tags,
+		Meta:    merged,
+	})
+}
+
 // Find 服务发现, 可添加tags
 func (n *Naming) Find(serviceName string, tags ...string) ([]EIM.ServiceRegistration, error) {
 	services, _, err := n.load(serviceName, 0, tags...)
@@ -150,7 +180,7 @@ func (n *Naming) watch(w *Watch) {
 		select {
 		case <-w.Quit:
 			stopped = true
-			logger.Info("watch %s stopped", w.Service)
+			logger.Infof("watch %s stopped", w.Service)
 			return
 		default:
 
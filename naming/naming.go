@@ -3,6 +3,7 @@ package naming
 import (
 	"EIM"
 	"errors"
+	"strings"
 )
 
 // errors
@@ -10,6 +11,22 @@ var (
 	ErrNotFound = errors.New("service not found")
 )
 
+// 服务注册时表示所在区域与可用区的标签前缀, 如region:cn-south
+const (
+	TagRegion = "region:"
+	TagZone   = "zone:"
+)
+
+// TagValue 返回tags中以prefix开头的标签的值
+func TagValue(tags []string, prefix string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			return strings.TrimPrefix(tag, prefix)
+		}
+	}
+	return ""
+}
+
 // Naming 接口 定义关于naming服务的方法
 type Naming interface {
 	Find(serviceName string, tags ...string) ([]EIM.ServiceRegistration, error)
@@ -17,4 +34,6 @@ type Naming interface {
 	Unsubscribe(serviceName string) error
 	Register(service EIM.ServiceRegistration) error
 	Deregister(serviceID string) error
+	// UpdateMeta 只修改已注册服务meta中的这些key, 服务没有注册时返回ErrNotFound, 不会重新注册
+	UpdateMeta(serviceID string, meta map[string]string) error
 }
@@ -1,9 +1,16 @@
 package EIM
 
 import (
+	"EIM/metrics"
+	"EIM/tracing"
 	"EIM/wire/pkt"
+	"context"
 	"fmt"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // FuncTree HandlerFun的树结构
@@ -66,6 +73,7 @@ func (r *Router) Serve(packet *pkt.LogicPkt, dispatcher Dispatcher, cache Sessio
 	ctx.Dispatcher = dispatcher
 	ctx.SessionStorage = cache
 	ctx.session = session
+	ctx.ctx = tracing.Extract(context.Background(), packet)
 	r.serveContext(ctx)
 	// ctx使用后放回对象池中
 	r.pool.Put(ctx)
@@ -73,14 +81,22 @@ func (r *Router) Serve(packet *pkt.LogicPkt, dispatcher Dispatcher, cache Sessio
 }
 
 func (r *Router) serveContext(ctx *ContextImpl) {
-	chain, ok := r.handlers.Get(ctx.Header().Command)
+	command := ctx.Header().Command
+	var span trace.Span
+	ctx.ctx, span = tracing.Start(ctx.Context(), "server "+command,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("channel_id", ctx.Header().ChannelId)))
+	defer span.End()
+	chain, ok := r.handlers.Get(command)
 	if !ok {
 		ctx.handlers = []HandlerFun{handleNoFound}
 		ctx.Next()
 		return
 	}
+	start := time.Now()
 	ctx.handlers = chain
 	ctx.Next()
+	metrics.HandlerDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
 }
 
 func handleNoFound(ctx Context) {
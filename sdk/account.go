@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"EIM/wire/rpc"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Register 在royal上注册账号, url为royal的地址
+func Register(ctx context.Context, url, app string, req *rpc.RegisterUserReq) error {
+	return postJSON(ctx, fmt.Sprintf("%s/api/%s/user", url, app), req, nil)
+}
+
+// Login 使用账号密码从royal获取登录网关的token
+func Login(ctx context.Context, url, app, account, password string) (*rpc.UserLoginResp, error) {
+	var resp rpc.UserLoginResp
+	err := postJSON(ctx, fmt.Sprintf("%s/api/%s/user/login", url, app), &rpc.UserLoginReq{
+		Account:  account,
+		Password: password,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func postJSON(ctx context.Context, url string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/json")
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("sdk: %s returns %d %s", url, response.StatusCode, data)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
@@ -0,0 +1,1147 @@
+package sdk
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/tcp"
+	"EIM/websocket"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"google.golang.org/protobuf/proto"
+)
+
+// 连接协议
+const (
+	ProtocolWebsocket = "ws"
+	ProtocolTCP       = "tcp"
+)
+
+// errors
+var (
+	ErrClosed         = errors.New("sdk: client closed")
+	ErrNotConnected   = errors.New("sdk: not connected")
+	ErrTimeout        = errors.New("sdk: request timeout")
+	ErrConnectionLost = errors.New("sdk: connection lost")
+)
+
+var log = logger.WithField("module", "sdk")
+
+// deliveredSize 用于去重的最近消息ID数量
+const deliveredSize = 1000
+
+// newClientId 生成一个随机的客户端消息id
+func newClientId() string {
+	b := make([]byte, 16)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// State 客户端的连接状态
+type State int32
+
+const (
+	StateDisconnected State = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "disconnected"
+}
+
+// Options 客户端参数, 为0的字段会使用默认值
+type Options struct {
+	Protocol         string        // 连接协议, ws或tcp
+	Heartbeat        time.Duration // 心跳间隔
+	ReadWait         time.Duration // 读超时
+	WriteWait        time.Duration // 写超时
+	RequestTimeout   time.Duration // 等待应答的超时时间
+	MinBackoff       time.Duration // 重连的最小等待时间
+	MaxBackoff       time.Duration // 重连的最大等待时间
+	DisableReconnect bool          // 连接断开后不自动重连
+	Device           string        // 设备标识, 服务端按设备记录离线消息的读索引
+}
+
+// Callbacks 事件回调, 回调在读消息的goroutine中执行, 不应阻塞
+type Callbacks struct {
+	OnMessage     func(msg *Message)              // 收到单聊/群聊消息(在线推送或离线同步)
+	OnKickout     func(notify *pkt.KickoutNotify) // 账号在其它地方登录, 被踢下线
+	OnTransient   func(msg *Transient)            // 瞬时消息, 如正在输入与通话信令
+	OnPresence    func(presence *pkt.Presence)    // 订阅的账号在线状态变化
+	OnPush        func(p *pkt.LogicPkt)           // 其它推送消息, 如群通知
+	OnStateChange func(state State)               // 连接状态变化
+}
+
+// Message 收到的一条聊天消息
+type Message struct {
+	*pkt.MessagePush
+	Group   string // 群聊消息的群ID, 单聊时为空
+	Peer    string // 单聊对方的账号, 群聊时为空
+	Offline bool   // 是否为离线同步或者补齐得到的消息
+}
+
+// conversation 会话类型与单聊对方的账号或群ID, 用于记录每个会话收到的序号
+type conversation struct {
+	typ    int32
+	target string
+}
+
+func (m *Message) conversation() conversation {
+	if m.Group != "" {
+		return conversation{typ: wire.ConversationGroup, target: m.Group}
+	}
+	return conversation{typ: wire.ConversationUser, target: m.Peer}
+}
+
+// Transient 收到的一条瞬时消息, 不会保存, 也不会出现在离线同步中
+type Transient struct {
+	*pkt.TransientPush
+	Group string // 群内瞬时消息的群ID, 单聊时为空
+}
+
+// StatusError 服务端返回了非Success的状态码
+type StatusError struct {
+	Command string
+	Status  pkt.Status
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed: %v %s", e.Command, e.Status, e.Message)
+}
+
+// link 一次成功建立的连接
+type link struct {
+	EIM.Client
+	done *EIM.Event
+}
+
+// Client 即时通讯客户端, 负责登录、心跳、请求应答、消息推送、断线重连以及离线同步
+type Client struct {
+	sync.Mutex
+	addr      string
+	token     string
+	opts      Options
+	cbs       Callbacks
+	link      *link
+	channelId string
+	account   string
+	pending   map[uint32]chan *pkt.LogicPkt
+	sendLock  sync.Mutex
+	state     int32
+	lastMsgId int64
+	lastPong  int64
+	closed    *EIM.Event
+	// 订阅的在线状态, 网关上的订阅随连接断开而清除, 重连后重新订阅
+	presenceSubs    map[string]struct{}
+	presenceFriends bool
+	// 最近收到的消息ID, 用于去重
+	delivered *lru.Cache
+	// 每个会话收到的最大序号, 用于发现缺少的消息
+	seqs map[conversation]int64
+}
+
+// NewClient 创建一个客户端, addr为网关地址, token为登录凭证
+func NewClient(addr, token string, opts Options, cbs Callbacks) *Client {
+	if opts.Protocol == "" {
+		opts.Protocol = ProtocolWebsocket
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = EIM.DefaultHeartbeat
+	}
+	if opts.ReadWait == 0 {
+		opts.ReadWait = EIM.DefaultReadWait
+	}
+	if opts.WriteWait == 0 {
+		opts.WriteWait = EIM.DefaultWriteWait
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = time.Second * 10
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff * 30
+	}
+	delivered, _ := lru.New(deliveredSize)
+	return &Client{
+		addr:         addr,
+		token:        token,
+		opts:         opts,
+		cbs:          cbs,
+		pending:      make(map[uint32]chan *pkt.LogicPkt),
+		closed:       EIM.NewEvent(),
+		presenceSubs: make(map[string]struct{}),
+		delivered:    delivered,
+		seqs:         make(map[conversation]int64),
+	}
+}
+
+// Connect 连接网关并登录
+func (c *Client) Connect() error {
+	if c.closed.HasFired() {
+		return ErrClosed
+	}
+	c.setState(StateConnecting)
+	if err := c.connect(); err != nil {
+		c.setState(StateDisconnected)
+		return err
+	}
+	return nil
+}
+
+// connect 建立一个新连接, 并启动读消息与心跳的goroutine
+func (c *Client) connect() error {
+	dialer := &loginDialer{
+		protocol: c.opts.Protocol,
+		token:    c.token,
+		device:   c.opts.Device,
+	}
+	var cli EIM.Client
+	if c.opts.Protocol == ProtocolTCP {
+		cli = tcp.NewClient("sdk", "client", tcp.ClientOptions{
+			ReadWait:  c.opts.ReadWait,
+			WriteWait: c.opts.WriteWait,
+		})
+	} else {
+		cli = websocket.NewClient("sdk", "client", websocket.ClientOptions{
+			ReadWait:  c.opts.ReadWait,
+			WriteWait: c.opts.WriteWait,
+		})
+	}
+	cli.SetDialer(dialer)
+	if err := cli.Connect(c.addr); err != nil {
+		return err
+	}
+	l := &link{Client: cli, done: EIM.NewEvent()}
+
+	c.Lock()
+	c.link = l
+	c.channelId = dialer.resp.GetChannelId()
+	if dialer.resp.GetAccount() != "" {
+		c.account = dialer.resp.GetAccount()
+	}
+	c.Unlock()
+	// Close可能与重连同时发生
+	if c.closed.HasFired() {
+		cli.Close()
+		return ErrClosed
+	}
+	atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+	c.setState(StateConnected)
+	log.Infof("connected to %s, channel %s", c.addr, c.ChannelId())
+
+	go c.readloop(l)
+	go c.heartbeatloop(l)
+	return nil
+}
+
+// readloop 循环读取消息, 连接断开后触发重连
+func (c *Client) readloop(l *link) {
+	for {
+		frame, err := l.Read()
+		if err != nil {
+			log.Info(err)
+			break
+		}
+		if frame.GetOpCode() != EIM.OpBinary {
+			continue
+		}
+		packet, err := pkt.Read(bytes.NewBuffer(frame.GetPayload()))
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		switch p := packet.(type) {
+		case *pkt.BasicPkt:
+			if p.Code == pkt.CodePong {
+				atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+			}
+		case *pkt.LogicPkt:
+			c.handle(p)
+		}
+	}
+	l.done.Fire()
+	l.Close()
+	c.Lock()
+	if c.link == l {
+		c.link = nil
+	}
+	c.Unlock()
+	c.failPending()
+
+	if c.closed.HasFired() {
+		return
+	}
+	if c.opts.DisableReconnect {
+		c.setState(StateDisconnected)
+		return
+	}
+	c.reconnect()
+}
+
+// heartbeatloop 定时发送BasicPkt心跳包, 长时间收不到pong时主动断开连接
+func (c *Client) heartbeatloop(l *link) {
+	tick := time.NewTicker(c.opts.Heartbeat)
+	defer tick.Stop()
+	ping := pkt.Marshal(&pkt.BasicPkt{Code: pkt.CodePing})
+	for {
+		select {
+		case <-tick.C:
+			last := time.Unix(0, atomic.LoadInt64(&c.lastPong))
+			if time.Since(last) > c.opts.Heartbeat+c.opts.ReadWait {
+				log.Warnf("no pong since %v, close the connection", last)
+				l.Close()
+				return
+			}
+			if err := c.send(l, ping); err != nil {
+				log.Warn(err)
+				l.Close()
+				return
+			}
+		case <-l.done.Done():
+			return
+		}
+	}
+}
+
+// reconnect 按指数退避重连, 重连成功后同步离线消息
+func (c *Client) reconnect() {
+	c.setState(StateReconnecting)
+	backoff := c.opts.MinBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-c.closed.Done():
+			return
+		}
+		err := c.connect()
+		if err == nil {
+			go func() {
+				if err := c.resubscribePresence(); err != nil {
+					log.Warn(err)
+				}
+				if _, err := c.SyncOffline(); err != nil {
+					log.Warn(err)
+				}
+			}()
+			return
+		}
+		if err == ErrClosed {
+			return
+		}
+		log.Warnf("reconnect attempt %d failed: %v", attempt, err)
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// jitter 返回[d/2, d]之间的随机时长, 避免大量客户端同时重连
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// handle 处理一个逻辑消息包
+func (c *Client) handle(p *pkt.LogicPkt) {
+	if p.Flag == pkt.Flag_Response {
+		c.Lock()
+		ch, ok := c.pending[p.Sequence]
+		delete(c.pending, p.Sequence)
+		c.Unlock()
+		if ok {
+			ch <- p
+		}
+		return
+	}
+	switch p.Command {
+	case wire.CommandChatUserTalk, wire.CommandChatGroupTalk:
+		var push pkt.MessagePush
+		if err := p.ReadBody(&push); err != nil {
+			log.Warn(err)
+			return
+		}
+		msg := &Message{MessagePush: &push}
+		if p.Command == wire.CommandChatGroupTalk {
+			msg.Group = p.Dest
+		} else {
+			msg.Peer = push.Sender
+		}
+		c.deliver(msg)
+	case wire.CommandGroupJoin, wire.CommandGroupInvite, wire.CommandGroupApprove,
+		wire.CommandFriendAdd, wire.CommandFriendApprove:
+		// 入群申请, 邀请, 好友申请与处理结果是保存为离线消息的通知, Type为MessageTypeNotify
+		var push pkt.MessagePush
+		if err := p.ReadBody(&push); err != nil {
+			log.Warn(err)
+			return
+		}
+		c.deliver(&Message{MessagePush: &push, Peer: push.Sender})
+	case wire.CommandTransientUser, wire.CommandTransientGroup:
+		var push pkt.TransientPush
+		if err := p.ReadBody(&push); err != nil {
+			log.Warn(err)
+			return
+		}
+		msg := &Transient{TransientPush: &push}
+		if p.Command == wire.CommandTransientGroup {
+			msg.Group = p.Dest
+		}
+		if c.cbs.OnTransient != nil {
+			c.cbs.OnTransient(msg)
+		}
+	case wire.CommandPresenceNotify:
+		var presence pkt.Presence
+		if err := p.ReadBody(&presence); err != nil {
+			log.Warn(err)
+			return
+		}
+		if c.cbs.OnPresence != nil {
+			c.cbs.OnPresence(&presence)
+		}
+	case wire.CommandLoginSignIn:
+		var notify pkt.KickoutNotify
+		_ = p.ReadBody(&notify)
+		log.Warnf("kicked out, channel %s", notify.GetChannelId())
+		if c.cbs.OnKickout != nil {
+			c.cbs.OnKickout(&notify)
+		}
+		// 被踢下线后不再重连, 否则两端会互相踢下线
+		go c.Close()
+	default:
+		if c.cbs.OnPush != nil {
+			c.cbs.OnPush(p)
+		}
+	}
+}
+
+// deliver 记录最新的消息ID与会话序号并回调OnMessage, 重复推送或者已经推送过的离线消息按消息ID去重
+func (c *Client) deliver(msg *Message) {
+	if ok, _ := c.delivered.ContainsOrAdd(msg.MessageId, struct{}{}); ok {
+		return
+	}
+	for {
+		last := atomic.LoadInt64(&c.lastMsgId)
+		if msg.MessageId <= last || atomic.CompareAndSwapInt64(&c.lastMsgId, last, msg.MessageId) {
+			break
+		}
+	}
+	c.checkSeq(msg.conversation(), msg.Seq)
+	if c.cbs.OnMessage != nil {
+		c.cbs.OnMessage(msg)
+	}
+}
+
+// checkSeq 记录会话收到的最大序号, 序号不连续时在后台拉取中间缺少的消息, 补齐的消息会晚于当前消息回调
+func (c *Client) checkSeq(conv conversation, seq int64) {
+	if seq == 0 || conv.target == "" {
+		return
+	}
+	c.Lock()
+	last := c.seqs[conv]
+	if seq > last {
+		c.seqs[conv] = seq
+	}
+	c.Unlock()
+	if last == 0 || seq <= last+1 {
+		return
+	}
+	// 在读消息的goroutine中不能等待应答
+	go func() {
+		if _, err := c.FetchRange(conv.typ, conv.target, last, seq); err != nil {
+			log.Warnf("fetch missing messages (%d, %d) of %s: %v", last, seq, conv.target, err)
+		}
+	}()
+}
+
+// failPending 连接断开时让所有等待中的请求返回
+func (c *Client) failPending() {
+	c.Lock()
+	defer c.Unlock()
+	for seq, ch := range c.pending {
+		close(ch)
+		delete(c.pending, seq)
+	}
+}
+
+// send 发送数据, 保证同一时刻只有一个goroutine在写连接
+func (c *Client) send(l *link, payload []byte) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return l.Send(payload)
+}
+
+// Request 发送一个请求并等待对应序列号的应答, 状态码不为Success时返回*StatusError
+func (c *Client) Request(command, dest string, body proto.Message) (*pkt.LogicPkt, error) {
+	seq := wire.Seq.Next()
+	req := pkt.New(command, pkt.WithSeq(seq), pkt.WithDest(dest)).WriteBody(body)
+	ch := make(chan *pkt.LogicPkt, 1)
+
+	c.Lock()
+	l := c.link
+	if l == nil {
+		c.Unlock()
+		if c.closed.HasFired() {
+			return nil, ErrClosed
+		}
+		return nil, ErrNotConnected
+	}
+	c.pending[seq] = ch
+	c.Unlock()
+
+	if err := c.send(l, pkt.Marshal(req)); err != nil {
+		c.removePending(seq)
+		return nil, err
+	}
+	timer := time.NewTimer(c.opts.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrConnectionLost
+		}
+		if resp.Status != pkt.Status_Success {
+			var errResp pkt.ErrorResp
+			_ = resp.ReadBody(&errResp)
+			return resp, &StatusError{Command: command, Status: resp.Status, Message: errResp.GetMessage()}
+		}
+		return resp, nil
+	case <-timer.C:
+		c.removePending(seq)
+		return nil, ErrTimeout
+	}
+}
+
+func (c *Client) removePending(seq uint32) {
+	c.Lock()
+	delete(c.pending, seq)
+	c.Unlock()
+}
+
+// Talk 发送一条单聊消息
+// req.ClientId为空时自动生成, 超时后使用同一个req重试, 服务端不会重复保存
+func (c *Client) Talk(dest string, req *pkt.MessageReq) (*pkt.MessageResp, error) {
+	return c.talk(wire.CommandChatUserTalk, dest, req)
+}
+
+// GroupTalk 发送一条群聊消息
+func (c *Client) GroupTalk(group string, req *pkt.MessageReq) (*pkt.MessageResp, error) {
+	return c.talk(wire.CommandChatGroupTalk, group, req)
+}
+
+func (c *Client) talk(command, dest string, req *pkt.MessageReq) (*pkt.MessageResp, error) {
+	if req.ClientId == "" {
+		req.ClientId = newClientId()
+	}
+	resp, err := c.Request(command, dest, req)
+	if err != nil {
+		return nil, err
+	}
+	var msgResp pkt.MessageResp
+	if err = resp.ReadBody(&msgResp); err != nil {
+		return nil, err
+	}
+	// 自己发送的消息也占用会话的序号
+	conv := conversation{typ: wire.ConversationUser, target: dest}
+	if command == wire.CommandChatGroupTalk {
+		conv.typ = wire.ConversationGroup
+	}
+	c.checkSeq(conv, msgResp.Seq)
+	return &msgResp, nil
+}
+
+// SyncOffline 从最后收到的消息开始同步离线消息, 每条消息都会回调OnMessage, 返回同步到的消息数
+// 索引超过一页时使用上一页最后一条索引继续同步, 直到没有更多的消息
+func (c *Client) SyncOffline() (int, error) {
+	cursor := c.LastMessageId()
+	count := 0
+	for {
+		resp, err := c.Request(wire.CommandOfflineIndex, "", &pkt.MessageIndexReq{MessageId: cursor})
+		if err != nil {
+			return count, err
+		}
+		var indexResp pkt.MessageIndexResp
+		if err = resp.ReadBody(&indexResp); err != nil {
+			return count, err
+		}
+		indexes := indexResp.GetIndexes()
+		n, err := c.syncContent(indexes)
+		count += n
+		if err != nil {
+			return count, err
+		}
+		if !indexResp.GetHasMore() || len(indexes) == 0 {
+			return count, nil
+		}
+		cursor = indexes[len(indexes)-1].GetMessageId()
+	}
+}
+
+// syncContent 按页拉取索引对应的消息内容并回调OnMessage
+func (c *Client) syncContent(indexes []*pkt.MessageIndex) (int, error) {
+	count := 0
+	for i := 0; i < len(indexes); i += wire.MessageMaxCountPerPage {
+		end := i + wire.MessageMaxCountPerPage
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+		page := indexes[i:end]
+		ids := make([]int64, len(page))
+		for j, index := range page {
+			ids[j] = index.GetMessageId()
+		}
+		resp, err := c.Request(wire.CommandOfflineContent, "", &pkt.MessageContentReq{MessageIds: ids})
+		if err != nil {
+			return count, err
+		}
+		var contentResp pkt.MessageContentResp
+		if err = resp.ReadBody(&contentResp); err != nil {
+			return count, err
+		}
+		contents := make(map[int64]*pkt.MessageContent, len(contentResp.GetContents()))
+		for _, content := range contentResp.GetContents() {
+			contents[content.GetMessageId()] = content
+		}
+		for _, index := range page {
+			content, ok := contents[index.GetMessageId()]
+			if !ok {
+				continue
+			}
+			sender := index.GetAccountB()
+			if index.GetDirection() == 1 {
+				sender = c.Account()
+			}
+			msg := &Message{
+				MessagePush: &pkt.MessagePush{
+					MessageId: index.GetMessageId(),
+					Type:      content.GetType(),
+					Body:      content.GetBody(),
+					Extra:     content.GetExtra(),
+					Sender:    sender,
+					SendTime:  index.GetSendTime(),
+					Seq:       index.GetSeq(),
+				},
+				Group:   index.GetGroup(),
+				Offline: true,
+			}
+			if msg.Group == "" {
+				msg.Peer = index.GetAccountB()
+			}
+			c.deliver(msg)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SendTransient 给dest在线的设备发送瞬时消息, 如wire.TransientTyping, 应答中的Online表示对方是否在线
+func (c *Client) SendTransient(dest string, typ int32, body string) (*pkt.TransientResp, error) {
+	return c.transient(wire.CommandTransientUser, dest, typ, body)
+}
+
+// SendGroupTransient 给群里其它在线的成员发送瞬时消息
+func (c *Client) SendGroupTransient(group string, typ int32, body string) (*pkt.TransientResp, error) {
+	return c.transient(wire.CommandTransientGroup, group, typ, body)
+}
+
+func (c *Client) transient(command, dest string, typ int32, body string) (*pkt.TransientResp, error) {
+	resp, err := c.Request(command, dest, &pkt.TransientReq{Type: typ, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	var transientResp pkt.TransientResp
+	if err = resp.ReadBody(&transientResp); err != nil {
+		return nil, err
+	}
+	return &transientResp, nil
+}
+
+// Conversations 同步version之后变更的会话, version为0时从头同步, 之后使用应答中的Version, HasMore为true时需要继续同步
+func (c *Client) Conversations(version int64, limit int32) (*pkt.ConversationListResp, error) {
+	resp, err := c.Request(wire.CommandConversationList, "", &pkt.ConversationListReq{Version: version, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	var listResp pkt.ConversationListResp
+	if err = resp.ReadBody(&listResp); err != nil {
+		return nil, err
+	}
+	return &listResp, nil
+}
+
+// UpdateConversation 置顶或免打扰, 为nil的字段不修改
+func (c *Client) UpdateConversation(typ int32, target string, pinned, muted *bool) error {
+	_, err := c.Request(wire.CommandConversationUpdate, "", &pkt.ConversationUpdateReq{
+		Type:   typ,
+		Target: target,
+		Pinned: pinned,
+		Muted:  muted,
+	})
+	return err
+}
+
+// Ack 确认已读到messageId, typ不为0时同时清除与target的会话的未读数
+func (c *Client) Ack(typ int32, target string, messageId int64) error {
+	_, err := c.Request(wire.CommandChatTalkAck, target, &pkt.MessageAckReq{
+		MessageId:        messageId,
+		ConversationType: typ,
+	})
+	return err
+}
+
+// History 翻取与target的会话中的历史消息, req.Type为会话类型, 默认从req.MessageId开始按序号倒序翻取更早的消息,
+// MessageId为0时从req.Seq开始, 都为0时从最新的消息开始, Forward为true时按序号升序翻取更新的消息, HasMore为true时还有下一页
+func (c *Client) History(target string, req *pkt.HistoryReq) (*pkt.HistoryResp, error) {
+	resp, err := c.Request(wire.CommandChatHistory, target, req)
+	if err != nil {
+		return nil, err
+	}
+	var historyResp pkt.HistoryResp
+	if err = resp.ReadBody(&historyResp); err != nil {
+		return nil, err
+	}
+	return &historyResp, nil
+}
+
+// FetchRange 拉取会话中序号在(from, to)之间的消息并回调OnMessage, 返回拉取到的消息数
+// 收到的消息序号不连续时会自动调用; 会话列表中的LastSeq大于本地最后一条消息的序号时, 可以用to为LastSeq+1补齐
+func (c *Client) FetchRange(typ int32, target string, from, to int64) (int, error) {
+	count := 0
+	for from+1 < to {
+		limit := to - from - 1
+		if limit > wire.MessageHistoryPageSize {
+			limit = wire.MessageHistoryPageSize
+		}
+		resp, err := c.History(target, &pkt.HistoryReq{Type: typ, Seq: from, Forward: true, Limit: int32(limit)})
+		if err != nil {
+			return count, err
+		}
+		for _, push := range resp.GetMessages() {
+			if push.GetSeq() >= to {
+				return count, nil
+			}
+			msg := &Message{MessagePush: push, Offline: true}
+			if typ == wire.ConversationGroup {
+				msg.Group = target
+			} else {
+				msg.Peer = target
+			}
+			c.deliver(msg)
+			count++
+			from = push.GetSeq()
+		}
+		if !resp.GetHasMore() || len(resp.GetMessages()) == 0 {
+			return count, nil
+		}
+	}
+	return count, nil
+}
+
+// CreateGroup 创建群
+func (c *Client) CreateGroup(req *pkt.GroupCreateReq) (*pkt.GroupCreateResp, error) {
+	if req.Owner == "" {
+		req.Owner = c.Account()
+	}
+	resp, err := c.Request(wire.CommandGroupCreate, "", req)
+	if err != nil {
+		return nil, err
+	}
+	var createResp pkt.GroupCreateResp
+	if err = resp.ReadBody(&createResp); err != nil {
+		return nil, err
+	}
+	return &createResp, nil
+}
+
+// JoinGroup 加入群, Account为空时使用当前登录的账号; 需要审批时应答中Pending为true
+func (c *Client) JoinGroup(req *pkt.GroupJoinReq) (*pkt.GroupJoinResp, error) {
+	if req.Account == "" {
+		req.Account = c.Account()
+	}
+	resp, err := c.Request(wire.CommandGroupJoin, "", req)
+	if err != nil {
+		return nil, err
+	}
+	var joinResp pkt.GroupJoinResp
+	if err = resp.ReadBody(&joinResp); err != nil {
+		return nil, err
+	}
+	return &joinResp, nil
+}
+
+// QuitGroup 退出群, Account为空时使用当前登录的账号
+func (c *Client) QuitGroup(req *pkt.GroupQuitReq) error {
+	if req.Account == "" {
+		req.Account = c.Account()
+	}
+	_, err := c.Request(wire.CommandGroupQuit, "", req)
+	return err
+}
+
+// GroupDetail 获取群详情
+func (c *Client) GroupDetail(groupId string) (*pkt.GroupGetResp, error) {
+	resp, err := c.Request(wire.CommandGroupDetail, "", &pkt.GroupGetReq{GroupId: groupId})
+	if err != nil {
+		return nil, err
+	}
+	var getResp pkt.GroupGetResp
+	if err = resp.ReadBody(&getResp); err != nil {
+		return nil, err
+	}
+	return &getResp, nil
+}
+
+// GroupMembers 获取群成员
+func (c *Client) GroupMembers(groupId string) ([]*pkt.Member, error) {
+	resp, err := c.Request(wire.CommandGroupMembers, "", &pkt.GroupMembersReq{GroupId: groupId})
+	if err != nil {
+		return nil, err
+	}
+	var membersResp pkt.GroupMembersResp
+	if err = resp.ReadBody(&membersResp); err != nil {
+		return nil, err
+	}
+	return membersResp.GetMembers(), nil
+}
+
+// KickMember 踢出群成员, 需要管理员权限
+func (c *Client) KickMember(req *pkt.GroupKickReq) error {
+	_, err := c.Request(wire.CommandGroupKick, "", req)
+	return err
+}
+
+// TransferGroup 转让群主
+func (c *Client) TransferGroup(req *pkt.GroupTransferReq) error {
+	_, err := c.Request(wire.CommandGroupTransfer, "", req)
+	return err
+}
+
+// SetGroupAdmin 设置或取消管理员, 只有群主可以操作
+func (c *Client) SetGroupAdmin(req *pkt.GroupSetAdminReq) error {
+	_, err := c.Request(wire.CommandGroupSetAdmin, "", req)
+	return err
+}
+
+// MuteGroup 禁言成员, Account为空时禁言全员
+func (c *Client) MuteGroup(req *pkt.GroupMuteReq) error {
+	_, err := c.Request(wire.CommandGroupMute, "", req)
+	return err
+}
+
+// UpdateGroup 修改群信息
+func (c *Client) UpdateGroup(req *pkt.GroupUpdateReq) error {
+	_, err := c.Request(wire.CommandGroupUpdate, "", req)
+	return err
+}
+
+// DissolveGroup 解散群, 只有群主可以操作
+func (c *Client) DissolveGroup(groupId string) error {
+	_, err := c.Request(wire.CommandGroupDissolve, "", &pkt.GroupDissolveReq{GroupId: groupId})
+	return err
+}
+
+// InviteGroup 邀请用户入群, 返回成功邀请的账号
+func (c *Client) InviteGroup(req *pkt.GroupInviteReq) (*pkt.GroupInviteResp, error) {
+	resp, err := c.Request(wire.CommandGroupInvite, "", req)
+	if err != nil {
+		return nil, err
+	}
+	var inviteResp pkt.GroupInviteResp
+	if err = resp.ReadBody(&inviteResp); err != nil {
+		return nil, err
+	}
+	return &inviteResp, nil
+}
+
+// ApproveJoin 审批入群申请, 需要管理员权限
+func (c *Client) ApproveJoin(requestId int64, approve bool) error {
+	_, err := c.Request(wire.CommandGroupApprove, "", &pkt.GroupApproveReq{RequestId: requestId, Approve: approve})
+	return err
+}
+
+// JoinRequests 获取待审批的入群申请, 需要管理员权限
+func (c *Client) JoinRequests(groupId string) ([]*pkt.GroupJoinRequest, error) {
+	resp, err := c.Request(wire.CommandGroupRequests, "", &pkt.GroupRequestsReq{GroupId: groupId})
+	if err != nil {
+		return nil, err
+	}
+	var requestsResp pkt.GroupRequestsResp
+	if err = resp.ReadBody(&requestsResp); err != nil {
+		return nil, err
+	}
+	return requestsResp.GetRequests(), nil
+}
+
+// Profiles 批量获取用户资料, 用于显示消息发送方的昵称与头像
+func (c *Client) Profiles(accounts ...string) ([]*pkt.UserProfile, error) {
+	resp, err := c.Request(wire.CommandUserProfiles, "", &pkt.UserProfilesReq{Accounts: accounts})
+	if err != nil {
+		return nil, err
+	}
+	var profilesResp pkt.UserProfilesResp
+	if err = resp.ReadBody(&profilesResp); err != nil {
+		return nil, err
+	}
+	return profilesResp.GetUsers(), nil
+}
+
+// UpdateProfile 修改自己的昵称与头像, 为空的字段不修改
+func (c *Client) UpdateProfile(nickname, avatar string) error {
+	_, err := c.Request(wire.CommandUserUpdate, "", &pkt.UserUpdateReq{Nickname: nickname, Avatar: avatar})
+	return err
+}
+
+// AddFriend 发送好友申请
+func (c *Client) AddFriend(account, remark string) (int64, error) {
+	resp, err := c.Request(wire.CommandFriendAdd, "", &pkt.FriendAddReq{Account: account, Remark: remark})
+	if err != nil {
+		return 0, err
+	}
+	var addResp pkt.FriendAddResp
+	if err = resp.ReadBody(&addResp); err != nil {
+		return 0, err
+	}
+	return addResp.GetRequestId(), nil
+}
+
+// ApproveFriend 同意或拒绝好友申请
+func (c *Client) ApproveFriend(requestId int64, approve bool) error {
+	_, err := c.Request(wire.CommandFriendApprove, "", &pkt.FriendApproveReq{RequestId: requestId, Approve: approve})
+	return err
+}
+
+// FriendRequests 获取待处理的好友申请
+func (c *Client) FriendRequests() ([]*pkt.FriendRequest, error) {
+	resp, err := c.Request(wire.CommandFriendRequests, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var requestsResp pkt.FriendRequestsResp
+	if err = resp.ReadBody(&requestsResp); err != nil {
+		return nil, err
+	}
+	return requestsResp.GetRequests(), nil
+}
+
+// Friends 获取好友列表
+func (c *Client) Friends() ([]*pkt.Friend, error) {
+	resp, err := c.Request(wire.CommandFriendList, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var listResp pkt.FriendListResp
+	if err = resp.ReadBody(&listResp); err != nil {
+		return nil, err
+	}
+	return listResp.GetFriends(), nil
+}
+
+// SetFriendRemark 修改好友备注
+func (c *Client) SetFriendRemark(account, remark string) error {
+	_, err := c.Request(wire.CommandFriendRemark, "", &pkt.FriendRemarkReq{Account: account, Remark: remark})
+	return err
+}
+
+// DeleteFriend 删除好友
+func (c *Client) DeleteFriend(account string) error {
+	_, err := c.Request(wire.CommandFriendDelete, "", &pkt.FriendDeleteReq{Account: account})
+	return err
+}
+
+// BlockUser 加入或移出黑名单
+func (c *Client) BlockUser(account string, block bool) error {
+	_, err := c.Request(wire.CommandFriendBlock, "", &pkt.FriendBlockReq{Account: account, Block: block})
+	return err
+}
+
+// Blocklist 获取黑名单
+func (c *Client) Blocklist() ([]string, error) {
+	resp, err := c.Request(wire.CommandFriendBlocklist, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var blocklistResp pkt.FriendBlocklistResp
+	if err = resp.ReadBody(&blocklistResp); err != nil {
+		return nil, err
+	}
+	return blocklistResp.GetAccounts(), nil
+}
+
+// QueryPresence 批量查询在线状态, 一次最多100个账号
+func (c *Client) QueryPresence(accounts ...string) ([]*pkt.Presence, error) {
+	return c.presence(wire.CommandPresenceQuery, accounts)
+}
+
+// SubscribePresence 订阅账号的在线状态变化, 不指定账号时订阅所有好友, 返回当前的在线状态; 变化通过OnPresence回调
+func (c *Client) SubscribePresence(accounts ...string) ([]*pkt.Presence, error) {
+	list, err := c.presence(wire.CommandPresenceSubscribe, accounts)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	if len(accounts) == 0 {
+		c.presenceFriends = true
+	}
+	for _, account := range accounts {
+		c.presenceSubs[account] = struct{}{}
+	}
+	c.Unlock()
+	return list, nil
+}
+
+// UnsubscribePresence 取消订阅, 不指定账号时取消所有好友
+func (c *Client) UnsubscribePresence(accounts ...string) error {
+	_, err := c.Request(wire.CommandPresenceUnsubscribe, "", &pkt.PresenceReq{Accounts: accounts})
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	if len(accounts) == 0 {
+		c.presenceFriends = false
+	}
+	for _, account := range accounts {
+		delete(c.presenceSubs, account)
+	}
+	c.Unlock()
+	return nil
+}
+
+// SetPresence 设置自己的在线状态, 如wire.PresenceAway与wire.PresenceBusy, text为自定义的描述
+func (c *Client) SetPresence(status int32, text string) error {
+	_, err := c.Request(wire.CommandPresenceSet, "", &pkt.PresenceSetReq{Status: status, Text: text})
+	return err
+}
+
+func (c *Client) presence(command string, accounts []string) ([]*pkt.Presence, error) {
+	resp, err := c.Request(command, "", &pkt.PresenceReq{Accounts: accounts})
+	if err != nil {
+		return nil, err
+	}
+	var presenceResp pkt.PresenceResp
+	if err = resp.ReadBody(&presenceResp); err != nil {
+		return nil, err
+	}
+	return presenceResp.GetPresences(), nil
+}
+
+// resubscribePresence 重连后恢复在线状态的订阅
+func (c *Client) resubscribePresence() error {
+	c.Lock()
+	friends := c.presenceFriends
+	accounts := make([]string, 0, len(c.presenceSubs))
+	for account := range c.presenceSubs {
+		accounts = append(accounts, account)
+	}
+	c.Unlock()
+	if friends {
+		if _, err := c.presence(wire.CommandPresenceSubscribe, nil); err != nil {
+			return err
+		}
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	_, err := c.presence(wire.CommandPresenceSubscribe, accounts)
+	return err
+}
+
+// Close 关闭客户端, 关闭后不会再重连
+func (c *Client) Close() {
+	if !c.closed.Fire() {
+		return
+	}
+	c.Lock()
+	l := c.link
+	c.link = nil
+	c.Unlock()
+	if l != nil {
+		l.Close()
+	}
+	c.setState(StateClosed)
+}
+
+// ChannelId 返回当前连接的channelId
+func (c *Client) ChannelId() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.channelId
+}
+
+// Account 返回当前登录的账号
+func (c *Client) Account() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.account
+}
+
+// SetAccount 设置当前登录的账号, 网关未在登录应答中返回账号时使用
+func (c *Client) SetAccount(account string) {
+	c.Lock()
+	defer c.Unlock()
+	c.account = account
+}
+
+// LastMessageId 返回收到的最新消息ID, 用于离线同步
+func (c *Client) LastMessageId() int64 {
+	return atomic.LoadInt64(&c.lastMsgId)
+}
+
+// SetLastMessageId 设置离线同步的起点, 客户端有本地消息存储时使用
+func (c *Client) SetLastMessageId(id int64) {
+	atomic.StoreInt64(&c.lastMsgId, id)
+}
+
+// LastSeq 返回会话中收到的最大序号
+func (c *Client) LastSeq(typ int32, target string) int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.seqs[conversation{typ: typ, target: target}]
+}
+
+// SetLastSeq 设置会话中已经收到的最大序号, 客户端有本地消息存储时使用, 之后收到的消息不连续时会自动补齐
+func (c *Client) SetLastSeq(typ int32, target string, seq int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.seqs[conversation{typ: typ, target: target}] = seq
+}
+
+// State 返回当前连接状态
+func (c *Client) State() State {
+	return State(atomic.LoadInt32(&c.state))
+}
+
+func (c *Client) setState(state State) {
+	old := atomic.SwapInt32(&c.state, int32(state))
+	if old == int32(state) {
+		return
+	}
+	if c.cbs.OnStateChange != nil {
+		c.cbs.OnStateChange(state)
+	}
+}
@@ -0,0 +1,445 @@
+package sdk
+
+import (
+	"EIM"
+	"EIM/naming"
+	"EIM/websocket"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGateway 模拟网关与服务端, 登录总是成功
+type fakeGateway struct {
+	sync.Mutex
+	conns       []EIM.Conn
+	offlineSync int32
+	backlog     []int64            // 离线消息ID, 每页返回2条
+	history     []*pkt.MessagePush // 与test2的会话中的历史消息, 按序号升序
+	uploadURL   string             // 媒体文件的上传地址
+}
+
+func (g *fakeGateway) Accept(conn EIM.Conn, timeout time.Duration) (string, error) {
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	req, err := pkt.MustReadLogicPkt(bytes.NewBuffer(frame.GetPayload()))
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("gate01_test_%d", wire.Seq.Next())
+	resp := pkt.NewForm(&req.Header)
+	resp.Flag = pkt.Flag_Response
+	resp.WriteBody(&pkt.LoginResp{ChannelId: id, Account: "test1"})
+	if err = conn.WriteFrame(EIM.OpBinary, pkt.Marshal(resp)); err != nil {
+		return "", err
+	}
+	g.Lock()
+	g.conns = append(g.conns, conn)
+	g.Unlock()
+	return id, nil
+}
+
+func (g *fakeGateway) Receive(ag EIM.Agent, payload []byte) {
+	packet, err := pkt.Read(bytes.NewBuffer(payload))
+	if err != nil {
+		return
+	}
+	if basic, ok := packet.(*pkt.BasicPkt); ok {
+		if basic.Code == pkt.CodePing {
+			_ = ag.Push(pkt.Marshal(&pkt.BasicPkt{Code: pkt.CodePong}))
+		}
+		return
+	}
+	req := packet.(*pkt.LogicPkt)
+	resp := pkt.NewForm(&req.Header)
+	resp.Flag = pkt.Flag_Response
+	switch req.Command {
+	case wire.CommandChatUserTalk:
+		resp.WriteBody(&pkt.MessageResp{MessageId: 100, SendTime: 1})
+		push := pkt.NewForm(&req.Header)
+		push.Flag = pkt.Flag_Push
+		push.WriteBody(&pkt.MessagePush{MessageId: 101, Body: "pong", Sender: req.Dest})
+		_ = ag.Push(pkt.Marshal(push))
+	case wire.CommandTransientUser:
+		resp.WriteBody(&pkt.TransientResp{Online: true, Delivered: 1})
+		push := pkt.NewForm(&req.Header)
+		push.Flag = pkt.Flag_Push
+		push.WriteBody(&pkt.TransientPush{Type: wire.TransientTyping, Sender: req.Dest})
+		_ = ag.Push(pkt.Marshal(push))
+	case wire.CommandOfflineIndex:
+		atomic.AddInt32(&g.offlineSync, 1)
+		var index pkt.MessageIndexReq
+		_ = req.ReadBody(&index)
+		resp.WriteBody(g.indexPage(index.GetMessageId()))
+	case wire.CommandOfflineContent:
+		var content pkt.MessageContentReq
+		_ = req.ReadBody(&content)
+		contents := make([]*pkt.MessageContent, len(content.GetMessageIds()))
+		for i, id := range content.GetMessageIds() {
+			contents[i] = &pkt.MessageContent{MessageId: id, Body: fmt.Sprint(id)}
+		}
+		resp.WriteBody(&pkt.MessageContentResp{Contents: contents})
+	case wire.CommandChatHistory:
+		var history pkt.HistoryReq
+		_ = req.ReadBody(&history)
+		page := &pkt.HistoryResp{}
+		for _, m := range g.history {
+			if m.Seq > history.GetSeq() && len(page.Messages) < int(history.GetLimit()) {
+				page.Messages = append(page.Messages, m)
+			}
+		}
+		resp.WriteBody(page)
+	case wire.CommandMediaToken:
+		resp.WriteBody(&pkt.MediaTokenResp{Key: "app/1/a", UploadUrl: g.uploadURL, Method: "PUT"})
+	case wire.CommandMediaComplete:
+		resp.WriteBody(&pkt.MediaInfo{Key: "app/1/a", Width: 10, Height: 20})
+	default:
+		resp.Status = pkt.Status_NotImplemented
+		resp.WriteBody(&pkt.ErrorResp{Message: "NotImplemented"})
+	}
+	_ = ag.Push(pkt.Marshal(resp))
+}
+
+// indexPage 返回cursor之后的一页离线消息索引
+func (g *fakeGateway) indexPage(cursor int64) *pkt.MessageIndexResp {
+	resp := &pkt.MessageIndexResp{}
+	for _, id := range g.backlog {
+		if id <= cursor {
+			continue
+		}
+		if len(resp.Indexes) == 2 {
+			resp.HasMore = true
+			break
+		}
+		resp.Indexes = append(resp.Indexes, &pkt.MessageIndex{MessageId: id, AccountB: "test2"})
+	}
+	return resp
+}
+
+// push 给所有连接推送一条单聊消息
+func (g *fakeGateway) push(msg *pkt.MessagePush) error {
+	packet := pkt.New(wire.CommandChatUserTalk)
+	packet.Flag = pkt.Flag_Push
+	packet.WriteBody(msg)
+	g.Lock()
+	defer g.Unlock()
+	for _, conn := range g.conns {
+		if err := conn.WriteFrame(EIM.OpBinary, pkt.Marshal(packet)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *fakeGateway) Disconnect(id string) error {
+	return nil
+}
+
+// closeAll 从服务端断开所有连接
+func (g *fakeGateway) closeAll() {
+	g.Lock()
+	defer g.Unlock()
+	for _, conn := range g.conns {
+		_ = conn.Close()
+	}
+	g.conns = nil
+}
+
+func startGateway(t *testing.T) (*fakeGateway, string) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lst.Addr().String()
+	_ = lst.Close()
+
+	gateway := &fakeGateway{}
+	srv := websocket.NewServer(addr, &naming.DefaultService{Id: "gate01", Protocol: "ws"})
+	srv.SetAcceptor(gateway)
+	srv.SetMessageListener(gateway)
+	srv.SetStateListener(gateway)
+	go func() {
+		_ = srv.Start()
+	}()
+	// 等待服务端开始监听
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return gateway, "ws://" + addr
+}
+
+func TestClientTalk(t *testing.T) {
+	_, addr := startGateway(t)
+
+	msgs := make(chan *Message, 1)
+	cli := NewClient(addr, "token", Options{}, Callbacks{
+		OnMessage: func(msg *Message) {
+			msgs <- msg
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if cli.Account() != "test1" {
+		t.Fatalf("Account() = %s, want test1", cli.Account())
+	}
+
+	resp, err := cli.Talk("test2", &pkt.MessageReq{Type: 1, Body: "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MessageId != 100 {
+		t.Fatalf("MessageId = %d, want 100", resp.MessageId)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Body != "pong" || msg.Sender != "test2" {
+			t.Fatalf("unexpected message %v", msg)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("push message not received")
+	}
+	if cli.LastMessageId() != 101 {
+		t.Fatalf("LastMessageId() = %d, want 101", cli.LastMessageId())
+	}
+
+	_, err = cli.Request(wire.CommandGroupDetail, "", &pkt.GroupGetReq{GroupId: "g1"})
+	if serr, ok := err.(*StatusError); !ok || serr.Status != pkt.Status_NotImplemented {
+		t.Fatalf("Request() error = %v, want NotImplemented", err)
+	}
+}
+
+func TestClientTransient(t *testing.T) {
+	_, addr := startGateway(t)
+
+	msgs := make(chan *Transient, 1)
+	cli := NewClient(addr, "token", Options{}, Callbacks{
+		OnTransient: func(msg *Transient) {
+			msgs <- msg
+		},
+		OnMessage: func(msg *Message) {
+			t.Errorf("transient message delivered as a message: %v", msg)
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	resp, err := cli.SendTransient("test2", wire.TransientTyping, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Online || resp.Delivered != 1 {
+		t.Fatalf("unexpected resp %v", resp)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Type != wire.TransientTyping || msg.Sender != "test2" || msg.Group != "" {
+			t.Fatalf("unexpected transient %v", msg)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("transient message not received")
+	}
+	if cli.LastMessageId() != 0 {
+		t.Fatalf("LastMessageId() = %d, want 0", cli.LastMessageId())
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	gateway, addr := startGateway(t)
+
+	states := make(chan State, 10)
+	cli := NewClient(addr, "token", Options{
+		MinBackoff: time.Millisecond * 50,
+	}, Callbacks{
+		OnStateChange: func(state State) {
+			states <- state
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	first := cli.ChannelId()
+
+	gateway.closeAll()
+	timeout := time.After(time.Second * 5)
+	for reconnected := false; !reconnected; {
+		select {
+		case state := <-states:
+			reconnected = state == StateConnected && cli.ChannelId() != first
+		case <-timeout:
+			t.Fatal("client did not reconnect")
+		}
+	}
+	// 重连后会同步离线消息
+	for atomic.LoadInt32(&gateway.offlineSync) == 0 {
+		select {
+		case <-timeout:
+			t.Fatal("offline messages not synced after reconnect")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
+
+func TestClientSyncOffline(t *testing.T) {
+	gateway, addr := startGateway(t)
+	gateway.backlog = []int64{11, 12, 13, 14, 15}
+
+	var received []int64
+	cli := NewClient(addr, "token", Options{Device: "phone"}, Callbacks{
+		OnMessage: func(msg *Message) {
+			received = append(received, msg.MessageId)
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	cli.SetLastMessageId(12)
+
+	// 从最后收到的消息开始, 翻页直到没有更多的消息
+	count, err := cli.SyncOffline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(received) != 3 || received[0] != 13 || received[2] != 15 {
+		t.Fatalf("unexpected messages %v", received)
+	}
+	if atomic.LoadInt32(&gateway.offlineSync) != 2 {
+		t.Fatalf("expect 2 index pages, got %d", gateway.offlineSync)
+	}
+	if cli.LastMessageId() != 15 {
+		t.Fatalf("LastMessageId() = %d, want 15", cli.LastMessageId())
+	}
+}
+
+func TestClientDeduplicate(t *testing.T) {
+	_, addr := startGateway(t)
+
+	var count int32
+	cli := NewClient(addr, "token", Options{}, Callbacks{
+		OnMessage: func(msg *Message) {
+			atomic.AddInt32(&count, 1)
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// 重试使用同一个req, 网关每次都推送同一条消息
+	req := &pkt.MessageReq{Type: 1, Body: "ping"}
+	for i := 0; i < 2; i++ {
+		if _, err := cli.Talk("test2", req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if req.ClientId == "" {
+		t.Fatal("client id should be generated")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Fatalf("expect message delivered once, got %d", n)
+	}
+}
+
+func TestClientFetchGap(t *testing.T) {
+	gateway, addr := startGateway(t)
+	for seq := int64(1); seq <= 4; seq++ {
+		gateway.history = append(gateway.history, &pkt.MessagePush{MessageId: 200 + seq, Sender: "test2", Seq: seq})
+	}
+
+	msgs := make(chan *Message, 4)
+	cli := NewClient(addr, "token", Options{}, Callbacks{
+		OnMessage: func(msg *Message) {
+			msgs <- msg
+		},
+	})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// 序号2和3的推送丢失, 收到4时从历史消息中补齐
+	for _, i := range []int{0, 3} {
+		if err := gateway.push(gateway.history[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := make(map[int64]bool)
+	for len(received) < 4 {
+		select {
+		case msg := <-msgs:
+			if msg.Peer != "test2" || received[msg.Seq] {
+				t.Fatalf("unexpected message %v", msg)
+			}
+			received[msg.Seq] = true
+			if (msg.Seq == 2 || msg.Seq == 3) != msg.Offline {
+				t.Fatalf("message %d: Offline = %v", msg.Seq, msg.Offline)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("missing messages not fetched, received %v", received)
+		}
+	}
+	if seq := cli.LastSeq(wire.ConversationUser, "test2"); seq != 4 {
+		t.Fatalf("LastSeq() = %d, want 4", seq)
+	}
+}
+
+func TestClientUploadMedia(t *testing.T) {
+	gateway, addr := startGateway(t)
+	uploaded := make(chan string, 1)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || r.ContentLength != 5 || r.Header.Get("Content-Type") != "image/png" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		uploaded <- string(data)
+	}))
+	defer storage.Close()
+	gateway.uploadURL = storage.URL + "/media/object/app/1/a?signature=x"
+
+	cli := NewClient(addr, "token", Options{}, Callbacks{})
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	info, err := cli.UploadMedia(context.Background(), &pkt.MediaTokenReq{Type: wire.MessageTypeImage, Mime: "image/png", Size: 5}, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "app/1/a" || info.Width != 10 || <-uploaded != "hello" {
+		t.Fatalf("unexpected info %v", info)
+	}
+
+	msg := &Message{MessagePush: &pkt.MessagePush{Type: wire.MessageTypeImage, Body: "app/1/a", Extra: `{"key":"app/1/a","width":10,"extra":"x"}`}}
+	if media, err := msg.Media(); err != nil || media.Width != 10 || media.Extra != "x" {
+		t.Fatalf("Media() = %v, %v", media, err)
+	}
+	msg.Type = wire.MessageTypeText
+	if _, err = msg.Media(); err != ErrNotMedia {
+		t.Fatalf("Media() error = %v, want ErrNotMedia", err)
+	}
+}
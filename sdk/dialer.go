@@ -0,0 +1,104 @@
+package sdk
+
+import (
+	"EIM"
+	"EIM/tcp"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// loginDialer 拨号并完成登录握手, 实现了EIM.Dialer接口
+type loginDialer struct {
+	protocol string
+	token    string
+	device   string
+	resp     *pkt.LoginResp
+}
+
+// DialAndHandshake 拨号后发送一个登录包, 并等待网关返回登录结果
+func (d *loginDialer) DialAndHandshake(ctx EIM.DialerContext) (net.Conn, error) {
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 发送登录包
+	req := pkt.New(wire.CommandLoginSignIn, pkt.WithSeq(wire.Seq.Next())).WriteBody(&pkt.LoginReq{
+		Token:  d.token,
+		Device: d.device,
+	})
+	_ = conn.SetWriteDeadline(time.Now().Add(ctx.Timeout))
+	if d.protocol == ProtocolTCP {
+		err = tcp.WriteFrame(conn, EIM.OpBinary, pkt.Marshal(req))
+	} else {
+		err = wsutil.WriteClientBinary(conn, pkt.Marshal(req))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// 等待登录结果
+	_ = conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	payload, err := d.read(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack, err := pkt.MustReadLogicPkt(bytes.NewBuffer(payload))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ack.Status != pkt.Status_Success {
+		conn.Close()
+		return nil, fmt.Errorf("login failed: %v", &ack.Header)
+	}
+	resp := new(pkt.LoginResp)
+	_ = ack.ReadBody(resp)
+	d.resp = resp
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dial 根据协议拨号
+func (d *loginDialer) dial(ctx EIM.DialerContext) (net.Conn, error) {
+	if d.protocol == ProtocolTCP {
+		return net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+	}
+	dialCtx, cancel := context.WithTimeout(context.TODO(), ctx.Timeout)
+	defer cancel()
+	conn, _, _, err := ws.Dial(dialCtx, ctx.Address)
+	return conn, err
+}
+
+// read 读取一帧登录应答
+func (d *loginDialer) read(conn net.Conn) ([]byte, error) {
+	var (
+		code    EIM.OpCode
+		payload []byte
+	)
+	if d.protocol == ProtocolTCP {
+		frame, err := tcp.NewConn(conn).ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		code, payload = frame.GetOpCode(), frame.GetPayload()
+	} else {
+		frame, err := ws.ReadFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		code, payload = EIM.OpCode(frame.Header.OpCode), frame.Payload
+	}
+	if code == EIM.OpClose {
+		return nil, fmt.Errorf("remote side close the channel: %s", payload)
+	}
+	return payload, nil
+}
@@ -0,0 +1,103 @@
+package sdk
+
+import (
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ErrNotMedia 消息不是图片, 语音或视频
+var ErrNotMedia = errors.New("sdk: message is not a media message")
+
+// MediaToken 申请上传地址, req.Size为文件的字节数, 上传的内容不能超过它
+func (c *Client) MediaToken(req *pkt.MediaTokenReq) (*pkt.MediaTokenResp, error) {
+	resp, err := c.Request(wire.CommandMediaToken, "", req)
+	if err != nil {
+		return nil, err
+	}
+	var tokenResp pkt.MediaTokenResp
+	if err = resp.ReadBody(&tokenResp); err != nil {
+		return nil, err
+	}
+	return &tokenResp, nil
+}
+
+// CompleteMedia 上传完成后调用, 返回服务端解析出的元数据, 之后key才能作为媒体消息的body发送
+func (c *Client) CompleteMedia(key string) (*pkt.MediaInfo, error) {
+	resp, err := c.Request(wire.CommandMediaComplete, "", &pkt.MediaCompleteReq{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	var info pkt.MediaInfo
+	if err = resp.ReadBody(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// UploadMedia 申请上传地址, 把r中req.Size字节的内容上传到对象存储, 然后完成上传
+func (c *Client) UploadMedia(ctx context.Context, req *pkt.MediaTokenReq, r io.Reader) (*pkt.MediaInfo, error) {
+	token, err := c.MediaToken(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = upload(ctx, token, req, r); err != nil {
+		return nil, err
+	}
+	return c.CompleteMedia(token.GetKey())
+}
+
+// upload 使用签名的地址上传, Content-Length必须与申请时的大小一致
+func upload(ctx context.Context, token *pkt.MediaTokenResp, req *pkt.MediaTokenReq, r io.Reader) error {
+	method := token.GetMethod()
+	if method == "" {
+		method = http.MethodPut
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, token.GetUploadUrl(), io.LimitReader(r, req.GetSize()))
+	if err != nil {
+		return err
+	}
+	httpReq.ContentLength = req.GetSize()
+	httpReq.Header.Set("Content-Type", req.GetMime())
+	response, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("sdk: upload %s returns %d %s", token.GetKey(), response.StatusCode, msg)
+	}
+	return nil
+}
+
+// MediaUrls 获取媒体文件或缩略图的下载地址, 地址会过期, 不存在的key不返回
+func (c *Client) MediaUrls(keys ...string) ([]*pkt.MediaUrl, error) {
+	resp, err := c.Request(wire.CommandMediaUrl, "", &pkt.MediaUrlReq{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	var urlResp pkt.MediaUrlResp
+	if err = resp.ReadBody(&urlResp); err != nil {
+		return nil, err
+	}
+	return urlResp.GetUrls(), nil
+}
+
+// Media 解析媒体消息extra中的元数据, 发送时的extra在返回值的Extra中
+func (m *Message) Media() (*pkt.MediaInfo, error) {
+	if wire.MediaMaxSize(m.GetType()) == 0 {
+		return nil, ErrNotMedia
+	}
+	var info pkt.MediaInfo
+	if err := protojson.Unmarshal([]byte(m.GetExtra()), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
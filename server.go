@@ -1,7 +1,10 @@
 package EIM
 
 import (
+	"EIM/wire/endian"
 	"context"
+	"encoding/binary"
+	"errors"
 	"net"
 	"time"
 )
@@ -40,6 +43,8 @@ type Server interface {
 	SetStateListener(StateListener)     // 用于设置一个StateListener(连接状态监听服务)
 	SetReadWait(time.Duration)          // 用于设置一个连接读超时等待时间
 	SetChannelMap(ChannelMap)           // 用于设置一个ChannelMap(连接管理器)
+	SetMaxFrameSize(uint32)             // 用于设置单帧payload的最大长度
+	Listening() bool                    // 是否已经开始监听端口
 
 	// Start 用于在内部实现网络端口的监听和接收连接，并完成一个Channel的初始化过程。
 	Start() error
@@ -79,6 +84,14 @@ type Channel interface {
 	Readloop(lst MessageListener) error
 	SetWriteWait(time.Duration)
 	SetReadWait(time.Duration)
+	Stats() ChannelStats // 连接的统计信息
+}
+
+// ChannelStats 连接的统计信息
+type ChannelStats struct {
+	ConnectedAt time.Time // 建立连接的时间
+	BytesIn     uint64    // 收到的payload字节数
+	BytesOut    uint64    // 发送的payload字节数
 }
 
 // Frame 数据包
@@ -109,6 +122,47 @@ const (
 	OpPong         OpCode = 0xa
 )
 
+// ErrFrameTooLarge 帧长度超过了允许的最大值
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// 关闭连接时的原因码, 与websocket协议中的关闭码保持一致
+const (
+	CloseNormal        uint16 = 1000
+	CloseProtocolError uint16 = 1002
+	CloseMessageTooBig uint16 = 1009
+)
+
+// CloseReason 生成OpClose帧的payload, 前2byte为原因码(大端序), 后面为原因描述
+func CloseReason(code uint16, reason string) []byte {
+	buf := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(buf, code)
+	copy(buf[2:], reason)
+	return buf
+}
+
+// ReasonCloser 可以带原因码关闭的Agent, 收到无法解析的消息时用于断开连接
+type ReasonCloser interface {
+	CloseWithReason(code uint16, reason string) error
+}
+
+// CloseCode 返回解析错误对应的原因码, 长度超限时为CloseMessageTooBig, 其它为CloseProtocolError
+func CloseCode(err error) uint16 {
+	if errors.Is(err, endian.ErrTooLarge) || errors.Is(err, ErrFrameTooLarge) {
+		return CloseMessageTooBig
+	}
+	return CloseProtocolError
+}
+
+// CloseAgent ag实现了ReasonCloser时带原因码关闭连接, 返回是否关闭
+func CloseAgent(ag Agent, code uint16, reason string) bool {
+	closer, ok := ag.(ReasonCloser)
+	if !ok {
+		return false
+	}
+	_ = closer.CloseWithReason(code, reason)
+	return true
+}
+
 // Client 客户端接口
 type Client interface {
 	Service
@@ -0,0 +1,329 @@
+package bench
+
+import (
+	"EIM/logger"
+	"EIM/sdk"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/token"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// 压测模式
+const (
+	ModeUser  = "user"
+	ModeGroup = "group"
+)
+
+// extraPrefix 消息Extra中携带发送时间, 用于计算端到端延迟
+const extraPrefix = "bench:"
+
+type StartOptions struct {
+	address     string
+	protocol    string
+	count       int
+	concurrency int
+	rate        int
+	duration    time.Duration
+	drain       time.Duration
+	timeout     time.Duration
+	mode        string
+	groupSize   int
+	prefix      string
+	app         string
+	format      string
+}
+
+// runner 一次压测的运行状态
+type runner struct {
+	opts    *StartOptions
+	clients []*sdk.Client
+	dests   []string // 每个客户端发送的目标, 用户或群
+
+	login    Recorder
+	ack      Recorder
+	endToEnd Recorder
+
+	loginFailed int64
+	sent        int64
+	sendFailed  int64
+	received    int64
+
+	errLock sync.Mutex
+	errors  map[string]int64
+}
+
+// RunBench 执行压测并输出报告
+func RunBench(ctx context.Context, opts *StartOptions, version string) error {
+	_ = logger.Init(logger.Settings{
+		Level: "error",
+	})
+	if opts.count < 1 {
+		return fmt.Errorf("count must be greater than 0")
+	}
+	if opts.mode != ModeUser && opts.mode != ModeGroup {
+		return fmt.Errorf("unknown mode %s", opts.mode)
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %s", opts.format)
+	}
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	r := &runner{
+		opts:   opts,
+		errors: make(map[string]int64),
+	}
+	r.connect()
+	defer r.close()
+
+	if err := r.prepare(); err != nil {
+		return err
+	}
+	elapsed := r.send(ctx)
+
+	report := r.report(elapsed)
+	if opts.format == "json" {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+func (r *runner) account(i int) string {
+	return fmt.Sprintf("%s%d", r.opts.prefix, i)
+}
+
+func (r *runner) fail(err error) {
+	r.errLock.Lock()
+	r.errors[err.Error()]++
+	r.errLock.Unlock()
+}
+
+// connect 并发登录所有连接, 登录失败的连接为nil
+func (r *runner) connect() {
+	r.clients = make([]*sdk.Client, r.opts.count)
+	sem := make(chan struct{}, r.opts.concurrency)
+	var wg sync.WaitGroup
+	for i := range r.clients {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			cli, err := r.login1(r.account(i))
+			if err != nil {
+				atomic.AddInt64(&r.loginFailed, 1)
+				r.fail(err)
+				return
+			}
+			r.clients[i] = cli
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (r *runner) login1(account string) (*sdk.Client, error) {
+	tk, err := token.Generate(token.DefaultKey, &token.Token{
+		Account: account,
+		App:     r.opts.app,
+		Exp:     time.Now().AddDate(0, 0, 1).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	cli := sdk.NewClient(r.opts.address, tk, sdk.Options{
+		Protocol:         r.opts.protocol,
+		RequestTimeout:   r.opts.timeout,
+		DisableReconnect: true,
+	}, sdk.Callbacks{
+		OnMessage: r.onMessage,
+	})
+	cli.SetAccount(account)
+	start := time.Now()
+	if err = cli.Connect(); err != nil {
+		return nil, err
+	}
+	r.login.Add(time.Since(start))
+	return cli, nil
+}
+
+func (r *runner) onMessage(msg *sdk.Message) {
+	if msg.Offline || !strings.HasPrefix(msg.Extra, extraPrefix) {
+		return
+	}
+	sendTime, err := strconv.ParseInt(strings.TrimPrefix(msg.Extra, extraPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+	atomic.AddInt64(&r.received, 1)
+	r.endToEnd.Add(time.Since(time.Unix(0, sendTime)))
+}
+
+// prepare 确定每个连接的发送目标, 群聊模式下按groupSize分组建群
+func (r *runner) prepare() error {
+	n := len(r.clients)
+	r.dests = make([]string, n)
+	if r.opts.mode == ModeUser {
+		for i := range r.clients {
+			r.dests[i] = r.account((i + 1) % n)
+		}
+		return nil
+	}
+	size := r.opts.groupSize
+	if size < 2 {
+		return fmt.Errorf("group size must be at least 2")
+	}
+	for i := 0; i < n; i += size {
+		owner := r.clients[i]
+		if owner == nil {
+			continue
+		}
+		members := make([]string, 0, size)
+		for j := i; j < i+size && j < n; j++ {
+			members = append(members, r.account(j))
+		}
+		resp, err := owner.CreateGroup(&pkt.GroupCreateReq{
+			Name:    fmt.Sprintf("bench-%s", r.account(i)),
+			Owner:   owner.Account(),
+			Members: members,
+		})
+		if err != nil {
+			return fmt.Errorf("create group failed: %v", err)
+		}
+		for j := i; j < i+size && j < n; j++ {
+			r.dests[j] = resp.GroupId
+		}
+	}
+	return nil
+}
+
+// send 按固定速率轮流使用各连接发送消息, 返回发送阶段的耗时
+func (r *runner) send(ctx context.Context) time.Duration {
+	var senders []int
+	for i, cli := range r.clients {
+		if cli != nil && r.dests[i] != "" {
+			senders = append(senders, i)
+		}
+	}
+	start := time.Now()
+	if len(senders) == 0 || r.opts.rate < 1 {
+		return time.Since(start)
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(r.opts.rate))
+	defer ticker.Stop()
+	deadline := time.After(r.opts.duration)
+
+	var wg sync.WaitGroup
+	for next := 0; ; next++ {
+		select {
+		case <-ctx.Done():
+		case <-deadline:
+		case <-ticker.C:
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r.send1(i)
+			}(senders[next%len(senders)])
+			continue
+		}
+		break
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// 等待推送消息到达
+	time.Sleep(r.opts.drain)
+	return elapsed
+}
+
+func (r *runner) send1(i int) {
+	cli := r.clients[i]
+	req := &pkt.MessageReq{
+		Type:  wire.MessageTypeText,
+		Body:  "hello from " + cli.Account(),
+		Extra: extraPrefix + strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	atomic.AddInt64(&r.sent, 1)
+	start := time.Now()
+	var err error
+	if r.opts.mode == ModeGroup {
+		_, err = cli.GroupTalk(r.dests[i], req)
+	} else {
+		_, err = cli.Talk(r.dests[i], req)
+	}
+	if err != nil {
+		atomic.AddInt64(&r.sendFailed, 1)
+		r.fail(err)
+		return
+	}
+	r.ack.Add(time.Since(start))
+}
+
+func (r *runner) close() {
+	for _, cli := range r.clients {
+		if cli != nil {
+			cli.Close()
+		}
+	}
+}
+
+func (r *runner) report(elapsed time.Duration) *Report {
+	report := &Report{
+		Protocol:    r.opts.protocol,
+		Mode:        r.opts.mode,
+		Connections: r.opts.count,
+		Duration:    elapsed.Seconds(),
+		LoginFailed: int(atomic.LoadInt64(&r.loginFailed)),
+		Login:       r.login.Latency(),
+		Sent:        atomic.LoadInt64(&r.sent),
+		SendFailed:  atomic.LoadInt64(&r.sendFailed),
+		Received:    atomic.LoadInt64(&r.received),
+		Ack:         r.ack.Latency(),
+		EndToEnd:    r.endToEnd.Latency(),
+		Errors:      r.errors,
+	}
+	report.LoginSuccess = report.Login.Count
+	if elapsed > 0 {
+		report.Throughput = float64(report.Sent-report.SendFailed) / elapsed.Seconds()
+	}
+	if report.Sent > 0 {
+		report.ErrorRate = float64(report.SendFailed) / float64(report.Sent)
+	}
+	return report
+}
+
+func NewBenchCmd(ctx context.Context, version string) *cobra.Command {
+	opts := &StartOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "load test gateways with concurrent clients",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunBench(ctx, opts, version)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&opts.address, "address", "a", "ws://localhost:8000", "gateway address")
+	cmd.PersistentFlags().StringVarP(&opts.protocol, "protocol", "p", "ws", "protocol of ws or tcp")
+	cmd.PersistentFlags().IntVarP(&opts.count, "count", "c", 100, "number of concurrent connections")
+	cmd.PersistentFlags().IntVar(&opts.concurrency, "login-concurrency", 50, "number of logins in flight")
+	cmd.PersistentFlags().IntVarP(&opts.rate, "rate", "r", 100, "messages sent per second in total")
+	cmd.PersistentFlags().DurationVarP(&opts.duration, "duration", "d", time.Second*30, "duration of sending messages")
+	cmd.PersistentFlags().DurationVar(&opts.drain, "drain", time.Second*2, "time to wait for pushes after sending")
+	cmd.PersistentFlags().DurationVar(&opts.timeout, "timeout", time.Second*5, "request timeout")
+	cmd.PersistentFlags().StringVarP(&opts.mode, "mode", "m", ModeUser, "message mode of user or group")
+	cmd.PersistentFlags().IntVar(&opts.groupSize, "group-size", 10, "members per group in group mode")
+	cmd.PersistentFlags().StringVar(&opts.prefix, "prefix", "bench_", "account prefix")
+	cmd.PersistentFlags().StringVar(&opts.app, "app", "EIM", "app of the generated tokens")
+	cmd.PersistentFlags().StringVarP(&opts.format, "output", "o", "text", "report format of text or json")
+	return cmd
+}
@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency 延迟分布, 单位为毫秒
+type Latency struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Recorder 记录一组延迟样本, 并发安全
+type Recorder struct {
+	sync.Mutex
+	samples []time.Duration
+}
+
+// Add 添加一个样本
+func (r *Recorder) Add(d time.Duration) {
+	r.Lock()
+	r.samples = append(r.samples, d)
+	r.Unlock()
+}
+
+// Latency 计算延迟分布
+func (r *Recorder) Latency() Latency {
+	r.Lock()
+	samples := make([]time.Duration, len(r.samples))
+	copy(samples, r.samples)
+	r.Unlock()
+
+	l := Latency{Count: len(samples)}
+	if len(samples) == 0 {
+		return l
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	l.Min = ms(samples[0])
+	l.Max = ms(samples[len(samples)-1])
+	l.Avg = ms(sum / time.Duration(len(samples)))
+	l.P50 = ms(percentile(samples, 50))
+	l.P90 = ms(percentile(samples, 90))
+	l.P99 = ms(percentile(samples, 99))
+	return l
+}
+
+// percentile 最近秩法, samples必须已排序
+func percentile(samples []time.Duration, p int) time.Duration {
+	idx := (len(samples)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return samples[idx]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Report 压测报告
+type Report struct {
+	Protocol    string  `json:"protocol"`
+	Mode        string  `json:"mode"`
+	Connections int     `json:"connections"`
+	Duration    float64 `json:"duration_seconds"`
+
+	LoginSuccess int     `json:"login_success"`
+	LoginFailed  int     `json:"login_failed"`
+	Login        Latency `json:"login_ms"`
+
+	Sent       int64   `json:"sent"`
+	SendFailed int64   `json:"send_failed"`
+	Received   int64   `json:"received"`
+	Throughput float64 `json:"throughput"` // 每秒成功发送的消息数
+	ErrorRate  float64 `json:"error_rate"`
+	Ack        Latency `json:"ack_ms"`        // 发送到收到应答的延迟
+	EndToEnd   Latency `json:"end_to_end_ms"` // 发送到接收方收到推送的延迟
+
+	Errors map[string]int64 `json:"errors,omitempty"`
+}
+
+// WriteJSON 以JSON格式输出报告
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText 以文本格式输出报告
+func (r *Report) WriteText(w io.Writer) error {
+	var err error
+	p := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format+"\n", args...)
+		}
+	}
+	latency := func(name string, l Latency) {
+		p("%-12s count=%d min=%.2fms avg=%.2fms p50=%.2fms p90=%.2fms p99=%.2fms max=%.2fms",
+			name, l.Count, l.Min, l.Avg, l.P50, l.P90, l.P99, l.Max)
+	}
+	p("protocol:    %s", r.Protocol)
+	p("mode:        %s", r.Mode)
+	p("connections: %d", r.Connections)
+	p("duration:    %.2fs", r.Duration)
+	p("login:       success=%d failed=%d", r.LoginSuccess, r.LoginFailed)
+	latency("login", r.Login)
+	p("messages:    sent=%d failed=%d received=%d", r.Sent, r.SendFailed, r.Received)
+	p("throughput:  %.2f msg/s", r.Throughput)
+	p("error rate:  %.2f%%", r.ErrorRate*100)
+	latency("ack", r.Ack)
+	latency("end-to-end", r.EndToEnd)
+	for e, n := range r.Errors {
+		p("error:       %s (%d)", e, n)
+	}
+	return err
+}
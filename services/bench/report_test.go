@@ -0,0 +1,23 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorderLatency(t *testing.T) {
+	r := &Recorder{}
+	for i := 100; i >= 1; i-- {
+		r.Add(time.Duration(i) * time.Millisecond)
+	}
+	l := r.Latency()
+	if l.Count != 100 || l.Min != 1 || l.Max != 100 {
+		t.Fatalf("unexpected latency %+v", l)
+	}
+	if l.P50 != 50 || l.P90 != 90 || l.P99 != 99 || l.Avg != 50.5 {
+		t.Fatalf("unexpected percentiles %+v", l)
+	}
+	if empty := (&Recorder{}).Latency(); empty.Count != 0 || empty.Max != 0 {
+		t.Fatalf("unexpected latency of empty recorder %+v", empty)
+	}
+}
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"EIM/logger"
+	"EIM/sdk"
+	"EIM/wire/token"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type StartOptions struct {
+	address  string
+	protocol string
+	token    string
+	account  string
+	app      string
+	script   string
+	timeout  time.Duration
+}
+
+// RunCli 连接网关并执行交互式或脚本中的指令
+func RunCli(ctx context.Context, opts *StartOptions, version string) error {
+	_ = logger.Init(logger.Settings{
+		Level: "warn",
+	})
+	tk := opts.token
+	if tk == "" {
+		if opts.account == "" {
+			return fmt.Errorf("token or account is required")
+		}
+		var err error
+		tk, err = token.Generate(token.DefaultKey, &token.Token{
+			Account: opts.account,
+			App:     opts.app,
+			Exp:     time.Now().AddDate(0, 0, 1).Unix(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	shell := NewShell(os.Stdout)
+	cli := sdk.NewClient(opts.address, tk, sdk.Options{
+		Protocol:       opts.protocol,
+		RequestTimeout: opts.timeout,
+	}, shell.Callbacks())
+	if opts.account != "" {
+		cli.SetAccount(opts.account)
+	}
+	if err := cli.Connect(); err != nil {
+		return err
+	}
+	defer cli.Close()
+	shell.SetClient(cli)
+	shell.Printf("logged in as %s, channel %s", cli.Account(), cli.ChannelId())
+
+	// 脚本模式: 逐行执行, 遇到错误即退出
+	if opts.script != "" {
+		f, err := os.Open(opts.script)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return runScript(shell, f)
+	}
+	// 交互模式
+	shell.Printf("type help for usage")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		err := shell.Exec(scanner.Text())
+		if err == ErrExit {
+			return nil
+		}
+		if err != nil {
+			shell.Printf("[error] %v", err)
+		}
+	}
+}
+
+// runScript 执行脚本中的每一行指令
+func runScript(shell *Shell, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		shell.Printf("> %s", line)
+		err := shell.Exec(line)
+		if err == ErrExit {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func NewClientStartCmd(ctx context.Context, version string) *cobra.Command {
+	opts := &StartOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "cli",
+		Short: "start an interactive chat client",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunCli(ctx, opts, version)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&opts.address, "address", "a", "ws://localhost:8000", "gateway address")
+	cmd.PersistentFlags().StringVarP(&opts.protocol, "protocol", "p", "ws", "protocol of ws or tcp")
+	cmd.PersistentFlags().StringVarP(&opts.token, "token", "t", "", "login token")
+	cmd.PersistentFlags().StringVarP(&opts.account, "account", "u", "", "generate a token for this account with the default key")
+	cmd.PersistentFlags().StringVar(&opts.app, "app", "EIM", "app of the generated token")
+	cmd.PersistentFlags().StringVarP(&opts.script, "script", "f", "", "read commands from a file instead of stdin")
+	cmd.PersistentFlags().DurationVar(&opts.timeout, "timeout", time.Second*10, "request timeout")
+	return cmd
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"EIM/sdk"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrExit 执行exit指令
+var ErrExit = errors.New("exit")
+
+// pushBodies 推送消息的command与body类型的对应关系
+var pushBodies = map[string]func() proto.Message{
+	wire.CommandGroupCreate:  func() proto.Message { return new(pkt.GroupCreateNotify) },
+	wire.CommandGroupJoin:    func() proto.Message { return new(pkt.GroupJoinNotify) },
+	wire.CommandGroupQuit:    func() proto.Message { return new(pkt.GroupQuitNotify) },
+	wire.CommandSystemNotice: func() proto.Message { return new(pkt.SystemNotice) },
+}
+
+const usage = `commands:
+  talk <account> <text>           send a message to a user
+  gtalk <group> <text>            send a message to a group
+  create <name> <member,...>      create a group, the owner is yourself
+  join <group> [account]          join a group
+  quit <group> [account]          quit a group
+  detail <group>                  show group details and members
+  sync                            sync offline messages
+  sleep <duration>                wait for a while, e.g. sleep 500ms
+  help                            show this help
+  exit                            quit the cli`
+
+// Shell 解析并执行指令, 同时负责打印收到的推送消息
+type Shell struct {
+	sync.Mutex
+	cli *sdk.Client
+	out io.Writer
+}
+
+// NewShell 创建一个Shell
+func NewShell(out io.Writer) *Shell {
+	return &Shell{out: out}
+}
+
+// SetClient 设置Shell使用的客户端
+func (s *Shell) SetClient(cli *sdk.Client) {
+	s.cli = cli
+}
+
+// Callbacks 返回打印推送消息的回调
+func (s *Shell) Callbacks() sdk.Callbacks {
+	return sdk.Callbacks{
+		OnMessage: func(msg *sdk.Message) {
+			from := msg.Sender
+			if msg.Group != "" {
+				from = fmt.Sprintf("%s@%s", msg.Sender, msg.Group)
+			}
+			tag := "push"
+			if msg.Offline {
+				tag = "offline"
+			}
+			s.Printf("[%s] %s (id:%d type:%d time:%s): %s %s", tag, from, msg.MessageId, msg.Type,
+				time.Unix(0, msg.SendTime).Format("15:04:05"), msg.Body, msg.Extra)
+		},
+		OnKickout: func(notify *pkt.KickoutNotify) {
+			if notify.GetReason() != "" {
+				s.Printf("[kickout] channel %s: %s", notify.GetChannelId(), notify.GetReason())
+				return
+			}
+			s.Printf("[kickout] channel %s logged in elsewhere", notify.GetChannelId())
+		},
+		OnPush: func(p *pkt.LogicPkt) {
+			newBody, ok := pushBodies[p.Command]
+			if !ok {
+				s.Printf("[push] %s", p)
+				return
+			}
+			body := newBody()
+			if err := p.ReadBody(body); err != nil {
+				s.Printf("[push] %s invalid body: %v", p.Command, err)
+				return
+			}
+			s.Printf("[push] %s %v", p.Command, body)
+		},
+		OnStateChange: func(state sdk.State) {
+			s.Printf("[state] %s", state)
+		},
+	}
+}
+
+// Printf 打印一行输出
+func (s *Shell) Printf(format string, args ...interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	_, _ = fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+// Exec 执行一条指令
+func (s *Shell) Exec(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "help":
+		s.Printf(usage)
+		return nil
+	case "exit":
+		return ErrExit
+	case "sleep":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: sleep <duration>")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+	}
+	if s.cli == nil {
+		return sdk.ErrNotConnected
+	}
+	switch cmd {
+	case "talk", "gtalk":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: %s <dest> <text>", cmd)
+		}
+		req := &pkt.MessageReq{Type: wire.MessageTypeText, Body: strings.Join(args[1:], " ")}
+		var (
+			resp *pkt.MessageResp
+			err  error
+		)
+		if cmd == "talk" {
+			resp, err = s.cli.Talk(args[0], req)
+		} else {
+			resp, err = s.cli.GroupTalk(args[0], req)
+		}
+		if err != nil {
+			return err
+		}
+		s.Printf("[resp] %s %v", cmd, resp)
+	case "create":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: create <name> <member,...>")
+		}
+		resp, err := s.cli.CreateGroup(&pkt.GroupCreateReq{
+			Name:    args[0],
+			Members: strings.Split(args[1], ","),
+		})
+		if err != nil {
+			return err
+		}
+		s.Printf("[resp] create %v", resp)
+	case "join", "quit":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("usage: %s <group> [account]", cmd)
+		}
+		account := ""
+		if len(args) == 2 {
+			account = args[1]
+		}
+		if cmd == "join" {
+			resp, err := s.cli.JoinGroup(&pkt.GroupJoinReq{GroupId: args[0], Account: account})
+			if err != nil {
+				return err
+			}
+			if resp.GetPending() {
+				s.Printf("[resp] join %s pending, request %d", args[0], resp.GetRequestId())
+				return nil
+			}
+		} else if err := s.cli.QuitGroup(&pkt.GroupQuitReq{GroupId: args[0], Account: account}); err != nil {
+			return err
+		}
+		s.Printf("[resp] %s %s ok", cmd, args[0])
+	case "detail":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: detail <group>")
+		}
+		resp, err := s.cli.GroupDetail(args[0])
+		if err != nil {
+			return err
+		}
+		s.Printf("[resp] detail %v", resp)
+	case "sync":
+		count, err := s.cli.SyncOffline()
+		if err != nil {
+			return err
+		}
+		s.Printf("[resp] sync %d messages", count)
+	default:
+		return fmt.Errorf("unknown command %q, type help for usage", cmd)
+	}
+	return nil
+}
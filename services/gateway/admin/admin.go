@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// KickWait 发送KickoutNotify后等待多久再关闭连接, 让通知有机会写出去
+var KickWait = time.Second
+
+// SessionFinder 查询网关上的会话
+type SessionFinder interface {
+	Session(channelId string) (*pkt.Session, bool)
+	Sessions(account string) []*pkt.Session
+}
+
+// AdminApi 网关管理接口
+type AdminApi struct {
+	ServiceID string
+	Token     string
+	Channels  EIM.ChannelMap
+	Sessions  SessionFinder
+	started   time.Time
+}
+
+// NewAdminApi 创建AdminApi
+func NewAdminApi(serviceID, token string, channels EIM.ChannelMap, sessions SessionFinder) *AdminApi {
+	return &AdminApi{
+		ServiceID: serviceID,
+		Token:     token,
+		Channels:  channels,
+		Sessions:  sessions,
+		started:   time.Now(),
+	}
+}
+
+// ChannelInfo 连接信息
+type ChannelInfo struct {
+	ChannelID   string    `json:"channelId"`
+	Account     string    `json:"account"`
+	App         string    `json:"app,omitempty"`
+	RemoteIP    string    `json:"remoteIP"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	BytesIn     uint64    `json:"bytesIn"`
+	BytesOut    uint64    `json:"bytesOut"`
+}
+
+// ChannelsResp 连接列表
+type ChannelsResp struct {
+	Total    int           `json:"total"`
+	Channels []ChannelInfo `json:"channels"`
+}
+
+// LocationsResp 账号在本网关上的位置
+type LocationsResp struct {
+	Account   string         `json:"account"`
+	Locations []EIM.Location `json:"locations"`
+}
+
+// KickReq 踢下线请求
+type KickReq struct {
+	Reason string `json:"reason"`
+}
+
+// BroadcastReq 广播系统通知的请求
+type BroadcastReq struct {
+	Body  string `json:"body"`
+	Extra string `json:"extra"`
+}
+
+// BroadcastResp 广播结果
+type BroadcastResp struct {
+	Total  int `json:"total"`
+	Failed int `json:"failed"`
+}
+
+// StatsResp 网关的统计信息
+type StatsResp struct {
+	ServiceID string `json:"serviceId"`
+	Uptime    int64  `json:"uptime"` // 秒
+	Channels  int    `json:"channels"`
+	Accounts  int    `json:"accounts"`
+	BytesIn   uint64 `json:"bytesIn"`
+	BytesOut  uint64 `json:"bytesOut"`
+}
+
+// NewApp 创建管理接口的iris应用, 所有接口都需要在请求头中携带Authorization: Bearer <token>
+func NewApp(api *AdminApi) *iris.Application {
+	app := iris.New()
+	admin := app.Party("/admin", api.Auth)
+	{
+		admin.Get("/channels", api.ListChannels)
+		admin.Post("/channels/{id}/kick", api.Kick)
+		admin.Get("/locations/{account}", api.Locations)
+		admin.Post("/broadcast", api.Broadcast)
+		admin.Get("/stats", api.Stats)
+	}
+	return app
+}
+
+// Auth 校验管理员token
+func (a *AdminApi) Auth(c iris.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if a.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		c.StopWithStatus(iris.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// ListChannels 列出本网关上的连接, 按建立连接的时间排序
+// GET /admin/channels?account=&offset=&limit=
+func (a *AdminApi) ListChannels(c iris.Context) {
+	account := c.URLParam("account")
+	offset := c.URLParamIntDefault("offset", 0)
+	limit := c.URLParamIntDefault("limit", 100)
+
+	infos := make([]ChannelInfo, 0)
+	for _, ch := range a.Channels.All() {
+		info := a.channelInfo(ch)
+		if account != "" && info.Account != account {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	resp := ChannelsResp{Total: len(infos), Channels: []ChannelInfo{}}
+	if offset >= 0 && offset < len(infos) {
+		infos = infos[offset:]
+		if limit > 0 && limit < len(infos) {
+			infos = infos[:limit]
+		}
+		resp.Channels = infos
+	}
+	_ = c.JSON(&resp)
+}
+
+// Locations 查询账号在本网关上的位置
+// GET /admin/locations/{account}
+func (a *AdminApi) Locations(c iris.Context) {
+	account := c.Params().Get("account")
+	resp := LocationsResp{Account: account, Locations: []EIM.Location{}}
+	for _, s := range a.Sessions.Sessions(account) {
+		resp.Locations = append(resp.Locations, EIM.Location{ChannelId: s.ChannelId, GateId: s.GateId})
+	}
+	if len(resp.Locations) == 0 {
+		c.StopWithText(iris.StatusNotFound, "account %s is offline", account)
+		return
+	}
+	_ = c.JSON(&resp)
+}
+
+// Kick 给连接发送KickoutNotify后强制断开
+// POST /admin/channels/{id}/kick
+func (a *AdminApi) Kick(c iris.Context) {
+	id := c.Params().Get("id")
+	var req KickReq
+	if c.Request().ContentLength != 0 {
+		if err := c.ReadJSON(&req); err != nil {
+			c.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+	}
+	ch, ok := a.Channels.Get(id)
+	if !ok {
+		c.StopWithText(iris.StatusNotFound, "channel %s not found", id)
+		return
+	}
+	p := pkt.New(wire.CommandLoginSignIn, pkt.WithChannelId(id))
+	p.Flag = pkt.Flag_Push
+	p.WriteBody(&pkt.KickoutNotify{ChannelId: id, Reason: req.Reason})
+	if err := ch.Push(pkt.Marshal(p)); err != nil {
+		logger.WithField("module", "admin").Warn(err)
+	}
+	// 关闭连接后Readloop退出, 由server完成移除channel与通知登出
+	time.AfterFunc(KickWait, func() {
+		_ = ch.Close()
+	})
+	logger.WithFields(logger.Fields{
+		"module":  "admin",
+		"channel": id,
+	}).Infof("kicked, reason: %s", req.Reason)
+	_ = c.JSON(iris.Map{"channelId": id})
+}
+
+// Broadcast 给本网关上的所有连接发送一条系统通知
+// POST /admin/broadcast
+func (a *AdminApi) Broadcast(c iris.Context) {
+	var req BroadcastReq
+	if err := c.ReadJSON(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	p := pkt.New(wire.CommandSystemNotice)
+	p.Flag = pkt.Flag_Push
+	p.WriteBody(&pkt.SystemNotice{
+		Body:     req.Body,
+		Extra:    req.Extra,
+		SendTime: time.Now().UnixNano(),
+	})
+	payload := pkt.Marshal(p)
+
+	channels := a.Channels.All()
+	resp := BroadcastResp{Total: len(channels)}
+	for _, ch := range channels {
+		if err := ch.Push(payload); err != nil {
+			resp.Failed++
+		}
+	}
+	_ = c.JSON(&resp)
+}
+
+// Stats 网关的统计信息
+// GET /admin/stats
+func (a *AdminApi) Stats(c iris.Context) {
+	resp := StatsResp{
+		ServiceID: a.ServiceID,
+		Uptime:    int64(time.Since(a.started).Seconds()),
+	}
+	accounts := make(map[string]struct{})
+	for _, ch := range a.Channels.All() {
+		info := a.channelInfo(ch)
+		resp.Channels++
+		resp.BytesIn += info.BytesIn
+		resp.BytesOut += info.BytesOut
+		if info.Account != "" {
+			accounts[info.Account] = struct{}{}
+		}
+	}
+	resp.Accounts = len(accounts)
+	_ = c.JSON(&resp)
+}
+
+func (a *AdminApi) channelInfo(ch EIM.Channel) ChannelInfo {
+	stats := ch.Stats()
+	info := ChannelInfo{
+		ChannelID:   ch.ID(),
+		ConnectedAt: stats.ConnectedAt,
+		BytesIn:     stats.BytesIn,
+		BytesOut:    stats.BytesOut,
+	}
+	if s, ok := a.Sessions.Session(ch.ID()); ok {
+		info.Account = s.Account
+		info.App = s.App
+		info.RemoteIP = s.RemoteIP
+	}
+	return info
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"EIM"
+	"EIM/tcp"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sessions map[string]*pkt.Session
+
+func (s sessions) Session(channelId string) (*pkt.Session, bool) {
+	session, ok := s[channelId]
+	return session, ok
+}
+
+func (s sessions) Sessions(account string) []*pkt.Session {
+	var list []*pkt.Session
+	for _, session := range s {
+		if session.Account == account {
+			list = append(list, session)
+		}
+	}
+	return list
+}
+
+// newTestApp 创建一个只有一个连接的网关, 返回管理接口与客户端一侧的连接
+func newTestApp(t *testing.T) (*AdminApi, http.Handler, *tcp.TcpConn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+
+	channels := EIM.NewChannels(10)
+	channels.Add(EIM.NewChannel("gate01_test1_1", tcp.NewConn(server)))
+	api := NewAdminApi("gate01", "secret", channels, sessions{
+		"gate01_test1_1": {ChannelId: "gate01_test1_1", GateId: "gate01", Account: "test1", RemoteIP: "127.0.0.1"},
+	})
+	app := NewApp(api)
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	return api, app, tcp.NewConn(client)
+}
+
+func do(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func readPush(t *testing.T, conn *tcp.TcpConn) *pkt.LogicPkt {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pkt.MustReadLogicPkt(bytes.NewBuffer(frame.GetPayload()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuth(t *testing.T) {
+	_, app, _ := newTestApp(t)
+	if rec := do(app, http.MethodGet, "/admin/stats", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if rec := do(app, http.MethodGet, "/admin/stats", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if rec := do(app, http.MethodGet, "/admin/stats", "secret", ""); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestListChannelsAndLocations(t *testing.T) {
+	_, app, _ := newTestApp(t)
+	rec := do(app, http.MethodGet, "/admin/channels?account=test1", "secret", "")
+	var resp ChannelsResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || resp.Channels[0].Account != "test1" || resp.Channels[0].RemoteIP != "127.0.0.1" {
+		t.Fatalf("unexpected channels %+v", resp)
+	}
+
+	rec = do(app, http.MethodGet, "/admin/locations/test1", "secret", "")
+	var locs LocationsResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &locs); err != nil {
+		t.Fatal(err)
+	}
+	if len(locs.Locations) != 1 || locs.Locations[0].GateId != "gate01" {
+		t.Fatalf("unexpected locations %+v", locs)
+	}
+	if rec = do(app, http.MethodGet, "/admin/locations/nobody", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	_, app, client := newTestApp(t)
+	rec := do(app, http.MethodPost, "/admin/broadcast", "secret", `{"body":"maintenance at 24:00"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	p := readPush(t, client)
+	var notice pkt.SystemNotice
+	if err := p.ReadBody(&notice); err != nil {
+		t.Fatal(err)
+	}
+	if p.Command != wire.CommandSystemNotice || notice.Body != "maintenance at 24:00" {
+		t.Fatalf("unexpected push %s %v", p.Command, &notice)
+	}
+}
+
+func TestKick(t *testing.T) {
+	KickWait = time.Millisecond * 10
+	api, app, client := newTestApp(t)
+	if rec := do(app, http.MethodPost, "/admin/channels/unknown/kick", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	rec := do(app, http.MethodPost, "/admin/channels/gate01_test1_1/kick", "secret", `{"reason":"banned"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	p := readPush(t, client)
+	var notify pkt.KickoutNotify
+	_ = p.ReadBody(&notify)
+	if p.Command != wire.CommandLoginSignIn || notify.Reason != "banned" {
+		t.Fatalf("unexpected push %s %v", p.Command, &notify)
+	}
+	// 连接随后被关闭
+	_ = client.SetReadDeadline(time.Now().Add(time.Second * 3))
+	if _, err := client.ReadFrame(); err == nil {
+		t.Fatal("connection should be closed")
+	}
+	ch, _ := api.Channels.Get("gate01_test1_1")
+	if ch.Stats().BytesOut == 0 {
+		t.Fatal("bytes out should be counted")
+	}
+}
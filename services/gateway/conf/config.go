@@ -9,14 +9,28 @@ import (
 )
 
 type Config struct {
-	ServiceID     string   `envconfig:"serviceId"`
-	ServiceName   string   `envconfig:"serviceName"`
-	Namespace     string   `envconfig:"namespace"`
-	Listen        string   `envconfig:"listen"`
-	PublicAddress string   `envconfig:"publicAddress"`
-	PublicPort    int      `envconfig:"publicPort"`
-	Tags          []string `envconfig:"tags"`
-	ConsulURL     string   `envconfig:"consulURL"`
+	ServiceID      string   `envconfig:"serviceId"`
+	ServiceName    string   `envconfig:"serviceName"`
+	Namespace      string   `envconfig:"namespace"`
+	Listen         string   `envconfig:"listen"`
+	PublicAddress  string   `envconfig:"publicAddress"`
+	PublicPort     int      `envconfig:"publicPort"`
+	MonitorPort    int      `envconfig:"monitorPort"`
+	AdminPort      int      `envconfig:"adminPort"`  // 管理接口端口, 为0时不开启
+	AdminToken     string   `envconfig:"adminToken"` // 管理接口的访问token
+	Tags           []string `envconfig:"tags"`
+	Region         string   `envconfig:"region"`
+	Zone           string   `envconfig:"zone"`
+	ConsulURL      string   `envconfig:"consulURL"`
+	MaxFrameSize   uint32   `envconfig:"maxFrameSize"`
+	MaxHeaderSize  uint32   `envconfig:"maxHeaderSize"`
+	MaxBodySize    uint32   `envconfig:"maxBodySize"`
+	LoadReport     int      `envconfig:"loadReport"`     // 上报连接数的间隔(秒)
+	PresenceReport int      `envconfig:"presenceReport"` // 上报会话刷新在线状态的间隔(秒), 需要小于在线状态的过期时间
+	TraceExporter  string   `envconfig:"traceExporter"`  // otlp, stdout, 为空时不导出
+	TraceEndpoint  string   `envconfig:"traceEndpoint"`
+	TraceSample    float64  `envconfig:"traceSample"`
+	TokenKey       string   `envconfig:"tokenKey"` // 校验登录token的密钥, 为空时使用token.DefaultKey
 }
 
 // Init 初始化配置
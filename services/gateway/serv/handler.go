@@ -4,13 +4,18 @@ import (
 	"EIM"
 	"EIM/container"
 	"EIM/logger"
+	"EIM/tracing"
 	"EIM/wire"
 	"EIM/wire/pkt"
 	"EIM/wire/token"
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 var log = logger.WithFields(logger.Fields{
@@ -20,6 +25,9 @@ var log = logger.WithFields(logger.Fields{
 
 type Handler struct {
 	ServiceID string
+	Topics    EIM.Topics // 本网关上channel对群的订阅
+	TokenKey  string     // 校验登录token的密钥, 为空时使用token.DefaultKey
+	sessions  sync.Map   // channelId -> *pkt.Session, 本网关上已登录的会话
 }
 
 // Accept 节点处理链路, 用于握手处理
@@ -54,8 +62,12 @@ func (h *Handler) Accept(conn EIM.Conn, timeout time.Duration) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	// 使用DefaultSecret解析token
-	tk, err := token.Parse(token.DefaultKey, login.Token)
+	// 解析token, 未配置密钥时使用DefaultKey
+	key := h.TokenKey
+	if key == "" {
+		key = token.DefaultKey
+	}
+	tk, err := token.Parse(key, login.Token)
 	if err != nil {
 		// token无效则返回给SDK一个Unauthorized消息
 		resp := pkt.NewForm(&req.Header)
@@ -66,18 +78,25 @@ func (h *Handler) Accept(conn EIM.Conn, timeout time.Duration) (string, error) {
 	// 生成全局唯一channelID
 	id := generateChannelID(h.ServiceID, tk.Account)
 	// 填写req包相关信息
-	req.WriteBody(&pkt.Session{
+	session := &pkt.Session{
 		ChannelId: id,
 		GateId:    h.ServiceID,
 		Account:   tk.Account,
 		App:       tk.App,
 		RemoteIP:  getIp(conn.RemoteAddr().String()),
-	})
+		Device:    login.GetDevice(),
+	}
+	req.WriteBody(session)
 	// 把req转发给login服务
+	ctx, span := tracing.Start(context.Background(), "gateway "+req.Command, trace.WithSpanKind(trace.SpanKindServer))
+	tracing.Inject(ctx, req)
 	err = container.Forward(wire.SNLogin, req)
+	tracing.EndSpan(span, err)
 	if err != nil {
 		return "", err
 	}
+	h.sessions.Store(id, session)
+	logger.WithContext(ctx).WithField("account", tk.Account).Infof("login forwarded with channel %s", id)
 	return id, nil
 }
 
@@ -86,7 +105,9 @@ func (h *Handler) Receive(ag EIM.Agent, payload []byte) {
 	buf := bytes.NewBuffer(payload)
 	packet, err := pkt.Read(buf)
 	if err != nil {
-		log.Error(err)
+		// 无法解析的消息不再继续处理这个连接, 避免客户端反复发送
+		log.WithField("id", ag.ID()).Warn(err)
+		EIM.CloseAgent(ag, EIM.CloseCode(err), err.Error())
 		return
 	}
 
@@ -101,15 +122,28 @@ func (h *Handler) Receive(ag EIM.Agent, payload []byte) {
 	}
 
 	if LogicPkt, ok := packet.(*pkt.LogicPkt); ok {
+		// 服务之间的指令不接受客户端发送, 避免伪造其他账号的在线状态
+		if wire.InternalCommand(LogicPkt.Command) {
+			log.WithField("id", ag.ID()).WithField("cmd", LogicPkt.Command).Warn("internal command from client is rejected")
+			resp := pkt.NewForm(&LogicPkt.Header)
+			resp.Status = pkt.Status_InvalidCommand
+			resp.Flag = pkt.Flag_Response
+			_ = ag.Push(pkt.Marshal(resp))
+			return
+		}
 		LogicPkt.ChannelId = ag.ID()
+		// 每个上行消息都是一条链路的起点, 忽略客户端带来的trace上下文
+		ctx, span := tracing.Start(context.Background(), "gateway "+LogicPkt.Command, trace.WithSpanKind(trace.SpanKindServer))
+		tracing.Inject(ctx, LogicPkt)
 		err = container.Forward(LogicPkt.ServiceName(), LogicPkt)
+		tracing.EndSpan(span, err)
 		if err != nil {
 			logger.WithFields(logger.Fields{
 				"module": "handler",
 				"id":     ag.ID(),
 				"cmd":    LogicPkt.Command,
 				"dest":   LogicPkt.Dest,
-			}).Error(err)
+			}).WithContext(ctx).Error(err)
 		}
 	}
 }
@@ -117,6 +151,10 @@ func (h *Handler) Receive(ag EIM.Agent, payload []byte) {
 // Disconnect 断开对应id的channel
 func (h *Handler) Disconnect(channelId string) error {
 	log.Infof("disconnect %s", channelId)
+	h.sessions.Delete(channelId)
+	if h.Topics != nil {
+		h.Topics.RemoveChannel(channelId)
+	}
 
 	logout := pkt.New(wire.CommandLoginSignOut, pkt.WithChannelId(channelId))
 	err := container.Push(wire.SNLogin, logout)
@@ -129,6 +167,54 @@ func (h *Handler) Disconnect(channelId string) error {
 	return nil
 }
 
+// Session 返回本网关上channelId对应的会话
+func (h *Handler) Session(channelId string) (*pkt.Session, bool) {
+	v, ok := h.sessions.Load(channelId)
+	if !ok {
+		return nil, false
+	}
+	return v.(*pkt.Session), true
+}
+
+// Sessions 返回本网关上account的所有会话
+func (h *Handler) Sessions(account string) []*pkt.Session {
+	var sessions []*pkt.Session
+	h.sessions.Range(func(key, value any) bool {
+		if s := value.(*pkt.Session); s.Account == account {
+			sessions = append(sessions, s)
+		}
+		return true
+	})
+	return sessions
+}
+
+// ReportPresence 定时把本网关上的会话分批上报给login服务, 刷新在线状态的过期时间, 网关宕机后在线状态会自动过期
+func (h *Handler) ReportPresence(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second * 30
+	}
+	for range time.Tick(interval) {
+		var sessions []*pkt.Session
+		h.sessions.Range(func(key, value any) bool {
+			sessions = append(sessions, value.(*pkt.Session))
+			return true
+		})
+		// 没有会话时也上报一次, 表示网关仍然存活
+		for start := 0; start == 0 || start < len(sessions); start += EIM.DispatchChunkSize {
+			end := start + EIM.DispatchChunkSize
+			if end > len(sessions) {
+				end = len(sessions)
+			}
+			p := pkt.New(wire.CommandLoginKeepalive, pkt.WithChannelId(h.ServiceID))
+			p.WriteBody(&pkt.PresenceKeepalive{Sessions: sessions[start:end]})
+			if err := container.Forward(wire.SNLogin, p); err != nil {
+				log.Warn(err)
+				break
+			}
+		}
+	}
+}
+
 var ipExp = regexp.MustCompile("\\:[0-9]+$")
 
 func getIp(remoteAddr string) string {
@@ -3,16 +3,23 @@ package gateway
 import (
 	"EIM"
 	"EIM/container"
+	"EIM/health"
 	"EIM/logger"
 	"EIM/naming"
 	"EIM/naming/consul"
+	"EIM/services/gateway/admin"
 	"EIM/services/gateway/conf"
 	"EIM/services/gateway/serv"
+	"EIM/tracing"
 	"EIM/websocket"
 	"EIM/wire"
+	"EIM/wire/pkt"
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/kataras/iris/v12"
 	"github.com/spf13/cobra"
 )
 
@@ -32,12 +39,41 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	_ = logger.Init(logger.Settings{
 		Level: "trace",
 	})
+	// 初始化链路追踪
+	shutdownTracing, err := tracing.Init(config.ServiceName, tracing.Settings{
+		Exporter:    config.TraceExporter,
+		Endpoint:    config.TraceEndpoint,
+		SampleRatio: config.TraceSample,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+	// 设置解包时的长度限制
+	pkt.SetMaxSize(config.MaxHeaderSize, config.MaxBodySize)
 	// 初始化handler
+	topics := EIM.NewTopics()
 	handler := &serv.Handler{
 		ServiceID: config.ServiceID,
+		Topics:    topics,
+		TokenKey:  config.TokenKey,
 	}
 	// 初始化server
 	var srv EIM.Server
+	// 复制一份, append时不会写入config.Tags的底层数组
+	tags := append([]string(nil), config.Tags...)
+	if config.Region != "" {
+		tags = append(tags, naming.TagRegion+config.Region)
+	}
+	if config.Zone != "" {
+		tags = append(tags, naming.TagZone+config.Zone)
+	}
+	meta := make(map[string]string)
+	if config.MonitorPort != 0 {
+		meta[consul.KeyHealthURL] = fmt.Sprintf("http://%s:%d/ready", config.PublicAddress, config.MonitorPort)
+	}
 	service := &naming.DefaultService{
 		Id:        config.ServiceID,
 		Name:      config.ServiceName,
@@ -45,13 +81,17 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Port:      config.PublicPort,
 		Protocol:  opts.protocol,
 		Namespace: config.Namespace,
-		Tags:      config.Tags,
+		Tags:      tags,
+		Meta:      meta,
 	}
 	if opts.protocol == "ws" {
 		srv = websocket.NewServer(config.Listen, service)
 	}
+	channels := EIM.NewChannels(100)
 	// 注册监听器
 	srv.SetReadWait(time.Minute * 2)
+	srv.SetChannelMap(channels)
+	srv.SetMaxFrameSize(config.MaxFrameSize)
 	srv.SetStateListener(handler)
 	srv.SetMessageListener(handler)
 	srv.SetAcceptor(handler)
@@ -63,7 +103,36 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		return err
 	}
 	container.SetServiceNaming(ns)
+	container.SetTopics(topics)
 	container.SetDialer(serv.NewDialer(config.ServiceID))
+	// 注册之后定时上报连接数, 供router按负载选择网关
+	container.SetMetaReporter(time.Duration(config.LoadReport)*time.Second, func() map[string]string {
+		return map[string]string{consul.KeyLoad: strconv.Itoa(len(channels.All()))}
+	})
+	go handler.ReportPresence(time.Duration(config.PresenceReport) * time.Second)
+	// 在监控端口上提供/health, /ready与/metrics
+	if config.MonitorPort != 0 {
+		go func() {
+			err := health.Serve(fmt.Sprintf(":%d", config.MonitorPort))
+			if err != nil {
+				logger.Error(err)
+			}
+		}()
+	}
+	// 开启管理接口
+	if config.AdminPort != 0 {
+		if config.AdminToken == "" {
+			logger.Warn("AdminToken is empty, admin api is disabled")
+		} else {
+			app := admin.NewApp(admin.NewAdminApi(config.ServiceID, config.AdminToken, channels, handler))
+			go func() {
+				err := app.Listen(fmt.Sprintf(":%d", config.AdminPort), iris.WithOptimizations)
+				if err != nil {
+					logger.Error(err)
+				}
+			}()
+		}
+	}
 	// 启动容器
 	return container.Start()
 }
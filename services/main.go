@@ -2,6 +2,8 @@ package main
 
 import (
 	"EIM/logger"
+	"EIM/services/bench"
+	"EIM/services/cli"
 	"EIM/services/gateway"
 	"EIM/services/router"
 	"EIM/services/server"
@@ -28,6 +30,8 @@ func main() {
 	root.AddCommand(server.NewServerStartCmd(ctx, version))
 	root.AddCommand(service.NewServerStartCmd(ctx, version))
 	root.AddCommand(router.NewServerStartCmd(ctx, version))
+	root.AddCommand(cli.NewClientStartCmd(ctx, version))
+	root.AddCommand(bench.NewBenchCmd(ctx, version))
 
 	if err := root.Execute(); err != nil {
 		logger.WithError(err).Fatal("Could not run command")
@@ -0,0 +1,137 @@
+package apis
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/naming"
+	"EIM/naming/consul"
+	"EIM/services/router/conf"
+	"EIM/services/router/ipregion"
+	"EIM/wire"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// RouterApi 网关路由接口
+type RouterApi struct {
+	Naming   naming.Naming
+	IpRegion *ipregion.Table
+	Config   *conf.Config
+}
+
+// Gateway 返回给客户端的网关信息
+type Gateway struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Region   string `json:"region,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Load     int    `json:"load"`
+}
+
+// LookupResp 路由结果
+type LookupResp struct {
+	UTC      int64     `json:"utc"`
+	IP       string    `json:"ip"`
+	Region   string    `json:"region"`
+	Zone     string    `json:"zone,omitempty"`
+	Gateways []Gateway `json:"gateways"`
+}
+
+// Lookup 根据客户端的ip, 区域和协议偏好返回最合适的网关地址
+// GET /api/lookup?protocol=ws&region=&zone=&count=
+func (r *RouterApi) Lookup(c iris.Context) {
+	ip := c.RemoteAddr()
+	loc, ok := r.IpRegion.Lookup(ip)
+	if !ok {
+		loc.Region = r.Config.DefaultRegion
+	}
+	// 客户端指定的区域优先
+	if region := c.URLParam("region"); region != "" {
+		loc = ipregion.Location{Region: region, Zone: c.URLParam("zone")}
+	}
+	protocol := c.URLParamDefault("protocol", r.Config.Protocol)
+	count := c.URLParamIntDefault("count", r.Config.Count)
+	if count <= 0 || count > r.Config.Count {
+		count = r.Config.Count
+	}
+
+	var services []EIM.ServiceRegistration
+	for _, name := range []string{wire.SNWGateway, wire.SNTGateway} {
+		found, err := r.Naming.Find(name)
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "router",
+				"service": name,
+			}).Warn(err)
+			continue
+		}
+		services = append(services, found...)
+	}
+	gateways := Select(services, loc, protocol)
+	if len(gateways) == 0 {
+		c.StopWithText(iris.StatusServiceUnavailable, "no gateway available")
+		return
+	}
+	if len(gateways) > count {
+		gateways = gateways[:count]
+	}
+	_ = c.JSON(&LookupResp{
+		UTC:      time.Now().Unix(),
+		IP:       ip,
+		Region:   loc.Region,
+		Zone:     loc.Zone,
+		Gateways: gateways,
+	})
+}
+
+// Select 对网关排序: 同可用区 > 同区域 > 其它区域, 其次是偏好的协议, 最后是负载从低到高
+func Select(services []EIM.ServiceRegistration, loc ipregion.Location, protocol string) []Gateway {
+	type scored struct {
+		Gateway
+		distance int
+		other    bool
+	}
+	list := make([]scored, 0, len(services))
+	for _, s := range services {
+		g := scored{
+			Gateway: Gateway{
+				ID:       s.ServiceID(),
+				URL:      s.DialURL(),
+				Protocol: s.GetProtocol(),
+				Region:   naming.TagValue(s.GetTags(), naming.TagRegion),
+				Zone:     naming.TagValue(s.GetTags(), naming.TagZone),
+			},
+			distance: 2,
+			other:    s.GetProtocol() != protocol,
+		}
+		if load, err := strconv.Atoi(s.GetMeta()[consul.KeyLoad]); err == nil {
+			g.Load = load
+		}
+		if loc.Region != "" && g.Region == loc.Region {
+			g.distance = 1
+			if loc.Zone != "" && g.Zone == loc.Zone {
+				g.distance = 0
+			}
+		}
+		list = append(list, g)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.other != b.other {
+			return !a.other
+		}
+		return a.Load < b.Load
+	})
+	gateways := make([]Gateway, len(list))
+	for i, g := range list {
+		gateways[i] = g.Gateway
+	}
+	return gateways
+}
@@ -0,0 +1,43 @@
+package apis
+
+import (
+	"EIM"
+	"EIM/naming"
+	"EIM/naming/consul"
+	"EIM/services/router/ipregion"
+	"testing"
+)
+
+func gateway(id, protocol, region, zone, load string) EIM.ServiceRegistration {
+	return &naming.DefaultService{
+		Id:       id,
+		Address:  "127.0.0.1",
+		Port:     8000,
+		Protocol: protocol,
+		Tags:     []string{naming.TagRegion + region, naming.TagZone + zone},
+		Meta:     map[string]string{consul.KeyLoad: load},
+	}
+}
+
+func TestSelect(t *testing.T) {
+	services := []EIM.ServiceRegistration{
+		gateway("north", "ws", "cn-north", "zone_n", "0"),
+		gateway("south-busy", "ws", "cn-south", "zone_b", "100"),
+		gateway("south-idle", "ws", "cn-south", "zone_b", "10"),
+		gateway("south-tcp", "tcp", "cn-south", "zone_b", "0"),
+		gateway("south-zone", "ws", "cn-south", "zone_a", "500"),
+	}
+	got := Select(services, ipregion.Location{Region: "cn-south", Zone: "zone_a"}, "ws")
+	want := []string{"south-zone", "south-idle", "south-busy", "south-tcp", "north"}
+	if len(got) != len(want) {
+		t.Fatalf("Select() returned %d gateways, want %d", len(got), len(want))
+	}
+	for i, g := range got {
+		if g.ID != want[i] {
+			t.Fatalf("Select()[%d] = %s, want %s", i, g.ID, want[i])
+		}
+	}
+	if got[0].URL != "ws://127.0.0.1:8000" || got[0].Load != 500 {
+		t.Fatalf("unexpected gateway %+v", got[0])
+	}
+}
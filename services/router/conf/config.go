@@ -0,0 +1,49 @@
+package conf
+
+import (
+	"EIM/logger"
+	"encoding/json"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Listen        string `default:":8100"`
+	ConsulURL     string
+	LogLevel      string `default:"INFO"`
+	IPRegionFile  string `default:"./router/data/ip_region.txt"`
+	DefaultRegion string // ip无法匹配时使用的区域
+	Protocol      string `default:"ws"` // 客户端未指定时优先返回的协议
+	Count         int    `default:"3"`  // 返回网关的最大数量
+}
+
+func (c Config) String() string {
+	bts, _ := json.Marshal(c)
+	return string(bts)
+}
+
+// Init 初始化Config
+func Init(file string) (*Config, error) {
+	viper.SetConfigFile(file)
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("/etc/conf")
+
+	var config Config
+	// 填充默认值与环境变量
+	err := envconfig.Process("EIM", &config)
+	if err != nil {
+		return nil, err
+	}
+	// 读配置文件
+	if err := viper.ReadInConfig(); err != nil {
+		logger.Warn(err)
+	} else {
+		// 将配置文件中的数据写入config
+		if err := viper.Unmarshal(&config); err != nil {
+			return nil, err
+		}
+	}
+	logger.Info(config)
+	return &config, nil
+}
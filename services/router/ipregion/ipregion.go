@@ -0,0 +1,86 @@
+package ipregion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Location ip所在的区域与可用区
+type Location struct {
+	Region string
+	Zone   string
+}
+
+type entry struct {
+	network *net.IPNet
+	ones    int
+	Location
+}
+
+// Table ip网段到区域的映射表, 加载后只读, 可并发使用
+type Table struct {
+	entries []entry
+}
+
+// Load 从文件加载映射表
+func Load(file string) (*Table, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse 解析映射表, 每行格式为: 网段 区域 [可用区], #开头的行为注释
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected <cidr> <region> [zone]", lineNo)
+		}
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		e := entry{network: network, Location: Location{Region: fields[1]}}
+		e.ones, _ = network.Mask.Size()
+		if len(fields) == 3 {
+			e.Zone = fields[2]
+		}
+		t.entries = append(t.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// 前缀更长的网段优先匹配
+	sort.SliceStable(t.entries, func(i, j int) bool {
+		return t.entries[i].ones > t.entries[j].ones
+	})
+	return t, nil
+}
+
+// Lookup 查找ip所在的区域
+func (t *Table) Lookup(ip string) (Location, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil || t == nil {
+		return Location{}, false
+	}
+	for _, e := range t.entries {
+		if e.network.Contains(addr) {
+			return e.Location, true
+		}
+	}
+	return Location{}, false
+}
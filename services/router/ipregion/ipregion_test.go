@@ -0,0 +1,37 @@
+package ipregion
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	table, err := Parse(strings.NewReader(`
+# comment
+10.0.0.0/8    cn-south
+10.1.0.0/16   cn-north  zone_a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want Location
+		ok   bool
+	}{
+		{"10.1.2.3", Location{Region: "cn-north", Zone: "zone_a"}, true},
+		{"10.2.2.3", Location{Region: "cn-south"}, true},
+		{"8.8.8.8", Location{}, false},
+		{"invalid", Location{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := table.Lookup(tt.ip)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%s) = %v, %v, want %v, %v", tt.ip, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, err = Parse(strings.NewReader("10.0.0.0/33 cn-south")); err == nil {
+		t.Fatal("Parse() expected error for invalid cidr")
+	}
+}
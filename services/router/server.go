@@ -1,6 +1,12 @@
 package router
 
 import (
+	"EIM/logger"
+	"EIM/metrics"
+	"EIM/naming/consul"
+	"EIM/services/router/apis"
+	"EIM/services/router/conf"
+	"EIM/services/router/ipregion"
 	"context"
 
 	"github.com/kataras/iris/v12"
@@ -13,12 +19,40 @@ type ServerStartOptions struct {
 
 // RunServerStart 启动路由服务器
 func RunServerStart(ctx context.Context, opts *ServerStartOptions, version string) error {
+	config, err := conf.Init(opts.config)
+	if err != nil {
+		return err
+	}
+	_ = logger.Init(logger.Settings{
+		Level: config.LogLevel,
+	})
+	// 加载ip区域映射
+	regions, err := ipregion.Load(config.IPRegionFile)
+	if err != nil {
+		return err
+	}
+	ns, err := consul.NewNaming(config.ConsulURL)
+	if err != nil {
+		return err
+	}
+	router := apis.RouterApi{
+		Naming:   ns,
+		IpRegion: regions,
+		Config:   config,
+	}
+
 	app := iris.Default()
 	app.Get("/health", func(ctx iris.Context) {
 		_, _ = ctx.WriteString("ok")
 	})
+	app.Get("/metrics", iris.FromStd(metrics.Handler()))
+	routerAPI := app.Party("/api")
+	{
+		routerAPI.Get("/lookup", router.Lookup)
+	}
 	// 开启服务器
-	return app.Listen("", iris.WithOptimizations)
+	return app.Listen(config.Listen, iris.WithOptimizations,
+		iris.WithRemoteAddrHeader("X-Real-Ip", "X-Forwarded-For"))
 }
 
 func NewServerStartCmd(ctx context.Context, version string) *cobra.Command {
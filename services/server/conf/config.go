@@ -19,6 +19,8 @@ type Config struct {
 	ServiceID       string
 	Listen          string `default:":8005"`
 	MonitorPort     int    `default:"8006"`
+	PushPort        int    // 推送接口端口, 为0时不开启
+	PushToken       string // 推送接口的访问token, 为空时不开启推送接口
 	PublicAddress   string
 	PublicPort      int `default:"8005"`
 	Tags            []string
@@ -29,6 +31,18 @@ type Config struct {
 	LogLevel        string `default:"DEBUG"`
 	MessageGPool    int    `default:"5000"`
 	ConnectionGPool int    `default:"500"`
+	MaxHeaderSize   uint32 // 解包时Header的最大长度, 为0时使用默认值
+	MaxBodySize     uint32 // 解包时Body的最大长度, 为0时使用默认值
+	TraceExporter   string // otlp, stdout, 为空时不导出
+	TraceEndpoint   string
+	TraceSample     float64
+	GroupCacheSize  int               `default:"10000"` // 本地群缓存的数量
+	GroupCacheTTL   time.Duration     `default:"1m"`    // 本地群缓存的过期时间
+	GroupRedisTTL   time.Duration     `default:"5m"`    // redis中群缓存的过期时间
+	TalkPolicies    map[string]string // app对应的单聊策略: friend只能给好友发送, block被拉黑时不能发送
+	PresenceTTL     time.Duration     `default:"90s"` // 在线状态的过期时间, 需要大于网关上报会话的间隔
+	TransientRate   float64           `default:"5"`   // 每个连接每秒可以发送的瞬时消息数
+	TransientBurst  int               `default:"10"`  // 瞬时消息允许的突发数
 }
 
 // Init 初始化配置
@@ -80,7 +94,11 @@ func InitRedis(addr string, password string) (*redis.Client, error) {
 	return redisDB, nil
 }
 
+// String 用于打印配置, 不输出PushToken的明文
 func (c Config) String() string {
+	if c.PushToken != "" {
+		c.PushToken = "******"
+	}
 	bts, _ := json.Marshal(c)
 	return string(bts)
 }
@@ -2,22 +2,41 @@ package handler
 
 import (
 	"EIM"
+	"EIM/logger"
 	"EIM/services/server/service"
+	"EIM/wire"
 	"EIM/wire/pkt"
 	"EIM/wire/rpc"
 	"errors"
+	"fmt"
 	"time"
 )
 
+// 单聊的好友策略, 按app配置
+const (
+	TalkPolicyOpen   = ""       // 不做检查
+	TalkPolicyBlock  = "block"  // 被对方拉黑时不能发送
+	TalkPolicyFriend = "friend" // 只能给好友发送, 同时检查黑名单
+)
+
 type ChatHandler struct {
-	msgService   service.Message
-	groupService service.Group
+	msgService    service.Message
+	groupService  service.Group
+	friendService service.Friend
+	mediaService  service.Media
+	talkPolicies  map[string]string
+	limiter       *channelLimiter // 瞬时消息的发送频率
 }
 
-func NewChatHandler(message service.Message, group service.Group) *ChatHandler {
+// NewChatHandler policies为app对应的单聊策略, 未配置的app不做检查; media用于检查媒体消息的key
+func NewChatHandler(message service.Message, group service.Group, friend service.Friend, media service.Media, policies map[string]string) *ChatHandler {
 	return &ChatHandler{
-		msgService:   message,
-		groupService: group,
+		msgService:    message,
+		groupService:  group,
+		friendService: friend,
+		mediaService:  media,
+		talkPolicies:  policies,
+		limiter:       newChannelLimiter(DefaultTransientRate, DefaultTransientBurst),
 	}
 }
 
@@ -34,8 +53,17 @@ func (h *ChatHandler) DoUserTalk(ctx EIM.Context) {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	// 寻址
 	receiver := ctx.Header().GetDest()
+	if status, err := h.checkTalk(ctx, receiver); err != nil {
+		_ = ctx.RespWithError(status, err)
+		return
+	}
+	extra, err := mediaExtra(ctx, h.mediaService, &req)
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	// 寻址
 	loc, err := ctx.GetLocation(receiver, "")
 	if err != nil && err != EIM.ErrSessionNil {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
@@ -43,28 +71,35 @@ func (h *ChatHandler) DoUserTalk(ctx EIM.Context) {
 	}
 	// 保存离线消息
 	sendTime := time.Now().UnixNano()
-	resp, err := h.msgService.InsertUser(ctx.Session().GetApp(), &rpc.InsertMessageReq{
+	resp, err := h.msgService.InsertUser(ctx.Context(), ctx.Session().GetApp(), &rpc.InsertMessageReq{
 		Sender:   ctx.Session().GetAccount(),
 		Dest:     receiver,
 		SendTime: sendTime,
 		Message: &rpc.Message{
 			Type:  req.GetType(),
 			Body:  req.GetBody(),
-			Extra: req.GetExtra(),
+			Extra: extra,
 		},
+		ClientId: req.GetClientId(),
 	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
+		return
+	}
+	// 重试的消息使用第一次保存时的发送时间, 再次推送时接收方按消息id去重
+	if resp.GetDuplicated() {
+		sendTime = resp.GetSendTime()
 	}
 	// 对方在线则直接将消息发送过去
 	if loc != nil {
-		err = ctx.Dispatch(&pkt.MessagePush{
+		_, err = ctx.Dispatch(&pkt.MessagePush{
 			MessageId: resp.MessageId,
 			Type:      req.GetType(),
 			Body:      req.GetBody(),
-			Extra:     req.GetExtra(),
+			Extra:     extra,
 			Sender:    ctx.Session().GetAccount(),
 			SendTime:  sendTime,
+			Seq:       resp.GetSeq(),
 		}, loc)
 		if err != nil {
 			_ = ctx.RespWithError(pkt.Status_SystemException, err)
@@ -75,6 +110,7 @@ func (h *ChatHandler) DoUserTalk(ctx EIM.Context) {
 	_ = ctx.Resp(pkt.Status_Success, &pkt.MessageResp{
 		MessageId: resp.MessageId,
 		SendTime:  sendTime,
+		Seq:       resp.GetSeq(),
 	})
 }
 
@@ -92,57 +128,118 @@ func (h *ChatHandler) DoGroupTalk(ctx EIM.Context) {
 		return
 	}
 	group := ctx.Header().GetDest()
+	if err := h.checkSpeak(ctx, group); err != nil {
+		respError(ctx, err)
+		return
+	}
+	extra, err := mediaExtra(ctx, h.mediaService, &req)
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
 	// 保存离线消息
 	sendTime := time.Now().UnixNano()
-	resp, err := h.msgService.InsertGroup(ctx.Session().GetApp(), &rpc.InsertMessageReq{
+	resp, err := h.msgService.InsertGroup(ctx.Context(), ctx.Session().GetApp(), &rpc.InsertMessageReq{
 		Sender:   ctx.Session().GetAccount(),
 		Dest:     group,
 		SendTime: sendTime,
 		Message: &rpc.Message{
 			Type:  req.GetType(),
 			Body:  req.GetBody(),
-			Extra: req.GetExtra(),
+			Extra: extra,
 		},
+		ClientId: req.GetClientId(),
 	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
 		return
 	}
-	// 读取群成员列表
-	membersResp, err := h.groupService.Members(ctx.Session().GetApp(), &rpc.GroupMembersReq{
-		GroupId: group,
+	if resp.GetDuplicated() {
+		sendTime = resp.GetSendTime()
+	}
+	// 每个网关只推送一个消息包, 由网关推送给本地订阅了该群的channel
+	// 消息已经保存为离线消息, 推送失败的成员可以通过离线同步拿到, 不影响发送结果
+	result, err := ctx.DispatchTopic(group, &pkt.MessagePush{
+		MessageId: resp.MessageId,
+		Type:      req.GetType(),
+		Body:      req.GetBody(),
+		Extra:     extra,
+		Sender:    ctx.Session().GetAccount(),
+		SendTime:  sendTime,
+		Seq:       resp.GetSeq(),
 	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
-		return
-	}
-	members := make([]string, len(membersResp.Users))
-	for i, user := range membersResp.Users {
-		members[i] = user.Account
-	}
-	// 寻址
-	locs, err := ctx.GetLocations(members...)
-	if err != nil && err != EIM.ErrSessionNil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
-		return
-	}
-	// 批量推送
-	if len(locs) > 0 {
-		if err = ctx.Dispatch(&pkt.MessagePush{
-			MessageId: resp.MessageId,
-			Type:      req.GetType(),
-			Body:      req.GetBody(),
-			Extra:     req.GetExtra(),
-			Sender:    ctx.Session().GetAccount(),
-			SendTime:  sendTime,
-		}, locs...); err != nil {
-			_ = ctx.RespWithError(pkt.Status_SystemException, err)
-			return
+		log := logger.WithFields(logger.Fields{
+			"module":  "chat",
+			"group":   group,
+			"message": resp.MessageId,
+		}).WithContext(ctx.Context())
+		if result != nil {
+			log = log.WithField("failed", len(result.Failed))
 		}
+		log.Warn(err)
 	}
 	// 返回一条成功的resp消息给发送方
 	_ = ctx.Resp(pkt.Status_Success, &pkt.MessageResp{
 		MessageId: resp.MessageId,
 		SendTime:  sendTime,
+		Seq:       resp.GetSeq(),
 	})
 }
+
+// checkTalk 按app的单聊策略检查发送方与接收方的关系
+func (h *ChatHandler) checkTalk(ctx EIM.Context, receiver string) (pkt.Status, error) {
+	policy := h.talkPolicies[ctx.Session().GetApp()]
+	if policy != TalkPolicyBlock && policy != TalkPolicyFriend {
+		return pkt.Status_Success, nil
+	}
+	sender := ctx.Session().GetAccount()
+	relation, err := h.friendService.Relation(ctx.Context(), ctx.Session().GetApp(), &rpc.RelationReq{
+		Account: sender,
+		Friend:  receiver,
+	})
+	if err != nil {
+		return pkt.Status_SystemException, err
+	}
+	if relation.GetBlocked() {
+		return pkt.Status_Blocked, fmt.Errorf("%s is blocked by %s", sender, receiver)
+	}
+	if policy == TalkPolicyFriend && !relation.GetFriend() {
+		return pkt.Status_NotFriend, fmt.Errorf("%s is not a friend of %s", sender, receiver)
+	}
+	return pkt.Status_Success, nil
+}
+
+// checkSpeak 检查发送方是群成员并且没有被禁言, 群成员与群信息都会被缓存
+func (h *ChatHandler) checkSpeak(ctx EIM.Context, group string) error {
+	app, account := ctx.Session().GetApp(), ctx.Session().GetAccount()
+	membersResp, err := h.groupService.Members(ctx.Context(), app, &rpc.GroupMembersReq{GroupId: group})
+	if err != nil {
+		return err
+	}
+	var member *rpc.Member
+	for _, m := range membersResp.GetUsers() {
+		if m.GetAccount() == account {
+			member = m
+			break
+		}
+	}
+	if member == nil {
+		return fmt.Errorf("%w: %s is not a member of group %s", service.ErrForbidden, account, group)
+	}
+	if member.GetMutedUntil() > time.Now().Unix() {
+		return fmt.Errorf("%w: %s is muted until %d", service.ErrForbidden, account, member.GetMutedUntil())
+	}
+	// 全员禁言时管理员和群主仍然可以发言
+	if member.GetRole() == wire.GroupRoleMember {
+		detail, err := h.groupService.Detail(ctx.Context(), app, &rpc.GetGroupReq{GroupId: group})
+		if err != nil {
+			return err
+		}
+		// 群信息会被缓存, 按截止时间判断禁言是否已经过期
+		if detail.GetMutedUntil() > time.Now().Unix() {
+			return fmt.Errorf("%w: group %s is muted until %d", service.ErrForbidden, group, detail.GetMutedUntil())
+		}
+	}
+	return nil
+}
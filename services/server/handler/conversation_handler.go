@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"errors"
+)
+
+type ConversationHandler struct {
+	convService service.Conversation
+	msgService  service.Message
+}
+
+// NewConversationHandler msgService用于ack时重置离线消息的读索引与翻取历史消息
+func NewConversationHandler(convService service.Conversation, msgService service.Message) *ConversationHandler {
+	return &ConversationHandler{
+		convService: convService,
+		msgService:  msgService,
+	}
+}
+
+// DoList 增量同步会话列表, version为0时返回全部会话
+func (h *ConversationHandler) DoList(ctx EIM.Context) {
+	var req pkt.ConversationListReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	limit := req.GetLimit()
+	if limit <= 0 || limit > wire.ConversationPageSize {
+		limit = wire.ConversationPageSize
+	}
+	resp, err := h.convService.List(ctx.Context(), ctx.Session().GetApp(), &rpc.ConversationsReq{
+		Account: ctx.Session().GetAccount(),
+		Version: req.GetVersion(),
+		Limit:   limit,
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	list := make([]*pkt.Conversation, len(resp.GetConversations()))
+	for i, c := range resp.GetConversations() {
+		list[i] = &pkt.Conversation{
+			Type:          c.GetType(),
+			Target:        c.GetTarget(),
+			LastMessageId: c.GetLastMessageId(),
+			LastSender:    c.GetLastSender(),
+			LastType:      c.GetLastType(),
+			LastSendTime:  c.GetLastSendTime(),
+			LastSeq:       c.GetLastSeq(),
+			Preview:       c.GetPreview(),
+			Unread:        c.GetUnread(),
+			ReadMessageId: c.GetReadMessageId(),
+			Pinned:        c.GetPinned(),
+			Muted:         c.GetMuted(),
+			Version:       c.GetVersion(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.ConversationListResp{
+		Conversations: list,
+		Version:       resp.GetVersion(),
+		HasMore:       resp.GetHasMore(),
+	})
+}
+
+// DoUpdate 置顶或免打扰
+func (h *ConversationHandler) DoUpdate(ctx EIM.Context) {
+	var req pkt.ConversationUpdateReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if !validConversation(req.GetType(), req.GetTarget()) {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("conversation is invalid"))
+		return
+	}
+	err := h.convService.Update(ctx.Context(), ctx.Session().GetApp(), &rpc.UpdateConversationReq{
+		Account: ctx.Session().GetAccount(),
+		Type:    req.GetType(),
+		Target:  req.GetTarget(),
+		Pinned:  req.Pinned,
+		Muted:   req.Muted,
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoAck 重置离线消息的读索引, 指定了会话时同时清除该会话的未读数
+func (h *ConversationHandler) DoAck(ctx EIM.Context) {
+	var req pkt.MessageAckReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	app := ctx.Session().GetApp()
+	account := ctx.Session().GetAccount()
+	err := h.msgService.SetAck(ctx.Context(), app, &rpc.AckMessageReq{
+		Account:   account,
+		MessageId: req.GetMessageId(),
+		Device:    ctx.Session().GetDevice(),
+	})
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	if req.GetConversationType() != 0 {
+		target := ctx.Header().GetDest()
+		if !validConversation(req.GetConversationType(), target) {
+			_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("conversation is invalid"))
+			return
+		}
+		err = h.convService.Read(ctx.Context(), app, &rpc.ReadConversationReq{
+			Account:   account,
+			Type:      req.GetConversationType(),
+			Target:    target,
+			MessageId: req.GetMessageId(),
+		})
+		if err != nil {
+			logger.WithContext(ctx.Context()).WithField("target", target).Warn(err)
+			_ = ctx.RespWithError(pkt.Status_SystemException, err)
+			return
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoHistory 翻取与Dest的会话中的历史消息
+func (h *ConversationHandler) DoHistory(ctx EIM.Context) {
+	var req pkt.HistoryReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	target := ctx.Header().GetDest()
+	if !validConversation(req.GetType(), target) {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("conversation is invalid"))
+		return
+	}
+	limit := req.GetLimit()
+	if limit <= 0 || limit > wire.MessageHistoryPageSize {
+		limit = wire.MessageHistoryPageSize
+	}
+	resp, err := h.msgService.History(ctx.Context(), ctx.Session().GetApp(), &rpc.HistoryReq{
+		Account:   ctx.Session().GetAccount(),
+		Type:      req.GetType(),
+		Target:    target,
+		MessageId: req.GetMessageId(),
+		Forward:   req.GetForward(),
+		Limit:     limit,
+		Types:     req.GetTypes(),
+		Seq:       req.GetSeq(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	list := make([]*pkt.MessagePush, len(resp.GetMessages()))
+	for i, m := range resp.GetMessages() {
+		list[i] = &pkt.MessagePush{
+			MessageId: m.GetMessageId(),
+			Type:      m.GetType(),
+			Body:      m.GetBody(),
+			Extra:     m.GetExtra(),
+			Sender:    m.GetSender(),
+			SendTime:  m.GetSendTime(),
+			Seq:       m.GetSeq(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.HistoryResp{Messages: list, HasMore: resp.GetHasMore()})
+}
+
+func validConversation(typ int32, target string) bool {
+	return (typ == wire.ConversationUser || typ == wire.ConversationGroup) && target != ""
+}
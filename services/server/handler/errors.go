@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/services/server/service"
+	"EIM/wire/pkt"
+	"errors"
+)
+
+// respError 把royal返回的错误转换为对应的状态码, 各handler共用
+func respError(ctx EIM.Context, err error) {
+	status := pkt.Status_SystemException
+	if errors.Is(err, service.ErrForbidden) {
+		status = pkt.Status_Forbidden
+	} else if errors.Is(err, service.ErrNotFound) {
+		status = pkt.Status_NoDestination
+	} else if errors.Is(err, service.ErrBadRequest) {
+		status = pkt.Status_InvalidPacketBody
+	} else if errors.Is(err, service.ErrNotImplemented) {
+		status = pkt.Status_NotImplemented
+	}
+	_ = ctx.RespWithError(status, err)
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"errors"
+)
+
+type FriendHandler struct {
+	friendService service.Friend
+	msgService    service.Message
+}
+
+// NewFriendHandler msgService用于把好友申请与处理结果保存为离线消息
+func NewFriendHandler(friendService service.Friend, msgService service.Message) *FriendHandler {
+	return &FriendHandler{
+		friendService: friendService,
+		msgService:    msgService,
+	}
+}
+
+// DoAdd 发送好友申请, 对方不在线时通过离线同步拿到
+func (h *FriendHandler) DoAdd(ctx EIM.Context) {
+	var req pkt.FriendAddReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.friendService.Add(ctx.Context(), ctx.Session().GetApp(), &rpc.AddFriendReq{
+		Account: ctx.Session().GetAccount(),
+		Friend:  req.GetAccount(),
+		Remark:  req.GetRemark(),
+	})
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	notifyOffline(ctx, h.msgService, []string{req.GetAccount()}, wire.CommandFriendAdd, &pkt.FriendRequestNotify{
+		RequestId: resp.GetRequestId(),
+		Account:   ctx.Session().GetAccount(),
+		Remark:    req.GetRemark(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, &pkt.FriendAddResp{RequestId: resp.GetRequestId()})
+}
+
+// DoApprove 同意或拒绝好友申请, 结果通知给申请人
+func (h *FriendHandler) DoApprove(ctx EIM.Context) {
+	var req pkt.FriendApproveReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.friendService.Approve(ctx.Context(), ctx.Session().GetApp(), &rpc.ApproveFriendReq{
+		Operator:  ctx.Session().GetAccount(),
+		RequestId: req.GetRequestId(),
+		Approve:   req.GetApprove(),
+	})
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	notifyOffline(ctx, h.msgService, []string{resp.GetAccount()}, wire.CommandFriendApprove, &pkt.FriendApproveNotify{
+		RequestId: req.GetRequestId(),
+		Account:   ctx.Session().GetAccount(),
+		Approved:  req.GetApprove(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoRequests 待处理的好友申请
+func (h *FriendHandler) DoRequests(ctx EIM.Context) {
+	resp, err := h.friendService.Requests(ctx.Context(), ctx.Session().GetApp(), ctx.Session().GetAccount())
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	requests := make([]*pkt.FriendRequest, len(resp.GetRequests()))
+	for i, r := range resp.GetRequests() {
+		requests[i] = &pkt.FriendRequest{
+			RequestId: r.GetId(),
+			Account:   r.GetAccount(),
+			Remark:    r.GetRemark(),
+			CreatedAt: r.GetCreatedAt(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.FriendRequestsResp{Requests: requests})
+}
+
+// DoList 好友列表
+func (h *FriendHandler) DoList(ctx EIM.Context) {
+	resp, err := h.friendService.List(ctx.Context(), ctx.Session().GetApp(), ctx.Session().GetAccount())
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	friends := make([]*pkt.Friend, len(resp.GetFriends()))
+	for i, f := range resp.GetFriends() {
+		friends[i] = &pkt.Friend{
+			Account:   f.GetAccount(),
+			Remark:    f.GetRemark(),
+			CreatedAt: f.GetCreatedAt(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.FriendListResp{Friends: friends})
+}
+
+// DoRemark 修改好友备注
+func (h *FriendHandler) DoRemark(ctx EIM.Context) {
+	var req pkt.FriendRemarkReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.friendService.Remark(ctx.Context(), ctx.Session().GetApp(), &rpc.FriendRemarkReq{
+		Account: ctx.Session().GetAccount(),
+		Friend:  req.GetAccount(),
+		Remark:  req.GetRemark(),
+	})
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoDelete 删除好友, 不通知对方
+func (h *FriendHandler) DoDelete(ctx EIM.Context) {
+	var req pkt.FriendDeleteReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.friendService.Delete(ctx.Context(), ctx.Session().GetApp(), &rpc.DeleteFriendReq{
+		Account: ctx.Session().GetAccount(),
+		Friend:  req.GetAccount(),
+	})
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoBlock 加入或移出黑名单
+func (h *FriendHandler) DoBlock(ctx EIM.Context) {
+	var req pkt.FriendBlockReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.friendService.Block(ctx.Context(), ctx.Session().GetApp(), &rpc.BlockReq{
+		Account: ctx.Session().GetAccount(),
+		Target:  req.GetAccount(),
+		Block:   req.GetBlock(),
+	})
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoBlocklist 黑名单
+func (h *FriendHandler) DoBlocklist(ctx EIM.Context) {
+	resp, err := h.friendService.Blocklist(ctx.Context(), ctx.Session().GetApp(), ctx.Session().GetAccount())
+	if err != nil {
+		respFriendError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.FriendBlocklistResp{Accounts: resp.GetAccounts()})
+}
+
+// respFriendError 被对方拉黑时返回Blocked, 其它错误与群操作相同
+func respFriendError(ctx EIM.Context, err error) {
+	if errors.Is(err, service.ErrForbidden) {
+		_ = ctx.RespWithError(pkt.Status_Blocked, err)
+		return
+	}
+	respError(ctx, err)
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DoMembers 群成员列表
+func (h *GroupHandler) DoMembers(ctx EIM.Context) {
+	var req pkt.GroupMembersReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.groupService.Members(ctx.Context(), ctx.Session().GetApp(), &rpc.GroupMembersReq{
+		GroupId: req.GetGroupId(),
+	})
+	if err == nil {
+		err = checkMember(ctx, req.GetGroupId(), resp)
+	}
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.GroupMembersResp{Members: toMembers(resp.GetUsers())})
+}
+
+// DoKick 踢出成员
+func (h *GroupHandler) DoKick(ctx EIM.Context) {
+	var req pkt.GroupKickReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.groupService.Kick(ctx.Context(), ctx.Session().GetApp(), &rpc.KickMemberReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Account:  req.GetAccount(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	// 先通知再取消订阅, 被踢出的成员也能收到通知
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupKickNotify{
+		GroupId:  req.GetGroupId(),
+		Operator: ctx.Session().GetAccount(),
+		Account:  req.GetAccount(),
+	})
+	if loc, err := ctx.GetLocation(req.GetAccount(), ""); err == nil {
+		h.unsubscribe(ctx, req.GetGroupId(), loc)
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoTransfer 转让群主
+func (h *GroupHandler) DoTransfer(ctx EIM.Context) {
+	var req pkt.GroupTransferReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.groupService.Transfer(ctx.Context(), ctx.Session().GetApp(), &rpc.TransferOwnerReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Account:  req.GetAccount(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupTransferNotify{
+		GroupId:  req.GetGroupId(),
+		Operator: ctx.Session().GetAccount(),
+		Owner:    req.GetAccount(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoSetAdmin 设置或取消管理员
+func (h *GroupHandler) DoSetAdmin(ctx EIM.Context) {
+	var req pkt.GroupSetAdminReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.groupService.SetAdmin(ctx.Context(), ctx.Session().GetApp(), &rpc.SetAdminReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Account:  req.GetAccount(),
+		Admin:    req.GetAdmin(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupSetAdminNotify{
+		GroupId:  req.GetGroupId(),
+		Operator: ctx.Session().GetAccount(),
+		Account:  req.GetAccount(),
+		Admin:    req.GetAdmin(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoMute 禁言成员或全员
+func (h *GroupHandler) DoMute(ctx EIM.Context) {
+	var req pkt.GroupMuteReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.groupService.Mute(ctx.Context(), ctx.Session().GetApp(), &rpc.MuteReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Account:  req.GetAccount(),
+		Duration: req.GetDuration(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupMuteNotify{
+		GroupId:    req.GetGroupId(),
+		Operator:   ctx.Session().GetAccount(),
+		Account:    req.GetAccount(),
+		MutedUntil: resp.GetMutedUntil(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoUpdate 修改群名称, 头像与简介
+func (h *GroupHandler) DoUpdate(ctx EIM.Context) {
+	var req pkt.GroupUpdateReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.groupService.Update(ctx.Context(), ctx.Session().GetApp(), &rpc.UpdateGroupReq{
+		Operator:     ctx.Session().GetAccount(),
+		GroupId:      req.GetGroupId(),
+		Name:         req.GetName(),
+		Avatar:       req.GetAvatar(),
+		Introduction: req.GetIntroduction(),
+		JoinPolicy:   req.JoinPolicy,
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupUpdateNotify{
+		GroupId:      req.GetGroupId(),
+		Operator:     ctx.Session().GetAccount(),
+		Name:         req.GetName(),
+		Avatar:       req.GetAvatar(),
+		Introduction: req.GetIntroduction(),
+		JoinPolicy:   req.JoinPolicy,
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoDissolve 解散群
+func (h *GroupHandler) DoDissolve(ctx EIM.Context) {
+	var req pkt.GroupDissolveReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	// 解散之后就读不到成员了, 先找到在线的成员用于取消订阅
+	membersResp, err := h.groupService.Members(ctx.Context(), ctx.Session().GetApp(), &rpc.GroupMembersReq{
+		GroupId: req.GetGroupId(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	err = h.groupService.Dissolve(ctx.Context(), ctx.Session().GetApp(), &rpc.DissolveGroupReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	h.notify(ctx, req.GetGroupId(), &pkt.GroupDissolveNotify{
+		GroupId:  req.GetGroupId(),
+		Operator: ctx.Session().GetAccount(),
+	})
+	accounts := make([]string, len(membersResp.GetUsers()))
+	for i, m := range membersResp.GetUsers() {
+		accounts[i] = m.GetAccount()
+	}
+	if locs, err := ctx.GetLocations(accounts...); err == nil {
+		h.unsubscribe(ctx, req.GetGroupId(), locs...)
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// notify 推送给群里所有在线成员(操作者除外), 失败时只记录日志
+func (h *GroupHandler) notify(ctx EIM.Context, group string, body proto.Message) {
+	if _, err := ctx.DispatchTopic(group, body); err != nil {
+		logger.WithContext(ctx.Context()).WithField("group", group).Warn(err)
+	}
+}
+
+func (h *GroupHandler) unsubscribe(ctx EIM.Context, group string, locs ...*EIM.Location) {
+	if err := ctx.Unsubscribe([]string{group}, locs...); err != nil {
+		logger.WithContext(ctx.Context()).WithField("group", group).Warn(err)
+	}
+}
+
+func toMembers(users []*rpc.Member) []*pkt.Member {
+	members := make([]*pkt.Member, len(users))
+	for i, m := range users {
+		members[i] = &pkt.Member{
+			Account:    m.GetAccount(),
+			Alias:      m.GetAlias(),
+			Avatar:     m.GetAvatar(),
+			JoinTime:   m.GetJoinTime(),
+			Role:       m.GetRole(),
+			MutedUntil: m.GetMutedUntil(),
+		}
+	}
+	return members
+}
@@ -2,17 +2,25 @@ package handler
 
 import (
 	"EIM"
+	"EIM/logger"
 	"EIM/services/server/service"
 	"EIM/wire/pkt"
 	"EIM/wire/rpc"
+	"errors"
+	"fmt"
 )
 
 type GroupHandler struct {
 	groupService service.Group
+	msgService   service.Message
 }
 
-func NewGroupHandler(groupService service.Group) *GroupHandler {
-	return &GroupHandler{groupService: groupService}
+// NewGroupHandler msgService用于把入群申请等通知保存为离线消息
+func NewGroupHandler(groupService service.Group, msgService service.Message) *GroupHandler {
+	return &GroupHandler{
+		groupService: groupService,
+		msgService:   msgService,
+	}
 }
 
 func (h *GroupHandler) DoCreate(ctx EIM.Context) {
@@ -21,17 +29,22 @@ func (h *GroupHandler) DoCreate(ctx EIM.Context) {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	resp, err := h.groupService.Create(ctx.Session().GetApp(), &rpc.CreateGroupReq{
+	resp, err := h.groupService.Create(ctx.Context(), ctx.Session().GetApp(), &rpc.CreateGroupReq{
 		Name:         req.GetName(),
 		Avatar:       req.GetAvatar(),
 		Introduction: req.GetIntroduction(),
-		Owner:        req.GetOwner(),
+		Owner:        ctx.Session().GetAccount(), // 创建者即群主
 		Members:      req.GetMembers(),
+		JoinPolicy:   req.GetJoinPolicy(),
 	})
 	if err != nil {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	h.subscribe(ctx, resp.GroupId, &EIM.Location{
+		ChannelId: ctx.Session().GetChannelId(),
+		GateId:    ctx.Session().GetGateId(),
+	})
 
 	locs, err := ctx.GetLocations(req.GetMembers()...)
 	if err != nil && err != EIM.ErrSessionNil {
@@ -39,7 +52,8 @@ func (h *GroupHandler) DoCreate(ctx EIM.Context) {
 		return
 	}
 	if len(locs) > 0 {
-		if err = ctx.Dispatch(&pkt.GroupCreateNotify{
+		h.subscribe(ctx, resp.GroupId, locs...)
+		if _, err = ctx.Dispatch(&pkt.GroupCreateNotify{
 			GroupId: resp.GroupId,
 			Members: req.GetMembers(),
 		}, locs...); err != nil {
@@ -57,16 +71,32 @@ func (h *GroupHandler) DoJoin(ctx EIM.Context) {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	err := h.groupService.Join(ctx.Session().GetApp(), &rpc.JoinGroupReq{
-		Account: req.GetAccount(),
-		GroupId: req.GetGroupId(),
+	resp, err := h.groupService.Join(ctx.Context(), ctx.Session().GetApp(), &rpc.JoinGroupReq{
+		Account:  req.GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Operator: ctx.Session().GetAccount(),
+		Remark:   req.GetRemark(),
 	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
 		return
 	}
+	// 需要审批时通知群主与管理员, 不在线的管理员通过离线同步拿到申请
+	if resp.GetPending() {
+		h.notifyAdmins(ctx, req.GetGroupId(), &pkt.GroupJoinRequestNotify{
+			GroupId:   req.GetGroupId(),
+			RequestId: resp.GetRequestId(),
+			Account:   req.GetAccount(),
+			Remark:    req.GetRemark(),
+		})
+	} else if loc, err := ctx.GetLocation(req.GetAccount(), ""); err == nil {
+		h.subscribe(ctx, req.GetGroupId(), loc)
+	}
 
-	_ = ctx.Resp(pkt.Status_Success, nil)
+	_ = ctx.Resp(pkt.Status_Success, &pkt.GroupJoinResp{
+		Pending:   resp.GetPending(),
+		RequestId: resp.GetRequestId(),
+	})
 }
 
 func (h *GroupHandler) DoQuit(ctx EIM.Context) {
@@ -75,46 +105,66 @@ func (h *GroupHandler) DoQuit(ctx EIM.Context) {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	err := h.groupService.Quit(ctx.Session().GetApp(), &rpc.QuitGroupReq{
+	// 只能自己退出, 移除其他成员使用DoKick
+	if req.GetAccount() != ctx.Session().GetAccount() {
+		_ = ctx.RespWithError(pkt.Status_Forbidden, errors.New("can not quit for others"))
+		return
+	}
+	err := h.groupService.Quit(ctx.Context(), ctx.Session().GetApp(), &rpc.QuitGroupReq{
 		Account: req.GetAccount(),
 		GroupId: req.GetGroupId(),
 	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
 		return
 	}
+	h.unsubscribe(ctx, req.GetGroupId(), &EIM.Location{
+		ChannelId: ctx.Session().GetChannelId(),
+		GateId:    ctx.Session().GetGateId(),
+	})
 
 	_ = ctx.Resp(pkt.Status_Success, nil)
 }
 
+// subscribe 在网关上把在线成员订阅到群, 失败时只记录日志
+func (h *GroupHandler) subscribe(ctx EIM.Context, group string, locs ...*EIM.Location) {
+	if err := ctx.Subscribe([]string{group}, locs...); err != nil {
+		logger.WithContext(ctx.Context()).WithField("group", group).Warn(err)
+	}
+}
+
+// checkMember 检查操作者是群成员, 只有群成员可以查看群信息与成员列表
+func checkMember(ctx EIM.Context, group string, members *rpc.GroupMembersResp) error {
+	account := ctx.Session().GetAccount()
+	for _, m := range members.GetUsers() {
+		if m.GetAccount() == account {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not a member of group %s", service.ErrForbidden, account, group)
+}
+
 func (h *GroupHandler) DoDetail(ctx EIM.Context) {
 	var req pkt.GroupGetReq
 	if err := ctx.ReadBody(&req); err != nil {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	resp, err := h.groupService.Detail(ctx.Session().GetApp(), &rpc.GetGroupReq{
-		GroupId: req.GetGroupId(),
-	})
+	membersResp, err := h.groupService.Members(ctx.Context(), ctx.Session().GetApp(), &rpc.GroupMembersReq{GroupId: req.GetGroupId()})
+	if err == nil {
+		err = checkMember(ctx, req.GetGroupId(), membersResp)
+	}
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
 		return
 	}
-
-	membersResp, err := h.groupService.Members(ctx.Session().GetApp(), &rpc.GroupMembersReq{GroupId: req.GetGroupId()})
+	resp, err := h.groupService.Detail(ctx.Context(), ctx.Session().GetApp(), &rpc.GetGroupReq{
+		GroupId: req.GetGroupId(),
+	})
 	if err != nil {
-		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		respError(ctx, err)
 		return
 	}
-	var members = make([]*pkt.Member, len(membersResp.GetUsers()))
-	for i, m := range membersResp.GetUsers() {
-		members[i] = &pkt.Member{
-			Account:  m.GetAccount(),
-			Alias:    m.GetAlias(),
-			Avatar:   m.GetAvatar(),
-			JoinTime: m.GetJoinTime(),
-		}
-	}
 
 	_ = ctx.Resp(pkt.Status_Success, &pkt.GroupGetResp{
 		Id:           resp.GetId(),
@@ -122,6 +172,10 @@ func (h *GroupHandler) DoDetail(ctx EIM.Context) {
 		Avatar:       resp.GetAvatar(),
 		Introduction: resp.GetIntroduction(),
 		Owner:        resp.GetOwner(),
-		Members:      members,
+		Members:      toMembers(membersResp.GetUsers()),
+		CreatedAt:    resp.GetCreatedAt(),
+		Muted:        resp.GetMuted(),
+		JoinPolicy:   resp.GetJoinPolicy(),
+		MutedUntil:   resp.GetMutedUntil(),
 	})
 }
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DoInvite 邀请用户入群, 被邀请人在有效期内加入群不需要审批
+func (h *GroupHandler) DoInvite(ctx EIM.Context) {
+	var req pkt.GroupInviteReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.groupService.Invite(ctx.Context(), ctx.Session().GetApp(), &rpc.InviteGroupReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+		Accounts: req.GetAccounts(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	notifyOffline(ctx, h.msgService, resp.GetAccounts(), wire.CommandGroupInvite, &pkt.GroupInviteNotify{
+		GroupId:  req.GetGroupId(),
+		Inviter:  ctx.Session().GetAccount(),
+		ExpireAt: resp.GetExpireAt(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, &pkt.GroupInviteResp{
+		Accounts: resp.GetAccounts(),
+		ExpireAt: resp.GetExpireAt(),
+	})
+}
+
+// DoApprove 审批入群申请, 结果通知给申请人
+func (h *GroupHandler) DoApprove(ctx EIM.Context) {
+	var req pkt.GroupApproveReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.groupService.Approve(ctx.Context(), ctx.Session().GetApp(), &rpc.ApproveJoinReq{
+		Operator:  ctx.Session().GetAccount(),
+		RequestId: req.GetRequestId(),
+		Approve:   req.GetApprove(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	if req.GetApprove() {
+		if loc, err := ctx.GetLocation(resp.GetAccount(), ""); err == nil {
+			h.subscribe(ctx, resp.GetGroupId(), loc)
+		}
+	}
+	notifyOffline(ctx, h.msgService, []string{resp.GetAccount()}, wire.CommandGroupApprove, &pkt.GroupApproveNotify{
+		GroupId:   resp.GetGroupId(),
+		RequestId: req.GetRequestId(),
+		Operator:  ctx.Session().GetAccount(),
+		Approved:  req.GetApprove(),
+	})
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoRequests 待审批的入群申请列表
+func (h *GroupHandler) DoRequests(ctx EIM.Context) {
+	var req pkt.GroupRequestsReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.groupService.JoinRequests(ctx.Context(), ctx.Session().GetApp(), &rpc.JoinRequestsReq{
+		Operator: ctx.Session().GetAccount(),
+		GroupId:  req.GetGroupId(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	requests := make([]*pkt.GroupJoinRequest, len(resp.GetRequests()))
+	for i, r := range resp.GetRequests() {
+		requests[i] = &pkt.GroupJoinRequest{
+			RequestId: r.GetId(),
+			Account:   r.GetAccount(),
+			Remark:    r.GetRemark(),
+			CreatedAt: r.GetCreatedAt(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.GroupRequestsResp{Requests: requests})
+}
+
+// notifyAdmins 把通知发给群主与所有管理员
+func (h *GroupHandler) notifyAdmins(ctx EIM.Context, group string, notify proto.Message) {
+	resp, err := h.groupService.Members(ctx.Context(), ctx.Session().GetApp(), &rpc.GroupMembersReq{
+		GroupId: group,
+	})
+	if err != nil {
+		logger.WithContext(ctx.Context()).WithField("group", group).Warn(err)
+		return
+	}
+	var admins []string
+	for _, m := range resp.GetUsers() {
+		if m.GetRole() >= wire.GroupRoleAdmin {
+			admins = append(admins, m.GetAccount())
+		}
+	}
+	notifyOffline(ctx, h.msgService, admins, ctx.Header().GetCommand(), notify)
+}
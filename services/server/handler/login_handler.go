@@ -3,15 +3,22 @@ package handler
 import (
 	"EIM"
 	"EIM/logger"
+	"EIM/services/server/service"
 	"EIM/wire/pkt"
+	"EIM/wire/rpc"
 )
 
 // LoginHandler 登录管理
 type LoginHandler struct {
+	groupService service.Group
+	presence     *service.Presence
 }
 
-func NewLoginHandler() *LoginHandler {
-	return &LoginHandler{}
+func NewLoginHandler(groupService service.Group, presence *service.Presence) *LoginHandler {
+	return &LoginHandler{
+		groupService: groupService,
+		presence:     presence,
+	}
 }
 
 // DoSysLogin 登录
@@ -28,7 +35,7 @@ func (h *LoginHandler) DoSysLogin(ctx EIM.Context) {
 		"ChannelId": session.GetChannelId(),
 		"Account":   session.GetAccount(),
 		"RemoteIP":  session.GetRemoteIP(),
-	}).Info("do login")
+	}).WithContext(ctx.Context()).Info("do login")
 	// 检测该账号是否已登录
 	old, err := ctx.GetLocation(session.Account, "")
 	if err != nil && err != EIM.ErrSessionNil {
@@ -37,7 +44,7 @@ func (h *LoginHandler) DoSysLogin(ctx EIM.Context) {
 	}
 	// 通知老用户下线
 	if old != nil {
-		_ = ctx.Dispatch(&pkt.KickoutNotify{
+		_, _ = ctx.Dispatch(&pkt.KickoutNotify{
 			ChannelId: old.ChannelId,
 		}, old)
 	}
@@ -47,9 +54,16 @@ func (h *LoginHandler) DoSysLogin(ctx EIM.Context) {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	// 在网关上订阅用户加入的群, 失败时不影响登录, 群消息可以通过离线同步拿到
+	h.subscribeGroups(ctx, &session)
+	// 更新在线状态并通知订阅者, 失败时不影响登录
+	if err = h.presence.Online(ctx.Context(), &session); err != nil {
+		logger.WithContext(ctx.Context()).WithField("account", session.GetAccount()).Warn(err)
+	}
 	// 通知登录成功
 	var resp = &pkt.LoginResp{
 		ChannelId: session.ChannelId,
+		Account:   session.Account,
 	}
 	_ = ctx.Resp(pkt.Status_Success, resp)
 }
@@ -60,13 +74,54 @@ func (h *LoginHandler) DoSysLogout(ctx EIM.Context) {
 		"Func":      "Logout",
 		"ChannelId": ctx.Session().GetChannelId(),
 		"Account":   ctx.Session().GetAccount(),
-	}).Info("do Logout ")
+	}).WithContext(ctx.Context()).Info("do Logout ")
 
 	err := ctx.Delete(ctx.Session().GetAccount(), ctx.Session().GetChannelId())
 	if err != nil {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	err = h.presence.Offline(ctx.Context(), ctx.Session().GetAccount(), ctx.Session().GetChannelId())
+	if err != nil {
+		logger.WithContext(ctx.Context()).WithField("account", ctx.Session().GetAccount()).Warn(err)
+	}
 
 	_ = ctx.Resp(pkt.Status_Success, nil)
 }
+
+// DoSysKeepalive 刷新网关上报的会话的在线状态, 网关不需要应答
+func (h *LoginHandler) DoSysKeepalive(ctx EIM.Context) {
+	var req pkt.PresenceKeepalive
+	if err := ctx.ReadBody(&req); err != nil {
+		logger.WithContext(ctx.Context()).Warn(err)
+		return
+	}
+	err := h.presence.Refresh(ctx.Context(), ctx.Session().GetGateId(), req.GetSessions())
+	if err != nil {
+		logger.WithContext(ctx.Context()).WithField("gateway", ctx.Session().GetGateId()).Warn(err)
+	}
+}
+
+func (h *LoginHandler) subscribeGroups(ctx EIM.Context, session *pkt.Session) {
+	log := logger.WithFields(logger.Fields{
+		"Func":    "Login",
+		"Account": session.GetAccount(),
+	}).WithContext(ctx.Context())
+	resp, err := h.groupService.Groups(ctx.Context(), session.GetApp(), &rpc.UserGroupsReq{
+		Account: session.GetAccount(),
+	})
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if len(resp.GetGroups()) == 0 {
+		return
+	}
+	err = ctx.Subscribe(resp.GetGroups(), &EIM.Location{
+		ChannelId: session.GetChannelId(),
+		GateId:    session.GetGateId(),
+	})
+	if err != nil {
+		log.Warn(err)
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type MediaHandler struct {
+	mediaService service.Media
+}
+
+func NewMediaHandler(mediaService service.Media) *MediaHandler {
+	return &MediaHandler{mediaService: mediaService}
+}
+
+// DoToken 申请上传地址, 客户端直接上传到对象存储, 不经过网关
+func (h *MediaHandler) DoToken(ctx EIM.Context) {
+	var req pkt.MediaTokenReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.mediaService.Token(ctx.Context(), ctx.Session().GetApp(), &rpc.MediaTokenReq{
+		Account:  ctx.Session().GetAccount(),
+		Type:     req.GetType(),
+		Name:     req.GetName(),
+		Mime:     req.GetMime(),
+		Size:     req.GetSize(),
+		Width:    req.GetWidth(),
+		Height:   req.GetHeight(),
+		Duration: req.GetDuration(),
+		Cover:    req.GetCover(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.MediaTokenResp{
+		Key:       resp.GetKey(),
+		UploadUrl: resp.GetUploadUrl(),
+		Method:    resp.GetMethod(),
+		ExpiresAt: resp.GetExpiresAt(),
+	})
+}
+
+// DoComplete 上传完成, 返回服务端解析出的元数据
+func (h *MediaHandler) DoComplete(ctx EIM.Context) {
+	var req pkt.MediaCompleteReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	info, err := h.mediaService.Complete(ctx.Context(), ctx.Session().GetApp(), &rpc.MediaReq{
+		Account: ctx.Session().GetAccount(),
+		Key:     req.GetKey(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, toMediaInfo(info, ""))
+}
+
+// DoUrl 批量获取媒体文件与缩略图的下载地址
+func (h *MediaHandler) DoUrl(ctx EIM.Context) {
+	var req pkt.MediaUrlReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if len(req.GetKeys()) == 0 || len(req.GetKeys()) > wire.MediaUrlMaxCount {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, fmt.Errorf("count of keys should be in [1, %d]", wire.MediaUrlMaxCount))
+		return
+	}
+	resp, err := h.mediaService.URL(ctx.Context(), ctx.Session().GetApp(), &rpc.MediaUrlReq{
+		Account: ctx.Session().GetAccount(),
+		Keys:    req.GetKeys(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	urls := make([]*pkt.MediaUrl, len(resp.GetUrls()))
+	for i, u := range resp.GetUrls() {
+		urls[i] = &pkt.MediaUrl{
+			Key:       u.GetKey(),
+			Url:       u.GetUrl(),
+			ExpiresAt: u.GetExpiresAt(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.MediaUrlResp{Urls: urls})
+}
+
+// mediaExtra 媒体消息的body为上传完成的key, 返回元数据的json作为消息的extra, 客户端原来的extra保存在其中; 其它消息原样返回extra,
+// extra超过wire.MaxExtraLength时返回ErrBadRequest
+func mediaExtra(ctx EIM.Context, mediaService service.Media, req *pkt.MessageReq) (string, error) {
+	if wire.MediaMaxSize(req.GetType()) == 0 {
+		if len(req.GetExtra()) > wire.MaxExtraLength {
+			return "", fmt.Errorf("%w: extra should not be longer than %d bytes", service.ErrBadRequest, wire.MaxExtraLength)
+		}
+		return req.GetExtra(), nil
+	}
+	if req.GetBody() == "" {
+		return "", fmt.Errorf("%w: body of media message should be the key", service.ErrBadRequest)
+	}
+	info, err := mediaService.Meta(ctx.Context(), ctx.Session().GetApp(), &rpc.MediaReq{
+		Account: ctx.Session().GetAccount(),
+		Key:     req.GetBody(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if info.GetType() != req.GetType() {
+		return "", fmt.Errorf("%w: media %s is not of type %d", service.ErrBadRequest, req.GetBody(), req.GetType())
+	}
+	extra, err := protojson.Marshal(toMediaInfo(info, req.GetExtra()))
+	if err != nil {
+		return "", err
+	}
+	if len(extra) > wire.MaxExtraLength {
+		return "", fmt.Errorf("%w: extra of media message should not be longer than %d bytes", service.ErrBadRequest, wire.MaxExtraLength)
+	}
+	return string(extra), nil
+}
+
+func toMediaInfo(info *rpc.MediaInfo, extra string) *pkt.MediaInfo {
+	return &pkt.MediaInfo{
+		Key:       info.GetKey(),
+		Name:      info.GetName(),
+		Mime:      info.GetMime(),
+		Size:      info.GetSize(),
+		Width:     info.GetWidth(),
+		Height:    info.GetHeight(),
+		Duration:  info.GetDuration(),
+		Thumbnail: info.GetThumbnail(),
+		Extra:     extra,
+	}
+}
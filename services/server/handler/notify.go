@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/services/server/push"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// notifyOffline 把通知保存为离线消息后推送给在线的用户, 消息类型为MessageTypeNotify,
+// Extra为command, Body为通知的json; 不在线的用户登录后通过离线同步拿到, 失败时只记录日志
+func notifyOffline(ctx EIM.Context, msgService service.Message, accounts []string, command string, notify proto.Message) {
+	body, err := protojson.Marshal(notify)
+	if err != nil {
+		logger.WithContext(ctx.Context()).Warn(err)
+		return
+	}
+	for _, account := range accounts {
+		log := logger.WithContext(ctx.Context()).WithField("account", account)
+		sendTime := time.Now().UnixNano()
+		resp, err := msgService.InsertUser(ctx.Context(), ctx.Session().GetApp(), &rpc.InsertMessageReq{
+			Sender:   push.SystemSender,
+			Dest:     account,
+			SendTime: sendTime,
+			Message: &rpc.Message{
+				Type:  wire.MessageTypeNotify,
+				Body:  string(body),
+				Extra: command,
+			},
+		})
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		loc, err := ctx.GetLocation(account, "")
+		if err != nil {
+			continue
+		}
+		_, err = ctx.Dispatch(&pkt.MessagePush{
+			MessageId: resp.GetMessageId(),
+			Type:      wire.MessageTypeNotify,
+			Body:      string(body),
+			Extra:     command,
+			Sender:    push.SystemSender,
+			SendTime:  sendTime,
+			Seq:       resp.GetSeq(),
+		}, loc)
+		if err != nil {
+			log.Warn(err)
+		}
+	}
+}
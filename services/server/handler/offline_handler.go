@@ -3,6 +3,7 @@ package handler
 import (
 	"EIM"
 	"EIM/services/server/service"
+	"EIM/wire"
 	"EIM/wire/pkt"
 	"EIM/wire/rpc"
 	"errors"
@@ -16,15 +17,17 @@ func NewOfflineHandler(msgService service.Message) *OfflineHandler {
 	return &OfflineHandler{msgService: msgService}
 }
 
-// DoSyncIndex 同步离线消息索引
+// DoSyncIndex 同步离线消息索引, 读索引按设备记录
 func (h *OfflineHandler) DoSyncIndex(ctx EIM.Context) {
 	var req pkt.MessageIndexReq
 	if err := ctx.ReadBody(&req); err != nil {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
 	}
-	resp, err := h.msgService.GetMessageIndex(ctx.Session().GetApp(), &rpc.GetOfflineMessageIndexReq{
+	resp, err := h.msgService.GetMessageIndex(ctx.Context(), ctx.Session().GetApp(), &rpc.GetOfflineMessageIndexReq{
 		Account:   ctx.Session().GetAccount(),
 		MessageId: req.GetMessageId(),
+		Device:    ctx.Session().GetDevice(),
 	})
 	if err != nil {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
@@ -38,9 +41,10 @@ func (h *OfflineHandler) DoSyncIndex(ctx EIM.Context) {
 			SendTime:  val.GetSendTime(),
 			AccountB:  val.GetAccountB(),
 			Group:     val.GetGroup(),
+			Seq:       val.GetSeq(),
 		}
 	}
-	_ = ctx.Resp(pkt.Status_Success, &pkt.MessageIndexResp{Indexes: list})
+	_ = ctx.Resp(pkt.Status_Success, &pkt.MessageIndexResp{Indexes: list, HasMore: resp.GetHasMore()})
 }
 
 // DoSyncContent 同步离线消息内容
@@ -48,13 +52,19 @@ func (h *OfflineHandler) DoSyncContent(ctx EIM.Context) {
 	var req pkt.MessageContentReq
 	if err := ctx.ReadBody(&req); err != nil {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
 	}
 	if len(req.MessageIds) == 0 {
 		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("empty MessageIds"))
 		return
 	}
-	resp, err := h.msgService.GetMessageContent(ctx.Session().GetApp(), &rpc.GetOfflineMessageContentReq{
+	if len(req.MessageIds) > wire.MessageMaxCountPerPage {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("too many MessageIds"))
+		return
+	}
+	resp, err := h.msgService.GetMessageContent(ctx.Context(), ctx.Session().GetApp(), &rpc.GetOfflineMessageContentReq{
 		MessageIds: req.GetMessageIds(),
+		Account:    ctx.Session().GetAccount(),
 	})
 	if err != nil {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
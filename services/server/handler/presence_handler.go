@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"errors"
+	"unicode/utf8"
+)
+
+// 在线状态请求的限制
+const (
+	MaxPresenceQuery     = 100  // 单次查询的账号数
+	MaxPresenceSubscribe = 1000 // 单次订阅的账号数
+	MaxPresenceText      = 64   // 自定义状态描述的长度
+)
+
+type PresenceHandler struct {
+	presence      *service.Presence
+	friendService service.Friend
+}
+
+// NewPresenceHandler friendService用于订阅时未指定账号的情况下取所有好友
+func NewPresenceHandler(presence *service.Presence, friendService service.Friend) *PresenceHandler {
+	return &PresenceHandler{
+		presence:      presence,
+		friendService: friendService,
+	}
+}
+
+// DoQuery 批量查询在线状态
+func (h *PresenceHandler) DoQuery(ctx EIM.Context) {
+	var req pkt.PresenceReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if len(req.GetAccounts()) > MaxPresenceQuery {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("too many accounts"))
+		return
+	}
+	list, err := h.presence.Query(ctx.Context(), req.GetAccounts())
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.PresenceResp{Presences: list})
+}
+
+// DoSubscribe 订阅账号的在线状态变化, 未指定账号时订阅所有好友, 应答中带上当前的在线状态
+func (h *PresenceHandler) DoSubscribe(ctx EIM.Context) {
+	accounts, ok := h.accounts(ctx)
+	if !ok {
+		return
+	}
+	if len(accounts) > 0 {
+		if err := ctx.Subscribe(presenceTopics(accounts), location(ctx.Session())); err != nil {
+			_ = ctx.RespWithError(pkt.Status_SystemException, err)
+			return
+		}
+	}
+	list, err := h.presence.Query(ctx.Context(), accounts)
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.PresenceResp{Presences: list})
+}
+
+// DoUnsubscribe 取消订阅, 未指定账号时取消所有好友
+func (h *PresenceHandler) DoUnsubscribe(ctx EIM.Context) {
+	accounts, ok := h.accounts(ctx)
+	if !ok {
+		return
+	}
+	if len(accounts) > 0 {
+		if err := ctx.Unsubscribe(presenceTopics(accounts), location(ctx.Session())); err != nil {
+			_ = ctx.RespWithError(pkt.Status_SystemException, err)
+			return
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// DoSet 设置自定义状态, 如离开与忙碌
+func (h *PresenceHandler) DoSet(ctx EIM.Context) {
+	var req pkt.PresenceSetReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if req.GetStatus() < wire.PresenceOnline || req.GetStatus() > wire.PresenceBusy {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("status is invalid"))
+		return
+	}
+	if utf8.RuneCountInString(req.GetText()) > MaxPresenceText {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("text is too long"))
+		return
+	}
+	err := h.presence.SetStatus(ctx.Context(), ctx.Session(), req.GetStatus(), req.GetText())
+	if err == service.ErrNotOnline {
+		_ = ctx.RespWithError(pkt.Status_SessionNotFound, err)
+		return
+	}
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+// accounts 读取请求中的账号, 为空时返回所有好友, 出错时已经给出应答
+func (h *PresenceHandler) accounts(ctx EIM.Context) ([]string, bool) {
+	var req pkt.PresenceReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return nil, false
+	}
+	if len(req.GetAccounts()) > MaxPresenceSubscribe {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("too many accounts"))
+		return nil, false
+	}
+	if len(req.GetAccounts()) > 0 {
+		return req.GetAccounts(), true
+	}
+	resp, err := h.friendService.List(ctx.Context(), ctx.Session().GetApp(), ctx.Session().GetAccount())
+	if err != nil {
+		respFriendError(ctx, err)
+		return nil, false
+	}
+	accounts := make([]string, len(resp.GetFriends()))
+	for i, f := range resp.GetFriends() {
+		accounts[i] = f.GetAccount()
+	}
+	return accounts, true
+}
+
+func presenceTopics(accounts []string) []string {
+	topics := make([]string, len(accounts))
+	for i, account := range accounts {
+		topics[i] = service.PresenceTopic(account)
+	}
+	return topics
+}
+
+func location(session EIM.Session) *EIM.Location {
+	return &EIM.Location{
+		ChannelId: session.GetChannelId(),
+		GateId:    session.GetGateId(),
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/wire/pkt"
+	"errors"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+)
+
+// 瞬时消息的默认限制
+const (
+	DefaultTransientRate  = 5  // 每个连接每秒的消息数
+	DefaultTransientBurst = 10 // 允许的突发消息数
+	MaxTransientBody      = 4096
+
+	transientLimiterSize = 100000
+)
+
+var errRateLimited = errors.New("too many transient messages")
+
+// channelLimiter 按channel限制发送频率, 同一个channel的消息由网关按hash转发给同一个chat服务, 在本地计数即可
+type channelLimiter struct {
+	limiters *lru.Cache
+	limit    rate.Limit
+	burst    int
+}
+
+func newChannelLimiter(r float64, burst int) *channelLimiter {
+	limiters, _ := lru.New(transientLimiterSize)
+	return &channelLimiter{
+		limiters: limiters,
+		limit:    rate.Limit(r),
+		burst:    burst,
+	}
+}
+
+// Allow 返回channel当前是否可以发送
+func (l *channelLimiter) Allow(channel string) bool {
+	if v, ok := l.limiters.Get(channel); ok {
+		return v.(*rate.Limiter).Allow()
+	}
+	limiter := rate.NewLimiter(l.limit, l.burst)
+	if prev, ok, _ := l.limiters.PeekOrAdd(channel, limiter); ok {
+		limiter = prev.(*rate.Limiter)
+	}
+	return limiter.Allow()
+}
+
+// SetTransientLimit 设置每个连接发送瞬时消息的频率, 为0的参数使用默认值
+func (h *ChatHandler) SetTransientLimit(r float64, burst int) {
+	if r <= 0 {
+		r = DefaultTransientRate
+	}
+	if burst <= 0 {
+		burst = DefaultTransientBurst
+	}
+	h.limiter = newChannelLimiter(r, burst)
+}
+
+// DoUserTransient 单聊的瞬时消息, 只推送给在线的接收方, 不保存离线消息
+func (h *ChatHandler) DoUserTransient(ctx EIM.Context) {
+	req, ok := h.readTransient(ctx)
+	if !ok {
+		return
+	}
+	receiver := ctx.Header().GetDest()
+	if status, err := h.checkTalk(ctx, receiver); err != nil {
+		_ = ctx.RespWithError(status, err)
+		return
+	}
+	h.dispatchTransient(ctx, req, receiver)
+}
+
+// DoGroupTransient 群聊的瞬时消息, 与群消息一样每个网关只推送一个消息包, 由网关推送给本地订阅了该群的channel;
+// 不再查询每个成员的位置, 应答中的delivered为推送成功的网关数
+func (h *ChatHandler) DoGroupTransient(ctx EIM.Context) {
+	req, ok := h.readTransient(ctx)
+	if !ok {
+		return
+	}
+	group := ctx.Header().GetDest()
+	if err := h.checkSpeak(ctx, group); err != nil {
+		respError(ctx, err)
+		return
+	}
+	result, err := ctx.DispatchTopic(group, newTransientPush(ctx, req))
+	if result == nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	if err != nil {
+		logger.WithContext(ctx.Context()).WithField("failed", len(result.Failed)).Warn(err)
+	}
+	delivered := result.Total - len(result.Failed)
+	_ = ctx.Resp(pkt.Status_Success, &pkt.TransientResp{
+		Online:    delivered > 0,
+		Delivered: int32(delivered),
+	})
+}
+
+// readTransient 检查目的地址, 发送频率与消息大小, 出错时已经给出应答
+func (h *ChatHandler) readTransient(ctx EIM.Context) (*pkt.TransientReq, bool) {
+	if ctx.Header().GetDest() == "" {
+		_ = ctx.RespWithError(pkt.Status_NoDestination, errors.New("dest is nil"))
+		return nil, false
+	}
+	if !h.limiter.Allow(ctx.Session().GetChannelId()) {
+		_ = ctx.RespWithError(pkt.Status_RateLimited, errRateLimited)
+		return nil, false
+	}
+	var req pkt.TransientReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return nil, false
+	}
+	if len(req.GetBody()) > MaxTransientBody {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("body is too large"))
+		return nil, false
+	}
+	return &req, true
+}
+
+// dispatchTransient 推送给accounts中在线的设备, 应答中告知是否有在线的接收方
+func (h *ChatHandler) dispatchTransient(ctx EIM.Context, req *pkt.TransientReq, accounts ...string) {
+	locs, err := ctx.GetLocations(accounts...)
+	if err == EIM.ErrSessionNil || len(accounts) == 0 {
+		_ = ctx.Resp(pkt.Status_Success, &pkt.TransientResp{})
+		return
+	}
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	result, err := ctx.Dispatch(newTransientPush(ctx, req), locs...)
+	if err != nil {
+		logger.WithContext(ctx.Context()).WithField("failed", len(result.Failed)).Warn(err)
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.TransientResp{
+		Online:    len(locs) > 0,
+		Delivered: int32(result.Total - len(result.Failed)),
+	})
+}
+
+func newTransientPush(ctx EIM.Context, req *pkt.TransientReq) *pkt.TransientPush {
+	return &pkt.TransientPush{
+		Type:     req.GetType(),
+		Body:     req.GetBody(),
+		Sender:   ctx.Session().GetAccount(),
+		SendTime: time.Now().UnixNano(),
+	}
+}
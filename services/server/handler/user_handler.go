@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"EIM"
+	"EIM/services/server/service"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"errors"
+)
+
+// MaxProfilesPerRequest 一次最多获取的用户资料数
+const MaxProfilesPerRequest = 100
+
+type UserHandler struct {
+	userService service.User
+}
+
+func NewUserHandler(userService service.User) *UserHandler {
+	return &UserHandler{userService: userService}
+}
+
+// DoProfiles 批量获取用户资料, 客户端用于显示消息发送方的昵称与头像
+func (h *UserHandler) DoProfiles(ctx EIM.Context) {
+	var req pkt.UserProfilesReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if len(req.GetAccounts()) > MaxProfilesPerRequest {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("too many accounts"))
+		return
+	}
+	resp, err := h.userService.Profiles(ctx.Context(), ctx.Session().GetApp(), &rpc.UserProfilesReq{
+		Accounts: req.GetAccounts(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	users := make([]*pkt.UserProfile, len(resp.GetUsers()))
+	for i, u := range resp.GetUsers() {
+		users[i] = &pkt.UserProfile{
+			Account:  u.GetAccount(),
+			Nickname: u.GetNickname(),
+			Avatar:   u.GetAvatar(),
+		}
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.UserProfilesResp{Users: users})
+}
+
+// DoUpdate 修改自己的昵称与头像
+func (h *UserHandler) DoUpdate(ctx EIM.Context) {
+	var req pkt.UserUpdateReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.userService.Update(ctx.Context(), ctx.Session().GetApp(), &rpc.UpdateUserReq{
+		Account:  ctx.Session().GetAccount(),
+		Nickname: req.GetNickname(),
+		Avatar:   req.GetAvatar(),
+	})
+	if err != nil {
+		respError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// PushApi 推送接口的http封装
+type PushApi struct {
+	Pusher *Pusher
+	Token  string // 请求头需携带Authorization: Bearer <Token>, 为空时拒绝所有请求
+}
+
+// NewApp 创建推送接口的iris应用
+func NewApp(api *PushApi) *iris.Application {
+	app := iris.New()
+	app.Post("/api/{app}/push", api.Auth, api.Push)
+	return app
+}
+
+// Auth 校验token, 没有配置token时拒绝所有请求
+func (a *PushApi) Auth(c iris.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if a.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		c.StopWithStatus(iris.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// Push 向指定账号, 群或所有在线用户推送一条消息
+// POST /api/{app}/push
+func (a *PushApi) Push(c iris.Context) {
+	var req PushReq
+	if err := c.ReadJSON(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	resp, err := a.Pusher.Push(c.Request().Context(), c.Params().Get("app"), &req)
+	if errors.Is(err, ErrNoReceiver) || errors.Is(err, ErrOfflineWithAll) {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_ = c.JSON(resp)
+}
@@ -0,0 +1,228 @@
+package push
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/naming"
+	"EIM/services/server/service"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// SystemSender 未指定发送方时使用的账号
+const SystemSender = "system"
+
+var (
+	ErrNoReceiver     = errors.New("accounts, groups or all is required")
+	ErrOfflineWithAll = errors.New("offline storage is not supported when pushing to all users")
+)
+
+// PushReq 推送请求
+type PushReq struct {
+	Accounts []string `json:"accounts"`
+	Groups   []string `json:"groups"`
+	All      bool     `json:"all"`     // 推送给所有在线用户
+	Offline  bool     `json:"offline"` // 是否同时保存为离线消息
+	Sender   string   `json:"sender"`
+	Type     int32    `json:"type"`
+	Body     string   `json:"body"`
+	Extra    string   `json:"extra"`
+}
+
+// PushResp 推送结果
+type PushResp struct {
+	Online   int `json:"online"`   // 在线并推送成功的连接数, 推送给所有用户时为0
+	Failed   int `json:"failed"`   // 推送失败的连接数
+	Gateways int `json:"gateways"` // 涉及的网关数
+	Stored   int `json:"stored"`   // 保存的离线消息数
+}
+
+// Pusher 供后台系统向用户推送消息, 在chat服务中运行
+type Pusher struct {
+	Storage    EIM.SessionStorage
+	Dispatcher EIM.Dispatcher
+	Naming     naming.Naming
+	Groups     service.Group
+	Messages   service.Message
+}
+
+// Push 解析接收方的位置, 按网关分组后推送; Offline为true时先保存离线消息, 推送的是带消息ID的MessagePush
+func (p *Pusher) Push(ctx context.Context, app string, req *PushReq) (*PushResp, error) {
+	if req.Sender == "" {
+		req.Sender = SystemSender
+	}
+	if req.Type == 0 {
+		req.Type = wire.MessageTypeText
+	}
+	if req.All {
+		if req.Offline {
+			return nil, ErrOfflineWithAll
+		}
+		return p.pushAll(req)
+	}
+	if len(req.Accounts) == 0 && len(req.Groups) == 0 {
+		return nil, ErrNoReceiver
+	}
+	resp := &PushResp{}
+	gateways := make(map[string]struct{})
+	if !req.Offline {
+		// 单聊与群聊的接收方合并后只推送一次
+		accounts := uniq(req.Accounts)
+		for _, group := range req.Groups {
+			members, err := p.members(ctx, app, group)
+			if err != nil {
+				return nil, err
+			}
+			accounts = uniq(append(accounts, members...))
+		}
+		body := &pkt.SystemNotice{Body: req.Body, Extra: req.Extra, SendTime: time.Now().UnixNano()}
+		err := p.pushTo(accounts, wire.CommandSystemNotice, "", body, resp, gateways)
+		return resp, err
+	}
+	for _, account := range uniq(req.Accounts) {
+		sendTime := time.Now().UnixNano()
+		inserted, err := p.Messages.InsertUser(ctx, app, p.insertReq(req, account, sendTime))
+		if err != nil {
+			return nil, err
+		}
+		resp.Stored++
+		err = p.pushTo([]string{account}, wire.CommandChatUserTalk, "", p.messagePush(req, inserted, sendTime), resp, gateways)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range uniq(req.Groups) {
+		sendTime := time.Now().UnixNano()
+		inserted, err := p.Messages.InsertGroup(ctx, app, p.insertReq(req, group, sendTime))
+		if err != nil {
+			return nil, err
+		}
+		resp.Stored++
+		members, err := p.members(ctx, app, group)
+		if err != nil {
+			return nil, err
+		}
+		err = p.pushTo(members, wire.CommandChatGroupTalk, group, p.messagePush(req, inserted, sendTime), resp, gateways)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// pushAll 让每个网关把消息推送给它上面的所有channel
+func (p *Pusher) pushAll(req *PushReq) (*PushResp, error) {
+	resp := &PushResp{}
+	body := &pkt.SystemNotice{Body: req.Body, Extra: req.Extra, SendTime: time.Now().UnixNano()}
+	for _, name := range []string{wire.SNWGateway, wire.SNTGateway} {
+		services, err := p.Naming.Find(name)
+		if err != nil && err != naming.ErrNotFound {
+			return nil, err
+		}
+		for _, s := range services {
+			resp.Gateways++
+			packet := newPacket(wire.CommandSystemNotice, "", body)
+			if err = p.Dispatcher.Push(s.ServiceID(), []string{wire.DestAllChannels}, packet); err != nil {
+				logger.WithField("module", "push").Warn(err)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// pushTo 查询accounts的位置, 按网关分组后推送
+func (p *Pusher) pushTo(accounts []string, command, dest string, body proto.Message, resp *PushResp, gateways map[string]struct{}) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	locs, err := p.Storage.GetLocations(accounts...)
+	if err == EIM.ErrSessionNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	group := make(map[string][]string)
+	for _, loc := range locs {
+		group[loc.GateId] = append(group[loc.GateId], loc.ChannelId)
+	}
+	for gateway, channels := range group {
+		gateways[gateway] = struct{}{}
+		resp.Gateways = len(gateways)
+		// 每个网关使用单独的packet, 避免meta重复
+		err := p.Dispatcher.Push(gateway, channels, newPacket(command, dest, body))
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "push",
+				"gateway": gateway,
+			}).Warn(err)
+			resp.Failed += len(channels)
+			continue
+		}
+		resp.Online += len(channels)
+	}
+	return nil
+}
+
+func (p *Pusher) members(ctx context.Context, app, group string) ([]string, error) {
+	resp, err := p.Groups.Members(ctx, app, &rpc.GroupMembersReq{GroupId: group})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, len(resp.GetUsers()))
+	for i, user := range resp.GetUsers() {
+		members[i] = user.Account
+	}
+	return members, nil
+}
+
+func (p *Pusher) insertReq(req *PushReq, dest string, sendTime int64) *rpc.InsertMessageReq {
+	return &rpc.InsertMessageReq{
+		Sender:   req.Sender,
+		Dest:     dest,
+		SendTime: sendTime,
+		Message: &rpc.Message{
+			Type:  req.Type,
+			Body:  req.Body,
+			Extra: req.Extra,
+		},
+	}
+}
+
+func (p *Pusher) messagePush(req *PushReq, inserted *rpc.InsertMessageResp, sendTime int64) *pkt.MessagePush {
+	return &pkt.MessagePush{
+		MessageId: inserted.GetMessageId(),
+		Type:      req.Type,
+		Body:      req.Body,
+		Extra:     req.Extra,
+		Sender:    req.Sender,
+		SendTime:  sendTime,
+		Seq:       inserted.GetSeq(),
+	}
+}
+
+func newPacket(command, dest string, body proto.Message) *pkt.LogicPkt {
+	packet := pkt.New(command, pkt.WithDest(dest))
+	packet.Flag = pkt.Flag_Push
+	packet.WriteBody(body)
+	return packet
+}
+
+func uniq(list []string) []string {
+	seen := make(map[string]struct{}, len(list))
+	res := make([]string, 0, len(list))
+	for _, s := range list {
+		if _, ok := seen[s]; ok || s == "" {
+			continue
+		}
+		seen[s] = struct{}{}
+		res = append(res, s)
+	}
+	return res
+}
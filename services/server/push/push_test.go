@@ -0,0 +1,244 @@
+package push
+
+import (
+	"EIM"
+	"EIM/naming"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type fakeStorage map[string]*EIM.Location
+
+func (s fakeStorage) Add(session *pkt.Session) error                { return nil }
+func (s fakeStorage) Delete(account string, channelId string) error { return nil }
+func (s fakeStorage) Get(channelId string) (*pkt.Session, error)    { return nil, EIM.ErrSessionNil }
+func (s fakeStorage) GetLocation(account, device string) (*EIM.Location, error) {
+	if loc, ok := s[account]; ok {
+		return loc, nil
+	}
+	return nil, EIM.ErrSessionNil
+}
+func (s fakeStorage) GetLocations(accounts ...string) ([]*EIM.Location, error) {
+	var locs []*EIM.Location
+	for _, account := range accounts {
+		if loc, ok := s[account]; ok {
+			locs = append(locs, loc)
+		}
+	}
+	if len(locs) == 0 {
+		return nil, EIM.ErrSessionNil
+	}
+	return locs, nil
+}
+
+type pushed struct {
+	gateway  string
+	channels []string
+	packet   *pkt.LogicPkt
+}
+
+type fakeDispatcher struct {
+	pushed []pushed
+}
+
+func (d *fakeDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	sort.Strings(channels)
+	d.pushed = append(d.pushed, pushed{gateway, channels, p})
+	return nil
+}
+
+type fakeGroup map[string][]string
+
+func (g fakeGroup) Create(ctx context.Context, app string, req *rpc.CreateGroupReq) (*rpc.CreateGroupResp, error) {
+	return nil, nil
+}
+func (g fakeGroup) Members(ctx context.Context, app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error) {
+	resp := &rpc.GroupMembersResp{}
+	for _, m := range g[req.GroupId] {
+		resp.Users = append(resp.Users, &rpc.Member{Account: m})
+	}
+	return resp, nil
+}
+func (g fakeGroup) Join(ctx context.Context, app string, req *rpc.JoinGroupReq) (*rpc.JoinGroupResp, error) {
+	return &rpc.JoinGroupResp{}, nil
+}
+func (g fakeGroup) Quit(ctx context.Context, app string, req *rpc.QuitGroupReq) error { return nil }
+func (g fakeGroup) Detail(ctx context.Context, app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error) {
+	return nil, nil
+}
+func (g fakeGroup) Groups(ctx context.Context, app string, req *rpc.UserGroupsReq) (*rpc.UserGroupsResp, error) {
+	return &rpc.UserGroupsResp{}, nil
+}
+func (g fakeGroup) Kick(ctx context.Context, app string, req *rpc.KickMemberReq) error { return nil }
+func (g fakeGroup) Transfer(ctx context.Context, app string, req *rpc.TransferOwnerReq) error {
+	return nil
+}
+func (g fakeGroup) SetAdmin(ctx context.Context, app string, req *rpc.SetAdminReq) error { return nil }
+func (g fakeGroup) Mute(ctx context.Context, app string, req *rpc.MuteReq) (*rpc.MuteResp, error) {
+	return &rpc.MuteResp{}, nil
+}
+func (g fakeGroup) Update(ctx context.Context, app string, req *rpc.UpdateGroupReq) error { return nil }
+func (g fakeGroup) Dissolve(ctx context.Context, app string, req *rpc.DissolveGroupReq) error {
+	return nil
+}
+func (g fakeGroup) Invite(ctx context.Context, app string, req *rpc.InviteGroupReq) (*rpc.InviteGroupResp, error) {
+	return &rpc.InviteGroupResp{}, nil
+}
+func (g fakeGroup) Approve(ctx context.Context, app string, req *rpc.ApproveJoinReq) (*rpc.ApproveJoinResp, error) {
+	return &rpc.ApproveJoinResp{}, nil
+}
+func (g fakeGroup) JoinRequests(ctx context.Context, app string, req *rpc.JoinRequestsReq) (*rpc.JoinRequestsResp, error) {
+	return &rpc.JoinRequestsResp{}, nil
+}
+
+type fakeMessage struct {
+	inserted []*rpc.InsertMessageReq
+}
+
+func (m *fakeMessage) insert(req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
+	m.inserted = append(m.inserted, req)
+	return &rpc.InsertMessageResp{MessageId: int64(len(m.inserted))}, nil
+}
+func (m *fakeMessage) InsertUser(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
+	return m.insert(req)
+}
+func (m *fakeMessage) InsertGroup(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
+	return m.insert(req)
+}
+func (m *fakeMessage) SetAck(ctx context.Context, app string, req *rpc.AckMessageReq) error {
+	return nil
+}
+func (m *fakeMessage) GetMessageIndex(ctx context.Context, app string, req *rpc.GetOfflineMessageIndexReq) (*rpc.GetOfflineMessageIndexResp, error) {
+	return nil, nil
+}
+func (m *fakeMessage) GetMessageContent(ctx context.Context, app string, req *rpc.GetOfflineMessageContentReq) (*rpc.GetOfflineMessageContentResp, error) {
+	return nil, nil
+}
+func (m *fakeMessage) History(ctx context.Context, app string, req *rpc.HistoryReq) (*rpc.HistoryResp, error) {
+	return nil, nil
+}
+
+type fakeNaming struct {
+	naming.Naming
+	services map[string][]EIM.ServiceRegistration
+}
+
+func (n *fakeNaming) Find(name string, tags ...string) ([]EIM.ServiceRegistration, error) {
+	return n.services[name], nil
+}
+
+func newPusher() (*Pusher, *fakeDispatcher, *fakeMessage) {
+	d := &fakeDispatcher{}
+	m := &fakeMessage{}
+	return &Pusher{
+		Storage: fakeStorage{
+			"a": {ChannelId: "gate1_a", GateId: "gate1"},
+			"b": {ChannelId: "gate1_b", GateId: "gate1"},
+			"c": {ChannelId: "gate2_c", GateId: "gate2"},
+		},
+		Dispatcher: d,
+		Naming: &fakeNaming{services: map[string][]EIM.ServiceRegistration{
+			wire.SNWGateway: {&naming.DefaultService{Id: "gate1"}, &naming.DefaultService{Id: "gate2"}},
+		}},
+		Groups:   fakeGroup{"g1": {"a", "c", "offline"}},
+		Messages: m,
+	}, d, m
+}
+
+func summary(list []pushed) []string {
+	var res []string
+	for _, p := range list {
+		res = append(res, p.gateway+":"+strings.Join(p.channels, ","))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestPushOnline(t *testing.T) {
+	p, d, m := newPusher()
+	resp, err := p.Push(context.Background(), "app", &PushReq{Accounts: []string{"a", "b", "a"}, Groups: []string{"g1"}, Body: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Online != 3 || resp.Gateways != 2 || resp.Stored != 0 || len(m.inserted) != 0 {
+		t.Fatalf("unexpected resp %+v", resp)
+	}
+	got := summary(d.pushed)
+	want := []string{"gate1:gate1_a,gate1_b", "gate2:gate2_c"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("pushed %v, want %v", got, want)
+	}
+	if d.pushed[0].packet.Command != wire.CommandSystemNotice || d.pushed[0].packet.Flag != pkt.Flag_Push {
+		t.Fatalf("unexpected packet %s", &d.pushed[0].packet.Header)
+	}
+}
+
+func TestPushOffline(t *testing.T) {
+	p, d, m := newPusher()
+	resp, err := p.Push(context.Background(), "app", &PushReq{Accounts: []string{"offline"}, Groups: []string{"g1"}, Offline: true, Body: "order shipped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stored != 2 || len(m.inserted) != 2 || m.inserted[0].Sender != SystemSender {
+		t.Fatalf("unexpected resp %+v", resp)
+	}
+	// 离线账号没有推送, 群消息推送给两个在线成员
+	if resp.Online != 2 || len(d.pushed) != 2 {
+		t.Fatalf("unexpected resp %+v, pushed %v", resp, summary(d.pushed))
+	}
+	for _, pushed := range d.pushed {
+		if pushed.packet.Command != wire.CommandChatGroupTalk || pushed.packet.Dest != "g1" {
+			t.Fatalf("unexpected packet %s", &pushed.packet.Header)
+		}
+	}
+}
+
+func TestPushAll(t *testing.T) {
+	p, d, _ := newPusher()
+	if _, err := p.Push(context.Background(), "app", &PushReq{All: true, Offline: true}); err != ErrOfflineWithAll {
+		t.Fatalf("err = %v, want %v", err, ErrOfflineWithAll)
+	}
+	if _, err := p.Push(context.Background(), "app", &PushReq{}); err != ErrNoReceiver {
+		t.Fatalf("err = %v, want %v", err, ErrNoReceiver)
+	}
+	resp, err := p.Push(context.Background(), "app", &PushReq{All: true, Body: "maintenance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summary(d.pushed)
+	want := []string{"gate1:*", "gate2:*"}
+	if resp.Gateways != 2 || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("pushed %v, want %v", got, want)
+	}
+}
+
+func TestPushAuth(t *testing.T) {
+	for _, c := range []struct {
+		token, header string
+		code          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusBadRequest}, // 通过校验后因为body为空返回400
+	} {
+		app := NewApp(&PushApi{Token: c.token})
+		if err := app.Build(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/app/push", nil)
+		r.Header.Set("Authorization", c.header)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, r)
+		if rec.Code != c.code {
+			t.Fatalf("token %q header %q: status %d, want %d", c.token, c.header, rec.Code, c.code)
+		}
+	}
+}
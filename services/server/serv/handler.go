@@ -55,10 +55,18 @@ func (h *ServHandler) Receive(ag EIM.Agent, payload []byte) {
 	buf := bytes.NewBuffer(payload)
 	packet, err := pkt.MustReadLogicPkt(buf)
 	if err != nil {
+		// 帧已经完整读取, 连接上的数据没有错乱, 只丢弃这个消息, 不影响同一网关上的其它连接
+		log.WithField("id", ag.ID()).Warn(err)
+		return
+	}
+	// 网关上报会话时ChannelId为网关的ServiceID, 与连接的ID不一致的上报是伪造的, 丢弃
+	if packet.Command == wire.CommandLoginKeepalive && packet.ChannelId != ag.ID() {
+		log.WithField("id", ag.ID()).WithField("channel", packet.ChannelId).Warn("keepalive not from the gateway is dropped")
 		return
 	}
 	var session *pkt.Session
-	if packet.Command == wire.CommandLoginSignIn {
+	// 登录与网关的会话上报还没有缓存的会话, 由网关的ID生成
+	if packet.Command == wire.CommandLoginSignIn || packet.Command == wire.CommandLoginKeepalive {
 		server, _ := packet.GetMeta(wire.MetaDestServer)
 		session = &pkt.Session{
 			ChannelId: packet.ChannelId,
@@ -105,3 +113,30 @@ func (s *ServerDispatcher) Push(gateway string, channels []string, pkt *pkt.Logi
 	pkt.AddStringMeta(wire.MetaDestChannels, strings.Join(channels, ","))
 	return container.Push(gateway, pkt)
 }
+
+// Gateways 返回连接到本服务的所有网关
+func (s *ServerDispatcher) Gateways() []string {
+	return container.ChannelIDs()
+}
+
+// PushTopic 推送给网关上订阅了topic的channel
+func (s *ServerDispatcher) PushTopic(gateway, topic string, pkt *pkt.LogicPkt) error {
+	pkt.AddStringMeta(wire.MetaDestTopic, topic)
+	return container.Push(gateway, pkt)
+}
+
+// Subscribe 将网关上的channels订阅到topics
+func (s *ServerDispatcher) Subscribe(gateway string, topics []string, channels []string) error {
+	return container.Push(gateway, topicPacket(wire.CommandTopicSubscribe, topics, channels))
+}
+
+// Unsubscribe 取消网关上的channels对topics的订阅
+func (s *ServerDispatcher) Unsubscribe(gateway string, topics []string, channels []string) error {
+	return container.Push(gateway, topicPacket(wire.CommandTopicUnsubscribe, topics, channels))
+}
+
+func topicPacket(command string, topics []string, channels []string) *pkt.LogicPkt {
+	p := pkt.New(command)
+	p.WriteBody(&pkt.TopicReq{Topics: topics, ChannelIds: channels})
+	return p
+}
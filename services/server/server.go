@@ -3,22 +3,27 @@ package server
 import (
 	"EIM"
 	"EIM/container"
+	"EIM/health"
 	"EIM/logger"
 	"EIM/naming"
 	"EIM/naming/consul"
 	"EIM/services/server/conf"
 	"EIM/services/server/handler"
+	"EIM/services/server/push"
 	"EIM/services/server/serv"
 	"EIM/services/server/service"
 	"EIM/storage"
 	"EIM/tcp"
+	"EIM/tracing"
 	"EIM/wire"
+	"EIM/wire/pkt"
 	"context"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/kataras/iris/v12"
 	"github.com/spf13/cobra"
 )
 
@@ -39,11 +44,41 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Level:    config.LogLevel,
 		Filename: "./data/server.log",
 	})
+	// 初始化链路追踪
+	shutdownTracing, err := tracing.Init(opts.serviceName, tracing.Settings{
+		Exporter:    config.TraceExporter,
+		Endpoint:    config.TraceEndpoint,
+		SampleRatio: config.TraceSample,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+	// 设置解包时的长度限制, 与网关保持一致
+	pkt.SetMaxSize(config.MaxHeaderSize, config.MaxBodySize)
+	// 初始化redis
+	redis, err := conf.InitRedis(config.RedisAddrs, "")
+	if err != nil {
+		return err
+	}
+	health.Add("redis", func(ctx context.Context) error {
+		return redis.Ping(ctx).Err()
+	})
 	var groupService service.Group
 	var messageService service.Message
+	var friendService service.Friend
+	var userService service.User
+	var conversationService service.Conversation
+	var mediaService service.Media
 	if strings.TrimSpace(config.RoyalURL) != "" {
 		groupService = service.NewGroupService(config.RoyalURL)
 		messageService = service.NewMessageService(config.RoyalURL)
+		friendService = service.NewFriendService(config.RoyalURL)
+		userService = service.NewUserService(config.RoyalURL)
+		conversationService = service.NewConversationService(config.RoyalURL)
+		mediaService = service.NewMediaService(config.RoyalURL)
 	} else {
 		srv := &resty.SRVRecord{
 			Service: "consul",
@@ -51,37 +86,97 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		}
 		groupService = service.NewGroupServiceWithSRV("http", srv)
 		messageService = service.NewMessageServiceWithSRV("http", srv)
+		friendService = service.NewFriendServiceWithSRV("http", srv)
+		userService = service.NewUserServiceWithSRV("http", srv)
+		conversationService = service.NewConversationServiceWithSRV("http", srv)
+		mediaService = service.NewMediaServiceWithSRV("http", srv)
 	}
+	// 群成员与群信息的缓存, 由royal或其它服务发布的变更事件清除本地缓存
+	groupCache, err := service.NewGroupCache(groupService, redis, config.GroupCacheSize)
+	if err != nil {
+		return err
+	}
+	groupCache.SetTTL(config.GroupCacheTTL, config.GroupRedisTTL)
+	go func() {
+		if err := groupCache.Watch(ctx); err != nil {
+			logger.Warn(err)
+		}
+	}()
+	groupService = groupCache
+	// 在线状态, 由网关定时上报会话刷新, 过期的在线状态由各服务清理并通知下线
+	presence := service.NewPresence(storage.NewPresenceStorage(redis, config.PresenceTTL), &serv.ServerDispatcher{}, config.PresenceTTL)
+	go presence.Keep(ctx)
 	// 初始化Router
 	r := EIM.NewRouter()
 	// login
-	loginHandler := handler.NewLoginHandler()
+	loginHandler := handler.NewLoginHandler(groupService, presence)
 	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
 	r.Handle(wire.CommandLoginSignOut, loginHandler.DoSysLogout)
+	r.Handle(wire.CommandLoginKeepalive, loginHandler.DoSysKeepalive)
 	// talk
-	chatHandler := handler.NewChatHandler(messageService, groupService)
+	chatHandler := handler.NewChatHandler(messageService, groupService, friendService, mediaService, config.TalkPolicies)
 	r.Handle(wire.CommandChatUserTalk, chatHandler.DoUserTalk)
 	r.Handle(wire.CommandChatGroupTalk, chatHandler.DoGroupTalk)
+	// transient
+	chatHandler.SetTransientLimit(config.TransientRate, config.TransientBurst)
+	r.Handle(wire.CommandTransientUser, chatHandler.DoUserTransient)
+	r.Handle(wire.CommandTransientGroup, chatHandler.DoGroupTransient)
 	// group
-	groupHandler := handler.NewGroupHandler(groupService)
+	groupHandler := handler.NewGroupHandler(groupService, messageService)
 	r.Handle(wire.CommandGroupCreate, groupHandler.DoCreate)
 	r.Handle(wire.CommandGroupJoin, groupHandler.DoJoin)
 	r.Handle(wire.CommandGroupQuit, groupHandler.DoQuit)
 	r.Handle(wire.CommandGroupDetail, groupHandler.DoDetail)
+	r.Handle(wire.CommandGroupMembers, groupHandler.DoMembers)
+	r.Handle(wire.CommandGroupKick, groupHandler.DoKick)
+	r.Handle(wire.CommandGroupTransfer, groupHandler.DoTransfer)
+	r.Handle(wire.CommandGroupSetAdmin, groupHandler.DoSetAdmin)
+	r.Handle(wire.CommandGroupMute, groupHandler.DoMute)
+	r.Handle(wire.CommandGroupUpdate, groupHandler.DoUpdate)
+	r.Handle(wire.CommandGroupDissolve, groupHandler.DoDissolve)
+	r.Handle(wire.CommandGroupInvite, groupHandler.DoInvite)
+	r.Handle(wire.CommandGroupApprove, groupHandler.DoApprove)
+	r.Handle(wire.CommandGroupRequests, groupHandler.DoRequests)
+	// user
+	userHandler := handler.NewUserHandler(userService)
+	r.Handle(wire.CommandUserProfiles, userHandler.DoProfiles)
+	r.Handle(wire.CommandUserUpdate, userHandler.DoUpdate)
+	// friend
+	friendHandler := handler.NewFriendHandler(friendService, messageService)
+	r.Handle(wire.CommandFriendAdd, friendHandler.DoAdd)
+	r.Handle(wire.CommandFriendApprove, friendHandler.DoApprove)
+	r.Handle(wire.CommandFriendRequests, friendHandler.DoRequests)
+	r.Handle(wire.CommandFriendList, friendHandler.DoList)
+	r.Handle(wire.CommandFriendRemark, friendHandler.DoRemark)
+	r.Handle(wire.CommandFriendDelete, friendHandler.DoDelete)
+	r.Handle(wire.CommandFriendBlock, friendHandler.DoBlock)
+	r.Handle(wire.CommandFriendBlocklist, friendHandler.DoBlocklist)
+	// presence
+	presenceHandler := handler.NewPresenceHandler(presence, friendService)
+	r.Handle(wire.CommandPresenceQuery, presenceHandler.DoQuery)
+	r.Handle(wire.CommandPresenceSubscribe, presenceHandler.DoSubscribe)
+	r.Handle(wire.CommandPresenceUnsubscribe, presenceHandler.DoUnsubscribe)
+	r.Handle(wire.CommandPresenceSet, presenceHandler.DoSet)
+	// conversation
+	conversationHandler := handler.NewConversationHandler(conversationService, messageService)
+	r.Handle(wire.CommandConversationList, conversationHandler.DoList)
+	r.Handle(wire.CommandConversationUpdate, conversationHandler.DoUpdate)
+	r.Handle(wire.CommandChatTalkAck, conversationHandler.DoAck)
+	r.Handle(wire.CommandChatHistory, conversationHandler.DoHistory)
 	// offline
 	offlineHandler := handler.NewOfflineHandler(messageService)
 	r.Handle(wire.CommandOfflineIndex, offlineHandler.DoSyncIndex)
 	r.Handle(wire.CommandOfflineContent, offlineHandler.DoSyncContent)
-	// 初始化redis
-	redis, err := conf.InitRedis(config.RedisAddrs, "")
-	if err != nil {
-		return err
-	}
+	// media
+	mediaHandler := handler.NewMediaHandler(mediaService)
+	r.Handle(wire.CommandMediaToken, mediaHandler.DoToken)
+	r.Handle(wire.CommandMediaComplete, mediaHandler.DoComplete)
+	r.Handle(wire.CommandMediaUrl, mediaHandler.DoUrl)
 	// 初始化会话管理
 	cache := storage.NewRedisStorage(redis)
 	servHandler := serv.NewServHandler(r, cache)
 	meta := make(map[string]string)
-	meta[consul.KeyHealthURL] = fmt.Sprintf("http://%s:%d/health", config.PublicAddress, config.MonitorPort)
+	meta[consul.KeyHealthURL] = fmt.Sprintf("http://%s:%d/ready", config.PublicAddress, config.MonitorPort)
 	service := &naming.DefaultService{
 		Id:       config.ServiceID,
 		Name:     opts.serviceName,
@@ -108,6 +203,34 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		return err
 	}
 	container.SetServiceNaming(ns)
+	// 开启推送接口, 供后台系统向用户推送消息
+	if config.PushPort != 0 && opts.serviceName == wire.SNChat && config.PushToken == "" {
+		logger.Warn("PushToken is empty, push api is disabled")
+	} else if config.PushPort != 0 && opts.serviceName == wire.SNChat {
+		app := push.NewApp(&push.PushApi{
+			Pusher: &push.Pusher{
+				Storage:    cache,
+				Dispatcher: &serv.ServerDispatcher{},
+				Naming:     ns,
+				Groups:     groupService,
+				Messages:   messageService,
+			},
+			Token: config.PushToken,
+		})
+		go func() {
+			err := app.Listen(fmt.Sprintf(":%d", config.PushPort), iris.WithOptimizations)
+			if err != nil {
+				logger.Error(err)
+			}
+		}()
+	}
+	// 在监控端口上提供/health, /ready与/metrics
+	go func() {
+		err := health.Serve(fmt.Sprintf(":%d", config.MonitorPort))
+		if err != nil {
+			logger.Error(err)
+		}
+	}()
 	// 启动container
 	return container.Start()
 }
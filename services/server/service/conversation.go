@@ -0,0 +1,93 @@
+package service
+
+import (
+	"EIM/tracing"
+	"EIM/wire/rpc"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+type Conversation interface {
+	List(ctx context.Context, app string, req *rpc.ConversationsReq) (*rpc.ConversationsResp, error)
+	Update(ctx context.Context, app string, req *rpc.UpdateConversationReq) error
+	Read(ctx context.Context, app string, req *rpc.ReadConversationReq) error
+}
+
+type ConversationHttp struct {
+	url string
+	cli *resty.Client
+	srv *resty.SRVRecord
+}
+
+// List 同步version之后变更的会话
+func (c *ConversationHttp) List(ctx context.Context, app string, req *rpc.ConversationsReq) (*rpc.ConversationsResp, error) {
+	var resp rpc.ConversationsResp
+	err := c.do(ctx, "ConversationHttp.List", http.MethodPost, fmt.Sprintf("%s/api/%s/conversation/list", c.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Update 置顶或免打扰
+func (c *ConversationHttp) Update(ctx context.Context, app string, req *rpc.UpdateConversationReq) error {
+	return c.do(ctx, "ConversationHttp.Update", http.MethodPost, fmt.Sprintf("%s/api/%s/conversation/update", c.url, app), req, nil)
+}
+
+// Read 标记会话已读
+func (c *ConversationHttp) Read(ctx context.Context, app string, req *rpc.ReadConversationReq) error {
+	return c.do(ctx, "ConversationHttp.Read", http.MethodPost, fmt.Sprintf("%s/api/%s/conversation/read", c.url, app), req, nil)
+}
+
+// do 发送protobuf请求, resp不为nil时解析应答
+func (c *ConversationHttp) do(ctx context.Context, name, method, path string, req proto.Message, resp proto.Message) error {
+	body, _ := proto.Marshal(req)
+	response, err := c.Req(ctx).SetBody(body).Execute(method, path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() != 200 {
+		return statusError(name, response)
+	}
+	if resp != nil {
+		return proto.Unmarshal(response.Body(), resp)
+	}
+	return nil
+}
+
+func (c *ConversationHttp) Req(ctx context.Context) *resty.Request {
+	if c.srv == nil {
+		return c.cli.R().SetContext(ctx)
+	}
+	return c.cli.R().SetContext(ctx).SetSRV(c.srv)
+}
+
+func NewConversationService(url string) Conversation {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme("http")
+	return &ConversationHttp{
+		url: url,
+		cli: cli,
+	}
+}
+
+func NewConversationServiceWithSRV(scheme string, srv *resty.SRVRecord) Conversation {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme(scheme)
+	return &ConversationHttp{
+		url: "",
+		cli: cli,
+		srv: srv,
+	}
+}
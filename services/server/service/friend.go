@@ -0,0 +1,162 @@
+package service
+
+import (
+	"EIM/tracing"
+	"EIM/wire/rpc"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+type Friend interface {
+	Add(ctx context.Context, app string, req *rpc.AddFriendReq) (*rpc.AddFriendResp, error)
+	Approve(ctx context.Context, app string, req *rpc.ApproveFriendReq) (*rpc.ApproveFriendResp, error)
+	Requests(ctx context.Context, app string, account string) (*rpc.FriendRequestsResp, error)
+	List(ctx context.Context, app string, account string) (*rpc.FriendsResp, error)
+	Remark(ctx context.Context, app string, req *rpc.FriendRemarkReq) error
+	Delete(ctx context.Context, app string, req *rpc.DeleteFriendReq) error
+	Block(ctx context.Context, app string, req *rpc.BlockReq) error
+	Blocklist(ctx context.Context, app string, account string) (*rpc.BlocklistResp, error)
+	Relation(ctx context.Context, app string, req *rpc.RelationReq) (*rpc.RelationResp, error)
+}
+
+type FriendHttp struct {
+	url string
+	cli *resty.Client
+	srv *resty.SRVRecord
+}
+
+// Add 发送好友申请
+func (f *FriendHttp) Add(ctx context.Context, app string, req *rpc.AddFriendReq) (*rpc.AddFriendResp, error) {
+	var resp rpc.AddFriendResp
+	err := f.do(ctx, "FriendHttp.Add", http.MethodPost, fmt.Sprintf("%s/api/%s/friend/request", f.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Approve 处理好友申请
+func (f *FriendHttp) Approve(ctx context.Context, app string, req *rpc.ApproveFriendReq) (*rpc.ApproveFriendResp, error) {
+	var resp rpc.ApproveFriendResp
+	err := f.do(ctx, "FriendHttp.Approve", http.MethodPost, fmt.Sprintf("%s/api/%s/friend/approve", f.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Requests 待处理的好友申请
+func (f *FriendHttp) Requests(ctx context.Context, app string, account string) (*rpc.FriendRequestsResp, error) {
+	var resp rpc.FriendRequestsResp
+	err := f.do(ctx, "FriendHttp.Requests", http.MethodGet, fmt.Sprintf("%s/api/%s/friend/requests/%s", f.url, app, account), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// List 好友列表
+func (f *FriendHttp) List(ctx context.Context, app string, account string) (*rpc.FriendsResp, error) {
+	var resp rpc.FriendsResp
+	err := f.do(ctx, "FriendHttp.List", http.MethodGet, fmt.Sprintf("%s/api/%s/friend/list/%s", f.url, app, account), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Remark 修改好友备注
+func (f *FriendHttp) Remark(ctx context.Context, app string, req *rpc.FriendRemarkReq) error {
+	return f.do(ctx, "FriendHttp.Remark", http.MethodPost, fmt.Sprintf("%s/api/%s/friend/remark", f.url, app), req, nil)
+}
+
+// Delete 删除好友
+func (f *FriendHttp) Delete(ctx context.Context, app string, req *rpc.DeleteFriendReq) error {
+	return f.do(ctx, "FriendHttp.Delete", http.MethodDelete, fmt.Sprintf("%s/api/%s/friend", f.url, app), req, nil)
+}
+
+// Block 加入或移出黑名单
+func (f *FriendHttp) Block(ctx context.Context, app string, req *rpc.BlockReq) error {
+	return f.do(ctx, "FriendHttp.Block", http.MethodPost, fmt.Sprintf("%s/api/%s/friend/block", f.url, app), req, nil)
+}
+
+// Blocklist 黑名单
+func (f *FriendHttp) Blocklist(ctx context.Context, app string, account string) (*rpc.BlocklistResp, error) {
+	var resp rpc.BlocklistResp
+	err := f.do(ctx, "FriendHttp.Blocklist", http.MethodGet, fmt.Sprintf("%s/api/%s/friend/blocks/%s", f.url, app, account), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Relation 查询两个用户的好友与黑名单关系
+func (f *FriendHttp) Relation(ctx context.Context, app string, req *rpc.RelationReq) (*rpc.RelationResp, error) {
+	query := url.Values{}
+	query.Set("account", req.Account)
+	query.Set("friend", req.Friend)
+	var resp rpc.RelationResp
+	err := f.do(ctx, "FriendHttp.Relation", http.MethodGet, fmt.Sprintf("%s/api/%s/friend/relation?%s", f.url, app, query.Encode()), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do 发送protobuf请求, req为nil时不带请求体, resp不为nil时解析应答
+func (f *FriendHttp) do(ctx context.Context, name, method, path string, req proto.Message, resp proto.Message) error {
+	r := f.Req(ctx)
+	if req != nil {
+		body, _ := proto.Marshal(req)
+		r.SetBody(body)
+	}
+	response, err := r.Execute(method, path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() != 200 {
+		return statusError(name, response)
+	}
+	if resp != nil {
+		return proto.Unmarshal(response.Body(), resp)
+	}
+	return nil
+}
+
+func (f *FriendHttp) Req(ctx context.Context) *resty.Request {
+	if f.srv == nil {
+		return f.cli.R().SetContext(ctx)
+	}
+	return f.cli.R().SetContext(ctx).SetSRV(f.srv)
+}
+
+func NewFriendService(url string) Friend {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme("http")
+	return &FriendHttp{
+		url: url,
+		cli: cli,
+	}
+}
+
+func NewFriendServiceWithSRV(scheme string, srv *resty.SRVRecord) Friend {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme(scheme)
+	return &FriendHttp{
+		url: "",
+		cli: cli,
+		srv: srv,
+	}
+}
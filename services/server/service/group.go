@@ -2,8 +2,12 @@ package service
 
 import (
 	"EIM/logger"
+	"EIM/tracing"
 	"EIM/wire/rpc"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -11,11 +15,44 @@ import (
 )
 
 type Group interface {
-	Create(app string, req *rpc.CreateGroupReq) (*rpc.CreateGroupResp, error)
-	Members(app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error)
-	Join(app string, req *rpc.JoinGroupReq) error
-	Quit(app string, req *rpc.QuitGroupReq) error
-	Detail(app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error)
+	Create(ctx context.Context, app string, req *rpc.CreateGroupReq) (*rpc.CreateGroupResp, error)
+	Members(ctx context.Context, app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error)
+	Join(ctx context.Context, app string, req *rpc.JoinGroupReq) (*rpc.JoinGroupResp, error)
+	Quit(ctx context.Context, app string, req *rpc.QuitGroupReq) error
+	Detail(ctx context.Context, app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error)
+	Groups(ctx context.Context, app string, req *rpc.UserGroupsReq) (*rpc.UserGroupsResp, error)
+	Kick(ctx context.Context, app string, req *rpc.KickMemberReq) error
+	Transfer(ctx context.Context, app string, req *rpc.TransferOwnerReq) error
+	SetAdmin(ctx context.Context, app string, req *rpc.SetAdminReq) error
+	Mute(ctx context.Context, app string, req *rpc.MuteReq) (*rpc.MuteResp, error)
+	Update(ctx context.Context, app string, req *rpc.UpdateGroupReq) error
+	Dissolve(ctx context.Context, app string, req *rpc.DissolveGroupReq) error
+	Invite(ctx context.Context, app string, req *rpc.InviteGroupReq) (*rpc.InviteGroupResp, error)
+	Approve(ctx context.Context, app string, req *rpc.ApproveJoinReq) (*rpc.ApproveJoinResp, error)
+	JoinRequests(ctx context.Context, app string, req *rpc.JoinRequestsReq) (*rpc.JoinRequestsResp, error)
+}
+
+// royal返回的业务错误
+var (
+	ErrForbidden      = errors.New("forbidden")
+	ErrNotFound       = errors.New("not found")
+	ErrBadRequest     = errors.New("bad request")
+	ErrNotImplemented = errors.New("not implemented")
+)
+
+// statusError 根据royal返回的状态码生成错误, 403, 404, 400与501可以用errors.Is判断
+func statusError(name string, response *resty.Response) error {
+	switch response.StatusCode() {
+	case http.StatusForbidden:
+		return fmt.Errorf("%s: %w, %s", name, ErrForbidden, response.Body())
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: %w, %s", name, ErrNotFound, response.Body())
+	case http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%s: %w, %s", name, ErrBadRequest, response.Body())
+	case http.StatusNotImplemented:
+		return fmt.Errorf("%s: %w, %s", name, ErrNotImplemented, response.Body())
+	}
+	return fmt.Errorf("%s response.StatusCode() = %d, but want 200", name, response.StatusCode())
 }
 
 type GroupHttp struct {
@@ -25,10 +62,10 @@ type GroupHttp struct {
 }
 
 // Create 创建组
-func (g *GroupHttp) Create(app string, req *rpc.CreateGroupReq) (*rpc.CreateGroupResp, error) {
+func (g *GroupHttp) Create(ctx context.Context, app string, req *rpc.CreateGroupReq) (*rpc.CreateGroupResp, error) {
 	path := fmt.Sprintf("%s/api/%s/group", g.url, app)
 	body, _ := proto.Marshal(req)
-	response, err := g.Req().SetBody(body).Post(path)
+	response, err := g.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return nil, err
 	}
@@ -37,14 +74,14 @@ func (g *GroupHttp) Create(app string, req *rpc.CreateGroupReq) (*rpc.CreateGrou
 	}
 	var resp rpc.CreateGroupResp
 	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("GroupHttp.Create resp: %v", &resp)
+	logger.WithContext(ctx).Debugf("GroupHttp.Create resp: %v", &resp)
 	return &resp, nil
 }
 
 // Members 返回所有组成员
-func (g *GroupHttp) Members(app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error) {
+func (g *GroupHttp) Members(ctx context.Context, app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error) {
 	path := fmt.Sprintf("%s/api/%s/group/members/%s", g.url, app, req.GroupId)
-	response, err := g.Req().Get(path)
+	response, err := g.Req(ctx).Get(path)
 	if err != nil {
 		return nil, err
 	}
@@ -53,42 +90,38 @@ func (g *GroupHttp) Members(app string, req *rpc.GroupMembersReq) (*rpc.GroupMem
 	}
 	var resp rpc.GroupMembersResp
 	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Errorf("GroupHttp.Members response: %v", &resp)
+	logger.WithContext(ctx).Errorf("GroupHttp.Members response: %v", &resp)
 	return &resp, nil
 }
 
-// Join 加入组
-func (g *GroupHttp) Join(app string, req *rpc.JoinGroupReq) error {
-	path := fmt.Sprintf("%s/api/%s/group/member", g.url, app)
-	body, _ := proto.Marshal(req)
-	response, err := g.Req().SetBody(body).Post(path)
+// Join 加入组, 需要审批时应答中Pending为true
+func (g *GroupHttp) Join(ctx context.Context, app string, req *rpc.JoinGroupReq) (*rpc.JoinGroupResp, error) {
+	var resp rpc.JoinGroupResp
+	err := g.post(ctx, "GroupHttp.Join", fmt.Sprintf("%s/api/%s/group/member", g.url, app), req, &resp)
 	if err != nil {
-		return err
-	}
-	if response.StatusCode() != 200 {
-		return fmt.Errorf("GroupHttp.Join response.StatusCode() = %d, but want 200", response.StatusCode())
+		return nil, err
 	}
-	return nil
+	return &resp, nil
 }
 
 // Quit 退出组
-func (g *GroupHttp) Quit(app string, req *rpc.QuitGroupReq) error {
+func (g *GroupHttp) Quit(ctx context.Context, app string, req *rpc.QuitGroupReq) error {
 	path := fmt.Sprintf("%s/api/%s/group/member", g.url, app)
 	body, _ := proto.Marshal(req)
-	response, err := g.Req().SetBody(body).Delete(path)
+	response, err := g.Req(ctx).SetBody(body).Delete(path)
 	if err != nil {
 		return err
 	}
 	if response.StatusCode() != 200 {
-		return fmt.Errorf("GroupHttp.Quit response.StatusCode() = %d, but want 200", response.StatusCode())
+		return statusError("GroupHttp.Quit", response)
 	}
 	return nil
 }
 
 // Detail 组信息
-func (g *GroupHttp) Detail(app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error) {
+func (g *GroupHttp) Detail(ctx context.Context, app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error) {
 	path := fmt.Sprintf("%s/api/%s/group/%s", g.url, app, req.GroupId)
-	response, err := g.Req().Get(path)
+	response, err := g.Req(ctx).Get(path)
 	if err != nil {
 		return nil, err
 	}
@@ -97,19 +130,122 @@ func (g *GroupHttp) Detail(app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp,
 	}
 	var resp rpc.GetGroupResp
 	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("GroupHttp.Detail resp: %v", &resp)
+	logger.WithContext(ctx).Debugf("GroupHttp.Detail resp: %v", &resp)
 	return &resp, nil
 }
 
-func (g *GroupHttp) Req() *resty.Request {
+// Groups 用户加入的所有组
+func (g *GroupHttp) Groups(ctx context.Context, app string, req *rpc.UserGroupsReq) (*rpc.UserGroupsResp, error) {
+	path := fmt.Sprintf("%s/api/%s/group/user/%s", g.url, app, req.Account)
+	response, err := g.Req(ctx).Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("GroupHttp.Groups response.StatusCode() = %d, but want 200", response.StatusCode())
+	}
+	var resp rpc.UserGroupsResp
+	_ = proto.Unmarshal(response.Body(), &resp)
+	logger.WithContext(ctx).Debugf("GroupHttp.Groups resp: %v", &resp)
+	return &resp, nil
+}
+
+// Kick 踢出成员
+func (g *GroupHttp) Kick(ctx context.Context, app string, req *rpc.KickMemberReq) error {
+	return g.post(ctx, "GroupHttp.Kick", fmt.Sprintf("%s/api/%s/group/kick", g.url, app), req, nil)
+}
+
+// Transfer 转让群主
+func (g *GroupHttp) Transfer(ctx context.Context, app string, req *rpc.TransferOwnerReq) error {
+	return g.post(ctx, "GroupHttp.Transfer", fmt.Sprintf("%s/api/%s/group/transfer", g.url, app), req, nil)
+}
+
+// SetAdmin 设置或取消管理员
+func (g *GroupHttp) SetAdmin(ctx context.Context, app string, req *rpc.SetAdminReq) error {
+	return g.post(ctx, "GroupHttp.SetAdmin", fmt.Sprintf("%s/api/%s/group/admin", g.url, app), req, nil)
+}
+
+// Mute 禁言成员或全员
+func (g *GroupHttp) Mute(ctx context.Context, app string, req *rpc.MuteReq) (*rpc.MuteResp, error) {
+	var resp rpc.MuteResp
+	err := g.post(ctx, "GroupHttp.Mute", fmt.Sprintf("%s/api/%s/group/mute", g.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Update 修改群信息
+func (g *GroupHttp) Update(ctx context.Context, app string, req *rpc.UpdateGroupReq) error {
+	return g.post(ctx, "GroupHttp.Update", fmt.Sprintf("%s/api/%s/group/update", g.url, app), req, nil)
+}
+
+// Dissolve 解散群
+func (g *GroupHttp) Dissolve(ctx context.Context, app string, req *rpc.DissolveGroupReq) error {
+	return g.post(ctx, "GroupHttp.Dissolve", fmt.Sprintf("%s/api/%s/group/dissolve", g.url, app), req, nil)
+}
+
+// Invite 邀请入群
+func (g *GroupHttp) Invite(ctx context.Context, app string, req *rpc.InviteGroupReq) (*rpc.InviteGroupResp, error) {
+	var resp rpc.InviteGroupResp
+	err := g.post(ctx, "GroupHttp.Invite", fmt.Sprintf("%s/api/%s/group/invite", g.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Approve 审批入群申请
+func (g *GroupHttp) Approve(ctx context.Context, app string, req *rpc.ApproveJoinReq) (*rpc.ApproveJoinResp, error) {
+	var resp rpc.ApproveJoinResp
+	err := g.post(ctx, "GroupHttp.Approve", fmt.Sprintf("%s/api/%s/group/approve", g.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// JoinRequests 待审批的入群申请
+func (g *GroupHttp) JoinRequests(ctx context.Context, app string, req *rpc.JoinRequestsReq) (*rpc.JoinRequestsResp, error) {
+	path := fmt.Sprintf("%s/api/%s/group/requests/%s", g.url, app, req.GroupId)
+	response, err := g.Req(ctx).SetQueryParam("operator", req.Operator).Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode() != 200 {
+		return nil, statusError("GroupHttp.JoinRequests", response)
+	}
+	var resp rpc.JoinRequestsResp
+	_ = proto.Unmarshal(response.Body(), &resp)
+	return &resp, nil
+}
+
+// post 发送protobuf请求, resp不为nil时解析应答
+func (g *GroupHttp) post(ctx context.Context, name, path string, req proto.Message, resp proto.Message) error {
+	body, _ := proto.Marshal(req)
+	response, err := g.Req(ctx).SetBody(body).Post(path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() != 200 {
+		return statusError(name, response)
+	}
+	if resp != nil {
+		return proto.Unmarshal(response.Body(), resp)
+	}
+	return nil
+}
+
+func (g *GroupHttp) Req(ctx context.Context) *resty.Request {
 	if g.srv == nil {
-		return g.cli.R()
+		return g.cli.R().SetContext(ctx)
 	}
-	return g.cli.R().SetSRV(g.srv)
+	return g.cli.R().SetContext(ctx).SetSRV(g.srv)
 }
 
 func NewGroupService(url string) Group {
 	cli := resty.New().SetRetryCount(5).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
 	cli.SetHeader("Content-type", "application/x-protobuf")
 	cli.SetHeader("Accept", "application/x-protobuf")
 	cli.SetScheme("http")
@@ -122,6 +258,7 @@ func NewGroupService(url string) Group {
 
 func NewGroupServiceWithSRV(scheme string, srv *resty.SRVRecord) Group {
 	cli := resty.New().SetRetryCount(5).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
 	cli.SetHeader("Content-type", "application/x-protobuf")
 	cli.SetHeader("Accept", "application/x-protobuf")
 	cli.SetScheme(scheme)
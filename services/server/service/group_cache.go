@@ -0,0 +1,291 @@
+package service
+
+import (
+	"EIM/logger"
+	"EIM/metrics"
+	"EIM/storage"
+	"EIM/wire/rpc"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru"
+	"google.golang.org/protobuf/proto"
+)
+
+// 群缓存的默认配置
+const (
+	DefaultGroupCacheSize = 10000
+	DefaultGroupLocalTTL  = time.Minute
+	DefaultGroupRedisTTL  = time.Minute * 5
+)
+
+const (
+	cacheKindMembers = "members"
+	cacheKindDetail  = "detail"
+)
+
+// generationSlots 本地代数的分片数, 不同的群可能共用一个代数, 只会多一次回源
+const generationSlots = 256
+
+type cacheEntry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// GroupCache 在Group之上增加本地LRU与redis两级缓存, 缓存群成员与群信息
+type GroupCache struct {
+	Group
+	local    *lru.Cache
+	redis    *redis.Client
+	localTTL time.Duration
+	redisTTL time.Duration
+	// 群变更时代数加1, 读取之前变更过的数据不再写回本地缓存
+	mu          sync.Mutex
+	generations [generationSlots]uint64
+}
+
+// NewGroupCache 创建GroupCache, cli为nil时只使用本地缓存
+func NewGroupCache(group Group, cli *redis.Client, size int) (*GroupCache, error) {
+	if size <= 0 {
+		size = DefaultGroupCacheSize
+	}
+	local, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &GroupCache{
+		Group:    group,
+		local:    local,
+		redis:    cli,
+		localTTL: DefaultGroupLocalTTL,
+		redisTTL: DefaultGroupRedisTTL,
+	}, nil
+}
+
+// SetTTL 设置本地缓存与redis缓存的过期时间
+func (g *GroupCache) SetTTL(local, remote time.Duration) {
+	if local > 0 {
+		g.localTTL = local
+	}
+	if remote > 0 {
+		g.redisTTL = remote
+	}
+}
+
+// Members 返回所有组成员, 优先从缓存中读取
+func (g *GroupCache) Members(ctx context.Context, app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error) {
+	var resp rpc.GroupMembersResp
+	err := g.load(ctx, cacheKindMembers, req.GroupId, storage.KeyGroupMembers(req.GroupId), &resp, func() (proto.Message, error) {
+		return g.Group.Members(ctx, app, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detail 组信息, 优先从缓存中读取
+func (g *GroupCache) Detail(ctx context.Context, app string, req *rpc.GetGroupReq) (*rpc.GetGroupResp, error) {
+	var resp rpc.GetGroupResp
+	err := g.load(ctx, cacheKindDetail, req.GroupId, storage.KeyGroupDetail(req.GroupId), &resp, func() (proto.Message, error) {
+		return g.Group.Detail(ctx, app, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Join 加入组, 成功加入后清除缓存, 等待审批时成员没有变化
+func (g *GroupCache) Join(ctx context.Context, app string, req *rpc.JoinGroupReq) (*rpc.JoinGroupResp, error) {
+	resp, err := g.Group.Join(ctx, app, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.GetPending() {
+		g.Invalidate(ctx, req.GroupId)
+	}
+	return resp, nil
+}
+
+// Quit 退出组, 成功后清除缓存
+func (g *GroupCache) Quit(ctx context.Context, app string, req *rpc.QuitGroupReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.Quit(ctx, app, req))
+}
+
+// Kick 踢出成员, 成功后清除缓存
+func (g *GroupCache) Kick(ctx context.Context, app string, req *rpc.KickMemberReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.Kick(ctx, app, req))
+}
+
+// Transfer 转让群主, 成功后清除缓存
+func (g *GroupCache) Transfer(ctx context.Context, app string, req *rpc.TransferOwnerReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.Transfer(ctx, app, req))
+}
+
+// SetAdmin 设置管理员, 成功后清除缓存
+func (g *GroupCache) SetAdmin(ctx context.Context, app string, req *rpc.SetAdminReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.SetAdmin(ctx, app, req))
+}
+
+// Mute 禁言, 成功后清除缓存
+func (g *GroupCache) Mute(ctx context.Context, app string, req *rpc.MuteReq) (*rpc.MuteResp, error) {
+	resp, err := g.Group.Mute(ctx, app, req)
+	if err != nil {
+		return nil, err
+	}
+	g.Invalidate(ctx, req.GroupId)
+	return resp, nil
+}
+
+// Update 修改群信息, 成功后清除缓存
+func (g *GroupCache) Update(ctx context.Context, app string, req *rpc.UpdateGroupReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.Update(ctx, app, req))
+}
+
+// Dissolve 解散群, 成功后清除缓存
+func (g *GroupCache) Dissolve(ctx context.Context, app string, req *rpc.DissolveGroupReq) error {
+	return g.invalidateOnSuccess(ctx, req.GroupId, g.Group.Dissolve(ctx, app, req))
+}
+
+// Approve 审批入群申请, 通过后清除缓存
+func (g *GroupCache) Approve(ctx context.Context, app string, req *rpc.ApproveJoinReq) (*rpc.ApproveJoinResp, error) {
+	resp, err := g.Group.Approve(ctx, app, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetApprove() {
+		g.Invalidate(ctx, resp.GetGroupId())
+	}
+	return resp, nil
+}
+
+func (g *GroupCache) invalidateOnSuccess(ctx context.Context, group string, err error) error {
+	if err != nil {
+		return err
+	}
+	g.Invalidate(ctx, group)
+	return nil
+}
+
+// Invalidate 清除群的本地与redis缓存, 并通知其它服务清除本地缓存
+func (g *GroupCache) Invalidate(ctx context.Context, group string) {
+	g.removeLocal(group)
+	if g.redis == nil {
+		return
+	}
+	if err := storage.PublishGroupChanged(ctx, g.redis, group); err != nil {
+		logger.WithContext(ctx).WithField("group", group).Warn(err)
+	}
+}
+
+// Watch 订阅群变更事件并清除本地缓存, 直到ctx结束
+func (g *GroupCache) Watch(ctx context.Context) error {
+	if g.redis == nil {
+		return nil
+	}
+	sub := g.redis.Subscribe(ctx, storage.ChannelGroupChanged)
+	defer sub.Close()
+	// 确认订阅成功
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			g.removeLocal(msg.Payload)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func generationSlot(group string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(group))
+	return int(h.Sum32() % generationSlots)
+}
+
+// generation 返回群当前的代数, 在读取数据之前调用
+func (g *GroupCache) generation(group string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.generations[generationSlot(group)]
+}
+
+func (g *GroupCache) removeLocal(group string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generations[generationSlot(group)]++
+	g.local.Remove(storage.KeyGroupMembers(group))
+	g.local.Remove(storage.KeyGroupDetail(group))
+}
+
+// load 依次从本地缓存, redis与源服务读取数据, 并回填缓存
+// 读取期间群发生了变更时不回填, 避免把变更之前的数据写回缓存
+func (g *GroupCache) load(ctx context.Context, kind, group, key string, dst proto.Message, fetch func() (proto.Message, error)) error {
+	if v, ok := g.local.Get(key); ok {
+		entry := v.(*cacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			metrics.GroupCacheTotal.WithLabelValues(kind, metrics.CacheLocal).Inc()
+			return proto.Unmarshal(entry.value, dst)
+		}
+		g.local.Remove(key)
+	}
+	gen := g.generation(group)
+	var version int64
+	cacheRedis := g.redis != nil
+	if cacheRedis {
+		bs, err := g.redis.Get(ctx, key).Bytes()
+		if err == nil {
+			metrics.GroupCacheTotal.WithLabelValues(kind, metrics.CacheRedis).Inc()
+			g.setLocal(group, key, gen, bs)
+			return proto.Unmarshal(bs, dst)
+		}
+		if err != redis.Nil {
+			logger.WithContext(ctx).WithField("key", key).Warn(err)
+		}
+		// 读不到版本号时无法判断数据是否过期, 不回填redis
+		version, err = storage.GroupVersion(ctx, g.redis, group)
+		if err != nil {
+			logger.WithContext(ctx).WithField("group", group).Warn(err)
+			cacheRedis = false
+		}
+	}
+	metrics.GroupCacheTotal.WithLabelValues(kind, metrics.CacheMiss).Inc()
+	msg, err := fetch()
+	if err != nil {
+		return err
+	}
+	bs, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	g.setLocal(group, key, gen, bs)
+	if cacheRedis {
+		if _, err = storage.SetGroupCache(ctx, g.redis, group, key, version, bs, g.redisTTL); err != nil {
+			logger.WithContext(ctx).WithField("key", key).Warn(err)
+		}
+	}
+	return proto.Unmarshal(bs, dst)
+}
+
+// setLocal 群的代数仍为gen时写入本地缓存
+func (g *GroupCache) setLocal(group, key string, gen uint64, value []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.generations[generationSlot(group)] != gen {
+		return
+	}
+	g.local.Add(key, &cacheEntry{
+		value:    value,
+		expireAt: time.Now().Add(g.localTTL),
+	})
+}
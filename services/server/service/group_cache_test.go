@@ -0,0 +1,119 @@
+package service
+
+import (
+	"EIM/wire/rpc"
+	"context"
+	"testing"
+	"time"
+)
+
+// countingGroup 记录Members被调用的次数
+type countingGroup struct {
+	Group
+	members map[string][]string
+	calls   int
+	pending bool   // 为true时加入群需要审批
+	fetched func() // 读取成员之后调用, 用于模拟读取期间群发生了变更
+}
+
+func (g *countingGroup) Members(ctx context.Context, app string, req *rpc.GroupMembersReq) (*rpc.GroupMembersResp, error) {
+	g.calls++
+	resp := &rpc.GroupMembersResp{}
+	for _, m := range g.members[req.GroupId] {
+		resp.Users = append(resp.Users, &rpc.Member{Account: m})
+	}
+	if g.fetched != nil {
+		g.fetched()
+	}
+	return resp, nil
+}
+
+func (g *countingGroup) Join(ctx context.Context, app string, req *rpc.JoinGroupReq) (*rpc.JoinGroupResp, error) {
+	if g.pending {
+		return &rpc.JoinGroupResp{Pending: true, RequestId: 1}, nil
+	}
+	g.members[req.GroupId] = append(g.members[req.GroupId], req.Account)
+	return &rpc.JoinGroupResp{}, nil
+}
+
+func TestGroupCache(t *testing.T) {
+	src := &countingGroup{members: map[string][]string{"g1": {"a", "b"}}}
+	cache, err := NewGroupCache(src, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := &rpc.GroupMembersReq{GroupId: "g1"}
+	for i := 0; i < 3; i++ {
+		resp, err := cache.Members(ctx, "app", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Users) != 2 {
+			t.Fatalf("got %d members, want 2", len(resp.Users))
+		}
+	}
+	if src.calls != 1 {
+		t.Fatalf("Members called %d times, want 1", src.calls)
+	}
+
+	// 加入群后缓存失效
+	if _, err = cache.Join(ctx, "app", &rpc.JoinGroupReq{GroupId: "g1", Account: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := cache.Members(ctx, "app", req)
+	if len(resp.Users) != 3 || src.calls != 2 {
+		t.Fatalf("got %d members and %d calls after join", len(resp.Users), src.calls)
+	}
+	// 等待审批时成员没有变化, 缓存仍然有效
+	src.pending = true
+	if _, err = cache.Join(ctx, "app", &rpc.JoinGroupReq{GroupId: "g1", Account: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = cache.Members(ctx, "app", req)
+	if src.calls != 2 {
+		t.Fatalf("Members called %d times after pending join, want 2", src.calls)
+	}
+
+	// 本地缓存过期
+	cache.SetTTL(time.Millisecond, 0)
+	cache.Invalidate(ctx, "g1")
+	_, _ = cache.Members(ctx, "app", req)
+	time.Sleep(time.Millisecond * 5)
+	_, _ = cache.Members(ctx, "app", req)
+	if src.calls != 4 {
+		t.Fatalf("Members called %d times, want 4", src.calls)
+	}
+}
+
+func TestGroupCacheInvalidateDuringLoad(t *testing.T) {
+	src := &countingGroup{members: map[string][]string{"g1": {"a", "b"}}}
+	cache, err := NewGroupCache(src, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := &rpc.GroupMembersReq{GroupId: "g1"}
+	// 读取到旧成员之后群发生了变更, 旧数据不能写回缓存
+	src.fetched = func() {
+		src.fetched = nil
+		src.members["g1"] = append(src.members["g1"], "c")
+		cache.Invalidate(ctx, "g1")
+	}
+	resp, err := cache.Members(ctx, "app", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 2 {
+		t.Fatalf("got %d members, want 2", len(resp.Users))
+	}
+	resp, _ = cache.Members(ctx, "app", req)
+	if len(resp.Users) != 3 || src.calls != 2 {
+		t.Fatalf("got %d members and %d calls after invalidate", len(resp.Users), src.calls)
+	}
+	// 没有变更时正常回填
+	_, _ = cache.Members(ctx, "app", req)
+	if src.calls != 2 {
+		t.Fatalf("Members called %d times, want 2", src.calls)
+	}
+}
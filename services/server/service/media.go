@@ -0,0 +1,108 @@
+package service
+
+import (
+	"EIM/tracing"
+	"EIM/wire/rpc"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+type Media interface {
+	Token(ctx context.Context, app string, req *rpc.MediaTokenReq) (*rpc.MediaTokenResp, error)
+	Complete(ctx context.Context, app string, req *rpc.MediaReq) (*rpc.MediaInfo, error)
+	Meta(ctx context.Context, app string, req *rpc.MediaReq) (*rpc.MediaInfo, error)
+	URL(ctx context.Context, app string, req *rpc.MediaUrlReq) (*rpc.MediaUrlResp, error)
+}
+
+type MediaHttp struct {
+	url string
+	cli *resty.Client
+	srv *resty.SRVRecord
+}
+
+// Token 申请上传地址
+func (m *MediaHttp) Token(ctx context.Context, app string, req *rpc.MediaTokenReq) (*rpc.MediaTokenResp, error) {
+	var resp rpc.MediaTokenResp
+	if err := m.do(ctx, "MediaHttp.Token", fmt.Sprintf("%s/api/%s/media/token", m.url, app), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Complete 上传完成, royal检查文件并生成缩略图
+func (m *MediaHttp) Complete(ctx context.Context, app string, req *rpc.MediaReq) (*rpc.MediaInfo, error) {
+	var resp rpc.MediaInfo
+	if err := m.do(ctx, "MediaHttp.Complete", fmt.Sprintf("%s/api/%s/media/complete", m.url, app), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Meta 获取上传完成的媒体文件的元数据
+func (m *MediaHttp) Meta(ctx context.Context, app string, req *rpc.MediaReq) (*rpc.MediaInfo, error) {
+	var resp rpc.MediaInfo
+	if err := m.do(ctx, "MediaHttp.Meta", fmt.Sprintf("%s/api/%s/media/meta", m.url, app), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// URL 获取下载地址
+func (m *MediaHttp) URL(ctx context.Context, app string, req *rpc.MediaUrlReq) (*rpc.MediaUrlResp, error) {
+	var resp rpc.MediaUrlResp
+	if err := m.do(ctx, "MediaHttp.URL", fmt.Sprintf("%s/api/%s/media/url", m.url, app), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do 发送protobuf请求并解析应答
+func (m *MediaHttp) do(ctx context.Context, name, path string, req proto.Message, resp proto.Message) error {
+	body, _ := proto.Marshal(req)
+	response, err := m.Req(ctx).SetBody(body).Execute(http.MethodPost, path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() != 200 {
+		return statusError(name, response)
+	}
+	return proto.Unmarshal(response.Body(), resp)
+}
+
+func (m *MediaHttp) Req(ctx context.Context) *resty.Request {
+	if m.srv == nil {
+		return m.cli.R().SetContext(ctx)
+	}
+	return m.cli.R().SetContext(ctx).SetSRV(m.srv)
+}
+
+// NewMediaService 上传完成时royal需要读取整个文件, 超时比其它服务长
+func NewMediaService(url string) Media {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 30)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme("http")
+	return &MediaHttp{
+		url: url,
+		cli: cli,
+	}
+}
+
+func NewMediaServiceWithSRV(scheme string, srv *resty.SRVRecord) Media {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 30)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme(scheme)
+	return &MediaHttp{
+		url: "",
+		cli: cli,
+		srv: srv,
+	}
+}
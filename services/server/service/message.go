@@ -2,7 +2,9 @@ package service
 
 import (
 	"EIM/logger"
+	"EIM/tracing"
 	"EIM/wire/rpc"
+	"context"
 	"fmt"
 	"time"
 
@@ -11,11 +13,12 @@ import (
 )
 
 type Message interface {
-	InsertUser(app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error)
-	InsertGroup(app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error)
-	SetAck(app string, req *rpc.AckMessageReq) error
-	GetMessageIndex(app string, req *rpc.GetOfflineMessageIndexReq) (*rpc.GetOfflineMessageIndexResp, error)
-	GetMessageContent(app string, req *rpc.GetOfflineMessageContentReq) (*rpc.GetOfflineMessageContentResp, error)
+	InsertUser(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error)
+	InsertGroup(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error)
+	SetAck(ctx context.Context, app string, req *rpc.AckMessageReq) error
+	GetMessageIndex(ctx context.Context, app string, req *rpc.GetOfflineMessageIndexReq) (*rpc.GetOfflineMessageIndexResp, error)
+	GetMessageContent(ctx context.Context, app string, req *rpc.GetOfflineMessageContentReq) (*rpc.GetOfflineMessageContentResp, error)
+	History(ctx context.Context, app string, req *rpc.HistoryReq) (*rpc.HistoryResp, error)
 }
 
 type MessageHttp struct {
@@ -25,46 +28,46 @@ type MessageHttp struct {
 }
 
 // InsertUser 插入用户
-func (m *MessageHttp) InsertUser(app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
+func (m *MessageHttp) InsertUser(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
 	path := fmt.Sprintf("%s/api/%s/message/user", m.url, app)
 	t := time.Now()
 	body, _ := proto.Marshal(req)
-	response, err := m.Req().SetBody(body).Post(path)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return nil, err
 	}
 	if response.StatusCode() != 200 {
-		return nil, fmt.Errorf("MessageHttp.InsertUser response.StatusCode() = %d, but want 200", response.StatusCode())
+		return nil, statusError("MessageHttp.InsertUser", response)
 	}
 	var resp rpc.InsertMessageResp
 	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("messageHttp.InsertUser cost %v, resp: %v", time.Since(t), &resp)
+	logger.WithContext(ctx).Debugf("messageHttp.InsertUser cost %v, resp: %v", time.Since(t), &resp)
 	return &resp, nil
 }
 
 // InsertGroup 插入组
-func (m *MessageHttp) InsertGroup(app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
+func (m *MessageHttp) InsertGroup(ctx context.Context, app string, req *rpc.InsertMessageReq) (*rpc.InsertMessageResp, error) {
 	path := fmt.Sprintf("%s/api/%s/message/group", m.url, app)
 	t := time.Now()
 	body, _ := proto.Marshal(req)
-	response, err := m.Req().SetBody(body).Post(path)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return nil, err
 	}
 	if response.StatusCode() != 200 {
-		return nil, fmt.Errorf("MessageHttp.InsertGroup response.StatusCode() = %d, but want 200", response.StatusCode())
+		return nil, statusError("MessageHttp.InsertGroup", response)
 	}
 	var resp rpc.InsertMessageResp
 	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("messageHttp.InsertGroup cost %v, resp: %v", time.Since(t), &resp)
+	logger.WithContext(ctx).Debugf("messageHttp.InsertGroup cost %v, resp: %v", time.Since(t), &resp)
 	return &resp, nil
 }
 
 // SetAck 设置Ack
-func (m *MessageHttp) SetAck(app string, req *rpc.AckMessageReq) error {
+func (m *MessageHttp) SetAck(ctx context.Context, app string, req *rpc.AckMessageReq) error {
 	path := fmt.Sprintf("%s/api/%s/message/ack", m.url, app)
 	body, _ := proto.Marshal(req)
-	response, err := m.Req().SetBody(body).Post(path)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return err
 	}
@@ -75,11 +78,11 @@ func (m *MessageHttp) SetAck(app string, req *rpc.AckMessageReq) error {
 }
 
 // GetMessageIndex 获取离线消息索引
-func (m *MessageHttp) GetMessageIndex(app string, req *rpc.GetOfflineMessageIndexReq) (*rpc.GetOfflineMessageIndexResp, error) {
+func (m *MessageHttp) GetMessageIndex(ctx context.Context, app string, req *rpc.GetOfflineMessageIndexReq) (*rpc.GetOfflineMessageIndexResp, error) {
 	path := fmt.Sprintf("%s/api/%s/offline/index", m.url, app)
 	t := time.Now()
 	body, _ := proto.Marshal(req)
-	response, err := m.Req().SetBody(body).Post(path)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return nil, err
 	}
@@ -87,17 +90,19 @@ func (m *MessageHttp) GetMessageIndex(app string, req *rpc.GetOfflineMessageInde
 		return nil, fmt.Errorf("MessageHttp.GetMessageIndex response.StatusCode() = %d, but want 200", response.StatusCode())
 	}
 	var resp rpc.GetOfflineMessageIndexResp
-	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("messageHttp.GetMessageIndex cost %v, resp: %v", time.Since(t), &resp)
+	if err = proto.Unmarshal(response.Body(), &resp); err != nil {
+		return nil, err
+	}
+	logger.WithContext(ctx).Debugf("messageHttp.GetMessageIndex cost %v, resp: %v", time.Since(t), &resp)
 	return &resp, nil
 }
 
 // GetMessageContent 获取离线消息内容
-func (m *MessageHttp) GetMessageContent(app string, req *rpc.GetOfflineMessageContentReq) (*rpc.GetOfflineMessageContentResp, error) {
+func (m *MessageHttp) GetMessageContent(ctx context.Context, app string, req *rpc.GetOfflineMessageContentReq) (*rpc.GetOfflineMessageContentResp, error) {
 	path := fmt.Sprintf("%s/api/%s/offline/content", m.url, app)
 	t := time.Now()
 	body, _ := proto.Marshal(req)
-	response, err := m.Req().SetBody(body).Post(path)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
 	if err != nil {
 		return nil, err
 	}
@@ -105,20 +110,43 @@ func (m *MessageHttp) GetMessageContent(app string, req *rpc.GetOfflineMessageCo
 		return nil, fmt.Errorf("MessageHttp.GetMessageContent response.StatusCode() = %d, but want 200", response.StatusCode())
 	}
 	var resp rpc.GetOfflineMessageContentResp
-	_ = proto.Unmarshal(response.Body(), &resp)
-	logger.Debugf("messageHttp.GetMessageContent cost %v, resp: %v", time.Since(t), &resp)
+	if err = proto.Unmarshal(response.Body(), &resp); err != nil {
+		return nil, err
+	}
+	logger.WithContext(ctx).Debugf("messageHttp.GetMessageContent cost %v, resp: %v", time.Since(t), &resp)
+	return &resp, nil
+}
+
+// History 翻取会话的历史消息
+func (m *MessageHttp) History(ctx context.Context, app string, req *rpc.HistoryReq) (*rpc.HistoryResp, error) {
+	path := fmt.Sprintf("%s/api/%s/message/history", m.url, app)
+	t := time.Now()
+	body, _ := proto.Marshal(req)
+	response, err := m.Req(ctx).SetBody(body).Post(path)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode() != 200 {
+		return nil, statusError("MessageHttp.History", response)
+	}
+	var resp rpc.HistoryResp
+	if err = proto.Unmarshal(response.Body(), &resp); err != nil {
+		return nil, err
+	}
+	logger.WithContext(ctx).Debugf("messageHttp.History cost %v, messages: %d", time.Since(t), len(resp.GetMessages()))
 	return &resp, nil
 }
 
-func (m *MessageHttp) Req() *resty.Request {
+func (m *MessageHttp) Req(ctx context.Context) *resty.Request {
 	if m.srv == nil {
-		return m.cli.R()
+		return m.cli.R().SetContext(ctx)
 	}
-	return m.cli.R().SetSRV(m.srv)
+	return m.cli.R().SetContext(ctx).SetSRV(m.srv)
 }
 
 func NewMessageService(url string) Message {
 	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
 	cli.SetHeader("Content-type", "application/x-protobuf")
 	cli.SetHeader("Accept", "application/x-protobuf")
 	cli.SetScheme("http")
@@ -130,6 +158,7 @@ func NewMessageService(url string) Message {
 
 func NewMessageServiceWithSRV(scheme string, srv *resty.SRVRecord) Message {
 	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
 	cli.SetHeader("Content-type", "application/x-protobuf")
 	cli.SetHeader("Accept", "application/x-protobuf")
 	cli.SetScheme(scheme)
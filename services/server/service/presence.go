@@ -0,0 +1,188 @@
+package service
+
+import (
+	"EIM"
+	"EIM/logger"
+	"EIM/storage"
+	"EIM/tracing"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotOnline 设置在线状态时会话已不在线
+var ErrNotOnline = errors.New("session is not online")
+
+// PresenceStore 在线状态的存储, 由storage.PresenceStorage实现
+type PresenceStore interface {
+	Set(ctx context.Context, account string, state *storage.PresenceState) error
+	Get(ctx context.Context, accounts ...string) (map[string]*storage.PresenceState, error)
+	Remove(ctx context.Context, account, channelId string) (bool, error)
+	Refresh(ctx context.Context, gateway string, sessions []*pkt.Session) error
+	Gateways(ctx context.Context) ([]string, error)
+	Sweep(ctx context.Context, gateway string) ([]string, error)
+}
+
+// Presence 管理用户的在线状态, 状态变化时推送给网关上订阅了PresenceTopic的channel
+type Presence struct {
+	store      PresenceStore
+	dispatcher EIM.TopicDispatcher
+	ttl        time.Duration
+}
+
+// NewPresence ttl为在线状态的过期时间, 用于决定清理的间隔
+func NewPresence(store PresenceStore, dispatcher EIM.TopicDispatcher, ttl time.Duration) *Presence {
+	if ttl <= 0 {
+		ttl = storage.DefaultPresenceTTL
+	}
+	return &Presence{
+		store:      store,
+		dispatcher: dispatcher,
+		ttl:        ttl,
+	}
+}
+
+// PresenceTopic 账号在线状态变化的主题
+func PresenceTopic(account string) string {
+	return "presence:" + account
+}
+
+// Online 用户登录, 在其它地方登录被踢下线的情况下保留原来的自定义状态
+func (p *Presence) Online(ctx context.Context, session *pkt.Session) error {
+	state := &storage.PresenceState{
+		Status:    wire.PresenceOnline,
+		GateId:    session.GetGateId(),
+		ChannelId: session.GetChannelId(),
+		UpdatedAt: time.Now().Unix(),
+	}
+	states, err := p.store.Get(ctx, session.GetAccount())
+	if err != nil {
+		return err
+	}
+	if prev, ok := states[session.GetAccount()]; ok {
+		state.Status = prev.Status
+		state.Text = prev.Text
+	}
+	if err = p.store.Set(ctx, session.GetAccount(), state); err != nil {
+		return err
+	}
+	p.notify(ctx, toPresence(session.GetAccount(), state))
+	return nil
+}
+
+// Offline 用户登出, 账号已在其它channel登录时不改变状态
+func (p *Presence) Offline(ctx context.Context, account, channelId string) error {
+	removed, err := p.store.Remove(ctx, account, channelId)
+	if err != nil || !removed {
+		return err
+	}
+	p.notify(ctx, offline(account))
+	return nil
+}
+
+// SetStatus 设置自定义状态, 只有当前登录的channel可以设置
+func (p *Presence) SetStatus(ctx context.Context, session EIM.Session, status int32, text string) error {
+	states, err := p.store.Get(ctx, session.GetAccount())
+	if err != nil {
+		return err
+	}
+	state, ok := states[session.GetAccount()]
+	if !ok || state.ChannelId != session.GetChannelId() {
+		return ErrNotOnline
+	}
+	state.Status = status
+	state.Text = text
+	state.UpdatedAt = time.Now().Unix()
+	if err = p.store.Set(ctx, session.GetAccount(), state); err != nil {
+		return err
+	}
+	p.notify(ctx, toPresence(session.GetAccount(), state))
+	return nil
+}
+
+// Query 批量查询在线状态, 结果与accounts的顺序一致
+func (p *Presence) Query(ctx context.Context, accounts []string) ([]*pkt.Presence, error) {
+	states, err := p.store.Get(ctx, accounts...)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*pkt.Presence, len(accounts))
+	for i, account := range accounts {
+		if state, ok := states[account]; ok {
+			list[i] = toPresence(account, state)
+		} else {
+			list[i] = &pkt.Presence{Account: account}
+		}
+	}
+	return list, nil
+}
+
+// Refresh 刷新网关上报的会话的过期时间
+func (p *Presence) Refresh(ctx context.Context, gateway string, sessions []*pkt.Session) error {
+	return p.store.Refresh(ctx, gateway, sessions)
+}
+
+// Keep 定时清理过期的在线状态并通知下线, 网关宕机或登出消息丢失时由它发现用户已下线, 直到ctx结束
+func (p *Presence) Keep(ctx context.Context) {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.sweep(ctx)
+		}
+	}
+}
+
+func (p *Presence) sweep(ctx context.Context) {
+	log := logger.WithField("module", "presence")
+	gateways, err := p.store.Gateways(ctx)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for _, gateway := range gateways {
+		expired, err := p.store.Sweep(ctx, gateway)
+		if err != nil {
+			log.WithField("gateway", gateway).Warn(err)
+		}
+		for _, account := range expired {
+			p.notify(ctx, offline(account))
+		}
+	}
+}
+
+// notify 给连接到本服务的每个网关推送一个消息包, 由网关推送给订阅了该账号的channel
+func (p *Presence) notify(ctx context.Context, presence *pkt.Presence) {
+	topic := PresenceTopic(presence.Account)
+	for _, gateway := range p.dispatcher.Gateways() {
+		packet := pkt.New(wire.CommandPresenceNotify)
+		packet.Flag = pkt.Flag_Push
+		packet.WriteBody(presence)
+		tracing.Inject(ctx, packet)
+		if err := p.dispatcher.PushTopic(gateway, topic, packet); err != nil {
+			logger.WithContext(ctx).WithField("gateway", gateway).Warn(err)
+		}
+	}
+}
+
+func toPresence(account string, state *storage.PresenceState) *pkt.Presence {
+	return &pkt.Presence{
+		Account:   account,
+		Status:    state.Status,
+		Text:      state.Text,
+		UpdatedAt: state.UpdatedAt,
+	}
+}
+
+func offline(account string) *pkt.Presence {
+	return &pkt.Presence{
+		Account:   account,
+		Status:    wire.PresenceOffline,
+		UpdatedAt: time.Now().Unix(),
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"EIM/storage"
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"context"
+	"testing"
+)
+
+// memPresenceStore 内存中的在线状态, expire模拟key过期
+type memPresenceStore struct {
+	states   map[string]*storage.PresenceState
+	gateways map[string]map[string]bool
+}
+
+func newMemPresenceStore() *memPresenceStore {
+	return &memPresenceStore{
+		states:   make(map[string]*storage.PresenceState),
+		gateways: make(map[string]map[string]bool),
+	}
+}
+
+func (m *memPresenceStore) Set(ctx context.Context, account string, state *storage.PresenceState) error {
+	if prev, ok := m.states[account]; ok && prev.GateId != state.GateId {
+		delete(m.gateways[prev.GateId], account)
+	}
+	s := *state
+	m.states[account] = &s
+	if m.gateways[state.GateId] == nil {
+		m.gateways[state.GateId] = make(map[string]bool)
+	}
+	m.gateways[state.GateId][account] = true
+	return nil
+}
+
+func (m *memPresenceStore) Get(ctx context.Context, accounts ...string) (map[string]*storage.PresenceState, error) {
+	states := make(map[string]*storage.PresenceState)
+	for _, account := range accounts {
+		if s, ok := m.states[account]; ok {
+			c := *s
+			states[account] = &c
+		}
+	}
+	return states, nil
+}
+
+func (m *memPresenceStore) Remove(ctx context.Context, account, channelId string) (bool, error) {
+	s, ok := m.states[account]
+	if !ok || s.ChannelId != channelId {
+		return false, nil
+	}
+	delete(m.states, account)
+	delete(m.gateways[s.GateId], account)
+	return true, nil
+}
+
+func (m *memPresenceStore) Refresh(ctx context.Context, gateway string, sessions []*pkt.Session) error {
+	return nil
+}
+
+func (m *memPresenceStore) Gateways(ctx context.Context) ([]string, error) {
+	var list []string
+	for gateway := range m.gateways {
+		list = append(list, gateway)
+	}
+	return list, nil
+}
+
+func (m *memPresenceStore) Sweep(ctx context.Context, gateway string) ([]string, error) {
+	var expired []string
+	for account := range m.gateways[gateway] {
+		if _, ok := m.states[account]; !ok {
+			delete(m.gateways[gateway], account)
+			expired = append(expired, account)
+		}
+	}
+	return expired, nil
+}
+
+func (m *memPresenceStore) expire(account string) {
+	delete(m.states, account)
+}
+
+// recordDispatcher 记录按主题推送的在线状态
+type recordDispatcher struct {
+	gateways []string
+	notified []*pkt.Presence
+	topics   []string
+}
+
+func (d *recordDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	return nil
+}
+
+func (d *recordDispatcher) Gateways() []string {
+	return d.gateways
+}
+
+func (d *recordDispatcher) PushTopic(gateway, topic string, p *pkt.LogicPkt) error {
+	var presence pkt.Presence
+	if err := p.ReadBody(&presence); err != nil {
+		return err
+	}
+	d.notified = append(d.notified, &presence)
+	d.topics = append(d.topics, topic)
+	return nil
+}
+
+func (d *recordDispatcher) Subscribe(gateway string, topics []string, channels []string) error {
+	return nil
+}
+
+func (d *recordDispatcher) Unsubscribe(gateway string, topics []string, channels []string) error {
+	return nil
+}
+
+func (d *recordDispatcher) last() *pkt.Presence {
+	if len(d.notified) == 0 {
+		return nil
+	}
+	return d.notified[len(d.notified)-1]
+}
+
+func TestPresence(t *testing.T) {
+	store := newMemPresenceStore()
+	d := &recordDispatcher{gateways: []string{"gate1"}}
+	p := NewPresence(store, d, 0)
+	ctx := context.Background()
+
+	session := &pkt.Session{Account: "a", ChannelId: "c1", GateId: "gate1"}
+	if err := p.Online(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if last := d.last(); last.GetStatus() != wire.PresenceOnline || d.topics[0] != PresenceTopic("a") {
+		t.Fatalf("unexpected notify %v on %v", last, d.topics)
+	}
+	if err := p.SetStatus(ctx, session, wire.PresenceBusy, "meeting"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := p.Query(ctx, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].GetStatus() != wire.PresenceBusy || list[0].GetText() != "meeting" || list[1].GetStatus() != wire.PresenceOffline {
+		t.Fatalf("unexpected presence %v", list)
+	}
+
+	// 在其它地方登录后保留自定义状态, 旧channel的登出不影响在线状态
+	other := &pkt.Session{Account: "a", ChannelId: "c2", GateId: "gate2"}
+	if err = p.Online(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if d.last().GetStatus() != wire.PresenceBusy {
+		t.Fatalf("custom status should be kept, got %v", d.last())
+	}
+	if err = p.SetStatus(ctx, session, wire.PresenceAway, ""); err != ErrNotOnline {
+		t.Fatalf("old channel should not set status, got %v", err)
+	}
+	count := len(d.notified)
+	if err = p.Offline(ctx, "a", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.notified) != count {
+		t.Fatalf("logout of old channel should not notify")
+	}
+	if err = p.Offline(ctx, "a", "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if d.last().GetStatus() != wire.PresenceOffline {
+		t.Fatalf("expect offline, got %v", d.last())
+	}
+}
+
+func TestPresenceSweep(t *testing.T) {
+	store := newMemPresenceStore()
+	d := &recordDispatcher{gateways: []string{"gate1"}}
+	p := NewPresence(store, d, 0)
+	ctx := context.Background()
+
+	for _, account := range []string{"a", "b"} {
+		err := p.Online(ctx, &pkt.Session{Account: account, ChannelId: account + "1", GateId: "gate1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 网关宕机后a的在线状态过期, b仍然在线
+	store.expire("a")
+	count := len(d.notified)
+	p.sweep(ctx)
+	if len(d.notified) != count+1 || d.last().GetAccount() != "a" || d.last().GetStatus() != wire.PresenceOffline {
+		t.Fatalf("expect a offline, got %v", d.notified[count:])
+	}
+	p.sweep(ctx)
+	if len(d.notified) != count+1 {
+		t.Fatalf("expired account should be notified only once")
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"EIM/tracing"
+	"EIM/wire/rpc"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+type User interface {
+	Profiles(ctx context.Context, app string, req *rpc.UserProfilesReq) (*rpc.UserProfilesResp, error)
+	Update(ctx context.Context, app string, req *rpc.UpdateUserReq) error
+}
+
+type UserHttp struct {
+	url string
+	cli *resty.Client
+	srv *resty.SRVRecord
+}
+
+// Profiles 批量获取用户资料
+func (u *UserHttp) Profiles(ctx context.Context, app string, req *rpc.UserProfilesReq) (*rpc.UserProfilesResp, error) {
+	var resp rpc.UserProfilesResp
+	err := u.post(ctx, "UserHttp.Profiles", fmt.Sprintf("%s/api/%s/user/profiles", u.url, app), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Update 修改用户资料
+func (u *UserHttp) Update(ctx context.Context, app string, req *rpc.UpdateUserReq) error {
+	return u.post(ctx, "UserHttp.Update", fmt.Sprintf("%s/api/%s/user/update", u.url, app), req, nil)
+}
+
+// post 发送protobuf请求, resp不为nil时解析应答
+func (u *UserHttp) post(ctx context.Context, name, path string, req proto.Message, resp proto.Message) error {
+	body, _ := proto.Marshal(req)
+	response, err := u.Req(ctx).SetBody(body).Post(path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() != 200 {
+		return statusError(name, response)
+	}
+	if resp != nil {
+		return proto.Unmarshal(response.Body(), resp)
+	}
+	return nil
+}
+
+func (u *UserHttp) Req(ctx context.Context) *resty.Request {
+	if u.srv == nil {
+		return u.cli.R().SetContext(ctx)
+	}
+	return u.cli.R().SetContext(ctx).SetSRV(u.srv)
+}
+
+func NewUserService(url string) User {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme("http")
+	return &UserHttp{
+		url: url,
+		cli: cli,
+	}
+}
+
+func NewUserServiceWithSRV(scheme string, srv *resty.SRVRecord) User {
+	cli := resty.New().SetRetryCount(3).SetTimeout(time.Second * 5)
+	cli.SetTransport(tracing.NewTransport(nil))
+	cli.SetHeader("Content-type", "application/x-protobuf")
+	cli.SetHeader("Accept", "application/x-protobuf")
+	cli.SetScheme(scheme)
+	return &UserHttp{
+		url: "",
+		cli: cli,
+		srv: srv,
+	}
+}
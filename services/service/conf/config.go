@@ -30,6 +30,23 @@ type Config struct {
 	BaseDB        string
 	MessageDB     string
 	LogLevel      string `default:"INFO"`
+	TraceExporter string // otlp, stdout, 为空时不导出
+	TraceEndpoint string
+	TraceSample   float64
+	TokenKey      string        // 签发登录token的密钥, 需要与网关的配置相同
+	TokenExpires  time.Duration `default:"168h"`
+	// 媒体文件
+	MediaStorage string        `default:"local"` // local或s3
+	MediaDir     string        `default:"./data/media"`
+	MediaURL     string        // 本地存储时客户端访问royal的地址, 为空时使用http://PublicAddress:PublicPort
+	MediaSecret  string        // 本地存储签名上传与下载地址的密钥, 为空时不能上传媒体文件
+	MediaExpires time.Duration `default:"1h"` // 上传与下载地址的有效期
+	S3Endpoint   string
+	S3Region     string
+	S3Bucket     string
+	S3AccessKey  string
+	S3SecretKey  string
+	S3PathStyle  bool
 }
 
 func (c Config) String() string {
@@ -10,29 +10,60 @@ type Model struct {
 	UpdatedAt time.Time
 }
 
+// MessageIndex idx_conv_user与idx_conv_group用于按会话序号翻取单聊与群聊的历史消息, idx_offline_version用于同步离线消息
 type MessageIndex struct {
 	ID        int64  `gorm:"primarykey"`
-	AccountA  string `gorm:"index;size:60;not null"`
-	AccountB  string `gorm:"size:60;not null"`
-	Direction byte   `gorm:"default:0;not null"`
-	MessageID int64  `gorm:"not null"`
-	Group     string `gorm:"size:30"`
-	SendTime  int64  `gorm:"index;not null"`
+	AccountA  string `gorm:"index;index:idx_conv_user,priority:1;index:idx_conv_group,priority:1;index:idx_offline_version,priority:1;size:60;not null"`
+	AccountB  string `gorm:"index:idx_conv_user,priority:2;size:60;not null"`
+	Direction byte   `gorm:"index:idx_offline_version,priority:2;default:0;not null"`
+	MessageID int64  `gorm:"index:idx_conv_user,priority:5;index:idx_conv_group,priority:4;index:idx_offline_version,priority:5;not null"`
+	Group     string `gorm:"index:idx_conv_user,priority:3;index:idx_conv_group,priority:2;size:30"`
+	SendTime  int64  `gorm:"index;index:idx_offline_version,priority:4;not null"`
+	Seq       int64  `gorm:"index:idx_conv_user,priority:4;index:idx_conv_group,priority:3;default:0;not null"` // 消息在会话中的序号
+	Version   int64  `gorm:"index:idx_offline_version,priority:3;default:0;not null"`                           // AccountA的版本号, 按提交顺序递增, 用于离线同步
+}
+
+// ConversationSeq 会话的消息序号, 单聊双方共用一条记录, 在插入消息的事务中递增, 保证同一个会话的消息严格有序
+type ConversationSeq struct {
+	Conversation string `gorm:"primarykey;size:130"` // 见handler.conversationKey
+	Seq          int64  `gorm:"not null"`
+	UpdatedAt    time.Time
+}
+
+// AccountVersion 账号的会话版本号, 在变更会话的事务中递增, 提交前一直锁住, 所以同一个账号的版本号按提交顺序递增
+type AccountVersion struct {
+	Account   string `gorm:"primarykey;size:60"`
+	Version   int64  `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+// MessageDedup 客户端消息id与保存的消息的对应关系, 与消息在同一个事务中插入, 超过去重窗口的记录由royal定时清理
+type MessageDedup struct {
+	ID        int64     `gorm:"primarykey"`
+	Sender    string    `gorm:"uniqueIndex:uni_sender_client;size:60;not null"`
+	ClientID  string    `gorm:"uniqueIndex:uni_sender_client;size:64;not null"`
+	Type      int32     `gorm:"default:0;not null"` // 会话类型, 与Dest一起判断重试的是不是同一条消息
+	Dest      string    `gorm:"size:60;not null"`
+	MessageID int64     `gorm:"not null"`
+	SendTime  int64     `gorm:"not null"`
+	Seq       int64     `gorm:"default:0;not null"`
+	CreatedAt time.Time `gorm:"index"`
 }
 
 type MessageContent struct {
 	ID       int64  `gorm:"primarykey"`
 	Type     byte   `gorm:"default:0"`
 	Body     string `gorm:"size:5000;not null"`
-	Extra    string `gorm:"size:500"`
+	Extra    string `gorm:"type:text"` // 不超过wire.MaxExtraLength
 	SendTime int64  `gorm:"index"`
 }
 
+// User 账号在app内唯一, 不同app可以注册相同的账号
 type User struct {
 	Model
-	App      string `gorm:"size:30"`
-	Account  string `gorm:"uniqueIndex;size:60"`
-	Password string `gorm:"size:30"`
+	App      string `gorm:"uniqueIndex:uni_app_account;size:30"`
+	Account  string `gorm:"uniqueIndex:uni_app_account;size:60"`
+	Password string `gorm:"size:100"` // bcrypt哈希, 不保存明文
 	Avatar   string `gorm:"size:200"`
 	Nickname string `gorm:"size:20"`
 }
@@ -45,11 +76,127 @@ type Group struct {
 	Owner        string `gorm:"size:60"`
 	Avatar       string `gorm:"size:200"`
 	Introduction string `gorm:"size:300"`
+	MutedUntil   int64  `gorm:"default:0"` // 全员禁言截止时间(unix秒)
+	JoinPolicy   int32  `gorm:"default:0"` // 0: 任何人可加入, 1: 需要审批, 2: 只能通过邀请加入
 }
 
 type GroupMember struct {
 	Model
-	Account string `gorm:"uniqueIndex:uni_gp_acc;size:60"`
-	Group   string `gorm:"uniqueIndex:uni_gp_acc;index;size:30"`
-	Alias   string `gorm:"size:30"`
+	Account    string `gorm:"uniqueIndex:uni_gp_acc;size:60"`
+	Group      string `gorm:"uniqueIndex:uni_gp_acc;index;size:30"`
+	Alias      string `gorm:"size:30"`
+	Role       int32  `gorm:"default:0"` // 0: 成员, 1: 管理员, 2: 群主
+	MutedUntil int64  `gorm:"default:0"` // 禁言截止时间(unix秒)
+}
+
+// 入群申请的状态
+const (
+	JoinRequestPending  = 0
+	JoinRequestApproved = 1
+	JoinRequestRejected = 2
+)
+
+// GroupJoinRequest 入群申请, 审批前保存在数据库中, 管理员不在线时通过离线消息拿到;
+// 每个账号在一个群中只有一条申请, 再次申请时重新变为待审批
+type GroupJoinRequest struct {
+	Model
+	Group    string `gorm:"index:idx_gp_status;uniqueIndex:uni_req_gp_acc;size:30"`
+	Account  string `gorm:"uniqueIndex:uni_req_gp_acc;size:60"`
+	Remark   string `gorm:"size:200"`
+	Status   int32  `gorm:"index:idx_gp_status;default:0"`
+	Operator string `gorm:"size:60"` // 审批人
+}
+
+// GroupInvitation 入群邀请, 过期前被邀请人加入群不需要审批
+type GroupInvitation struct {
+	Model
+	Group    string `gorm:"index:idx_gp_acc;size:30"`
+	Account  string `gorm:"index:idx_gp_acc;size:60"`
+	Inviter  string `gorm:"size:60"`
+	ExpireAt int64
+}
+
+// Friend 好友关系, 双方各保存一条记录
+type Friend struct {
+	Model
+	Account string `gorm:"uniqueIndex:uni_acc_friend;size:60"`
+	Friend  string `gorm:"uniqueIndex:uni_acc_friend;size:60"`
+	Remark  string `gorm:"size:60"` // 备注名
+}
+
+// 好友申请的状态
+const (
+	FriendRequestPending  = 0
+	FriendRequestApproved = 1
+	FriendRequestRejected = 2
+)
+
+// FriendRequest 好友申请
+type FriendRequest struct {
+	Model
+	Account string `gorm:"size:60"`
+	Friend  string `gorm:"index:idx_friend_status;size:60"`
+	Remark  string `gorm:"size:200"`
+	Status  int32  `gorm:"index:idx_friend_status;default:0"`
+}
+
+// Block 黑名单, Account不接收Target的消息与好友申请
+type Block struct {
+	Model
+	Account string `gorm:"uniqueIndex:uni_acc_target;size:60"`
+	Target  string `gorm:"uniqueIndex:uni_acc_target;size:60"`
+}
+
+// Conversation 会话, 每个账号的每个单聊对象或群一条记录, 插入消息时在同一个事务中更新
+type Conversation struct {
+	Model
+	Account       string `gorm:"uniqueIndex:uni_acc_conv;index:idx_acc_version;size:60"`
+	Type          int32  `gorm:"uniqueIndex:uni_acc_conv"`         // 1: 单聊, 2: 群聊
+	Target        string `gorm:"uniqueIndex:uni_acc_conv;size:60"` // 单聊对方的账号或群id
+	LastMessageID int64
+	LastSender    string `gorm:"size:60"`
+	LastType      int32
+	LastSendTime  int64
+	LastSeq       int64  `gorm:"default:0"`
+	Preview       string `gorm:"size:100"` // 最后一条文本消息的摘要
+	Unread        int32  `gorm:"default:0"`
+	ReadMessageID int64  // 已读到的消息
+	ReadSeq       int64  `gorm:"default:0"` // 已读到的消息在会话中的序号
+	Pinned        bool   `gorm:"default:false"`
+	Muted         bool   `gorm:"default:false"`
+	Version       int64  `gorm:"index:idx_acc_version"` // 每次变更时取AccountVersion中递增后的值, 用于增量同步
+}
+
+// 媒体文件的状态
+const (
+	MediaPending  = 0
+	MediaUploaded = 1
+)
+
+// Media 媒体文件, 申请上传地址时创建, 上传完成后记录实际大小与解析出的元数据
+type Media struct {
+	Model
+	Key       string `gorm:"uniqueIndex;size:100"` // 对象存储中的路径
+	App       string `gorm:"size:30"`
+	Owner     string `gorm:"index;size:60"`
+	Type      int32  // 消息类型 图片 语音 视频
+	Name      string `gorm:"size:200"`
+	Mime      string `gorm:"size:100"`
+	Size      int64  // 上传完成前为申请的大小
+	Width     int32
+	Height    int32
+	Duration  int32  // 毫秒
+	Thumbnail string `gorm:"index;size:100"` // 缩略图的key
+	Status    int32  `gorm:"default:0"`
+}
+
+// BaseModels base库中的表, royal启动时与测试中迁移
+func BaseModels() []interface{} {
+	return []interface{}{&User{}, &Group{}, &GroupMember{}, &GroupJoinRequest{}, &GroupInvitation{},
+		&Friend{}, &FriendRequest{}, &Block{}}
+}
+
+// MessageModels message库中的表
+func MessageModels() []interface{} {
+	return []interface{}{&MessageIndex{}, &MessageContent{}, &Conversation{}, &MessageDedup{}, &ConversationSeq{}, &AccountVersion{}, &Media{}}
 }
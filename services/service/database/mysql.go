@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
@@ -20,8 +21,11 @@ func InitDB(driver string, dsn string) (*gorm.DB, error) {
 	})
 
 	var dialector gorm.Dialector
-	if driver == "mysql" {
+	switch driver {
+	case "mysql":
 		dialector = mysql.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
 	}
 
 	db, err := gorm.Open(dialector, &gorm.Config{
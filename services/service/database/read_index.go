@@ -0,0 +1,78 @@
+package database
+
+import (
+	"EIM/wire"
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ReadIndex 离线消息的读索引, 每个设备单独记录, 账号的读索引为所有设备中最新的一个, 用于新设备冷启动
+type ReadIndex interface {
+	// Get 返回设备的读索引, 设备没有记录时返回账号的读索引, 都没有时返回0
+	Get(ctx context.Context, account, device string) (int64, error)
+	// Set 更新设备与账号的读索引, 只前进不后退
+	Set(ctx context.Context, account, device string, msgID int64) error
+}
+
+// KeyMessageAckDeviceIndex 返回设备读索引的redis key
+func KeyMessageAckDeviceIndex(account, device string) string {
+	return fmt.Sprintf("chat:ack:%s:%s", account, device)
+}
+
+// setReadIndex 消息ID超过了lua中number的精度, 按十进制字符串比较大小
+var setReadIndex = redis.NewScript(`
+local function less(a, b)
+	if #a ~= #b then
+		return #a < #b
+	end
+	return a < b
+end
+for _, key in ipairs(KEYS) do
+	local cur = redis.call('GET', key)
+	if not cur or less(cur, ARGV[1]) then
+		redis.call('SET', key, ARGV[1], 'EX', ARGV[2])
+	else
+		redis.call('EXPIRE', key, ARGV[2])
+	end
+end
+return 0
+`)
+
+type RedisReadIndex struct {
+	cli *redis.Client
+}
+
+func NewRedisReadIndex(cli *redis.Client) *RedisReadIndex {
+	return &RedisReadIndex{cli: cli}
+}
+
+func (r *RedisReadIndex) Get(ctx context.Context, account, device string) (int64, error) {
+	if device != "" {
+		id, err := r.cli.Get(ctx, KeyMessageAckDeviceIndex(account, device)).Int64()
+		if err == nil {
+			return id, nil
+		}
+		if err != redis.Nil {
+			return 0, err
+		}
+	}
+	id, err := r.cli.Get(ctx, KeyMessageAckIndex(account)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (r *RedisReadIndex) Set(ctx context.Context, account, device string, msgID int64) error {
+	if msgID <= 0 {
+		return nil
+	}
+	keys := []string{KeyMessageAckIndex(account)}
+	if device != "" {
+		keys = append(keys, KeyMessageAckDeviceIndex(account, device))
+	}
+	ttl := int64(wire.OfflineReadIndexExpiresIn.Seconds())
+	return setReadIndex.Run(ctx, r.cli, keys, msgID, ttl).Err()
+}
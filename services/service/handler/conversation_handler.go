@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"errors"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxPreviewLength 会话中最后一条消息摘要的长度
+const MaxPreviewLength = 50
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	errMessageNotFound      = errors.New("message not found")
+)
+
+// ConversationList 返回version之后变更的会话, 按version升序分页, 应答中的version用于下一次同步
+func (h *ServiceHandler) ConversationList(c iris.Context) {
+	var req rpc.ConversationsReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	limit := int(req.Limit)
+	if limit <= 0 || limit > wire.ConversationPageSize {
+		limit = wire.ConversationPageSize
+	}
+	var list []database.Conversation
+	err := h.MessageDB.WithContext(c.Request().Context()).
+		Where("account = ? AND version > ?", req.Account, req.Version).
+		Order("version asc").Limit(limit + 1).Find(&list).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	resp := &rpc.ConversationsResp{Version: req.Version}
+	if len(list) > limit {
+		list = list[:limit]
+		resp.HasMore = true
+	}
+	resp.Conversations = make([]*rpc.Conversation, len(list))
+	for i := range list {
+		resp.Conversations[i] = toConversation(&list[i])
+		resp.Version = list[i].Version
+	}
+	_, _ = c.Negotiate(resp)
+}
+
+// ConversationUpdate 置顶或免打扰, 为空的字段不修改
+func (h *ServiceHandler) ConversationUpdate(c iris.Context) {
+	var req rpc.UpdateConversationReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	updates := map[string]interface{}{}
+	if req.Pinned != nil {
+		updates["pinned"] = req.GetPinned()
+	}
+	if req.Muted != nil {
+		updates["muted"] = req.GetMuted()
+	}
+	if len(updates) == 0 {
+		return
+	}
+	err := h.MessageDB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		versions, err := nextVersions(tx, req.Account)
+		if err != nil {
+			return err
+		}
+		updates["version"] = versions[req.Account]
+		res := tx.Model(&database.Conversation{}).
+			Where("account = ? AND type = ? AND target = ?", req.Account, req.Type, req.Target).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errConversationNotFound
+		}
+		return nil
+	})
+	if err == errConversationNotFound {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+	}
+}
+
+// ConversationRead 标记会话已读到message_id, 未读数为会话中序号在它之后收到的消息数
+func (h *ServiceHandler) ConversationRead(c iris.Context) {
+	var req rpc.ReadConversationReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	db := h.MessageDB.WithContext(c.Request().Context())
+	var index database.MessageIndex
+	err := db.Select("seq").Where("account_a = ? AND message_id = ?", req.Account, req.MessageId).Take(&index).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errMessageNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 先锁住账号的版本号, 插入消息的事务也要先拿到它, 未读数不会漏掉同时插入的消息
+		versions, err := nextVersions(tx, req.Account)
+		if err != nil {
+			return err
+		}
+		query := tx.Model(&database.MessageIndex{}).
+			Where("account_a = ? AND direction = ? AND seq > ?", req.Account, 0, index.Seq)
+		if req.Type == wire.ConversationGroup {
+			query = query.Where("`group` = ?", req.Target)
+		} else {
+			query = query.Where("account_b = ? AND (`group` = '' OR `group` IS NULL)", req.Target)
+		}
+		var unread int64
+		if err = query.Count(&unread).Error; err != nil {
+			return err
+		}
+		// 已读位置只前进不后退
+		conv := tx.Model(&database.Conversation{}).Where("account = ? AND type = ? AND target = ?", req.Account, req.Type, req.Target)
+		res := conv.Session(&gorm.Session{}).Where("read_seq <= ?", index.Seq).
+			Updates(map[string]interface{}{
+				"unread":          unread,
+				"read_message_id": req.MessageId,
+				"read_seq":        index.Seq,
+				"version":         versions[req.Account],
+			})
+		if res.Error != nil || res.RowsAffected > 0 {
+			return res.Error
+		}
+		// 没有更新时区分会话不存在与已经读到了更新的位置
+		var count int64
+		if err = conv.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errConversationNotFound
+		}
+		return nil
+	})
+	if err == errConversationNotFound {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+	}
+}
+
+// nextVersions 在事务中递增accounts的会话版本号并返回, 按账号排序后加锁, 避免并发的事务互相死锁;
+// 版本号在事务提交前一直被锁住, 同步时不会因为先分配的版本号晚提交而漏掉变更
+func nextVersions(tx *gorm.DB, accounts ...string) (map[string]int64, error) {
+	sorted := make([]string, len(accounts))
+	copy(sorted, accounts)
+	sort.Strings(sorted)
+	rows := make([]database.AccountVersion, 0, len(sorted))
+	for i, account := range sorted {
+		if i > 0 && account == sorted[i-1] {
+			continue
+		}
+		rows = append(rows, database.AccountVersion{Account: account, Version: 1})
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account"}},
+		DoUpdates: clause.Set{{Column: clause.Column{Name: "version"}, Value: gorm.Expr("version + 1")}, {Column: clause.Column{Name: "updated_at"}, Value: time.Now()}},
+	}).Create(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Where("account IN ?", sorted).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		versions[row.Account] = row.Version
+	}
+	return versions, nil
+}
+
+// applyVersions 把分配到的版本号写入每个账号的消息索引与会话
+func applyVersions(versions map[string]int64, ids []database.MessageIndex, convs ...[]database.Conversation) {
+	for i := range ids {
+		ids[i].Version = versions[ids[i].AccountA]
+	}
+	for _, list := range convs {
+		for i := range list {
+			list[i].Version = versions[list[i].Account]
+		}
+	}
+}
+
+// touchConversations 在插入消息的事务中更新一批会话的最后一条消息, 会话不存在时创建, 每个会话的未读数增加unread,
+// 版本号使用applyVersions写入的每个账号各自的值
+// 只保留序号较新的消息, last_seq放在最后更新, 保证mysql中前面的比较使用的是旧值
+func (h *ServiceHandler) touchConversations(tx *gorm.DB, convs []database.Conversation, unread int32) error {
+	if len(convs) == 0 {
+		return nil
+	}
+	last := convs[0]
+	newer := func(column string, value interface{}) clause.Assignment {
+		return clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value:  gorm.Expr("CASE WHEN last_seq < ? THEN ? ELSE "+column+" END", last.LastSeq, value),
+		}
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "account"}, {Name: "type"}, {Name: "target"}},
+		DoUpdates: clause.Set{
+			newer("last_sender", last.LastSender),
+			newer("last_type", last.LastType),
+			newer("last_send_time", last.LastSendTime),
+			newer("preview", last.Preview),
+			{Column: clause.Column{Name: "unread"}, Value: gorm.Expr("unread + ?", unread)},
+			{Column: clause.Column{Name: "version"}, Value: clause.Column{Table: "excluded", Name: "version"}},
+			{Column: clause.Column{Name: "updated_at"}, Value: time.Now()},
+			newer("last_message_id", last.LastMessageID),
+			newer("last_seq", last.LastSeq),
+		},
+	}).Create(&convs).Error
+}
+
+// newConversations 为accounts创建插入消息时使用的会话记录, 同一批记录的消息相同
+func (h *ServiceHandler) newConversations(accounts []string, typ int32, target string, messageID int64, req *rpc.InsertMessageReq, unread int32) []database.Conversation {
+	convs := make([]database.Conversation, len(accounts))
+	for i, account := range accounts {
+		convs[i] = database.Conversation{
+			Model: database.Model{
+				ID: h.IDGen.Next().Int64(),
+			},
+			Account:       account,
+			Type:          typ,
+			Target:        target,
+			LastMessageID: messageID,
+			LastSender:    req.GetSender(),
+			LastType:      req.GetMessage().GetType(),
+			LastSendTime:  req.GetSendTime(),
+			Preview:       preview(req.GetMessage()),
+			Unread:        unread,
+		}
+	}
+	return convs
+}
+
+// preview 文本消息取前MaxPreviewLength个字符, 其它类型的消息由客户端根据LastType显示
+func preview(msg *rpc.Message) string {
+	if msg.GetType() != wire.MessageTypeText {
+		return ""
+	}
+	body := msg.GetBody()
+	if utf8.RuneCountInString(body) <= MaxPreviewLength {
+		return body
+	}
+	return string([]rune(body)[:MaxPreviewLength])
+}
+
+func toConversation(c *database.Conversation) *rpc.Conversation {
+	return &rpc.Conversation{
+		Type:          c.Type,
+		Target:        c.Target,
+		LastMessageId: c.LastMessageID,
+		LastSender:    c.LastSender,
+		LastType:      c.LastType,
+		LastSendTime:  c.LastSendTime,
+		LastSeq:       c.LastSeq,
+		Preview:       c.Preview,
+		Unread:        c.Unread,
+		ReadMessageId: c.ReadMessageID,
+		Pinned:        c.Pinned,
+		Muted:         c.Muted,
+		Version:       c.Version,
+	}
+}
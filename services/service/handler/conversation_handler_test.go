@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sendMessage(t *testing.T, app http.Handler, path, sender, dest, body string) int64 {
+	var resp rpc.InsertMessageResp
+	code := call(t, app, http.MethodPost, path, &rpc.InsertMessageReq{
+		Sender:   sender,
+		Dest:     dest,
+		SendTime: time.Now().UnixNano(),
+		Message:  &rpc.Message{Type: wire.MessageTypeText, Body: body},
+	}, &resp)
+	if code != http.StatusOK {
+		t.Fatalf("insert message: status %d", code)
+	}
+	return resp.MessageId
+}
+
+func conversations(t *testing.T, app http.Handler, account string, version int64) *rpc.ConversationsResp {
+	var resp rpc.ConversationsResp
+	if code := call(t, app, http.MethodPost, "/api/app/conversation/list", &rpc.ConversationsReq{Account: account, Version: version}, &resp); code != http.StatusOK {
+		t.Fatalf("list conversations: status %d", code)
+	}
+	return &resp
+}
+
+func TestConversation(t *testing.T) {
+	app := newTestApp(t)
+	sendMessage(t, app, "/api/app/message/user", "a", "b", "hello")
+	last := sendMessage(t, app, "/api/app/message/user", "a", "b", "world")
+
+	// 接收方未读数累加, 发送方没有未读
+	list := conversations(t, app, "b", 0)
+	if len(list.Conversations) != 1 {
+		t.Fatalf("expect 1 conversation, got %v", list.Conversations)
+	}
+	c := list.Conversations[0]
+	if c.Target != "a" || c.Unread != 2 || c.LastMessageId != last || c.Preview != "world" {
+		t.Fatalf("unexpected conversation %v", c)
+	}
+	if c = conversations(t, app, "a", 0).Conversations[0]; c.Target != "b" || c.Unread != 0 {
+		t.Fatalf("unexpected sender conversation %v", c)
+	}
+
+	// 增量同步只返回version之后变更的会话
+	version := list.Version
+	if got := conversations(t, app, "b", version).Conversations; len(got) != 0 {
+		t.Fatalf("expect no changes, got %v", got)
+	}
+	pinned := true
+	call(t, app, http.MethodPost, "/api/app/conversation/update", &rpc.UpdateConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", Pinned: &pinned}, nil)
+	if got := conversations(t, app, "b", version).Conversations; len(got) != 1 || !got[0].Pinned || got[0].Unread != 2 {
+		t.Fatalf("expect pinned conversation, got %v", got)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/conversation/update", &rpc.UpdateConversationReq{Account: "b", Type: wire.ConversationUser, Target: "x", Pinned: &pinned}, nil); code != http.StatusNotFound {
+		t.Fatalf("update unknown conversation: status %d, want 404", code)
+	}
+
+	// 已读到最后一条消息后清空未读数, 已读位置不会后退
+	call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: last}, nil)
+	call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: 1}, nil)
+	if c = conversations(t, app, "b", 0).Conversations[0]; c.Unread != 0 || c.ReadMessageId != last {
+		t.Fatalf("expect read conversation, got %v", c)
+	}
+
+	// 群消息只增加其它成员的未读数
+	var created rpc.CreateGroupResp
+	call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{Name: "test", Owner: "a", Members: []string{"b", "c"}}, &created)
+	sendMessage(t, app, "/api/app/message/group", "a", created.GroupId, "hi all")
+	for account, unread := range map[string]int32{"a": 0, "b": 1, "c": 1} {
+		list = conversations(t, app, account, 0)
+		c = list.Conversations[len(list.Conversations)-1]
+		if c.Type != wire.ConversationGroup || c.Target != created.GroupId || c.Unread != unread {
+			t.Fatalf("unexpected group conversation of %s: %v", account, c)
+		}
+	}
+}
+
+func TestConversationVersion(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+
+	// 每次变更的版本号按账号连续递增
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendMessage(t, app, "/api/app/message/user", "a", "b", "hello")
+		}()
+	}
+	wg.Wait()
+	list := conversations(t, app, "b", 0)
+	if list.Version != 8 || len(conversations(t, app, "b", 7).Conversations) != 1 {
+		t.Fatalf("unexpected version %d", list.Version)
+	}
+
+	// 未读数按会话序号计算, 与message_id的大小无关
+	m1 := sendMessage(t, app, "/api/app/message/user", "a", "b", "1")
+	m2 := sendMessage(t, app, "/api/app/message/user", "a", "b", "2")
+	m3 := sendMessage(t, app, "/api/app/message/user", "a", "b", "3")
+	h.MessageDB.Model(&database.MessageIndex{}).Where("message_id = ?", m3).Update("message_id", m1-1)
+	call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: m2}, nil)
+	c := conversations(t, app, "b", list.Version).Conversations
+	if len(c) != 1 || c[0].Unread != 1 || c[0].ReadMessageId != m2 {
+		t.Fatalf("unexpected conversation %v", c)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: 1}, nil); code != http.StatusNotFound {
+		t.Fatalf("read unknown message: status %d, want 404", code)
+	}
+	// 会话不存在时返回404, 读到更早的位置时不后退
+	if code := call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "c", MessageId: m2}, nil); code != http.StatusNotFound {
+		t.Fatalf("read unknown conversation: status %d, want 404", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/conversation/read", &rpc.ReadConversationReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: m1}, nil); code != http.StatusOK {
+		t.Fatalf("read older message: status %d, want 200", code)
+	}
+	c = conversations(t, app, "b", 0).Conversations
+	if len(c) != 1 || c[0].ReadMessageId != m2 {
+		t.Fatalf("unexpected conversation %v", c)
+	}
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire/rpc"
+	"errors"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errNotFriend      = errors.New("not a friend")
+	errAlreadyFriend  = errors.New("already a friend")
+	errBlocked        = errors.New("blocked by the user")
+	errFriendYourself = errors.New("can not add yourself")
+)
+
+// FriendAdd 发送好友申请, 被对方拉黑时返回403, 重复申请时复用未处理的申请
+func (h *ServiceHandler) FriendAdd(c iris.Context) {
+	var req rpc.AddFriendReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if req.Account == "" || req.Friend == "" || req.Account == req.Friend {
+		c.StopWithError(iris.StatusBadRequest, errFriendYourself)
+		return
+	}
+	db := h.BaseDB.WithContext(c.Request().Context())
+	relation, err := h.relation(db, req.Account, req.Friend)
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if relation.Blocked {
+		c.StopWithError(iris.StatusForbidden, errBlocked)
+		return
+	}
+	if relation.Friend {
+		c.StopWithError(iris.StatusBadRequest, errAlreadyFriend)
+		return
+	}
+	var fr database.FriendRequest
+	err = db.Where("account = ? AND friend = ? AND status = ?", req.Account, req.Friend, database.FriendRequestPending).
+		First(&fr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fr = database.FriendRequest{
+			Model: database.Model{
+				ID: h.IDGen.Next().Int64(),
+			},
+			Account: req.Account,
+			Friend:  req.Friend,
+			Remark:  req.Remark,
+			Status:  database.FriendRequestPending,
+		}
+		err = db.Create(&fr).Error
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.AddFriendResp{RequestId: fr.ID})
+}
+
+// FriendApprove 处理好友申请, 只有被申请人可以处理, 同意后双方互为好友
+func (h *ServiceHandler) FriendApprove(c iris.Context) {
+	var req rpc.ApproveFriendReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	db := h.BaseDB.WithContext(c.Request().Context())
+	var fr database.FriendRequest
+	err := db.Where("id = ? AND friend = ? AND status = ?", req.RequestId, req.Operator, database.FriendRequestPending).
+		First(&fr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errRequestNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	status := database.FriendRequestRejected
+	if req.Approve {
+		status = database.FriendRequestApproved
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&database.FriendRequest{}).
+			Where("id = ? AND status = ?", fr.ID, database.FriendRequestPending).Update("status", status)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRequestNotFound
+		}
+		if !req.Approve {
+			return nil
+		}
+		friends := []database.Friend{
+			{Model: database.Model{ID: h.IDGen.Next().Int64()}, Account: fr.Account, Friend: fr.Friend},
+			{Model: database.Model{ID: h.IDGen.Next().Int64()}, Account: fr.Friend, Friend: fr.Account},
+		}
+		// 已经是好友的记录保持不变
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&friends).Error
+	})
+	if err == errRequestNotFound {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.ApproveFriendResp{Account: fr.Account})
+}
+
+// FriendRequests 返回发给account的待处理的好友申请
+func (h *ServiceHandler) FriendRequests(c iris.Context) {
+	account := c.Params().Get("account")
+	var list []database.FriendRequest
+	err := h.BaseDB.WithContext(c.Request().Context()).
+		Where("friend = ? AND status = ?", account, database.FriendRequestPending).
+		Order("id asc").Find(&list).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	requests := make([]*rpc.FriendRequest, len(list))
+	for i, fr := range list {
+		requests[i] = &rpc.FriendRequest{
+			Id:        fr.ID,
+			Account:   fr.Account,
+			Remark:    fr.Remark,
+			CreatedAt: fr.CreatedAt.Unix(),
+		}
+	}
+	_, _ = c.Negotiate(&rpc.FriendRequestsResp{Requests: requests})
+}
+
+// FriendList 返回account的好友列表
+func (h *ServiceHandler) FriendList(c iris.Context) {
+	account := c.Params().Get("account")
+	var list []database.Friend
+	err := h.BaseDB.WithContext(c.Request().Context()).
+		Where("account = ?", account).Order("id asc").Find(&list).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	friends := make([]*rpc.Friend, len(list))
+	for i, f := range list {
+		friends[i] = &rpc.Friend{
+			Account:   f.Friend,
+			Remark:    f.Remark,
+			CreatedAt: f.CreatedAt.Unix(),
+		}
+	}
+	_, _ = c.Negotiate(&rpc.FriendsResp{Friends: friends})
+}
+
+// FriendRemark 修改好友备注, 只修改自己一方的记录
+func (h *ServiceHandler) FriendRemark(c iris.Context) {
+	var req rpc.FriendRemarkReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	res := h.BaseDB.WithContext(c.Request().Context()).Model(&database.Friend{}).
+		Where("account = ? AND friend = ?", req.Account, req.Friend).Update("remark", req.Remark)
+	if res.Error != nil {
+		c.StopWithError(iris.StatusInternalServerError, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.StopWithError(iris.StatusNotFound, errNotFriend)
+	}
+}
+
+// FriendDelete 删除好友, 双方的记录都会被删除
+func (h *ServiceHandler) FriendDelete(c iris.Context) {
+	var req rpc.DeleteFriendReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	res := h.BaseDB.WithContext(c.Request().Context()).
+		Where("(account = ? AND friend = ?) OR (account = ? AND friend = ?)", req.Account, req.Friend, req.Friend, req.Account).
+		Delete(&database.Friend{})
+	if res.Error != nil {
+		c.StopWithError(iris.StatusInternalServerError, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.StopWithError(iris.StatusNotFound, errNotFriend)
+	}
+}
+
+// FriendBlock 把target加入或移出account的黑名单, 加入黑名单不会删除好友关系
+func (h *ServiceHandler) FriendBlock(c iris.Context) {
+	var req rpc.BlockReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if req.Account == "" || req.Target == "" || req.Account == req.Target {
+		c.StopWithError(iris.StatusBadRequest, errors.New("target is invalid"))
+		return
+	}
+	db := h.BaseDB.WithContext(c.Request().Context())
+	var err error
+	if req.Block {
+		err = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.Block{
+			Model: database.Model{
+				ID: h.IDGen.Next().Int64(),
+			},
+			Account: req.Account,
+			Target:  req.Target,
+		}).Error
+	} else {
+		err = db.Where("account = ? AND target = ?", req.Account, req.Target).Delete(&database.Block{}).Error
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+	}
+}
+
+// FriendBlocklist 返回account的黑名单
+func (h *ServiceHandler) FriendBlocklist(c iris.Context) {
+	account := c.Params().Get("account")
+	var accounts []string
+	err := h.BaseDB.WithContext(c.Request().Context()).Model(&database.Block{}).
+		Where("account = ?", account).Order("id asc").Pluck("target", &accounts).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.BlocklistResp{Accounts: accounts})
+}
+
+// FriendRelation 查询friend是否为account的好友, 以及account是否被friend拉黑
+func (h *ServiceHandler) FriendRelation(c iris.Context) {
+	resp, err := h.relation(h.BaseDB.WithContext(c.Request().Context()), c.URLParam("account"), c.URLParam("friend"))
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(resp)
+}
+
+func (h *ServiceHandler) relation(db *gorm.DB, account, friend string) (*rpc.RelationResp, error) {
+	var friends, blocks int64
+	err := db.Model(&database.Friend{}).Where("account = ? AND friend = ?", account, friend).Count(&friends).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&database.Block{}).Where("account = ? AND target = ?", friend, account).Count(&blocks).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rpc.RelationResp{
+		Friend:  friends > 0,
+		Blocked: blocks > 0,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"EIM/wire/rpc"
+	"net/http"
+	"testing"
+)
+
+func relationOf(t *testing.T, app http.Handler, account, friend string) *rpc.RelationResp {
+	var resp rpc.RelationResp
+	code := call(t, app, http.MethodGet, "/api/app/friend/relation?account="+account+"&friend="+friend, nil, &resp)
+	if code != http.StatusOK {
+		t.Fatalf("relation: status %d", code)
+	}
+	return &resp
+}
+
+func TestFriend(t *testing.T) {
+	app := newTestApp(t)
+	if code := call(t, app, http.MethodPost, "/api/app/friend/request", &rpc.AddFriendReq{Account: "a", Friend: "a"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("add yourself: status %d, want 400", code)
+	}
+	var added rpc.AddFriendResp
+	call(t, app, http.MethodPost, "/api/app/friend/request", &rpc.AddFriendReq{Account: "a", Friend: "b", Remark: "hi"}, &added)
+	if added.RequestId == 0 {
+		t.Fatal("request id should not be 0")
+	}
+	var requests rpc.FriendRequestsResp
+	call(t, app, http.MethodGet, "/api/app/friend/requests/b", nil, &requests)
+	if len(requests.Requests) != 1 || requests.Requests[0].Account != "a" || requests.Requests[0].Remark != "hi" {
+		t.Fatalf("unexpected requests %v", requests.Requests)
+	}
+	// 只有被申请人可以处理
+	if code := call(t, app, http.MethodPost, "/api/app/friend/approve", &rpc.ApproveFriendReq{Operator: "a", RequestId: added.RequestId, Approve: true}, nil); code != http.StatusNotFound {
+		t.Fatalf("approve by applicant: status %d, want 404", code)
+	}
+	var approved rpc.ApproveFriendResp
+	call(t, app, http.MethodPost, "/api/app/friend/approve", &rpc.ApproveFriendReq{Operator: "b", RequestId: added.RequestId, Approve: true}, &approved)
+	if approved.Account != "a" {
+		t.Fatalf("unexpected approve resp %v", &approved)
+	}
+	if r := relationOf(t, app, "b", "a"); !r.Friend || r.Blocked {
+		t.Fatalf("unexpected relation %v", r)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/friend/request", &rpc.AddFriendReq{Account: "b", Friend: "a"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("add friend twice: status %d, want 400", code)
+	}
+
+	call(t, app, http.MethodPost, "/api/app/friend/remark", &rpc.FriendRemarkReq{Account: "a", Friend: "b", Remark: "bob"}, nil)
+	var friends rpc.FriendsResp
+	call(t, app, http.MethodGet, "/api/app/friend/list/a", nil, &friends)
+	if len(friends.Friends) != 1 || friends.Friends[0].Account != "b" || friends.Friends[0].Remark != "bob" {
+		t.Fatalf("unexpected friends %v", friends.Friends)
+	}
+
+	// 被拉黑后不能再发送好友申请
+	call(t, app, http.MethodDelete, "/api/app/friend", &rpc.DeleteFriendReq{Account: "b", Friend: "a"}, nil)
+	call(t, app, http.MethodPost, "/api/app/friend/block", &rpc.BlockReq{Account: "b", Target: "a", Block: true}, nil)
+	if r := relationOf(t, app, "a", "b"); r.Friend || !r.Blocked {
+		t.Fatalf("unexpected relation %v", r)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/friend/request", &rpc.AddFriendReq{Account: "a", Friend: "b"}, nil); code != http.StatusForbidden {
+		t.Fatalf("add by blocked user: status %d, want 403", code)
+	}
+	var blocks rpc.BlocklistResp
+	call(t, app, http.MethodGet, "/api/app/friend/blocks/b", nil, &blocks)
+	if len(blocks.Accounts) != 1 || blocks.Accounts[0] != "a" {
+		t.Fatalf("unexpected blocklist %v", blocks.Accounts)
+	}
+	call(t, app, http.MethodPost, "/api/app/friend/block", &rpc.BlockReq{Account: "b", Target: "a"}, nil)
+	if r := relationOf(t, app, "a", "b"); r.Blocked {
+		t.Fatalf("unexpected relation %v", r)
+	}
+}
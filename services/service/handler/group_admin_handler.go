@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"errors"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+var (
+	errNotMember        = errors.New("not a member of the group")
+	errPermissionDenied = errors.New("permission denied")
+)
+
+// GroupKick 踢出成员, 只能踢出角色比自己低的成员
+func (h *ServiceHandler) GroupKick(c iris.Context) {
+	var req rpc.KickMemberReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	operator, target, ok := h.checkTarget(c, req.GroupId, req.Operator, req.Account)
+	if !ok {
+		return
+	}
+	if operator.Role <= target.Role {
+		c.StopWithError(iris.StatusForbidden, errPermissionDenied)
+		return
+	}
+	err := h.BaseDB.WithContext(c.Request().Context()).Delete(target).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	h.groupChanged(c, req.GroupId)
+}
+
+// GroupTransfer 群主转让给其他成员, 原群主成为管理员
+func (h *ServiceHandler) GroupTransfer(c iris.Context) {
+	var req rpc.TransferOwnerReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	operator, target, ok := h.checkTarget(c, req.GroupId, req.Operator, req.Account)
+	if !ok {
+		return
+	}
+	if operator.Role != wire.GroupRoleOwner {
+		c.StopWithError(iris.StatusForbidden, errPermissionDenied)
+		return
+	}
+	err := h.BaseDB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(operator).Update("role", wire.GroupRoleAdmin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(target).Updates(map[string]interface{}{"role": wire.GroupRoleOwner, "muted_until": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&database.Group{}).Where("`group` = ?", req.GroupId).Update("owner", req.Account).Error
+	})
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	h.groupChanged(c, req.GroupId)
+}
+
+// GroupSetAdmin 设置或取消管理员, 只有群主可以操作
+func (h *ServiceHandler) GroupSetAdmin(c iris.Context) {
+	var req rpc.SetAdminReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	operator, target, ok := h.checkTarget(c, req.GroupId, req.Operator, req.Account)
+	if !ok {
+		return
+	}
+	if operator.Role != wire.GroupRoleOwner || target.Role == wire.GroupRoleOwner {
+		c.StopWithError(iris.StatusForbidden, errPermissionDenied)
+		return
+	}
+	role := wire.GroupRoleMember
+	if req.Admin {
+		role = wire.GroupRoleAdmin
+	}
+	err := h.BaseDB.WithContext(c.Request().Context()).Model(target).Update("role", role).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	h.groupChanged(c, req.GroupId)
+}
+
+// GroupMute 禁言成员或全员, duration为0时解除禁言
+func (h *ServiceHandler) GroupMute(c iris.Context) {
+	var req rpc.MuteReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if req.Duration < 0 {
+		c.StopWithError(iris.StatusBadRequest, errors.New("duration is invalid"))
+		return
+	}
+	var mutedUntil int64
+	if req.Duration > 0 {
+		mutedUntil = time.Now().Unix() + req.Duration
+	}
+	// 全员禁言
+	if req.Account == "" {
+		if _, ok := h.checkRole(c, req.GroupId, req.Operator, wire.GroupRoleAdmin); !ok {
+			return
+		}
+		err := h.BaseDB.WithContext(c.Request().Context()).Model(&database.Group{}).
+			Where("`group` = ?", req.GroupId).Update("muted_until", mutedUntil).Error
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		operator, target, ok := h.checkTarget(c, req.GroupId, req.Operator, req.Account)
+		if !ok {
+			return
+		}
+		if operator.Role <= target.Role {
+			c.StopWithError(iris.StatusForbidden, errPermissionDenied)
+			return
+		}
+		err := h.BaseDB.WithContext(c.Request().Context()).Model(target).Update("muted_until", mutedUntil).Error
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+	}
+	h.groupChanged(c, req.GroupId)
+	_, _ = c.Negotiate(&rpc.MuteResp{MutedUntil: mutedUntil})
+}
+
+// GroupUpdate 修改群名称, 头像与简介, 需要管理员权限
+func (h *ServiceHandler) GroupUpdate(c iris.Context) {
+	var req rpc.UpdateGroupReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if _, ok := h.checkRole(c, req.GroupId, req.Operator, wire.GroupRoleAdmin); !ok {
+		return
+	}
+	// 为空的字段不会被更新
+	updates := make(map[string]interface{})
+	if req.Name != "" {
+		updates["name"] = req.Name
+	}
+	if req.Avatar != "" {
+		updates["avatar"] = req.Avatar
+	}
+	if req.Introduction != "" {
+		updates["introduction"] = req.Introduction
+	}
+	if req.JoinPolicy != nil {
+		if !validJoinPolicy(*req.JoinPolicy) {
+			c.StopWithError(iris.StatusBadRequest, errors.New("join policy is invalid"))
+			return
+		}
+		updates["join_policy"] = *req.JoinPolicy
+	}
+	if len(updates) == 0 {
+		return
+	}
+	err := h.BaseDB.WithContext(c.Request().Context()).Model(&database.Group{}).
+		Where("`group` = ?", req.GroupId).Updates(updates).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	h.groupChanged(c, req.GroupId)
+}
+
+// GroupDissolve 解散群, 只有群主可以操作
+func (h *ServiceHandler) GroupDissolve(c iris.Context) {
+	var req rpc.DissolveGroupReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if _, ok := h.checkRole(c, req.GroupId, req.Operator, wire.GroupRoleOwner); !ok {
+		return
+	}
+	err := h.BaseDB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("`group` = ?", req.GroupId).Delete(&database.GroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("`group` = ?", req.GroupId).Delete(&database.Group{}).Error
+	})
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	h.groupChanged(c, req.GroupId)
+}
+
+// findMember 读取群成员, 不是群成员时返回errNotMember
+func (h *ServiceHandler) findMember(c iris.Context, group, account string) (*database.GroupMember, error) {
+	var member database.GroupMember
+	err := h.BaseDB.WithContext(c.Request().Context()).
+		Where("`group` = ? AND account = ?", group, account).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// checkRole 检查account在群中的角色不低于role, 失败时直接返回错误应答
+func (h *ServiceHandler) checkRole(c iris.Context, group, account string, role int32) (*database.GroupMember, bool) {
+	member, err := h.findMember(c, group, account)
+	if err == errNotMember {
+		c.StopWithError(iris.StatusForbidden, err)
+		return nil, false
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return nil, false
+	}
+	if member.Role < role {
+		c.StopWithError(iris.StatusForbidden, errPermissionDenied)
+		return nil, false
+	}
+	return member, true
+}
+
+// checkTarget 读取操作者与被操作的成员, 被操作者不是群成员时返回404
+func (h *ServiceHandler) checkTarget(c iris.Context, group, operator, account string) (*database.GroupMember, *database.GroupMember, bool) {
+	if operator == account {
+		c.StopWithError(iris.StatusBadRequest, errors.New("can not operate on yourself"))
+		return nil, nil, false
+	}
+	op, ok := h.checkRole(c, group, operator, wire.GroupRoleMember)
+	if !ok {
+		return nil, nil, false
+	}
+	target, err := h.findMember(c, group, account)
+	if err == errNotMember {
+		c.StopWithError(iris.StatusNotFound, err)
+		return nil, nil, false
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	return op, target, true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
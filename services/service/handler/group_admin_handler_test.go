@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func roles(t *testing.T, app http.Handler, group string) map[string]int32 {
+	var resp rpc.GroupMembersResp
+	if code := call(t, app, http.MethodGet, "/api/app/group/members/"+group, nil, &resp); code != http.StatusOK {
+		t.Fatalf("members: status %d", code)
+	}
+	m := make(map[string]int32)
+	for _, u := range resp.Users {
+		m[u.Account] = u.Role
+	}
+	return m
+}
+
+func TestGroupAdmin(t *testing.T) {
+	app := newTestApp(t)
+	var created rpc.CreateGroupResp
+	code := call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{
+		Name:    "test",
+		Owner:   "owner",
+		Members: []string{"a", "b"},
+	}, &created)
+	if code != http.StatusOK {
+		t.Fatalf("create: status %d", code)
+	}
+	group := created.GroupId
+	if r := roles(t, app, group); len(r) != 3 || r["owner"] != wire.GroupRoleOwner {
+		t.Fatalf("owner should be added as a member, got %v", r)
+	}
+
+	steps := []struct {
+		name string
+		path string
+		req  proto.Message
+		want int
+	}{
+		{"member can not kick", "/kick", &rpc.KickMemberReq{Operator: "a", GroupId: group, Account: "b"}, http.StatusForbidden},
+		{"member can not add others", "/member", &rpc.JoinGroupReq{Operator: "a", GroupId: group, Account: "c"}, http.StatusForbidden},
+		{"owner sets admin", "/admin", &rpc.SetAdminReq{Operator: "owner", GroupId: group, Account: "a", Admin: true}, http.StatusOK},
+		{"admin kicks member", "/kick", &rpc.KickMemberReq{Operator: "a", GroupId: group, Account: "b"}, http.StatusOK},
+		{"admin can not kick owner", "/kick", &rpc.KickMemberReq{Operator: "a", GroupId: group, Account: "owner"}, http.StatusForbidden},
+		{"kick non-member", "/kick", &rpc.KickMemberReq{Operator: "owner", GroupId: group, Account: "b"}, http.StatusNotFound},
+		{"admin mutes group", "/mute", &rpc.MuteReq{Operator: "a", GroupId: group, Duration: 60}, http.StatusOK},
+		{"admin can not transfer", "/transfer", &rpc.TransferOwnerReq{Operator: "a", GroupId: group, Account: "owner"}, http.StatusForbidden},
+		{"owner transfers", "/transfer", &rpc.TransferOwnerReq{Operator: "owner", GroupId: group, Account: "a"}, http.StatusOK},
+		{"old owner can not dissolve", "/dissolve", &rpc.DissolveGroupReq{Operator: "owner", GroupId: group}, http.StatusForbidden},
+	}
+	for _, s := range steps {
+		if code := call(t, app, http.MethodPost, "/api/app/group"+s.path, s.req, nil); code != s.want {
+			t.Fatalf("%s: status %d, want %d", s.name, code, s.want)
+		}
+	}
+
+	r := roles(t, app, group)
+	if len(r) != 2 || r["a"] != wire.GroupRoleOwner || r["owner"] != wire.GroupRoleAdmin {
+		t.Fatalf("unexpected roles %v", r)
+	}
+	var detail rpc.GetGroupResp
+	call(t, app, http.MethodGet, "/api/app/group/"+group, nil, &detail)
+	if detail.Owner != "a" || !detail.Muted {
+		t.Fatalf("unexpected detail %v", &detail)
+	}
+	if code := call(t, app, http.MethodDelete, "/api/app/group/member", &rpc.QuitGroupReq{GroupId: group, Account: "a"}, nil); code != http.StatusForbidden {
+		t.Fatalf("owner quit: status %d, want 403", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/group/dissolve", &rpc.DissolveGroupReq{Operator: "a", GroupId: group}, nil); code != http.StatusOK {
+		t.Fatalf("dissolve: status %d", code)
+	}
+	if r = roles(t, app, group); len(r) != 0 {
+		t.Fatalf("members should be removed after dissolve, got %v", r)
+	}
+}
+
+// 全员禁言记录截止时间, 过期后自动解除
+func TestGroupMuteExpires(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	var created rpc.CreateGroupResp
+	call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{Name: "test", Owner: "owner", Members: []string{"a"}}, &created)
+
+	var mute rpc.MuteResp
+	if code := call(t, app, http.MethodPost, "/api/app/group/mute", &rpc.MuteReq{Operator: "owner", GroupId: created.GroupId, Duration: 60}, &mute); code != http.StatusOK {
+		t.Fatalf("mute: status %d", code)
+	}
+	now := time.Now().Unix()
+	if mute.MutedUntil < now+59 || mute.MutedUntil > now+61 {
+		t.Fatalf("MutedUntil = %d, want about %d", mute.MutedUntil, now+60)
+	}
+	var detail rpc.GetGroupResp
+	call(t, app, http.MethodGet, "/api/app/group/"+created.GroupId, nil, &detail)
+	if !detail.Muted || detail.MutedUntil != mute.MutedUntil {
+		t.Fatalf("unexpected detail %v", &detail)
+	}
+
+	// 截止时间已过
+	h.BaseDB.Model(&database.Group{}).Where("1 = 1").Update("muted_until", now-1)
+	call(t, app, http.MethodGet, "/api/app/group/"+created.GroupId, nil, &detail)
+	if detail.Muted {
+		t.Fatalf("group mute should expire, got %v", &detail)
+	}
+
+	call(t, app, http.MethodPost, "/api/app/group/mute", &rpc.MuteReq{Operator: "owner", GroupId: created.GroupId, Duration: 60}, nil)
+	if code := call(t, app, http.MethodPost, "/api/app/group/mute", &rpc.MuteReq{Operator: "owner", GroupId: created.GroupId}, &mute); code != http.StatusOK || mute.MutedUntil != 0 {
+		t.Fatalf("unmute: status %d %v", code, &mute)
+	}
+	call(t, app, http.MethodGet, "/api/app/group/"+created.GroupId, nil, &detail)
+	if detail.Muted || detail.MutedUntil != 0 {
+		t.Fatalf("group should be unmuted, got %v", &detail)
+	}
+}
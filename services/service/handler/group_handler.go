@@ -1,12 +1,17 @@
 package handler
 
 import (
+	"EIM/logger"
 	"EIM/services/service/database"
+	"EIM/storage"
+	"EIM/wire"
 	"EIM/wire/rpc"
 	"errors"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (h *ServiceHandler) GroupCreate(c iris.Context) {
@@ -27,9 +32,19 @@ func (h *ServiceHandler) GroupCreate(c iris.Context) {
 		Owner:        req.Owner,
 		Avatar:       req.Avatar,
 		Introduction: req.Introduction,
+		JoinPolicy:   req.JoinPolicy,
 	}
-	members := make([]database.GroupMember, len(req.Members))
-	for i, m := range req.Members {
+	if !validJoinPolicy(req.JoinPolicy) {
+		c.StopWithError(iris.StatusBadRequest, errors.New("join policy is invalid"))
+		return
+	}
+	// 群主总是群成员
+	accounts := req.Members
+	if req.Owner != "" && !contains(accounts, req.Owner) {
+		accounts = append([]string{req.Owner}, accounts...)
+	}
+	members := make([]database.GroupMember, len(accounts))
+	for i, m := range accounts {
 		members[i] = database.GroupMember{
 			Model: database.Model{
 				ID: h.IDGen.Next().Int64(),
@@ -37,8 +52,11 @@ func (h *ServiceHandler) GroupCreate(c iris.Context) {
 			Account: m,
 			Group:   groupID.Base36(),
 		}
+		if m == req.Owner {
+			members[i].Role = wire.GroupRoleOwner
+		}
 	}
-	err := h.BaseDB.Transaction(func(tx *gorm.DB) error {
+	err := h.BaseDB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(g).Error; err != nil {
 			return err
 		}
@@ -60,6 +78,23 @@ func (h *ServiceHandler) GroupJoin(c iris.Context) {
 		c.StopWithError(iris.StatusBadRequest, err)
 		return
 	}
+	var invited, ok bool
+	// 把其他人拉进群需要管理员权限
+	if req.Operator != "" && req.Operator != req.Account {
+		if _, ok = h.checkRole(c, req.GroupId, req.Operator, wire.GroupRoleAdmin); !ok {
+			return
+		}
+	} else {
+		// 本人加入时按群的入群方式处理, 未被邀请时需要审批或被拒绝
+		var resp *rpc.JoinGroupResp
+		resp, invited, ok = h.checkJoinPolicy(c, &req)
+		if !ok || resp != nil {
+			if resp != nil {
+				_, _ = c.Negotiate(resp)
+			}
+			return
+		}
+	}
 	gm := &database.GroupMember{
 		Model: database.Model{
 			ID: h.IDGen.Next().Int64(),
@@ -67,11 +102,36 @@ func (h *ServiceHandler) GroupJoin(c iris.Context) {
 		Account: req.Account,
 		Group:   req.GroupId,
 	}
-	err := h.BaseDB.Create(gm).Error
-	if err != nil {
+	// 邀请与成员在同一个事务中处理, 加入失败时邀请仍然有效
+	err := h.BaseDB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if invited {
+			if err := consumeInvitation(tx, req.GroupId, req.Account); err != nil {
+				return err
+			}
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(gm)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errAlreadyMember
+		}
+		return nil
+	})
+	switch err {
+	case nil:
+	case errInviteOnly:
+		c.StopWithError(iris.StatusForbidden, err)
+		return
+	case errAlreadyMember:
+		c.StopWithError(iris.StatusConflict, err)
+		return
+	default:
 		c.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
+	h.groupChanged(c, req.GroupId)
+	_, _ = c.Negotiate(&rpc.JoinGroupResp{})
 }
 
 func (h *ServiceHandler) GroupQuit(c iris.Context) {
@@ -80,15 +140,20 @@ func (h *ServiceHandler) GroupQuit(c iris.Context) {
 		c.StopWithError(iris.StatusBadRequest, err)
 		return
 	}
-	gm := &database.GroupMember{
-		Account: req.Account,
-		Group:   req.GroupId,
+	member, ok := h.checkRole(c, req.GroupId, req.Account, wire.GroupRoleMember)
+	if !ok {
+		return
+	}
+	if member.Role == wire.GroupRoleOwner {
+		c.StopWithError(iris.StatusForbidden, errors.New("owner can not quit, transfer or dissolve the group first"))
+		return
 	}
-	err := h.BaseDB.Delete(&database.GroupMember{}, gm).Error
+	err := h.BaseDB.WithContext(c.Request().Context()).Delete(member).Error
 	if err != nil {
 		c.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
+	h.groupChanged(c, req.GroupId)
 }
 
 func (h *ServiceHandler) GroupMembers(c iris.Context) {
@@ -98,7 +163,7 @@ func (h *ServiceHandler) GroupMembers(c iris.Context) {
 		return
 	}
 	var members []database.GroupMember
-	err := h.BaseDB.Order("Updated_At asc").Find(&members, database.GroupMember{Group: group}).Error
+	err := h.BaseDB.WithContext(c.Request().Context()).Order("Updated_At asc").Find(&members, database.GroupMember{Group: group}).Error
 	if err != nil {
 		c.StopWithError(iris.StatusInternalServerError, err)
 		return
@@ -106,9 +171,11 @@ func (h *ServiceHandler) GroupMembers(c iris.Context) {
 	var users = make([]*rpc.Member, len(members))
 	for i, m := range members {
 		users[i] = &rpc.Member{
-			Account:  m.Account,
-			Alias:    m.Alias,
-			JoinTime: m.CreatedAt.Unix(),
+			Account:    m.Account,
+			Alias:      m.Alias,
+			JoinTime:   m.CreatedAt.Unix(),
+			Role:       m.Role,
+			MutedUntil: m.MutedUntil,
 		}
 	}
 	_, _ = c.Negotiate(&rpc.GroupMembersResp{
@@ -116,6 +183,25 @@ func (h *ServiceHandler) GroupMembers(c iris.Context) {
 	})
 }
 
+// GroupsOfUser 返回用户加入的所有组
+func (h *ServiceHandler) GroupsOfUser(c iris.Context) {
+	account := c.Params().Get("account")
+	if account == "" {
+		c.StopWithError(iris.StatusBadRequest, errors.New("account is null"))
+		return
+	}
+	var groups []string
+	err := h.BaseDB.WithContext(c.Request().Context()).Model(&database.GroupMember{}).
+		Where(&database.GroupMember{Account: account}).Pluck("`group`", &groups).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.UserGroupsResp{
+		Groups: groups,
+	})
+}
+
 func (h *ServiceHandler) GroupGet(c iris.Context) {
 	groupId := c.Params().Get("id")
 	if groupId == "" {
@@ -128,7 +214,7 @@ func (h *ServiceHandler) GroupGet(c iris.Context) {
 		return
 	}
 	var g database.Group
-	err = h.BaseDB.First(&g, id.Int64()).Error
+	err = h.BaseDB.WithContext(c.Request().Context()).First(&g, id.Int64()).Error
 	if err != nil {
 		c.StopWithError(iris.StatusInternalServerError, err)
 		return
@@ -140,5 +226,18 @@ func (h *ServiceHandler) GroupGet(c iris.Context) {
 		Introduction: g.Introduction,
 		Owner:        g.Owner,
 		CreatedAt:    g.CreatedAt.Unix(),
+		Muted:        g.MutedUntil > time.Now().Unix(),
+		JoinPolicy:   g.JoinPolicy,
+		MutedUntil:   g.MutedUntil,
 	})
 }
+
+// groupChanged 通知chat服务群成员已变更, 失败时只记录日志, 缓存会在过期后更新
+func (h *ServiceHandler) groupChanged(c iris.Context, group string) {
+	if h.Cache == nil {
+		return
+	}
+	if err := storage.PublishGroupChanged(c.Request().Context(), h.Cache, group); err != nil {
+		logger.WithContext(c.Request().Context()).WithField("group", group).Warn(err)
+	}
+}
@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"errors"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errGroupNotFound   = errors.New("group not found")
+	errInviteOnly      = errors.New("group can only be joined by invitation")
+	errRequestNotFound = errors.New("join request not found")
+	errAlreadyMember   = errors.New("account is already a member of the group")
+)
+
+func validJoinPolicy(policy int32) bool {
+	return policy >= wire.GroupJoinOpen && policy <= wire.GroupJoinInvite
+}
+
+// checkJoinPolicy 按群的入群方式检查本人加入的请求, 有有效的邀请时返回invited, 由调用方在加入成员的事务中消耗掉;
+// 需要审批时创建入群申请并返回pending的应答, 失败时直接返回错误应答
+func (h *ServiceHandler) checkJoinPolicy(c iris.Context, req *rpc.JoinGroupReq) (resp *rpc.JoinGroupResp, invited bool, ok bool) {
+	db := h.BaseDB.WithContext(c.Request().Context())
+	var g database.Group
+	err := db.Where("`group` = ?", req.GroupId).First(&g).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errGroupNotFound)
+		return nil, false, false
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return nil, false, false
+	}
+	if g.JoinPolicy == wire.GroupJoinOpen {
+		return nil, false, true
+	}
+	var count int64
+	err = db.Model(&database.GroupInvitation{}).
+		Where("`group` = ? AND account = ? AND expire_at > ?", req.GroupId, req.Account, time.Now().Unix()).
+		Count(&count).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return nil, false, false
+	}
+	if count > 0 {
+		return nil, true, true
+	}
+	if g.JoinPolicy == wire.GroupJoinInvite {
+		c.StopWithError(iris.StatusForbidden, errInviteOnly)
+		return nil, false, false
+	}
+	// 每个账号在群中只有一条申请, 重复申请复用未处理的申请, 已处理的申请重新变为待审批;
+	// 用唯一索引upsert, 并发申请时也只有一条
+	jr := database.GroupJoinRequest{
+		Model: database.Model{
+			ID: h.IDGen.Next().Int64(),
+		},
+		Group:   req.GroupId,
+		Account: req.Account,
+		Remark:  req.Remark,
+		Status:  database.JoinRequestPending,
+	}
+	columns := []string{"status", "operator", "created_at", "updated_at"}
+	if req.Remark != "" {
+		columns = append(columns, "remark")
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group"}, {Name: "account"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&jr).Error
+	if err == nil {
+		err = db.Where("`group` = ? AND account = ?", req.GroupId, req.Account).Take(&jr).Error
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return nil, false, false
+	}
+	return &rpc.JoinGroupResp{Pending: true, RequestId: jr.ID}, false, true
+}
+
+// consumeInvitation 在加入成员的事务中删除有效的邀请, 邀请已被使用或过期时返回errInviteOnly
+func consumeInvitation(tx *gorm.DB, group, account string) error {
+	res := tx.Where("`group` = ? AND account = ? AND expire_at > ?", group, account, time.Now().Unix()).
+		Delete(&database.GroupInvitation{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errInviteOnly
+	}
+	return nil
+}
+
+// GroupInvite 邀请用户入群, 群成员都可以邀请, 已经是群成员的账号会被忽略
+func (h *ServiceHandler) GroupInvite(c iris.Context) {
+	var req rpc.InviteGroupReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if len(req.Accounts) == 0 || req.ExpiresIn < 0 {
+		c.StopWithError(iris.StatusBadRequest, errors.New("accounts or expires_in is invalid"))
+		return
+	}
+	if _, ok := h.checkRole(c, req.GroupId, req.Operator, wire.GroupRoleMember); !ok {
+		return
+	}
+	db := h.BaseDB.WithContext(c.Request().Context())
+	var members []string
+	err := db.Model(&database.GroupMember{}).Where("`group` = ? AND account IN ?", req.GroupId, req.Accounts).
+		Pluck("account", &members).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	expiresIn := time.Duration(req.ExpiresIn) * time.Second
+	if expiresIn == 0 {
+		expiresIn = wire.GroupInvitationExpiresIn
+	}
+	expireAt := time.Now().Add(expiresIn).Unix()
+
+	accounts := make([]string, 0, len(req.Accounts))
+	invitations := make([]database.GroupInvitation, 0, len(req.Accounts))
+	for _, account := range req.Accounts {
+		if account == "" || contains(members, account) || contains(accounts, account) {
+			continue
+		}
+		accounts = append(accounts, account)
+		invitations = append(invitations, database.GroupInvitation{
+			Model: database.Model{
+				ID: h.IDGen.Next().Int64(),
+			},
+			Group:    req.GroupId,
+			Account:  account,
+			Inviter:  req.Operator,
+			ExpireAt: expireAt,
+		})
+	}
+	if len(invitations) > 0 {
+		if err = db.Create(&invitations).Error; err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+	}
+	_, _ = c.Negotiate(&rpc.InviteGroupResp{
+		Accounts: accounts,
+		ExpireAt: expireAt,
+	})
+}
+
+// GroupApprove 审批入群申请, 需要管理员权限, 每个申请只能被处理一次
+func (h *ServiceHandler) GroupApprove(c iris.Context) {
+	var req rpc.ApproveJoinReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	db := h.BaseDB.WithContext(c.Request().Context())
+	var jr database.GroupJoinRequest
+	err := db.Where("id = ? AND status = ?", req.RequestId, database.JoinRequestPending).First(&jr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errRequestNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if _, ok := h.checkRole(c, jr.Group, req.Operator, wire.GroupRoleAdmin); !ok {
+		return
+	}
+	status := database.JoinRequestRejected
+	if req.Approve {
+		status = database.JoinRequestApproved
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 带上status条件, 多个管理员同时审批时只有一个成功
+		res := tx.Model(&database.GroupJoinRequest{}).
+			Where("id = ? AND status = ?", jr.ID, database.JoinRequestPending).
+			Updates(map[string]interface{}{"status": status, "operator": req.Operator})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRequestNotFound
+		}
+		if !req.Approve {
+			return nil
+		}
+		var count int64
+		err := tx.Model(&database.GroupMember{}).Where("`group` = ? AND account = ?", jr.Group, jr.Account).
+			Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		return tx.Create(&database.GroupMember{
+			Model: database.Model{
+				ID: h.IDGen.Next().Int64(),
+			},
+			Account: jr.Account,
+			Group:   jr.Group,
+		}).Error
+	})
+	if err == errRequestNotFound {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if req.Approve {
+		h.groupChanged(c, jr.Group)
+	}
+	_, _ = c.Negotiate(&rpc.ApproveJoinResp{
+		GroupId: jr.Group,
+		Account: jr.Account,
+	})
+}
+
+// GroupJoinRequests 返回群中待审批的入群申请, 需要管理员权限
+func (h *ServiceHandler) GroupJoinRequests(c iris.Context) {
+	group := c.Params().Get("id")
+	if group == "" {
+		c.StopWithError(iris.StatusBadRequest, errors.New("group is null"))
+		return
+	}
+	if _, ok := h.checkRole(c, group, c.URLParam("operator"), wire.GroupRoleAdmin); !ok {
+		return
+	}
+	var list []database.GroupJoinRequest
+	err := h.BaseDB.WithContext(c.Request().Context()).
+		Where("`group` = ? AND status = ?", group, database.JoinRequestPending).
+		Order("id asc").Find(&list).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	requests := make([]*rpc.JoinRequest, len(list))
+	for i, jr := range list {
+		requests[i] = &rpc.JoinRequest{
+			Id:        jr.ID,
+			GroupId:   jr.Group,
+			Account:   jr.Account,
+			Remark:    jr.Remark,
+			CreatedAt: jr.CreatedAt.Unix(),
+		}
+	}
+	_, _ = c.Negotiate(&rpc.JoinRequestsResp{
+		Requests: requests,
+	})
+}
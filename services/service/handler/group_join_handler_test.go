@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestGroupJoinPolicy(t *testing.T) {
+	app := newTestApp(t)
+	var created rpc.CreateGroupResp
+	code := call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{
+		Name:       "test",
+		Owner:      "owner",
+		JoinPolicy: wire.GroupJoinApproval,
+	}, &created)
+	if code != http.StatusOK {
+		t.Fatalf("create: status %d", code)
+	}
+	group := created.GroupId
+
+	// 需要审批时返回待审批的申请, 重复申请复用同一个
+	var joined rpc.JoinGroupResp
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "a", Remark: "hi"}, &joined)
+	if !joined.Pending || joined.RequestId == 0 {
+		t.Fatalf("join should be pending, got %v", &joined)
+	}
+	var again rpc.JoinGroupResp
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "a"}, &again)
+	if again.RequestId != joined.RequestId {
+		t.Fatalf("request should be reused, got %d want %d", again.RequestId, joined.RequestId)
+	}
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "b"}, nil)
+
+	if code = call(t, app, http.MethodGet, "/api/app/group/requests/"+group+"?operator=a", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("non-member list requests: status %d, want 403", code)
+	}
+	var pending rpc.JoinRequestsResp
+	call(t, app, http.MethodGet, "/api/app/group/requests/"+group+"?operator=owner", nil, &pending)
+	if len(pending.Requests) != 2 || pending.Requests[0].Remark != "hi" {
+		t.Fatalf("unexpected requests %v", pending.Requests)
+	}
+
+	var approved rpc.ApproveJoinResp
+	code = call(t, app, http.MethodPost, "/api/app/group/approve", &rpc.ApproveJoinReq{Operator: "owner", RequestId: joined.RequestId, Approve: true}, &approved)
+	if code != http.StatusOK || approved.GroupId != group || approved.Account != "a" {
+		t.Fatalf("approve: status %d, resp %v", code, &approved)
+	}
+	if code = call(t, app, http.MethodPost, "/api/app/group/approve", &rpc.ApproveJoinReq{Operator: "owner", RequestId: joined.RequestId}, nil); code != http.StatusNotFound {
+		t.Fatalf("approve twice: status %d, want 404", code)
+	}
+	// 普通成员不能审批
+	code = call(t, app, http.MethodPost, "/api/app/group/approve", &rpc.ApproveJoinReq{Operator: "a", RequestId: pending.Requests[1].Id}, nil)
+	if code != http.StatusForbidden {
+		t.Fatalf("member approve: status %d, want 403", code)
+	}
+	call(t, app, http.MethodPost, "/api/app/group/approve", &rpc.ApproveJoinReq{Operator: "owner", RequestId: pending.Requests[1].Id}, nil)
+	if r := roles(t, app, group); len(r) != 2 || r["a"] != wire.GroupRoleMember {
+		t.Fatalf("unexpected members %v", r)
+	}
+
+	// 只能通过邀请加入
+	policy := int32(wire.GroupJoinInvite)
+	call(t, app, http.MethodPost, "/api/app/group/update", &rpc.UpdateGroupReq{Operator: "owner", GroupId: group, JoinPolicy: &policy}, nil)
+	if code = call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "b"}, nil); code != http.StatusForbidden {
+		t.Fatalf("join invite only group: status %d, want 403", code)
+	}
+	var invited rpc.InviteGroupResp
+	call(t, app, http.MethodPost, "/api/app/group/invite", &rpc.InviteGroupReq{Operator: "a", GroupId: group, Accounts: []string{"owner", "b"}}, &invited)
+	if len(invited.Accounts) != 1 || invited.Accounts[0] != "b" || invited.ExpireAt == 0 {
+		t.Fatalf("unexpected invite resp %v", &invited)
+	}
+	joined.Reset()
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "b"}, &joined)
+	if joined.Pending {
+		t.Fatal("invited user should join directly")
+	}
+	if r := roles(t, app, group); len(r) != 3 {
+		t.Fatalf("unexpected members %v", r)
+	}
+	var detail rpc.GetGroupResp
+	call(t, app, http.MethodGet, "/api/app/group/"+group, nil, &detail)
+	if detail.JoinPolicy != wire.GroupJoinInvite {
+		t.Fatalf("join policy %d, want %d", detail.JoinPolicy, wire.GroupJoinInvite)
+	}
+}
+
+func TestGroupJoinConcurrent(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	var created rpc.CreateGroupResp
+	call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{
+		Name:       "test",
+		Owner:      "owner",
+		JoinPolicy: wire.GroupJoinApproval,
+	}, &created)
+	group := created.GroupId
+
+	// 并发申请只生成一条申请
+	var wg sync.WaitGroup
+	ids := make([]int64, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var joined rpc.JoinGroupResp
+			call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "a"}, &joined)
+			ids[i] = joined.RequestId
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id == 0 || id != ids[0] {
+			t.Fatalf("concurrent requests should share one id, got %v", ids)
+		}
+	}
+	// 被拒绝后再次申请重新变为待审批
+	call(t, app, http.MethodPost, "/api/app/group/approve", &rpc.ApproveJoinReq{Operator: "owner", RequestId: ids[0]}, nil)
+	var joined rpc.JoinGroupResp
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "a", Remark: "again"}, &joined)
+	var pending rpc.JoinRequestsResp
+	call(t, app, http.MethodGet, "/api/app/group/requests/"+group+"?operator=owner", nil, &pending)
+	if !joined.Pending || len(pending.Requests) != 1 || pending.Requests[0].Remark != "again" {
+		t.Fatalf("unexpected requests %v after %v", pending.Requests, &joined)
+	}
+
+	// 加入失败时邀请不被消耗
+	call(t, app, http.MethodPost, "/api/app/group/invite", &rpc.InviteGroupReq{Operator: "owner", GroupId: group, Accounts: []string{"b"}}, nil)
+	call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "b", Operator: "owner"}, nil)
+	if code := call(t, app, http.MethodPost, "/api/app/group/member", &rpc.JoinGroupReq{GroupId: group, Account: "b"}, nil); code != http.StatusConflict {
+		t.Fatalf("join twice: status %d, want 409", code)
+	}
+	var count int64
+	h.BaseDB.Model(&database.GroupInvitation{}).Where("`group` = ? AND account = ?", group, "b").Count(&count)
+	if count != 1 {
+		t.Fatalf("invitation should be kept, count %d", count)
+	}
+}
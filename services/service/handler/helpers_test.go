@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/services/service/media"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestApp(t *testing.T) http.Handler {
+	return newTestRouter(t, newTestHandler(t))
+}
+
+// newTestHandler 使用sqlite创建ServiceHandler
+func newTestHandler(t *testing.T) *ServiceHandler {
+	// 并发写入时等待锁, 而不是直接返回database is locked
+	db, err := database.InitDB("sqlite", filepath.Join(t.TempDir(), "base.db")+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(append(database.BaseModels(), database.MessageModels()...)...); err != nil {
+		t.Fatal(err)
+	}
+	idGen, err := database.NewIDGenerator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := media.NewLocalStorage(filepath.Join(t.TempDir(), "media"), "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ServiceHandler{BaseDB: db, MessageDB: db, IDGen: idGen, ReadIndex: newMemReadIndex(), Media: storage, MediaExpires: time.Minute}
+}
+
+func newTestRouter(t *testing.T, h *ServiceHandler) http.Handler {
+	app := iris.New()
+	app.UseRouter(func(c iris.Context) {
+		c.Negotiation().Protobuf()
+		c.Next()
+	})
+	h.Register(app)
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// call 发送protobuf请求, resp不为nil时解析应答, 返回http状态码
+func call(t *testing.T, app http.Handler, method, path string, req, resp proto.Message) int {
+	var body []byte
+	if req != nil {
+		body, _ = proto.Marshal(req)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("Accept", "application/x-protobuf")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, r)
+	if resp != nil && rec.Code == http.StatusOK {
+		if err := proto.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+// memReadIndex 内存中的读索引, 与database.RedisReadIndex的行为一致
+type memReadIndex struct {
+	sync.Mutex
+	index map[string]int64
+}
+
+func newMemReadIndex() *memReadIndex {
+	return &memReadIndex{index: make(map[string]int64)}
+}
+
+func (m *memReadIndex) Get(ctx context.Context, account, device string) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	if id, ok := m.index[database.KeyMessageAckDeviceIndex(account, device)]; ok && device != "" {
+		return id, nil
+	}
+	return m.index[database.KeyMessageAckIndex(account)], nil
+}
+
+func (m *memReadIndex) Set(ctx context.Context, account, device string, msgID int64) error {
+	if msgID <= 0 {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	keys := []string{database.KeyMessageAckIndex(account)}
+	if device != "" {
+		keys = append(keys, database.KeyMessageAckDeviceIndex(account, device))
+	}
+	for _, key := range keys {
+		if m.index[key] < msgID {
+			m.index[key] = msgID
+		}
+	}
+	return nil
+}
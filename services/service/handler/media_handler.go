@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/services/service/media"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
+)
+
+// thumbnailSuffix 缩略图的key为原图的key加上这个后缀
+const thumbnailSuffix = "_thumb.jpg"
+
+// uploadSuffix 上传地址指向key加上这个后缀的临时对象, 完成时复制到key, 上传地址在有效期内再次使用也不会覆盖已经完成的文件
+const uploadSuffix = ".upload"
+
+// multipartOverhead POST上传时multipart的边界与头部允许的额外字节数
+const multipartOverhead = 64 << 10
+
+var (
+	errMediaDisabled    = errors.New("media storage is not configured")
+	errMediaNotFound    = errors.New("media not found")
+	errMediaNotUploaded = errors.New("media is not uploaded")
+)
+
+// RequireMedia 没有配置对象存储时媒体接口返回501
+func (h *ServiceHandler) RequireMedia(c iris.Context) {
+	if h.Media == nil {
+		c.StopWithError(iris.StatusNotImplemented, errMediaDisabled)
+		return
+	}
+	c.Next()
+}
+
+// MediaToken 检查类型与大小后创建等待上传的媒体记录, 返回有效期为MediaExpires的上传地址
+func (h *ServiceHandler) MediaToken(c iris.Context) {
+	var req rpc.MediaTokenReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	maxSize := wire.MediaMaxSize(req.GetType())
+	if maxSize == 0 || !validMime(req.GetType(), req.GetMime()) {
+		c.StopWithError(iris.StatusBadRequest, fmt.Errorf("type %d or mime %s is not supported", req.GetType(), req.GetMime()))
+		return
+	}
+	if req.GetSize() <= 0 || req.GetSize() > maxSize || len(req.GetName()) > 200 {
+		c.StopWithError(iris.StatusBadRequest, fmt.Errorf("size should be in (0, %d]", maxSize))
+		return
+	}
+	app := c.Params().Get("app")
+	db := h.MessageDB.WithContext(c.Request().Context())
+	record := database.Media{
+		Model:    database.Model{ID: h.IDGen.Next().Int64()},
+		Key:      fmt.Sprintf("%s/%s/%s", app, time.Now().Format("20060102"), ksuid.New().String()),
+		App:      app,
+		Owner:    req.GetAccount(),
+		Type:     req.GetType(),
+		Name:     req.GetName(),
+		Mime:     req.GetMime(),
+		Size:     req.GetSize(),
+		Width:    req.GetWidth(),
+		Height:   req.GetHeight(),
+		Duration: req.GetDuration(),
+	}
+	// 视频使用自己上传的封面图片作为缩略图
+	if req.GetCover() != "" {
+		var cover database.Media
+		err := db.Where("`key` = ? AND app = ? AND owner = ? AND type = ? AND status = ?", req.GetCover(), app, req.GetAccount(), wire.MessageTypeImage, database.MediaUploaded).Take(&cover).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.StopWithError(iris.StatusBadRequest, errors.New("cover is not an uploaded image"))
+			return
+		}
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+		record.Thumbnail = cover.Thumbnail
+		if record.Thumbnail == "" {
+			record.Thumbnail = cover.Key
+		}
+	}
+	uploadURL, err := h.Media.SignURL(http.MethodPut, record.Key+uploadSuffix, record.Size, h.MediaExpires)
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if err = db.Create(&record).Error; err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.MediaTokenResp{
+		Key:       record.Key,
+		UploadUrl: uploadURL,
+		Method:    http.MethodPut,
+		ExpiresAt: time.Now().Add(h.MediaExpires).Unix(),
+	})
+}
+
+// validMime 图片, 语音与视频分别只接受image/, audio/与video/开头的mime
+func validMime(typ int32, mime string) bool {
+	prefix := map[int32]string{
+		wire.MessageTypeImage: "image/",
+		wire.MessageTypeVoice: "audio/",
+		wire.MessageTypeVideo: "video/",
+	}[typ]
+	return prefix != "" && strings.HasPrefix(mime, prefix) && len(mime) <= 100
+}
+
+// MediaComplete 上传完成后读取临时对象检查实际大小, 图片解析宽高并生成缩略图, 然后复制到key并删除临时对象,
+// 重复或并发调用时返回先完成的一次记录的元数据
+func (h *ServiceHandler) MediaComplete(c iris.Context) {
+	var req rpc.MediaReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	ctx := c.Request().Context()
+	db := h.MessageDB.WithContext(ctx)
+	var record database.Media
+	err := db.Where("`key` = ? AND app = ? AND owner = ?", req.GetKey(), c.Params().Get("app"), req.GetAccount()).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errMediaNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if record.Status == database.MediaUploaded {
+		_, _ = c.Negotiate(toMediaInfo(&record))
+		return
+	}
+	upload := record.Key + uploadSuffix
+	r, err := h.Media.Get(ctx, upload)
+	if err == media.ErrNotFound {
+		// 临时对象可能已经被并发的调用删除
+		h.respCompleted(c, record.ID, errMediaNotUploaded)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	defer r.Close()
+	// 图片需要解码, 读到内存中, 其它类型只统计大小
+	var data []byte
+	var size int64
+	if record.Type == wire.MessageTypeImage {
+		data, err = io.ReadAll(io.LimitReader(r, record.Size+1))
+		size = int64(len(data))
+	} else {
+		size, err = io.Copy(io.Discard, io.LimitReader(r, record.Size+1))
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if size > record.Size {
+		_ = h.Media.Delete(ctx, upload)
+		c.StopWithError(iris.StatusBadRequest, fmt.Errorf("media is larger than %d bytes", record.Size))
+		return
+	}
+	record.Size = size
+	if record.Type == wire.MessageTypeImage {
+		info, err := media.ProbeImage(data, wire.MediaThumbnailSize, wire.MediaMaxPixels)
+		if err != nil {
+			c.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		record.Width, record.Height = int32(info.Width), int32(info.Height)
+		if info.Thumbnail != nil {
+			thumbnail := record.Key + thumbnailSuffix
+			err = h.Media.Put(ctx, thumbnail, bytes.NewReader(info.Thumbnail), int64(len(info.Thumbnail)), "image/jpeg")
+			if err != nil {
+				c.StopWithError(iris.StatusInternalServerError, err)
+				return
+			}
+			record.Thumbnail = thumbnail
+		}
+	}
+	if err = h.copyUpload(ctx, &record, data); err == media.ErrNotFound {
+		h.respCompleted(c, record.ID, errMediaNotUploaded)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	// 只有状态仍为等待上传时才更新, 并发调用时只有一次会成功
+	record.Status = database.MediaUploaded
+	res := db.Model(&database.Media{}).Where("id = ? AND status = ?", record.ID, database.MediaPending).
+		Select("size", "width", "height", "thumbnail", "status").Updates(&record)
+	if res.Error != nil {
+		c.StopWithError(iris.StatusInternalServerError, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		h.respCompleted(c, record.ID, errMediaNotUploaded)
+		return
+	}
+	_ = h.Media.Delete(ctx, upload)
+	_, _ = c.Negotiate(toMediaInfo(&record))
+}
+
+// respCompleted 媒体文件已经被其它调用完成时返回记录的元数据, 否则返回err
+func (h *ServiceHandler) respCompleted(c iris.Context, id int64, err error) {
+	var record database.Media
+	if e := h.MessageDB.WithContext(c.Request().Context()).Take(&record, id).Error; e != nil {
+		c.StopWithError(iris.StatusInternalServerError, e)
+		return
+	}
+	if record.Status != database.MediaUploaded {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	_, _ = c.Negotiate(toMediaInfo(&record))
+}
+
+// copyUpload 把检查过的临时对象复制到record.Key, 图片已经读到data中, 其它类型重新读取临时对象
+func (h *ServiceHandler) copyUpload(ctx context.Context, record *database.Media, data []byte) error {
+	if data != nil {
+		return h.Media.Put(ctx, record.Key, bytes.NewReader(data), record.Size, record.Mime)
+	}
+	r, err := h.Media.Get(ctx, record.Key+uploadSuffix)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return h.Media.Put(ctx, record.Key, io.LimitReader(r, record.Size), record.Size, record.Mime)
+}
+
+// MediaMeta 返回已经上传完成的媒体文件的元数据, 用于发送媒体消息, 转发时也可以使用别人上传的文件
+func (h *ServiceHandler) MediaMeta(c iris.Context) {
+	var req rpc.MediaReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	var record database.Media
+	err := h.MessageDB.WithContext(c.Request().Context()).Where("`key` = ? AND app = ?", req.GetKey(), c.Params().Get("app")).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errMediaNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if record.Status != database.MediaUploaded {
+		c.StopWithError(iris.StatusBadRequest, errMediaNotUploaded)
+		return
+	}
+	_, _ = c.Negotiate(toMediaInfo(&record))
+}
+
+// MediaURL 为上传完成的媒体文件与缩略图生成下载地址, 拿到key的用户都可以下载, key中包含随机的ksuid
+func (h *ServiceHandler) MediaURL(c iris.Context) {
+	var req rpc.MediaUrlReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	keys := req.GetKeys()
+	if len(keys) == 0 || len(keys) > wire.MediaUrlMaxCount {
+		c.StopWithError(iris.StatusBadRequest, fmt.Errorf("count of keys should be in [1, %d]", wire.MediaUrlMaxCount))
+		return
+	}
+	var list []database.Media
+	err := h.MessageDB.WithContext(c.Request().Context()).Select("key", "thumbnail").
+		Where("app = ? AND status = ? AND (`key` IN ? OR thumbnail IN ?)", c.Params().Get("app"), database.MediaUploaded, keys, keys).Find(&list).Error
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	found := make(map[string]bool, len(list)*2)
+	for _, m := range list {
+		found[m.Key] = true
+		if m.Thumbnail != "" {
+			found[m.Thumbnail] = true
+		}
+	}
+	expiresAt := time.Now().Add(h.MediaExpires).Unix()
+	resp := &rpc.MediaUrlResp{}
+	for _, key := range keys {
+		if !found[key] {
+			continue
+		}
+		found[key] = false
+		signed, err := h.Media.SignURL(http.MethodGet, key, 0, h.MediaExpires)
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+		resp.Urls = append(resp.Urls, &rpc.MediaUrl{Key: key, Url: signed, ExpiresAt: expiresAt})
+	}
+	_, _ = c.Negotiate(resp)
+}
+
+// MediaUpload 本地存储的上传地址, PUT时请求体为文件内容, POST时为multipart/form-data中的file字段, 内容不能超过申请的大小
+func (h *ServiceHandler) MediaUpload(c iris.Context) {
+	local, ok := h.Media.(*media.LocalStorage)
+	if !ok {
+		c.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	key := c.Params().Get("key")
+	if err := local.Verify(http.MethodPut, key, c.URLParam("expires"), c.URLParam("signature")); err != nil {
+		c.StopWithError(iris.StatusForbidden, err)
+		return
+	}
+	if !strings.HasSuffix(key, uploadSuffix) {
+		c.StopWithError(iris.StatusForbidden, media.ErrInvalidKey)
+		return
+	}
+	ctx := c.Request().Context()
+	var record database.Media
+	err := h.MessageDB.WithContext(ctx).Where("`key` = ?", strings.TrimSuffix(key, uploadSuffix)).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.StopWithError(iris.StatusNotFound, errMediaNotFound)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if record.Status == database.MediaUploaded {
+		c.StopWithError(iris.StatusConflict, errors.New("media is already uploaded"))
+		return
+	}
+	body := http.MaxBytesReader(c.ResponseWriter(), c.Request().Body, record.Size+multipartOverhead)
+	var r io.Reader = body
+	if c.Method() == http.MethodPost {
+		c.Request().Body = body
+		file, _, err := c.Request().FormFile("file")
+		if err != nil {
+			c.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		defer file.Close()
+		r = file
+	}
+	counter := &countReader{r: io.LimitReader(r, record.Size+1)}
+	if err = local.Put(ctx, key, counter, record.Size, record.Mime); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if counter.n > record.Size {
+		_ = local.Delete(ctx, key)
+		c.StopWithError(iris.StatusRequestEntityTooLarge, fmt.Errorf("media is larger than %d bytes", record.Size))
+	}
+}
+
+// MediaDownload 本地存储的下载地址, 支持Range请求
+func (h *ServiceHandler) MediaDownload(c iris.Context) {
+	local, ok := h.Media.(*media.LocalStorage)
+	if !ok {
+		c.StopWithStatus(iris.StatusNotFound)
+		return
+	}
+	key := c.Params().Get("key")
+	if err := local.Verify(http.MethodGet, key, c.URLParam("expires"), c.URLParam("signature")); err != nil {
+		c.StopWithError(iris.StatusForbidden, err)
+		return
+	}
+	ctx := c.Request().Context()
+	contentType := "image/jpeg"
+	if !strings.HasSuffix(key, thumbnailSuffix) {
+		var record database.Media
+		if err := h.MessageDB.WithContext(ctx).Select("mime").Where("`key` = ?", key).Take(&record).Error; err != nil {
+			c.StopWithError(iris.StatusNotFound, errMediaNotFound)
+			return
+		}
+		contentType = record.Mime
+	}
+	r, err := local.Get(ctx, key)
+	if err != nil {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	defer r.Close()
+	c.Header("Content-Type", contentType)
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		// 不能Seek时不支持Range请求, 直接返回整个文件
+		_, _ = io.Copy(c.ResponseWriter(), r)
+		return
+	}
+	http.ServeContent(c.ResponseWriter(), c.Request(), "", time.Time{}, rs)
+}
+
+// countReader 统计读取的字节数
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func toMediaInfo(m *database.Media) *rpc.MediaInfo {
+	return &rpc.MediaInfo{
+		Key:       m.Key,
+		Type:      m.Type,
+		Name:      m.Name,
+		Mime:      m.Mime,
+		Size:      m.Size,
+		Width:     m.Width,
+		Height:    m.Height,
+		Duration:  m.Duration,
+		Thumbnail: m.Thumbnail,
+	}
+}
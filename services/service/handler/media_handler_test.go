@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"EIM/wire/rpc"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// rawCall 按签名地址的路径与参数直接访问上传与下载接口
+func rawCall(app http.Handler, method, signed, contentType string, body []byte) *httptest.ResponseRecorder {
+	u, _ := url.Parse(signed)
+	r := httptest.NewRequest(method, u.RequestURI(), bytes.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, r)
+	return rec
+}
+
+func mediaToken(t *testing.T, app http.Handler, req *rpc.MediaTokenReq) *rpc.MediaTokenResp {
+	var resp rpc.MediaTokenResp
+	if code := call(t, app, http.MethodPost, "/api/app/media/token", req, &resp); code != http.StatusOK {
+		t.Fatalf("token: status %d", code)
+	}
+	return &resp
+}
+
+func TestMediaUpload(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 480, 320)))
+	data := buf.Bytes()
+
+	token := mediaToken(t, app, &rpc.MediaTokenReq{Account: "a", Type: wire.MessageTypeImage, Name: "a.png", Mime: "image/png", Size: int64(len(data))})
+	if !strings.HasPrefix(token.Key, "app/") || token.Method != http.MethodPut {
+		t.Fatalf("unexpected token %v", token)
+	}
+	// 上传前不能完成
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, nil); code != http.StatusBadRequest {
+		t.Fatalf("complete before upload: status %d", code)
+	}
+	if rec := rawCall(app, http.MethodPut, token.UploadUrl+"0", "image/png", data); rec.Code != http.StatusForbidden {
+		t.Fatalf("tampered signature: status %d", rec.Code)
+	}
+	if rec := rawCall(app, http.MethodPut, token.UploadUrl, "image/png", data); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d %s", rec.Code, rec.Body)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "b", Key: token.Key}, nil); code != http.StatusNotFound {
+		t.Fatalf("complete by others: status %d", code)
+	}
+	var info rpc.MediaInfo
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, &info); code != http.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+	if info.Width != 480 || info.Height != 320 || info.Thumbnail != token.Key+thumbnailSuffix {
+		t.Fatalf("unexpected info %v", &info)
+	}
+	// 完成后不能覆盖, 对象存储在上传地址过期前仍然接受上传时, 写入的也只是临时对象
+	if rec := rawCall(app, http.MethodPut, token.UploadUrl, "image/png", data); rec.Code != http.StatusConflict {
+		t.Fatalf("upload after complete: status %d", rec.Code)
+	}
+	if err := h.Media.Put(context.Background(), token.Key+uploadSuffix, strings.NewReader("evil"), 4, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	// 其他用户可以读取元数据用于转发
+	var meta rpc.MediaInfo
+	if code := call(t, app, http.MethodPost, "/api/app/media/meta", &rpc.MediaReq{Account: "b", Key: token.Key}, &meta); code != http.StatusOK || meta.Size != int64(len(data)) {
+		t.Fatalf("meta: status %d %v", code, &meta)
+	}
+
+	// 其它app不能读取
+	if code := call(t, app, http.MethodPost, "/api/other/media/meta", &rpc.MediaReq{Account: "b", Key: token.Key}, nil); code != http.StatusNotFound {
+		t.Fatalf("meta from other app: status %d", code)
+	}
+	var others rpc.MediaUrlResp
+	if code := call(t, app, http.MethodPost, "/api/other/media/url", &rpc.MediaUrlReq{Account: "b", Keys: []string{token.Key}}, &others); code != http.StatusOK || len(others.Urls) != 0 {
+		t.Fatalf("url from other app: status %d %v", code, &others)
+	}
+
+	var urls rpc.MediaUrlResp
+	code := call(t, app, http.MethodPost, "/api/app/media/url", &rpc.MediaUrlReq{Account: "b", Keys: []string{token.Key, info.Thumbnail, "app/unknown"}}, &urls)
+	if code != http.StatusOK || len(urls.Urls) != 2 {
+		t.Fatalf("url: status %d %v", code, &urls)
+	}
+	rec := rawCall(app, http.MethodGet, urls.Urls[0].Url, "", nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("download: status %d", rec.Code)
+	}
+	rec = rawCall(app, http.MethodGet, urls.Urls[1].Url, "", nil)
+	if thumb, _, err := image.Decode(rec.Body); err != nil || thumb.Bounds().Dx() != wire.MediaThumbnailSize {
+		t.Fatalf("thumbnail: status %d err %v", rec.Code, err)
+	}
+	// 下载地址不能用于上传
+	if rec = rawCall(app, http.MethodPut, urls.Urls[0].Url, "image/png", data); rec.Code != http.StatusForbidden {
+		t.Fatalf("upload with download url: status %d", rec.Code)
+	}
+}
+
+func TestMediaUploadLimit(t *testing.T) {
+	app := newTestApp(t)
+	for _, req := range []*rpc.MediaTokenReq{
+		{Account: "a", Type: wire.MessageTypeImage, Mime: "video/mp4", Size: 10},
+		{Account: "a", Type: wire.MessageTypeText, Mime: "text/plain", Size: 10},
+		{Account: "a", Type: wire.MessageTypeVoice, Mime: "audio/aac", Size: wire.MediaVoiceMaxSize + 1},
+		{Account: "a", Type: wire.MessageTypeVideo, Mime: "video/mp4", Cover: "app/unknown", Size: 10},
+	} {
+		if code := call(t, app, http.MethodPost, "/api/app/media/token", req, nil); code != http.StatusBadRequest {
+			t.Fatalf("token %v: status %d", req, code)
+		}
+	}
+
+	token := mediaToken(t, app, &rpc.MediaTokenReq{Account: "a", Type: wire.MessageTypeVoice, Mime: "audio/aac", Size: 4, Duration: 3})
+	if rec := rawCall(app, http.MethodPut, token.UploadUrl, "audio/aac", []byte("12345")); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversize upload: status %d", rec.Code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, nil); code != http.StatusBadRequest {
+		t.Fatalf("complete after oversize upload: status %d", code)
+	}
+
+	// 浏览器使用multipart上传
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "a.aac")
+	_, _ = io.WriteString(part, "1234")
+	_ = form.Close()
+	if rec := rawCall(app, http.MethodPost, token.UploadUrl, form.FormDataContentType(), body.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("multipart upload: status %d %s", rec.Code, rec.Body)
+	}
+	var info rpc.MediaInfo
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, &info); code != http.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+	if info.Size != 4 || info.Duration != 3 || info.Thumbnail != "" {
+		t.Fatalf("unexpected info %v", &info)
+	}
+}
+
+func TestMediaCompleteConcurrent(t *testing.T) {
+	app := newTestApp(t)
+	token := mediaToken(t, app, &rpc.MediaTokenReq{Account: "a", Type: wire.MessageTypeVoice, Mime: "audio/aac", Size: 4, Duration: 3})
+	rawCall(app, http.MethodPut, token.UploadUrl, "audio/aac", []byte("1234"))
+	// 并发的调用都返回先完成的一次记录的元数据
+	const n = 4
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	infos := make([]rpc.MediaInfo, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, &infos[i])
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		if codes[i] != http.StatusOK || infos[i].Size != 4 {
+			t.Fatalf("complete %d: status %d %v", i, codes[i], &infos[i])
+		}
+	}
+}
+
+func TestMediaMessageExtra(t *testing.T) {
+	app := newTestApp(t)
+	// 名字中的引号在json中会被转义, 元数据的json超过了原来extra的500字节
+	name := strings.Repeat(`"`, 200)
+	token := mediaToken(t, app, &rpc.MediaTokenReq{Account: "a", Type: wire.MessageTypeVoice, Name: name, Mime: "audio/aac", Size: 4, Duration: 3})
+	rawCall(app, http.MethodPut, token.UploadUrl, "audio/aac", []byte("1234"))
+	var info rpc.MediaInfo
+	if code := call(t, app, http.MethodPost, "/api/app/media/complete", &rpc.MediaReq{Account: "a", Key: token.Key}, &info); code != http.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+	extra, _ := protojson.Marshal(&pkt.MediaInfo{Key: info.Key, Name: info.Name, Mime: info.Mime, Size: info.Size, Duration: info.Duration, Extra: `{"from":"test"}`})
+	if len(extra) <= 500 {
+		t.Fatalf("extra should be longer than 500 bytes, got %d", len(extra))
+	}
+	var resp rpc.InsertMessageResp
+	code := call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+		Sender:   "a",
+		Dest:     "b",
+		SendTime: time.Now().UnixNano(),
+		Message:  &rpc.Message{Type: wire.MessageTypeVoice, Body: info.Key, Extra: string(extra)},
+	}, &resp)
+	if code != http.StatusOK {
+		t.Fatalf("insert media message: status %d", code)
+	}
+	var content rpc.GetOfflineMessageContentResp
+	call(t, app, http.MethodPost, "/api/app/offline/content", &rpc.GetOfflineMessageContentReq{Account: "b", MessageIds: []int64{resp.MessageId}}, &content)
+	if len(content.List) != 1 || content.List[0].Extra != string(extra) {
+		t.Fatalf("unexpected content %v", content.List)
+	}
+
+	code = call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+		Sender:   "a",
+		Dest:     "b",
+		SendTime: time.Now().UnixNano(),
+		Message:  &rpc.Message{Type: wire.MessageTypeText, Body: "hi", Extra: strings.Repeat("x", wire.MaxExtraLength+1)},
+	}, nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("too long extra: status %d, want 400", code)
+	}
+}
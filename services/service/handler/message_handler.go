@@ -1,21 +1,31 @@
 package handler
 
 import (
+	"EIM/logger"
 	"EIM/services/service/database"
+	"EIM/services/service/media"
 	"EIM/wire"
 	"EIM/wire/rpc"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/kataras/iris/v12"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
 type ServiceHandler struct {
-	BaseDB    *gorm.DB
-	MessageDB *gorm.DB
-	Cache     *redis.Client
-	IDGen     *database.IDGenerator
+	BaseDB       *gorm.DB
+	MessageDB    *gorm.DB
+	Cache        *redis.Client
+	ReadIndex    database.ReadIndex // 离线消息的读索引
+	IDGen        *database.IDGenerator
+	Media        media.Storage // 媒体文件的对象存储, 为nil时不能上传
+	MediaExpires time.Duration // 上传与下载地址的有效期
+	TokenKey     string        // 签发登录token的密钥, 需要与网关的配置相同
+	TokenExpires time.Duration // 登录token的有效期
 }
 
 // InsertUserMessage 插入单聊消息对应的数据到数据库中
@@ -25,7 +35,12 @@ func (h *ServiceHandler) InsertUserMessage(ctx iris.Context) {
 		ctx.StopWithError(iris.StatusBadRequest, err)
 		return
 	}
+	if err := checkInsertReq(&req); err != nil {
+		ctx.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
 	messageID := h.IDGen.Next().Int64()
+	dedup := h.newDedup(wire.ConversationUser, &req, messageID)
 	// 消息内容
 	content := database.MessageContent{
 		ID:       messageID,
@@ -52,21 +67,45 @@ func (h *ServiceHandler) InsertUserMessage(ctx iris.Context) {
 		MessageID: messageID,
 		SendTime:  req.GetSendTime(),
 	}
+	// 双方的会话, 接收方的未读数加一
+	senderConv := h.newConversations([]string{req.GetSender()}, wire.ConversationUser, req.GetDest(), messageID, &req, 0)
+	destConv := h.newConversations([]string{req.GetDest()}, wire.ConversationUser, req.GetSender(), messageID, &req, 1)
 	// 创建事务将数据存入数据库
-	err := h.MessageDB.Transaction(func(tx *gorm.DB) error {
+	var seq int64
+	err := h.MessageDB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if seq, err = nextSeq(tx, conversationKey(wire.ConversationUser, req.GetSender(), req.GetDest())); err != nil {
+			return err
+		}
+		applySeq(seq, dedup, ids, senderConv, destConv)
+		versions, err := nextVersions(tx, req.GetSender(), req.GetDest())
+		if err != nil {
+			return err
+		}
+		applyVersions(versions, ids, senderConv, destConv)
+		if err := claimClientID(tx, dedup); err != nil {
+			return err
+		}
 		if err := tx.Create(&content).Error; err != nil {
 			return err
 		}
 		if err := tx.Create(&ids).Error; err != nil {
 			return err
 		}
-		return nil
+		if err := h.touchConversations(tx, senderConv, 0); err != nil {
+			return err
+		}
+		return h.touchConversations(tx, destConv, 1)
 	})
+	if errors.Is(err, errDuplicated) {
+		h.respDuplicated(ctx, wire.ConversationUser, &req)
+		return
+	}
 	if err != nil {
 		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
-	_, _ = ctx.Negotiate(&rpc.InsertMessageResp{MessageId: messageID})
+	_, _ = ctx.Negotiate(&rpc.InsertMessageResp{MessageId: messageID, SendTime: req.GetSendTime(), Seq: seq})
 }
 
 // InsertGroupMessage 插入群聊消息对应的数据到数据库中
@@ -76,7 +115,12 @@ func (h *ServiceHandler) InsertGroupMessage(ctx iris.Context) {
 		ctx.StopWithError(iris.StatusBadRequest, err)
 		return
 	}
+	if err := checkInsertReq(&req); err != nil {
+		ctx.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
 	messageID := h.IDGen.Next().Int64()
+	dedup := h.newDedup(wire.ConversationGroup, &req, messageID)
 	// 消息内容
 	content := database.MessageContent{
 		ID:       messageID,
@@ -87,7 +131,7 @@ func (h *ServiceHandler) InsertGroupMessage(ctx iris.Context) {
 	}
 	// 找到群中的所有用户
 	var members []database.GroupMember
-	err := h.BaseDB.Where(&database.GroupMember{Group: req.Dest}).Find(&members).Error
+	err := h.BaseDB.WithContext(ctx.Request().Context()).Where(&database.GroupMember{Group: req.Dest}).Find(&members).Error
 	if err != nil {
 		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
@@ -108,122 +152,288 @@ func (h *ServiceHandler) InsertGroupMessage(ctx iris.Context) {
 			ids[i].Direction = 1
 		}
 	}
+	// 群成员的会话, 发送方以外的成员未读数加一
+	var receivers, senders []string
+	for _, m := range members {
+		if m.Account == req.GetSender() {
+			senders = append(senders, m.Account)
+		} else {
+			receivers = append(receivers, m.Account)
+		}
+	}
+	senderConv := h.newConversations(senders, wire.ConversationGroup, req.GetDest(), messageID, &req, 0)
+	memberConv := h.newConversations(receivers, wire.ConversationGroup, req.GetDest(), messageID, &req, 1)
 	// 创建事务将数据存入数据库
-	err = h.MessageDB.Transaction(func(tx *gorm.DB) error {
+	var seq int64
+	err = h.MessageDB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if seq, err = nextSeq(tx, conversationKey(wire.ConversationGroup, req.GetSender(), req.GetDest())); err != nil {
+			return err
+		}
+		applySeq(seq, dedup, ids, senderConv, memberConv)
+		versions, err := nextVersions(tx, append(senders, receivers...)...)
+		if err != nil {
+			return err
+		}
+		applyVersions(versions, ids, senderConv, memberConv)
+		if err := claimClientID(tx, dedup); err != nil {
+			return err
+		}
 		if err = tx.Create(&content).Error; err != nil {
 			return err
 		}
 		if err = tx.Create(&ids).Error; err != nil {
 			return err
 		}
-		return nil
+		if err = h.touchConversations(tx, senderConv, 0); err != nil {
+			return err
+		}
+		return h.touchConversations(tx, memberConv, 1)
 	})
+	if errors.Is(err, errDuplicated) {
+		h.respDuplicated(ctx, wire.ConversationGroup, &req)
+		return
+	}
 	if err != nil {
 		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
-	_, _ = ctx.Negotiate(&rpc.InsertMessageResp{MessageId: messageID})
+	_, _ = ctx.Negotiate(&rpc.InsertMessageResp{MessageId: messageID, SendTime: req.GetSendTime(), Seq: seq})
 }
 
-// MessageAck 根据Ack包重置读索引
-func (h *ServiceHandler) MessageAck(ctx iris.Context) {
-	var req rpc.AckMessageReq
-	if err := ctx.ReadBody(&req); err != nil {
-		ctx.StopWithError(iris.StatusBadRequest, err)
-		return
+// checkInsertReq 检查客户端消息id与extra的长度
+func checkInsertReq(req *rpc.InsertMessageReq) error {
+	if len(req.GetClientId()) > wire.MaxClientIdLength {
+		return errors.New("client_id is too long")
 	}
-	// 保存到redis中
-	err := setMessageAck(h.Cache, req.Account, req.MessageId)
-	if err != nil {
-		ctx.StopWithError(iris.StatusInternalServerError, err)
-		return
+	if len(req.GetMessage().GetExtra()) > wire.MaxExtraLength {
+		return fmt.Errorf("extra should not be longer than %d bytes", wire.MaxExtraLength)
 	}
+	return nil
 }
 
-// setMessageAck 重置读索引
-func setMessageAck(cache *redis.Client, account string, msgID int64) error {
-	if msgID == 0 {
+var (
+	// errDuplicated 客户端消息id已经保存过
+	errDuplicated = errors.New("duplicated client_id")
+	// errClientIDConflict 客户端消息id已经被发给其它会话的消息使用
+	errClientIDConflict = errors.New("client_id is used by another message")
+)
+
+// newDedup 客户端没有带消息id时不去重, 返回nil
+func (h *ServiceHandler) newDedup(typ int32, req *rpc.InsertMessageReq, messageID int64) *database.MessageDedup {
+	if req.GetClientId() == "" {
 		return nil
 	}
-	key := database.KeyMessageAckIndex(account)
-	return cache.Set(context.Background(), key, msgID, wire.OfflineReadIndexExpiresIn).Err()
+	return &database.MessageDedup{
+		ID:        h.IDGen.Next().Int64(),
+		Sender:    req.GetSender(),
+		ClientID:  req.GetClientId(),
+		Type:      typ,
+		Dest:      req.GetDest(),
+		MessageID: messageID,
+		SendTime:  req.GetSendTime(),
+	}
 }
 
-// GetOfflineMessageIndex 同步离线消息索引
-func (h *ServiceHandler) GetOfflineMessageIndex(ctx iris.Context) {
-	var req rpc.GetOfflineMessageIndexReq
-	if err := ctx.ReadBody(&req); err != nil {
-		ctx.StopWithError(iris.StatusBadRequest, err)
-		return
+// conversationKey 返回会话序号的key, 单聊双方的消息使用同一个序号
+func conversationKey(typ int32, sender, dest string) string {
+	if typ == wire.ConversationGroup {
+		return "g:" + dest
+	}
+	if sender > dest {
+		sender, dest = dest, sender
 	}
-	msgID := req.GetMessageId()
-	// 获取读索引全局时钟
-	start, err := h.getSentTime(req.Account, req.MessageId)
+	return "u:" + sender + ":" + dest
+}
+
+// nextSeq 在插入消息的事务中递增会话的序号, 同一个会话的插入在这里排队, 事务回滚时序号也一起回滚, 不会出现空洞
+func nextSeq(tx *gorm.DB, conversation string) (int64, error) {
+	row := database.ConversationSeq{Conversation: conversation, Seq: 1}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation"}},
+		DoUpdates: clause.Set{{Column: clause.Column{Name: "seq"}, Value: gorm.Expr("seq + 1")}, {Column: clause.Column{Name: "updated_at"}, Value: time.Now()}},
+	}).Create(&row).Error
 	if err != nil {
-		ctx.StopWithError(iris.StatusInternalServerError, err)
-		return
+		return 0, err
+	}
+	if err = tx.Where("conversation = ?", conversation).Take(&row).Error; err != nil {
+		return 0, err
+	}
+	return row.Seq, nil
+}
+
+// applySeq 把分配到的序号写入同一条消息的去重记录, 索引与会话
+func applySeq(seq int64, dedup *database.MessageDedup, ids []database.MessageIndex, convs ...[]database.Conversation) {
+	if dedup != nil {
+		dedup.Seq = seq
+	}
+	for i := range ids {
+		ids[i].Seq = seq
+	}
+	for _, list := range convs {
+		for i := range list {
+			list[i].LastSeq = seq
+		}
+	}
+}
+
+// claimClientID 在插入消息的事务中占用(sender, client_id), 并发的重试会等待先到的事务提交, 已被占用时返回errDuplicated
+func claimClientID(tx *gorm.DB, dedup *database.MessageDedup) error {
+	if dedup == nil {
+		return nil
+	}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(dedup)
+	if res.Error != nil {
+		return res.Error
 	}
-	// 从DB中加载消息索引列表
-	var indexes []*rpc.MessageIndex
-	tx := h.MessageDB.Model(&database.MessageIndex{}).Select("send_time", "account_b", "direction", "group")
-	err = tx.Where("account_a=? and send_time>? and direction=?", req.GetAccount(), start, 0).
-		Order("send_time asc").Limit(wire.OfflineSyncIndexCount).Find(indexes).Error
+	if res.RowsAffected == 0 {
+		return errDuplicated
+	}
+	return nil
+}
+
+// respDuplicated 返回重试的消息第一次保存时的message_id, send_time与seq, 会话不同时返回409
+func (h *ServiceHandler) respDuplicated(ctx iris.Context, typ int32, req *rpc.InsertMessageReq) {
+	var dedup database.MessageDedup
+	err := h.MessageDB.WithContext(ctx.Request().Context()).
+		Where("sender = ? AND client_id = ?", req.GetSender(), req.GetClientId()).Take(&dedup).Error
 	if err != nil {
 		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
-	// 重置读索引
-	err = setMessageAck(h.Cache, req.GetAccount(), msgID)
-	if err != nil {
-		ctx.StopWithError(iris.StatusInternalServerError, err)
+	if dedup.Type != typ || dedup.Dest != req.GetDest() {
+		ctx.StopWithError(iris.StatusConflict, errClientIDConflict)
 		return
 	}
-	_, _ = ctx.Negotiate(&rpc.GetOfflineMessageIndexResp{List: indexes})
+	_, _ = ctx.Negotiate(&rpc.InsertMessageResp{
+		MessageId:  dedup.MessageID,
+		SendTime:   dedup.SendTime,
+		Seq:        dedup.Seq,
+		Duplicated: true,
+	})
 }
 
-// getSentTime 获取全局时钟
-func (h *ServiceHandler) getSentTime(account string, msgID int64) (int64, error) {
-	// 冷启动(指在某个设备中第一次启动。或者在web端没有本地消息存储的情况下，第一次同步索引，消息ID就会为空)
-	if msgID == 0 {
-		key := database.KeyMessageAckIndex(account)
-		msgID, _ = h.Cache.Get(context.Background(), key).Int64()
-	}
-	var start int64
-	if msgID > 0 {
-		var content database.MessageContent
-		err := h.MessageDB.Select("send_time").First(&content, &msgID).Error
-		if err != nil {
-			start = time.Now().AddDate(0, 0, -1).UnixNano()
-		} else {
-			start = content.SendTime
+// PurgeMessageDedup 定时删除超过去重窗口的客户端消息id, 直到ctx结束
+func (h *ServiceHandler) PurgeMessageDedup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired := time.Now().Add(-wire.MessageDedupWindow)
+			err := h.MessageDB.WithContext(ctx).Where("created_at < ?", expired).Delete(&database.MessageDedup{}).Error
+			if err != nil {
+				logger.WithField("module", "royal").Warn(err)
+			}
 		}
 	}
-	// 返回默认的离线消息过期时间
-	earliestKeepTime := time.Now().AddDate(0, 0, -1*wire.OfflineMessageExpiresIn).UnixNano()
-	if start == 0 || start < earliestKeepTime {
-		start = earliestKeepTime
-	}
-	return start, nil
 }
 
-// GetOfflineMessageContent 同步离线消息内容
-func (h *ServiceHandler) GetOfflineMessageContent(ctx iris.Context) {
-	var req rpc.GetOfflineMessageContentReq
+// MessageAck 根据Ack包重置设备的读索引
+func (h *ServiceHandler) MessageAck(ctx iris.Context) {
+	var req rpc.AckMessageReq
 	if err := ctx.ReadBody(&req); err != nil {
 		ctx.StopWithError(iris.StatusBadRequest, err)
 		return
 	}
-	msgIds := len(req.GetMessageIds())
-	if msgIds > wire.MessageMaxCountPerPage {
-		ctx.StopWithText(iris.StatusInternalServerError, "too much msgIds")
+	err := h.ReadIndex.Set(ctx.Request().Context(), req.GetAccount(), req.GetDevice(), req.GetMessageId())
+	if err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
+}
 
-	var contents []*rpc.Message
-	err := h.MessageDB.Model(&database.MessageContent{}).Where(msgIds).Find(&contents).Error
-	if err != nil {
+// historyRow 消息索引与内容联表查询的结果
+type historyRow struct {
+	MessageID int64
+	Direction byte
+	AccountB  string
+	SendTime  int64
+	Seq       int64
+	Type      byte
+	Body      string
+	Extra     string
+}
+
+// MessageHistory 按会话序号翻取一个会话的历史消息, 只能看到自己索引中的消息
+func (h *ServiceHandler) MessageHistory(ctx iris.Context) {
+	var req rpc.HistoryReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if req.GetTarget() == "" || (req.GetType() != wire.ConversationUser && req.GetType() != wire.ConversationGroup) {
+		ctx.StopWithError(iris.StatusBadRequest, errors.New("conversation is invalid"))
+		return
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > wire.MessageHistoryPageSize {
+		limit = wire.MessageHistoryPageSize
+	}
+	db := h.MessageDB.WithContext(ctx.Request().Context())
+	tx := db.Table("t_message_index AS i").
+		Select("i.message_id, i.direction, i.account_b, i.send_time, i.seq, c.type, c.body, c.extra").
+		Joins("JOIN t_message_content AS c ON c.id = i.message_id").
+		Where("i.account_a = ?", req.GetAccount())
+	if req.GetType() == wire.ConversationGroup {
+		tx = tx.Where("i.`group` = ?", req.GetTarget())
+	} else {
+		tx = tx.Where("i.account_b = ? AND i.`group` = ?", req.GetTarget(), "")
+	}
+	if len(req.GetTypes()) > 0 {
+		tx = tx.Where("c.type IN ?", req.GetTypes())
+	}
+	// 以消息的(seq, message_id)作为游标, 升级前保存的消息seq都为0, 按message_id排序
+	op := "<"
+	if req.GetForward() {
+		op = ">"
+	}
+	if req.GetMessageId() != 0 {
+		var cursor database.MessageIndex
+		err := db.Select("seq").Where("account_a = ? AND message_id = ?", req.GetAccount(), req.GetMessageId()).Take(&cursor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			ctx.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+		tx = tx.Where(fmt.Sprintf("(i.seq %s ? OR (i.seq = ? AND i.message_id %s ?))", op, op),
+			cursor.Seq, cursor.Seq, req.GetMessageId())
+	} else if req.GetSeq() != 0 {
+		tx = tx.Where(fmt.Sprintf("i.seq %s ?", op), req.GetSeq())
+	}
+	order := "i.seq desc, i.message_id desc"
+	if req.GetForward() {
+		order = "i.seq asc, i.message_id asc"
+	}
+	var rows []historyRow
+	if err := tx.Order(order).Limit(limit + 1).Scan(&rows).Error; err != nil {
 		ctx.StopWithError(iris.StatusInternalServerError, err)
 		return
 	}
-	_, _ = ctx.Negotiate(&rpc.GetOfflineMessageContentResp{List: contents})
+	resp := &rpc.HistoryResp{}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		resp.HasMore = true
+	}
+	resp.Messages = make([]*rpc.HistoryMessage, len(rows))
+	for i, row := range rows {
+		sender := row.AccountB
+		if row.Direction == 1 {
+			sender = req.GetAccount()
+		}
+		resp.Messages[i] = &rpc.HistoryMessage{
+			MessageId: row.MessageID,
+			Type:      int32(row.Type),
+			Body:      row.Body,
+			Extra:     row.Extra,
+			Sender:    sender,
+			SendTime:  row.SendTime,
+			Seq:       row.Seq,
+		}
+	}
+	_, _ = ctx.Negotiate(resp)
 }
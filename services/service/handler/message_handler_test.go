@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func history(t *testing.T, app http.Handler, req *rpc.HistoryReq) *rpc.HistoryResp {
+	var resp rpc.HistoryResp
+	if code := call(t, app, http.MethodPost, "/api/app/message/history", req, &resp); code != http.StatusOK {
+		t.Fatalf("history: status %d", code)
+	}
+	return &resp
+}
+
+func TestMessageHistory(t *testing.T) {
+	app := newTestApp(t)
+	var ids []int64
+	for i, body := range []string{"1", "2", "3", "4", "5"} {
+		sender, dest := "a", "b"
+		if i%2 == 1 {
+			sender, dest = dest, sender
+		}
+		ids = append(ids, sendMessage(t, app, "/api/app/message/user", sender, dest, body))
+	}
+	// 和其它人的消息不在这个会话中
+	sendMessage(t, app, "/api/app/message/user", "c", "a", "other")
+	var image rpc.InsertMessageResp
+	call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+		Sender:   "b",
+		Dest:     "a",
+		SendTime: time.Now().UnixNano(),
+		Message:  &rpc.Message{Type: wire.MessageTypeImage, Body: "image"},
+	}, &image)
+
+	// 从最新的消息向前翻
+	resp := history(t, app, &rpc.HistoryReq{Account: "a", Type: wire.ConversationUser, Target: "b", Limit: 3})
+	if len(resp.Messages) != 3 || !resp.HasMore || resp.Messages[0].MessageId != image.MessageId || resp.Messages[2].MessageId != ids[3] {
+		t.Fatalf("unexpected first page %v", resp.Messages)
+	}
+	if resp.Messages[1].Sender != "a" || resp.Messages[2].Sender != "b" {
+		t.Fatalf("unexpected sender %v", resp.Messages)
+	}
+	resp = history(t, app, &rpc.HistoryReq{Account: "a", Type: wire.ConversationUser, Target: "b", MessageId: ids[3], Limit: 3})
+	if len(resp.Messages) != 3 || resp.HasMore || resp.Messages[2].MessageId != ids[0] {
+		t.Fatalf("unexpected second page %v", resp.Messages)
+	}
+
+	// 从某条消息向后翻, 并按类型过滤
+	resp = history(t, app, &rpc.HistoryReq{Account: "b", Type: wire.ConversationUser, Target: "a", MessageId: ids[2], Forward: true, Types: []int32{wire.MessageTypeText}})
+	if len(resp.Messages) != 2 || resp.Messages[0].MessageId != ids[3] || resp.Messages[1].MessageId != ids[4] {
+		t.Fatalf("unexpected forward page %v", resp.Messages)
+	}
+
+	// 游标不在自己的消息中
+	if code := call(t, app, http.MethodPost, "/api/app/message/history", &rpc.HistoryReq{Account: "c", Type: wire.ConversationUser, Target: "a", MessageId: ids[0]}, nil); code != http.StatusNotFound {
+		t.Fatalf("foreign cursor: status %d, want 404", code)
+	}
+
+	// 群消息
+	var created rpc.CreateGroupResp
+	call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{Name: "test", Owner: "a", Members: []string{"b"}}, &created)
+	sendMessage(t, app, "/api/app/message/group", "b", created.GroupId, "hi")
+	resp = history(t, app, &rpc.HistoryReq{Account: "a", Type: wire.ConversationGroup, Target: created.GroupId})
+	if len(resp.Messages) != 1 || resp.Messages[0].Sender != "b" || resp.Messages[0].Body != "hi" {
+		t.Fatalf("unexpected group history %v", resp.Messages)
+	}
+}
+
+func TestMessageDedup(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	insert := func(clientID, body string, sendTime int64) (int, *rpc.InsertMessageResp) {
+		var resp rpc.InsertMessageResp
+		code := call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+			Sender:   "a",
+			Dest:     "b",
+			SendTime: sendTime,
+			Message:  &rpc.Message{Type: wire.MessageTypeText, Body: body},
+			ClientId: clientID,
+		}, &resp)
+		return code, &resp
+	}
+
+	// 重试返回第一次保存的结果
+	_, first := insert("c1", "hello", 1)
+	_, retry := insert("c1", "hello", 2)
+	if first.Duplicated || !retry.Duplicated || retry.MessageId != first.MessageId || retry.SendTime != 1 {
+		t.Fatalf("unexpected retry %v, first %v", retry, first)
+	}
+	// 同一个client_id发给其它会话时返回409, 不会把第一条消息当作结果
+	var conflict rpc.InsertMessageResp
+	code := call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+		Sender:   "a",
+		Dest:     "c",
+		SendTime: 2,
+		Message:  &rpc.Message{Type: wire.MessageTypeText, Body: "hello"},
+		ClientId: "c1",
+	}, &conflict)
+	if code != http.StatusConflict {
+		t.Fatalf("client_id reused for another dest: status %d, want 409", code)
+	}
+	// 没有client_id时不去重
+	_, a := insert("", "hi", 3)
+	_, b := insert("", "hi", 3)
+	if a.MessageId == b.MessageId || a.Duplicated || b.Duplicated {
+		t.Fatalf("messages without client_id should not be deduplicated")
+	}
+	if code, _ := insert(strings.Repeat("x", wire.MaxClientIdLength+1), "hi", 4); code != http.StatusBadRequest {
+		t.Fatalf("long client_id: status %d, want 400", code)
+	}
+
+	// 并发的重试只保存一次
+	const retries = 8
+	var wg sync.WaitGroup
+	results := make([]*rpc.InsertMessageResp, retries)
+	codes := make([]int, retries)
+	for i := 0; i < retries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i], results[i] = insert("c2", "concurrent", int64(10+i))
+		}(i)
+	}
+	wg.Wait()
+	saved := 0
+	for i, resp := range results {
+		if codes[i] != http.StatusOK {
+			t.Fatalf("concurrent insert: status %d", codes[i])
+		}
+		if resp.MessageId != results[0].MessageId {
+			t.Fatalf("expect the same message id, got %d and %d", resp.MessageId, results[0].MessageId)
+		}
+		if !resp.Duplicated {
+			saved++
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("expect message saved once, got %d", saved)
+	}
+	var count int64
+	h.MessageDB.Model(&database.MessageIndex{}).Where("message_id = ?", results[0].MessageId).Count(&count)
+	if count != 2 {
+		t.Fatalf("expect 2 indexes, got %d", count)
+	}
+	if c := conversations(t, app, "b", 0).Conversations[0]; c.Unread != 4 {
+		t.Fatalf("expect 4 unread messages, got %d", c.Unread)
+	}
+}
+
+func TestMessageSeq(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	insert := func(path, sender, dest string, sendTime int64, clientID string) *rpc.InsertMessageResp {
+		var resp rpc.InsertMessageResp
+		code := call(t, app, http.MethodPost, path, &rpc.InsertMessageReq{
+			Sender:   sender,
+			Dest:     dest,
+			SendTime: sendTime,
+			Message:  &rpc.Message{Type: wire.MessageTypeText, Body: "hi"},
+			ClientId: clientID,
+		}, &resp)
+		if code != http.StatusOK {
+			t.Fatalf("insert message: status %d", code)
+		}
+		return &resp
+	}
+
+	// 不同服务器的时钟不一致, 序号仍然按保存的顺序递增, 单聊双方共用序号
+	first := insert("/api/app/message/user", "a", "b", 100, "c1")
+	second := insert("/api/app/message/user", "b", "a", 50, "")
+	third := insert("/api/app/message/user", "a", "b", 70, "")
+	if first.Seq != 1 || second.Seq != 2 || third.Seq != 3 {
+		t.Fatalf("unexpected seq %d %d %d", first.Seq, second.Seq, third.Seq)
+	}
+	if retry := insert("/api/app/message/user", "a", "b", 200, "c1"); !retry.Duplicated || retry.Seq != 1 {
+		t.Fatalf("unexpected retry %v", retry)
+	}
+	if other := insert("/api/app/message/user", "c", "a", 1, ""); other.Seq != 1 {
+		t.Fatalf("new conversation starts with seq %d", other.Seq)
+	}
+
+	// 历史消息与会话按序号排序, 不受发送时间影响
+	resp := history(t, app, &rpc.HistoryReq{Account: "b", Type: wire.ConversationUser, Target: "a"})
+	if len(resp.Messages) != 3 || resp.Messages[0].MessageId != third.MessageId || resp.Messages[2].Seq != 1 {
+		t.Fatalf("unexpected history %v", resp.Messages)
+	}
+	resp = history(t, app, &rpc.HistoryReq{Account: "a", Type: wire.ConversationUser, Target: "b", Seq: 1, Forward: true, Limit: 1})
+	if len(resp.Messages) != 1 || !resp.HasMore || resp.Messages[0].MessageId != second.MessageId {
+		t.Fatalf("unexpected range %v", resp.Messages)
+	}
+	for _, account := range []string{"a", "b"} {
+		list := conversations(t, app, account, 0).Conversations
+		if c := list[0]; c.LastSeq != 3 || c.LastMessageId != third.MessageId {
+			t.Fatalf("unexpected conversation of %s: %v", account, c)
+		}
+	}
+
+	// 群的所有成员使用同一个序号
+	var created rpc.CreateGroupResp
+	call(t, app, http.MethodPost, "/api/app/group", &rpc.CreateGroupReq{Name: "test", Owner: "a", Members: []string{"b", "c"}}, &created)
+	group := insert("/api/app/message/group", "b", created.GroupId, 1, "")
+	var seqs []int64
+	h.MessageDB.Model(&database.MessageIndex{}).Where("message_id = ?", group.MessageId).Pluck("seq", &seqs)
+	if group.Seq != 1 || len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 1 || seqs[2] != 1 {
+		t.Fatalf("unexpected group seq %d, indexes %v", group.Seq, seqs)
+	}
+
+	// 并发插入的序号连续且不重复
+	const n = 8
+	var wg sync.WaitGroup
+	got := make([]bool, n+1)
+	var lock sync.Mutex
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			seq := insert("/api/app/message/user", "d", "e", int64(i), "").Seq
+			lock.Lock()
+			defer lock.Unlock()
+			if seq < 1 || seq > n || got[seq] {
+				t.Errorf("unexpected concurrent seq %d", seq)
+				return
+			}
+			got[seq] = true
+		}(i)
+	}
+	wg.Wait()
+}
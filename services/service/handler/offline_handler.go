@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// syncCursor 离线同步的位置, 返回(version, send_time, message_id)之后的消息;
+// version在插入消息的事务中分配, 按提交顺序递增, 不受各服务器时钟的影响, 升级前保存的消息version为0, 按发送时间排在前面
+type syncCursor struct {
+	version   int64
+	sendTime  int64
+	messageID int64
+}
+
+// GetOfflineMessageIndex 同步收到的离线消息索引, 按(version, send_time, message_id)升序, 每页最多wire.OfflineSyncIndexCount条,
+// has_more为true时客户端使用最后一条索引的message_id继续同步
+func (h *ServiceHandler) GetOfflineMessageIndex(ctx iris.Context) {
+	var req rpc.GetOfflineMessageIndexReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	c := ctx.Request().Context()
+	earliest := time.Now().AddDate(0, 0, -1*wire.OfflineMessageExpiresIn).UnixNano()
+	cursor, err := h.syncCursor(c, req.GetAccount(), req.GetDevice(), req.GetMessageId(), earliest)
+	if err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	var list []database.MessageIndex
+	err = h.MessageDB.WithContext(c).Select("message_id", "direction", "account_b", "group", "send_time", "seq", "version").
+		Where("account_a = ? AND direction = ? AND send_time > ?", req.GetAccount(), 0, earliest).
+		Where("(version > ? OR (version = ? AND (send_time > ? OR (send_time = ? AND message_id > ?))))",
+			cursor.version, cursor.version, cursor.sendTime, cursor.sendTime, cursor.messageID).
+		Order("version asc, send_time asc, message_id asc").Limit(wire.OfflineSyncIndexCount + 1).Find(&list).Error
+	if err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	// 客户端已经收到了message_id之前的消息, 记录为设备的读索引
+	if err = h.ReadIndex.Set(c, req.GetAccount(), req.GetDevice(), req.GetMessageId()); err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	resp := &rpc.GetOfflineMessageIndexResp{}
+	if len(list) > wire.OfflineSyncIndexCount {
+		list = list[:wire.OfflineSyncIndexCount]
+		resp.HasMore = true
+	}
+	resp.List = make([]*rpc.MessageIndex, len(list))
+	for i, index := range list {
+		resp.List[i] = &rpc.MessageIndex{
+			MessageId: index.MessageID,
+			Direction: int32(index.Direction),
+			SendTime:  index.SendTime,
+			AccountB:  index.AccountB,
+			Group:     index.Group,
+			Seq:       index.Seq,
+		}
+	}
+	_, _ = ctx.Negotiate(resp)
+}
+
+// syncCursor 根据客户端最后收到的消息计算同步的起点, 冷启动时(新设备或者web端没有本地消息)使用读索引,
+// 都没有或者早于离线消息的保存期限时从保存期限开始
+func (h *ServiceHandler) syncCursor(ctx context.Context, account, device string, msgID int64, earliest int64) (syncCursor, error) {
+	start := syncCursor{sendTime: earliest}
+	if msgID == 0 {
+		var err error
+		if msgID, err = h.ReadIndex.Get(ctx, account, device); err != nil {
+			return start, err
+		}
+	}
+	if msgID <= 0 {
+		return start, nil
+	}
+	var index database.MessageIndex
+	err := h.MessageDB.WithContext(ctx).Select("send_time", "version").
+		Where("account_a = ? AND message_id = ?", account, msgID).Take(&index).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return start, nil
+	}
+	if err != nil {
+		return start, err
+	}
+	if index.SendTime < earliest {
+		return start, nil
+	}
+	return syncCursor{version: index.Version, sendTime: index.SendTime, messageID: msgID}, nil
+}
+
+// GetOfflineMessageContent 同步离线消息内容, 只返回account的索引中存在的消息
+func (h *ServiceHandler) GetOfflineMessageContent(ctx iris.Context) {
+	var req rpc.GetOfflineMessageContentReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	ids := req.GetMessageIds()
+	if len(ids) == 0 || req.GetAccount() == "" {
+		ctx.StopWithError(iris.StatusBadRequest, errors.New("account or message_ids is empty"))
+		return
+	}
+	if len(ids) > wire.MessageMaxCountPerPage {
+		ctx.StopWithError(iris.StatusBadRequest, errors.New("too many message_ids"))
+		return
+	}
+	db := h.MessageDB.WithContext(ctx.Request().Context())
+	owned := db.Model(&database.MessageIndex{}).Select("message_id").Where("account_a = ? AND message_id IN ?", req.GetAccount(), ids)
+	var contents []database.MessageContent
+	if err := db.Where("id IN (?)", owned).Find(&contents).Error; err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	list := make([]*rpc.Message, len(contents))
+	for i, content := range contents {
+		list[i] = &rpc.Message{
+			Id:    content.ID,
+			Type:  int32(content.Type),
+			Body:  content.Body,
+			Extra: content.Extra,
+		}
+	}
+	_, _ = ctx.Negotiate(&rpc.GetOfflineMessageContentResp{List: list})
+}
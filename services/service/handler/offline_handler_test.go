@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire"
+	"EIM/wire/rpc"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// seedMessages 直接在数据库中插入n条sender发给account的消息, 返回消息ID
+func seedMessages(t *testing.T, h *ServiceHandler, account, sender string, n int, sendTime func(i int) int64) []int64 {
+	ids := make([]int64, n)
+	contents := make([]database.MessageContent, n)
+	indexes := make([]database.MessageIndex, n)
+	for i := 0; i < n; i++ {
+		ids[i] = h.IDGen.Next().Int64()
+		contents[i] = database.MessageContent{ID: ids[i], Type: wire.MessageTypeText, Body: "hello", SendTime: sendTime(i)}
+		indexes[i] = database.MessageIndex{ID: h.IDGen.Next().Int64(), AccountA: account, AccountB: sender, MessageID: ids[i], SendTime: sendTime(i)}
+	}
+	if err := h.MessageDB.CreateInBatches(contents, 500).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := h.MessageDB.CreateInBatches(indexes, 500).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func syncIndex(t *testing.T, app http.Handler, account, device string, msgID int64) *rpc.GetOfflineMessageIndexResp {
+	var resp rpc.GetOfflineMessageIndexResp
+	code := call(t, app, http.MethodPost, "/api/app/offline/index", &rpc.GetOfflineMessageIndexReq{Account: account, Device: device, MessageId: msgID}, &resp)
+	if code != http.StatusOK {
+		t.Fatalf("sync index: status %d", code)
+	}
+	return &resp
+}
+
+func TestOfflineColdStart(t *testing.T) {
+	app := newTestApp(t)
+	var ids []int64
+	for _, body := range []string{"1", "2", "3"} {
+		ids = append(ids, sendMessage(t, app, "/api/app/message/user", "a", "b", body))
+	}
+
+	// 没有读索引时从保存期限开始, 只返回收到的消息
+	if got := syncIndex(t, app, "a", "phone", 0).List; len(got) != 0 {
+		t.Fatalf("sender should not sync its own messages, got %v", got)
+	}
+	resp := syncIndex(t, app, "b", "phone", 0)
+	if len(resp.List) != 3 || resp.HasMore || resp.List[0].MessageId != ids[0] || resp.List[0].AccountB != "a" {
+		t.Fatalf("unexpected indexes %v", resp.List)
+	}
+	var content rpc.GetOfflineMessageContentResp
+	call(t, app, http.MethodPost, "/api/app/offline/content", &rpc.GetOfflineMessageContentReq{Account: "b", MessageIds: ids}, &content)
+	if len(content.List) != 3 {
+		t.Fatalf("expect 3 contents, got %v", content.List)
+	}
+	var other rpc.GetOfflineMessageContentResp
+	call(t, app, http.MethodPost, "/api/app/offline/content", &rpc.GetOfflineMessageContentReq{Account: "c", MessageIds: ids}, &other)
+	if len(other.List) != 0 {
+		t.Fatalf("messages of others should not be returned, got %v", other.List)
+	}
+
+	// phone已收到ids[1], 新设备冷启动时从账号的读索引开始
+	if got := syncIndex(t, app, "b", "phone", ids[1]).List; len(got) != 1 || got[0].MessageId != ids[2] {
+		t.Fatalf("unexpected indexes after %d: %v", ids[1], got)
+	}
+	if got := syncIndex(t, app, "b", "pad", 0).List; len(got) != 1 || got[0].MessageId != ids[2] {
+		t.Fatalf("new device should start from read index, got %v", got)
+	}
+	// pad确认全部已读后phone冷启动仍然从自己的读索引开始
+	call(t, app, http.MethodPost, "/api/app/message/ack", &rpc.AckMessageReq{Account: "b", Device: "pad", MessageId: ids[2]}, nil)
+	if got := syncIndex(t, app, "b", "phone", 0).List; len(got) != 1 || got[0].MessageId != ids[2] {
+		t.Fatalf("phone should start from its own read index, got %v", got)
+	}
+	if got := syncIndex(t, app, "b", "watch", 0).List; len(got) != 0 {
+		t.Fatalf("new device should start from latest read index, got %v", got)
+	}
+}
+
+func TestOfflineExpiry(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	expired := time.Now().AddDate(0, 0, -wire.OfflineMessageExpiresIn-1).UnixNano()
+	old := seedMessages(t, h, "b", "a", 2, func(i int) int64 { return expired + int64(i) })
+	recent := seedMessages(t, h, "b", "a", 1, func(i int) int64 { return time.Now().UnixNano() })
+
+	if got := syncIndex(t, app, "b", "phone", 0).List; len(got) != 1 || got[0].MessageId != recent[0] {
+		t.Fatalf("expired messages should not be synced, got %v", got)
+	}
+	// 最后收到的消息已过期时同样从保存期限开始
+	if got := syncIndex(t, app, "b", "phone", old[0]).List; len(got) != 1 || got[0].MessageId != recent[0] {
+		t.Fatalf("expect to start from expiry, got %v", got)
+	}
+}
+
+func TestOfflineLargeBacklog(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	total := wire.OfflineSyncIndexCount + 500
+	start := time.Now().Add(-time.Hour).UnixNano()
+	// 每10条消息的发送时间相同, 翻页时不能丢失或重复
+	ids := seedMessages(t, h, "b", "a", total, func(i int) int64 { return start + int64(i/10) })
+
+	seen := make(map[int64]bool)
+	var cursor int64
+	pages := 0
+	for {
+		resp := syncIndex(t, app, "b", "phone", cursor)
+		pages++
+		for _, index := range resp.List {
+			if seen[index.MessageId] {
+				t.Fatalf("duplicated index %d", index.MessageId)
+			}
+			seen[index.MessageId] = true
+		}
+		if !resp.HasMore {
+			break
+		}
+		if len(resp.List) != wire.OfflineSyncIndexCount {
+			t.Fatalf("expect full page, got %d", len(resp.List))
+		}
+		cursor = resp.List[len(resp.List)-1].MessageId
+	}
+	if pages != 2 || len(seen) != total {
+		t.Fatalf("expect %d indexes in 2 pages, got %d in %d", total, len(seen), pages)
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Fatalf("index %d is missing", id)
+		}
+	}
+}
+
+func TestOfflineClockSkew(t *testing.T) {
+	app := newTestApp(t)
+	first := sendMessage(t, app, "/api/app/message/user", "a", "b", "1")
+	if got := syncIndex(t, app, "b", "phone", 0).List; len(got) != 1 || got[0].MessageId != first {
+		t.Fatalf("unexpected indexes %v", got)
+	}
+	// 时钟慢了一小时的chat服务在客户端同步之后保存的消息
+	var skewed rpc.InsertMessageResp
+	call(t, app, http.MethodPost, "/api/app/message/user", &rpc.InsertMessageReq{
+		Sender:   "c",
+		Dest:     "b",
+		SendTime: time.Now().Add(-time.Hour).UnixNano(),
+		Message:  &rpc.Message{Type: wire.MessageTypeText, Body: "2"},
+	}, &skewed)
+	last := sendMessage(t, app, "/api/app/message/user", "a", "b", "3")
+
+	got := syncIndex(t, app, "b", "phone", first).List
+	if len(got) != 2 || got[0].MessageId != skewed.MessageId || got[1].MessageId != last {
+		t.Fatalf("skewed message should be synced, got %v", got)
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"EIM/services/service/media"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// Register 注册royal的http接口, service.newApp与测试共用同一份路由
+func (h *ServiceHandler) Register(app iris.Party) {
+	messageAPI := app.Party("/api/:app/message")
+	{
+		messageAPI.Post("/user", h.InsertUserMessage)
+		messageAPI.Post("/group", h.InsertGroupMessage)
+		messageAPI.Post("/ack", h.MessageAck)
+		messageAPI.Post("/history", h.MessageHistory)
+	}
+
+	conversationAPI := app.Party("/api/:app/conversation")
+	{
+		conversationAPI.Post("/list", h.ConversationList)
+		conversationAPI.Post("/update", h.ConversationUpdate)
+		conversationAPI.Post("/read", h.ConversationRead)
+	}
+
+	groupAPI := app.Party("/api/:app/group")
+	{
+		groupAPI.Get("/:id", h.GroupGet)
+		groupAPI.Post("", h.GroupCreate)
+		groupAPI.Post("/member", h.GroupJoin)
+		groupAPI.Delete("/member", h.GroupQuit)
+		groupAPI.Get("/members/:id", h.GroupMembers)
+		groupAPI.Get("/user/:account", h.GroupsOfUser)
+		groupAPI.Post("/kick", h.GroupKick)
+		groupAPI.Post("/transfer", h.GroupTransfer)
+		groupAPI.Post("/admin", h.GroupSetAdmin)
+		groupAPI.Post("/mute", h.GroupMute)
+		groupAPI.Post("/update", h.GroupUpdate)
+		groupAPI.Post("/dissolve", h.GroupDissolve)
+		groupAPI.Post("/invite", h.GroupInvite)
+		groupAPI.Post("/approve", h.GroupApprove)
+		groupAPI.Get("/requests/:id", h.GroupJoinRequests)
+	}
+
+	userAPI := app.Party("/api/:app/user")
+	{
+		userAPI.Post("", h.UserRegister)
+		userAPI.Post("/login", h.UserLogin)
+		userAPI.Post("/update", h.UserUpdate)
+		userAPI.Post("/profiles", h.UserProfiles)
+		userAPI.Get("/:account", h.UserGet)
+	}
+
+	friendAPI := app.Party("/api/:app/friend")
+	{
+		friendAPI.Post("/request", h.FriendAdd)
+		friendAPI.Post("/approve", h.FriendApprove)
+		friendAPI.Get("/requests/:account", h.FriendRequests)
+		friendAPI.Get("/list/:account", h.FriendList)
+		friendAPI.Post("/remark", h.FriendRemark)
+		friendAPI.Delete("", h.FriendDelete)
+		friendAPI.Post("/block", h.FriendBlock)
+		friendAPI.Get("/blocks/:account", h.FriendBlocklist)
+		friendAPI.Get("/relation", h.FriendRelation)
+	}
+
+	offlineAPI := app.Party("/api/:app/offline")
+	{
+		offlineAPI.Use(iris.Compression)
+		offlineAPI.Post("/index", h.GetOfflineMessageIndex)
+		offlineAPI.Post("/content", h.GetOfflineMessageContent)
+	}
+
+	mediaAPI := app.Party("/api/:app/media")
+	{
+		mediaAPI.Use(h.RequireMedia)
+		mediaAPI.Post("/token", h.MediaToken)
+		mediaAPI.Post("/complete", h.MediaComplete)
+		mediaAPI.Post("/meta", h.MediaMeta)
+		mediaAPI.Post("/url", h.MediaURL)
+	}
+
+	// 本地存储的上传与下载地址, 由客户端直接访问, 使用地址中的签名鉴权
+	objectAPI := app.Party(strings.TrimSuffix(media.LocalPathPrefix, "/"))
+	{
+		objectAPI.Use(h.RequireMedia)
+		objectAPI.Put("/{key:path}", h.MediaUpload)
+		objectAPI.Post("/{key:path}", h.MediaUpload)
+		objectAPI.Get("/{key:path}", h.MediaDownload)
+	}
+}
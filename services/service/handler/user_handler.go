@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire/rpc"
+	"EIM/wire/token"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 账号与密码的长度限制
+const (
+	MaxAccountLength  = 60
+	MinPasswordLength = 6
+	MaxPasswordLength = 72 // bcrypt只使用前72个字节
+	MaxNicknameLength = 20
+
+	DefaultTokenExpires = time.Hour * 24 * 7
+)
+
+var (
+	errUserExists     = errors.New("account already exists")
+	errUserNotFound   = errors.New("user not found")
+	errWrongPassword  = errors.New("account or password is wrong")
+	errInvalidAccount = errors.New("account is invalid")
+)
+
+// UserRegister 注册用户, 密码使用bcrypt保存, 账号已存在时返回409
+func (h *ServiceHandler) UserRegister(c iris.Context) {
+	var req rpc.RegisterUserReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if req.Account == "" || len(req.Account) > MaxAccountLength {
+		c.StopWithError(iris.StatusBadRequest, errInvalidAccount)
+		return
+	}
+	if err := checkProfile(req.Password, req.Nickname, true); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	// 并发注册同一个账号时由唯一索引保证只有一个成功
+	res := h.BaseDB.WithContext(c.Request().Context()).Clauses(clause.OnConflict{DoNothing: true}).Create(&database.User{
+		Model: database.Model{
+			ID: h.IDGen.Next().Int64(),
+		},
+		App:      c.Params().Get("app"),
+		Account:  req.Account,
+		Password: string(hash),
+		Avatar:   req.Avatar,
+		Nickname: req.Nickname,
+	})
+	if res.Error != nil {
+		c.StopWithError(iris.StatusInternalServerError, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.StopWithError(iris.StatusConflict, errUserExists)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.User{
+		Account:  req.Account,
+		Nickname: req.Nickname,
+		Avatar:   req.Avatar,
+	})
+}
+
+// UserLogin 校验密码并签发登录网关使用的token, 只能登录注册时的app, 账号不存在与密码错误返回相同的错误
+func (h *ServiceHandler) UserLogin(c iris.Context) {
+	var req rpc.UserLoginReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	user, err := h.findUser(c, c.Params().Get("app"), req.Account)
+	if err == errUserNotFound {
+		c.StopWithError(iris.StatusUnauthorized, errWrongPassword)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.StopWithError(iris.StatusUnauthorized, errWrongPassword)
+		return
+	}
+	expires := h.TokenExpires
+	if expires <= 0 {
+		expires = DefaultTokenExpires
+	}
+	key := h.TokenKey
+	if key == "" {
+		key = token.DefaultKey
+	}
+	expireAt := time.Now().Add(expires).Unix()
+	tk, err := token.Generate(key, &token.Token{
+		Account: user.Account,
+		App:     user.App,
+		Exp:     expireAt,
+	})
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(&rpc.UserLoginResp{
+		Token:    tk,
+		ExpireAt: expireAt,
+	})
+}
+
+// UserGet 获取用户资料
+func (h *ServiceHandler) UserGet(c iris.Context) {
+	user, err := h.findUser(c, c.Params().Get("app"), c.Params().Get("account"))
+	if err == errUserNotFound {
+		c.StopWithError(iris.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	_, _ = c.Negotiate(toUser(user))
+}
+
+// UserUpdate 修改用户资料或密码, 为空的字段不修改
+func (h *ServiceHandler) UserUpdate(c iris.Context) {
+	var req rpc.UpdateUserReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	if err := checkProfile(req.Password, req.Nickname, false); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	updates := make(map[string]interface{})
+	if req.Nickname != "" {
+		updates["nickname"] = req.Nickname
+	}
+	if req.Avatar != "" {
+		updates["avatar"] = req.Avatar
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+		updates["password"] = string(hash)
+	}
+	if len(updates) == 0 {
+		return
+	}
+	res := h.BaseDB.WithContext(c.Request().Context()).Model(&database.User{}).
+		Where("app = ? AND account = ?", c.Params().Get("app"), req.Account).Updates(updates)
+	if res.Error != nil {
+		c.StopWithError(iris.StatusInternalServerError, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.StopWithError(iris.StatusNotFound, errUserNotFound)
+	}
+}
+
+// UserProfiles 批量获取用户资料, 不存在的账号会被忽略
+func (h *ServiceHandler) UserProfiles(c iris.Context) {
+	var req rpc.UserProfilesReq
+	if err := c.ReadBody(&req); err != nil {
+		c.StopWithError(iris.StatusBadRequest, err)
+		return
+	}
+	var users []database.User
+	if len(req.Accounts) > 0 {
+		err := h.BaseDB.WithContext(c.Request().Context()).Where("app = ? AND account IN ?", c.Params().Get("app"), req.Accounts).Find(&users).Error
+		if err != nil {
+			c.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+	}
+	resp := &rpc.UserProfilesResp{Users: make([]*rpc.User, len(users))}
+	for i := range users {
+		resp.Users[i] = toUser(&users[i])
+	}
+	_, _ = c.Negotiate(resp)
+}
+
+func (h *ServiceHandler) findUser(c iris.Context, app, account string) (*database.User, error) {
+	var user database.User
+	err := h.BaseDB.WithContext(c.Request().Context()).Where("app = ? AND account = ?", app, account).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkProfile 检查密码与昵称的长度, required为false时密码可以为空
+func checkProfile(password, nickname string, required bool) error {
+	if (required || password != "") && (len(password) < MinPasswordLength || len(password) > MaxPasswordLength) {
+		return errors.New("password length must be between 6 and 72")
+	}
+	if utf8.RuneCountInString(nickname) > MaxNicknameLength {
+		return errors.New("nickname is too long")
+	}
+	return nil
+}
+
+func toUser(user *database.User) *rpc.User {
+	return &rpc.User{
+		Account:   user.Account,
+		Nickname:  user.Nickname,
+		Avatar:    user.Avatar,
+		CreatedAt: user.CreatedAt.Unix(),
+	}
+}
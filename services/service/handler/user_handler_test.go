@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"EIM/services/service/database"
+	"EIM/wire/rpc"
+	"EIM/wire/token"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestUser(t *testing.T) {
+	app := newTestApp(t)
+	register := &rpc.RegisterUserReq{Account: "u1", Password: "secret1", Nickname: "小明"}
+	if code := call(t, app, http.MethodPost, "/api/app/user", &rpc.RegisterUserReq{Account: "u1", Password: "123"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("short password: status %d, want 400", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/user", register, nil); code != http.StatusOK {
+		t.Fatalf("register: status %d", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/user", register, nil); code != http.StatusConflict {
+		t.Fatalf("register twice: status %d, want 409", code)
+	}
+
+	if code := call(t, app, http.MethodPost, "/api/app/user/login", &rpc.UserLoginReq{Account: "u1", Password: "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d, want 401", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/app/user/login", &rpc.UserLoginReq{Account: "nobody", Password: "secret1"}, nil); code != http.StatusUnauthorized {
+		t.Fatalf("unknown account: status %d, want 401", code)
+	}
+	var login rpc.UserLoginResp
+	call(t, app, http.MethodPost, "/api/app/user/login", &rpc.UserLoginReq{Account: "u1", Password: "secret1"}, &login)
+	tk, err := token.Parse(token.DefaultKey, login.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Account != "u1" || tk.App != "app" || tk.Exp != login.ExpireAt {
+		t.Fatalf("unexpected token %+v", tk)
+	}
+
+	call(t, app, http.MethodPost, "/api/app/user/update", &rpc.UpdateUserReq{Account: "u1", Avatar: "a.png", Password: "secret2"}, nil)
+	if code := call(t, app, http.MethodPost, "/api/app/user/login", &rpc.UserLoginReq{Account: "u1", Password: "secret2"}, nil); code != http.StatusOK {
+		t.Fatalf("login with new password: status %d", code)
+	}
+	var user rpc.User
+	call(t, app, http.MethodGet, "/api/app/user/u1", nil, &user)
+	if user.Nickname != "小明" || user.Avatar != "a.png" {
+		t.Fatalf("unexpected user %v", &user)
+	}
+	var profiles rpc.UserProfilesResp
+	call(t, app, http.MethodPost, "/api/app/user/profiles", &rpc.UserProfilesReq{Accounts: []string{"u1", "nobody"}}, &profiles)
+	if len(profiles.Users) != 1 || profiles.Users[0].Account != "u1" {
+		t.Fatalf("unexpected profiles %v", profiles.Users)
+	}
+}
+
+// 密码不能以明文保存
+func TestUserPasswordHashed(t *testing.T) {
+	h := newTestHandler(t)
+	app := newTestRouter(t, h)
+	call(t, app, http.MethodPost, "/api/app/user", &rpc.RegisterUserReq{Account: "u1", Password: "secret1"}, nil)
+	var user database.User
+	if err := h.BaseDB.First(&user, "account = ?", "u1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == "" || user.Password == "secret1" {
+		t.Fatalf("password is not hashed: %q", user.Password)
+	}
+}
+
+// 账号只属于注册时的app, 并发注册同一个账号时只有一个成功
+func TestUserScopedByApp(t *testing.T) {
+	app := newTestApp(t)
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = call(t, app, http.MethodPost, "/api/app/user", &rpc.RegisterUserReq{Account: "u1", Password: "secret1"}, nil)
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			created++
+		} else if code != http.StatusConflict {
+			t.Fatalf("concurrent register: status %d, want 409", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d registrations succeeded, want 1", created)
+	}
+
+	if code := call(t, app, http.MethodPost, "/api/other/user/login", &rpc.UserLoginReq{Account: "u1", Password: "secret1"}, nil); code != http.StatusUnauthorized {
+		t.Fatalf("login to another app: status %d, want 401", code)
+	}
+	if code := call(t, app, http.MethodGet, "/api/other/user/u1", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("get from another app: status %d, want 404", code)
+	}
+	if code := call(t, app, http.MethodPost, "/api/other/user", &rpc.RegisterUserReq{Account: "u1", Password: "secret2"}, nil); code != http.StatusOK {
+		t.Fatalf("register in another app: status %d", code)
+	}
+	var login rpc.UserLoginResp
+	call(t, app, http.MethodPost, "/api/other/user/login", &rpc.UserLoginReq{Account: "u1", Password: "secret2"}, &login)
+	if tk, err := token.Parse(token.DefaultKey, login.Token); err != nil || tk.App != "other" {
+		t.Fatalf("unexpected token %+v, err %v", tk, err)
+	}
+}
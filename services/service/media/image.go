@@ -0,0 +1,85 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// ImageInfo 解析出的图片尺寸与缩略图
+type ImageInfo struct {
+	Width     int
+	Height    int
+	Thumbnail []byte // jpeg格式, 图片过大或者无法解码时为nil
+}
+
+// ProbeImage 解析jpeg, png与gif图片的尺寸, 像素数不超过maxPixels时生成最大边长为thumbSize的缩略图
+func ProbeImage(data []byte, thumbSize, maxPixels int) (*ImageInfo, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	info := &ImageInfo{Width: config.Width, Height: config.Height}
+	if config.Width*config.Height > maxPixels {
+		return info, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return info, nil
+	}
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, Thumbnail(img, thumbSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	info.Thumbnail = buf.Bytes()
+	return info, nil
+}
+
+// Thumbnail 按比例缩小到最大边长为size, 每个像素取原图对应区域的平均值; 图片不大于size时只复制
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, sh*size/sw
+		} else {
+			dw, dh = sw*size/sh, size
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
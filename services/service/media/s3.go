@@ -0,0 +1,225 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options 兼容S3协议的对象存储, 如aws s3, minio, 阿里云oss与腾讯云cos
+type S3Options struct {
+	Endpoint  string // 如https://s3.us-east-1.amazonaws.com, 没有scheme时使用https
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // 为true时bucket放在路径中, minio通常需要开启
+}
+
+// S3Storage 使用aws signature v4预签名的地址访问对象, 客户端直接上传到对象存储
+type S3Storage struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" || opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, fmt.Errorf("media: bucket and access key of s3 storage are required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if !strings.Contains(opts.Endpoint, "://") {
+		opts.Endpoint = "https://" + opts.Endpoint
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{
+		opts:     opts,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute * 10},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	signed, err := s.SignURL(http.MethodPut, key, size, time.Minute*15)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, signed, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	return s.do(req, nil)
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	signed, err := s.SignURL(http.MethodGet, key, 0, time.Minute*15)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signed, nil)
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser
+	if err = s.do(req, &body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	signed, err := s.SignURL(http.MethodDelete, key, 0, time.Minute*15)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, signed, nil)
+	if err != nil {
+		return err
+	}
+	err = s.do(req, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// do 发送请求, body不为nil时返回应答的body, 由调用方关闭
+func (s *S3Storage) do(req *http.Request, body *io.ReadCloser) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		return fmt.Errorf("media: s3 %s %s: %d %s", req.Method, req.URL.Path, resp.StatusCode, msg)
+	}
+	if body == nil {
+		_ = resp.Body.Close()
+		return nil
+	}
+	*body = resp.Body
+	return nil
+}
+
+// SignURL 生成预签名的地址, 上传时content-length参与签名, 客户端必须按申请的大小上传
+func (s *S3Storage) SignURL(method, key string, size int64, expires time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	u := *s.endpoint
+	if s.opts.PathStyle {
+		u.Path = "/" + s.opts.Bucket + "/" + key
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	headers := map[string]string{"host": u.Host}
+	if method == http.MethodPut {
+		headers["content-length"] = strconv.FormatInt(size, 10)
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.opts.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapePath(u.Path),
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.opts.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQueryString 按key排序, key与value都按uri规则编码
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(path string) string {
+	return escape(path, false)
+}
+
+// escape 除了A-Z a-z 0-9 - _ . ~以外的字符都编码为%XY, encodeSlash为false时保留/
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
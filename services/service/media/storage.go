@@ -0,0 +1,38 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("media: object not found")
+	ErrInvalidKey   = errors.New("media: invalid key")
+	ErrInvalidToken = errors.New("media: invalid or expired signature")
+)
+
+// Storage 对象存储, key为对象在存储中的路径, 如app/20230301/2MjuW1w0WDbYHtaP3hfE3sTH4eN
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 对象不存在时返回ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// SignURL 返回expires之内有效的地址, method为http.MethodPut时用于上传, size为上传内容的字节数, http.MethodGet时用于下载
+	SignURL(method, key string, size int64, expires time.Duration) (string, error)
+}
+
+// ValidKey 检查key是否为相对路径, 不能跳出存储的根目录
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
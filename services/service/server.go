@@ -1,20 +1,29 @@
 package service
 
 import (
+	"EIM/health"
 	"EIM/logger"
+	"EIM/metrics"
 	"EIM/naming"
 	"EIM/naming/consul"
 	"EIM/services/service/conf"
 	"EIM/services/service/database"
 	"EIM/services/service/handler"
+	"EIM/services/service/media"
+	"EIM/tracing"
 	"EIM/wire"
 	"context"
 	"fmt"
 	"gorm.io/gorm"
 	"hash/crc32"
+	"math"
+	"net"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ServerStartOptions struct {
@@ -45,6 +54,18 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Filename: "./data/royal.log",
 	})
 
+	// 初始化链路追踪
+	shutdownTracing, err := tracing.Init(wire.SNService, tracing.Settings{
+		Exporter:    config.TraceExporter,
+		Endpoint:    config.TraceEndpoint,
+		SampleRatio: config.TraceSample,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
 	// 初始化DB
 	var (
 		baseDB    *gorm.DB
@@ -58,9 +79,48 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	if err != nil {
 		return err
 	}
+	// 记录数据库操作耗时
+	if err = database.UseMetrics(baseDB, "base"); err != nil {
+		return err
+	}
+	if err = database.UseMetrics(messageDB, "message"); err != nil {
+		return err
+	}
+	// 为数据库操作创建span
+	if err = database.UseTracing(baseDB, "base"); err != nil {
+		return err
+	}
+	if err = database.UseTracing(messageDB, "message"); err != nil {
+		return err
+	}
+	// 入群申请改为每个账号一条, 建唯一索引前只保留旧版本中最新的申请
+	if migrator := baseDB.Migrator(); migrator.HasTable(&database.GroupJoinRequest{}) &&
+		!migrator.HasIndex(&database.GroupJoinRequest{}, "uni_req_gp_acc") {
+		baseDB.Exec("DELETE FROM t_group_join_request WHERE id NOT IN " +
+			"(SELECT id FROM (SELECT MAX(id) AS id FROM t_group_join_request GROUP BY `group`, account) t)")
+	}
 	// 迁移对应模型
-	_ = baseDB.AutoMigrate(&database.Group{}, &database.GroupMember{})
-	_ = messageDB.AutoMigrate(&database.MessageIndex{}, &database.MessageContent{})
+	_ = baseDB.AutoMigrate(database.BaseModels()...)
+	// 全员禁言改为记录截止时间, 旧版本中已经全员禁言的群保持禁言
+	if migrator := baseDB.Migrator(); migrator.HasColumn(&database.Group{}, "muted") {
+		baseDB.Model(&database.Group{}).Where("muted = ?", true).Update("muted_until", math.MaxInt32)
+		_ = migrator.DropColumn(&database.Group{}, "muted")
+	}
+	// 账号改为在app内唯一, 删除旧版本的全局唯一索引
+	if migrator := baseDB.Migrator(); migrator.HasIndex(&database.User{}, "idx_t_user_account") {
+		_ = migrator.DropIndex(&database.User{}, "idx_t_user_account")
+	}
+	// 会话的版本号改为按账号递增, 从旧版本中每个账号最大的版本号继续, 客户端保存的version仍然有效
+	seedVersions := !messageDB.Migrator().HasTable(&database.AccountVersion{})
+	_ = messageDB.AutoMigrate(database.MessageModels()...)
+	if seedVersions {
+		messageDB.Exec("INSERT INTO t_account_version (account, version, updated_at) "+
+			"SELECT account, MAX(version), ? FROM t_conversation GROUP BY account", time.Now())
+	}
+	// 离线同步改为按version翻页, 删除旧版本按send_time排序的索引
+	if migrator := messageDB.Migrator(); migrator.HasIndex(&database.MessageIndex{}, "idx_offline") {
+		_ = migrator.DropIndex(&database.MessageIndex{}, "idx_offline")
+	}
 
 	// 处理NodeID为0的情况
 	if config.NodeID == 0 {
@@ -75,34 +135,35 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	if err != nil {
 		return err
 	}
+	// 就绪检查
+	health.Add("redis", func(ctx context.Context) error {
+		return redis.Ping(ctx).Err()
+	})
+	health.Add("base_db", pingDB(baseDB))
+	health.Add("message_db", pingDB(messageDB))
 	// 初始化注册中心consul
 	ns, err := consul.NewNaming(config.ConsulURL)
 	if err != nil {
 		return err
 	}
-	err = ns.Register(&naming.DefaultService{
-		Id:       config.ServiceID,
-		Name:     wire.SNService,
-		Address:  config.PublicAddress,
-		Port:     config.PublicPort,
-		Protocol: "http",
-		Tags:     config.Tags,
-		Meta: map[string]string{
-			consul.KeyHealthURL: fmt.Sprintf("http://%s:%d/health", config.PublicAddress, config.PublicPort),
-		},
-	})
+	// 媒体文件的对象存储
+	mediaStorage, err := newMediaStorage(config)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = ns.Deregister(config.ServiceID)
-	}()
 	serviceHandler := handler.ServiceHandler{
-		BaseDB:    baseDB,
-		MessageDB: messageDB,
-		Cache:     redis,
-		IDGen:     idGen,
+		BaseDB:       baseDB,
+		MessageDB:    messageDB,
+		Cache:        redis,
+		ReadIndex:    database.NewRedisReadIndex(redis),
+		IDGen:        idGen,
+		TokenKey:     config.TokenKey,
+		TokenExpires: config.TokenExpires,
+		Media:        mediaStorage,
+		MediaExpires: config.MediaExpires,
 	}
+	// 清理超过去重窗口的客户端消息id
+	go serviceHandler.PurgeMessageDedup(ctx, time.Hour)
 
 	ac := conf.MakeAccessLog()
 	defer ac.Close()
@@ -111,8 +172,73 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	app.UseRouter(ac.Handler)
 	app.UseRouter(setAllowedResponse)
 
+	// 先监听端口, 就绪后再注册到consul
+	lst, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return err
+	}
+	readyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		if err := health.WaitReady(readyCtx, time.Second); err != nil {
+			logger.Warn(err)
+			return
+		}
+		err := ns.Register(&naming.DefaultService{
+			Id:       config.ServiceID,
+			Name:     wire.SNService,
+			Address:  config.PublicAddress,
+			Port:     config.PublicPort,
+			Protocol: "http",
+			Tags:     config.Tags,
+			Meta: map[string]string{
+				consul.KeyHealthURL: fmt.Sprintf("http://%s:%d/ready", config.PublicAddress, config.PublicPort),
+			},
+		})
+		if err != nil {
+			logger.Error(err)
+		}
+	}()
+	defer func() {
+		_ = ns.Deregister(config.ServiceID)
+	}()
+
 	// Start server
-	return app.Listen(config.Listen, iris.WithOptimizations)
+	return app.Run(iris.Listener(lst), iris.WithOptimizations)
+}
+
+// newMediaStorage 根据配置创建对象存储, 本地存储没有配置密钥时返回nil, 媒体接口不可用
+func newMediaStorage(config *conf.Config) (media.Storage, error) {
+	if config.MediaStorage == "s3" {
+		return media.NewS3Storage(media.S3Options{
+			Endpoint:  config.S3Endpoint,
+			Region:    config.S3Region,
+			Bucket:    config.S3Bucket,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			PathStyle: config.S3PathStyle,
+		})
+	}
+	if config.MediaSecret == "" {
+		logger.Warn("MediaSecret is empty, media upload is disabled")
+		return nil, nil
+	}
+	baseURL := config.MediaURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://%s:%d", config.PublicAddress, config.PublicPort)
+	}
+	return media.NewLocalStorage(config.MediaDir, baseURL, config.MediaSecret)
+}
+
+// pingDB 检查数据库连接
+func pingDB(db *gorm.DB) health.Checker {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
 }
 
 func newApp(handler *handler.ServiceHandler) *iris.Application {
@@ -121,29 +247,34 @@ func newApp(handler *handler.ServiceHandler) *iris.Application {
 	app.Get("/health", func(ctx iris.Context) {
 		_, _ = ctx.WriteString("ok")
 	})
-	messageAPI := app.Party("/api/:app/message")
-	{
-		messageAPI.Post("/user", handler.InsertUserMessage)
-		messageAPI.Post("/group", handler.InsertGroupMessage)
-		messageAPI.Post("/ack", handler.MessageAck)
-	}
+	app.Get("/ready", iris.FromStd(health.Default().ReadyHandler()))
+	app.Get("/metrics", iris.FromStd(metrics.Handler()))
 
-	groupAPI := app.Party("/api/:app/group")
-	{
-		groupAPI.Get("/:id", handler.GroupGet)
-		groupAPI.Post("", handler.GroupCreate)
-		groupAPI.Post("/member", handler.GroupJoin)
-		groupAPI.Delete("/member", handler.GroupQuit)
-		groupAPI.Get("/members/:id", handler.GroupMembers)
-	}
+	app.Use(traceRequest)
+	handler.Register(app)
+	return app
+}
 
-	offlineAPI := app.Party("/api/:app/offline")
-	{
-		offlineAPI.Use(iris.Compression)
-		offlineAPI.Post("/index", handler.GetOfflineMessageIndex)
-		offlineAPI.Post("/content", handler.GetOfflineMessageContent)
+// traceRequest 从请求头中读取trace上下文并为每个请求创建一个span
+func traceRequest(c iris.Context) {
+	r := c.Request()
+	ctx := tracing.ExtractHTTP(r.Context(), r.Header)
+	ctx, span := tracing.Start(ctx, c.Method()+" "+c.GetCurrentRoute().Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("app", c.Params().Get("app"))))
+	c.ResetRequest(r.WithContext(ctx))
+	c.Next()
+
+	status := c.GetStatusCode()
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	var err error
+	if status >= iris.StatusInternalServerError {
+		err = c.GetErr()
+		if err == nil {
+			err = fmt.Errorf("http status %d", status)
+		}
 	}
-	return app
+	tracing.EndSpan(span, err)
 }
 
 func setAllowedResponse(ctx iris.Context) {
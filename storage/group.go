@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ChannelGroupChanged 群成员或群信息变更时发布事件的频道, 消息内容为群id
+const ChannelGroupChanged = "group:changed"
+
+// groupVersionTTL 版本号的过期时间, 过期后从0开始, 不影响判断
+const groupVersionTTL = time.Hour * 24
+
+// KeyGroupMembers 生成群成员缓存的key
+func KeyGroupMembers(group string) string {
+	return fmt.Sprintf("group:mb:%s", group)
+}
+
+// KeyGroupDetail 生成群信息缓存的key
+func KeyGroupDetail(group string) string {
+	return fmt.Sprintf("group:dt:%s", group)
+}
+
+// KeyGroupVersion 生成群缓存版本号的key, 每次变更加1
+func KeyGroupVersion(group string) string {
+	return fmt.Sprintf("group:ver:%s", group)
+}
+
+// setGroupCache 版本号没有变化时才写入缓存, 避免变更之前读到的数据写回redis
+var setGroupCache = redis.NewScript(`
+local ver = redis.call('GET', KEYS[1]) or '0'
+if ver ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// GroupVersion 返回群缓存的版本号, 在读取源数据之前调用
+func GroupVersion(ctx context.Context, cli *redis.Client, group string) (int64, error) {
+	ver, err := cli.Get(ctx, KeyGroupVersion(group)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return ver, err
+}
+
+// SetGroupCache 群缓存的版本号仍为version时写入key, 返回是否写入
+func SetGroupCache(ctx context.Context, cli *redis.Client, group, key string, version int64, value []byte, ttl time.Duration) (bool, error) {
+	n, err := setGroupCache.Run(ctx, cli, []string{KeyGroupVersion(group), key},
+		version, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// PublishGroupChanged 增加群缓存的版本号并删除redis中的缓存, 然后通知各服务清除本地缓存
+func PublishGroupChanged(ctx context.Context, cli *redis.Client, group string) error {
+	_, err := cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, KeyGroupVersion(group))
+		pipe.Expire(ctx, KeyGroupVersion(group), groupVersionTTL)
+		pipe.Del(ctx, KeyGroupMembers(group), KeyGroupDetail(group))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return cli.Publish(ctx, ChannelGroupChanged, group).Err()
+}
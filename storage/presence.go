@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"EIM/wire/pkt"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultPresenceTTL 在线状态的过期时间, 网关上报会话的间隔需要小于它
+const DefaultPresenceTTL = time.Second * 90
+
+// KeyPresenceGateways 记录网关最后一次上报时间的zset
+const KeyPresenceGateways = "presence:gateways"
+
+// PresenceState redis中保存的在线状态, 记录所在的网关与channel, 登出与刷新时只处理同一个channel
+type PresenceState struct {
+	Status    int32  `json:"status"`
+	Text      string `json:"text,omitempty"`
+	GateId    string `json:"gate"`
+	ChannelId string `json:"channel"`
+	UpdatedAt int64  `json:"updated"`
+}
+
+// PresenceStorage 在线状态存储, 每个账号一个带过期时间的key, 每个网关一个账号集合
+type PresenceStorage struct {
+	cli *redis.Client
+	ttl time.Duration
+}
+
+func NewPresenceStorage(cli *redis.Client, ttl time.Duration) *PresenceStorage {
+	if ttl <= 0 {
+		ttl = DefaultPresenceTTL
+	}
+	return &PresenceStorage{
+		cli: cli,
+		ttl: ttl,
+	}
+}
+
+// Set 保存在线状态, 账号换了网关时从原网关的集合中移除
+func (s *PresenceStorage) Set(ctx context.Context, account string, state *PresenceState) error {
+	prev, err := s.get(ctx, s.cli, account)
+	if err != nil {
+		return err
+	}
+	buf, _ := json.Marshal(state)
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, KeyPresence(account), buf, s.ttl)
+		pipe.SAdd(ctx, KeyPresenceGateway(state.GateId), account)
+		pipe.ZAdd(ctx, KeyPresenceGateways, &redis.Z{Score: float64(time.Now().Unix()), Member: state.GateId})
+		if prev != nil && prev.GateId != state.GateId {
+			pipe.SRem(ctx, KeyPresenceGateway(prev.GateId), account)
+		}
+		return nil
+	})
+	return err
+}
+
+// Get 批量获取在线状态, 不在线的账号不在结果中
+func (s *PresenceStorage) Get(ctx context.Context, accounts ...string) (map[string]*PresenceState, error) {
+	states := make(map[string]*PresenceState)
+	if len(accounts) == 0 {
+		return states, nil
+	}
+	keys := make([]string, len(accounts))
+	for i, account := range accounts {
+		keys[i] = KeyPresence(account)
+	}
+	values, err := s.cli.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var state PresenceState
+		if err = json.Unmarshal([]byte(str), &state); err != nil {
+			continue
+		}
+		states[accounts[i]] = &state
+	}
+	return states, nil
+}
+
+// Remove 删除channelId对应的在线状态, 账号已在其它channel登录时不删除, 返回是否删除
+func (s *PresenceStorage) Remove(ctx context.Context, account, channelId string) (bool, error) {
+	key := KeyPresence(account)
+	removed := false
+	err := s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		state, err := s.get(ctx, tx, account)
+		if err != nil || state == nil || state.ChannelId != channelId {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, KeyPresenceGateway(state.GateId), account)
+			return nil
+		})
+		removed = err == nil
+		return err
+	}, key)
+	return removed, err
+}
+
+// Refresh 刷新网关上报的会话的过期时间, 只刷新channel一致的账号
+func (s *PresenceStorage) Refresh(ctx context.Context, gateway string, sessions []*pkt.Session) error {
+	err := s.cli.ZAdd(ctx, KeyPresenceGateways, &redis.Z{Score: float64(time.Now().Unix()), Member: gateway}).Err()
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+	accounts := make([]string, len(sessions))
+	for i, session := range sessions {
+		accounts[i] = session.GetAccount()
+	}
+	states, err := s.Get(ctx, accounts...)
+	if err != nil {
+		return err
+	}
+	_, err = s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, session := range sessions {
+			state, ok := states[session.GetAccount()]
+			if ok && state.ChannelId == session.GetChannelId() {
+				pipe.Expire(ctx, KeyPresence(session.GetAccount()), s.ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Gateways 返回有在线状态记录的网关
+func (s *PresenceStorage) Gateways(ctx context.Context) ([]string, error) {
+	return s.cli.ZRange(ctx, KeyPresenceGateways, 0, -1).Result()
+}
+
+// Sweep 清理网关集合中已过期的账号, 返回由本次调用移出集合的账号, 多个服务同时清理时每个账号只会返回一次
+func (s *PresenceStorage) Sweep(ctx context.Context, gateway string) ([]string, error) {
+	members, err := s.cli.SMembers(ctx, KeyPresenceGateway(gateway)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		// 长时间没有上报且没有会话的网关不再检查
+		score, err := s.cli.ZScore(ctx, KeyPresenceGateways, gateway).Result()
+		if err == nil && int64(score) < time.Now().Add(-s.ttl).Unix() {
+			err = s.cli.ZRem(ctx, KeyPresenceGateways, gateway).Err()
+		}
+		if err == redis.Nil {
+			err = nil
+		}
+		return nil, err
+	}
+	states, err := s.Get(ctx, members...)
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for _, account := range members {
+		state, ok := states[account]
+		if ok && state.GateId == gateway {
+			continue
+		}
+		n, err := s.cli.SRem(ctx, KeyPresenceGateway(gateway), account).Result()
+		if err != nil {
+			return expired, err
+		}
+		// 已经在其它网关登录的账号只从集合中移除
+		if !ok && n > 0 {
+			expired = append(expired, account)
+		}
+	}
+	return expired, nil
+}
+
+func (s *PresenceStorage) get(ctx context.Context, cmd redis.Cmdable, account string) (*PresenceState, error) {
+	bs, err := cmd.Get(ctx, KeyPresence(account)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state PresenceState
+	if err = json.Unmarshal(bs, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// KeyPresence 生成在线状态的key
+func KeyPresence(account string) string {
+	return fmt.Sprintf("presence:st:%s", account)
+}
+
+// KeyPresenceGateway 生成网关上账号集合的key
+func KeyPresenceGateway(gateway string) string {
+	return fmt.Sprintf("presence:gw:%s", gateway)
+}
@@ -38,8 +38,8 @@ func (r *RedisStorage) Add(session *pkt.Session) error {
 	if err != nil {
 		return err
 	}
-	// 保存Session
-	snKey := KeySession(session.Account)
+	// 保存Session, 以channelId为key, 与Get保持一致
+	snKey := KeySession(session.ChannelId)
 	buf, _ := proto.Marshal(session)
 	err = r.cli.Set(ctx, snKey, buf, LocationExpired).Err()
 	if err != nil {
@@ -58,7 +58,7 @@ func (r *RedisStorage) Delete(account string, channelId string) error {
 		return err
 	}
 	// 删除Session
-	snKey := KeySession(account)
+	snKey := KeySession(channelId)
 	err = r.cli.Del(ctx, snKey).Err()
 	if err != nil {
 		return err
@@ -77,9 +77,11 @@ func (r *RedisStorage) Get(channelId string) (*pkt.Session, error) {
 		}
 		return nil, err
 	}
-	var session *pkt.Session
-	_ = proto.Unmarshal(bs, session)
-	return session, nil
+	var session pkt.Session
+	if err = proto.Unmarshal(bs, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
 }
 
 // GetLocations 获取多个用户的位置
@@ -110,9 +112,11 @@ func (r *RedisStorage) GetLocation(account string, device string) (*EIM.Location
 		}
 		return nil, err
 	}
-	var loc *EIM.Location
-	_ = loc.Unmarshal(bs)
-	return loc, nil
+	var loc EIM.Location
+	if err = loc.Unmarshal(bs); err != nil {
+		return nil, err
+	}
+	return &loc, nil
 }
 
 // KeySession 根据channel生成session的key
@@ -6,7 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -70,8 +70,8 @@ func (c *Client) GetMeta() map[string]string {
 
 // Connect 客户端核心逻辑部分, 将客户端连接到对应服务端
 func (c *Client) Connect(addr string) error {
-	// 解析地址
-	_, err := url.Parse(addr)
+	// 解析地址, tcp地址为host:port的形式, 不能用url.Parse解析ip地址
+	_, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return err
 	}
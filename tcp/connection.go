@@ -2,7 +2,9 @@ package tcp
 
 import (
 	"EIM"
+	"EIM/wire"
 	"EIM/wire/endian"
+	"errors"
 	"io"
 	"net"
 )
@@ -36,23 +38,36 @@ func (f *Frame) GetPayload() []byte {
 // TcpConn Tcp连接, 二次包装net.Conn并实现EIM.Conn
 type TcpConn struct {
 	net.Conn
+	maxFrameSize uint32
 }
 
 // NewConn 创建一个新的TcpConn
 func NewConn(conn net.Conn) *TcpConn {
 	return &TcpConn{
-		Conn: conn,
+		Conn:         conn,
+		maxFrameSize: wire.DefaultMaxFrameSize,
 	}
 }
 
+// SetMaxFrameSize 设置单帧payload的最大长度
+func (c *TcpConn) SetMaxFrameSize(size uint32) {
+	if size == 0 {
+		return
+	}
+	c.maxFrameSize = size
+}
+
 // ReadFrame 从c.Conn中读取一帧
 func (c *TcpConn) ReadFrame() (EIM.Frame, error) {
 	opcode, err := endian.ReadUint8(c.Conn)
 	if err != nil {
 		return nil, err
 	}
-	payload, err := endian.ReadBytes(c.Conn)
+	payload, err := endian.ReadBytesMax(c.Conn, c.maxFrameSize)
 	if err != nil {
+		if errors.Is(err, endian.ErrTooLarge) {
+			return nil, EIM.ErrFrameTooLarge
+		}
 		return nil, err
 	}
 	return &Frame{
@@ -0,0 +1,23 @@
+package tcp
+
+import (
+	"EIM"
+	"net"
+	"testing"
+)
+
+func TestReadFrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server)
+	conn.SetMaxFrameSize(8)
+	go func() {
+		_ = WriteFrame(client, EIM.OpBinary, []byte("0123456789"))
+	}()
+	_, err := conn.ReadFrame()
+	if err != EIM.ErrFrameTooLarge {
+		t.Fatalf("ReadFrame() error = %v, want %v", err, EIM.ErrFrameTooLarge)
+	}
+}
@@ -3,11 +3,14 @@ package tcp
 import (
 	"EIM"
 	"EIM/logger"
+	"EIM/metrics"
+	"EIM/wire"
 	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/ksuid"
@@ -18,6 +21,7 @@ type ServerOptions struct {
 	loginwait time.Duration // 登录超时
 	readwait  time.Duration // 读超时
 	writewait time.Duration // 写超时
+	maxframe  uint32        // 单帧payload的最大长度
 }
 
 // Server tcp的Server实现
@@ -28,9 +32,10 @@ type Server struct {
 	EIM.MessageListener
 	EIM.StateListener
 	EIM.ChannelMap
-	once    sync.Once
-	options ServerOptions
-	quit    *EIM.Event
+	once      sync.Once
+	options   ServerOptions
+	quit      *EIM.Event
+	listening int32
 }
 
 // NewServer 创建一个新服务端
@@ -44,6 +49,7 @@ func NewServer(listen string, service EIM.ServiceRegistration) EIM.Server {
 			loginwait: EIM.DefaultLoginWait,
 			readwait:  EIM.DefaultReadWait,
 			writewait: EIM.DefaultWriteWait,
+			maxframe:  wire.DefaultMaxFrameSize,
 		},
 	}
 }
@@ -68,6 +74,14 @@ func (s *Server) SetReadWait(readwait time.Duration) {
 	s.options.readwait = readwait
 }
 
+// SetMaxFrameSize 设置单帧payload的最大长度
+func (s *Server) SetMaxFrameSize(size uint32) {
+	if size == 0 {
+		return
+	}
+	s.options.maxframe = size
+}
+
 // SetChannelMap 设置连接管理表
 func (s *Server) SetChannelMap(channelMap EIM.ChannelMap) {
 	s.ChannelMap = channelMap
@@ -92,6 +106,9 @@ func (s *Server) Start() error {
 	if err != nil {
 		return err
 	}
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+	channelTotal := metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName())
 	log.Info("started")
 	for {
 		// 2. 接收新的连接
@@ -103,8 +120,11 @@ func (s *Server) Start() error {
 		}
 		go func(rawconn net.Conn) {
 			conn := NewConn(rawconn)
+			conn.SetMaxFrameSize(s.options.maxframe)
 			// 3. 交给上层处理认证等逻辑
+			start := time.Now()
 			id, err := s.Accept(conn, s.options.loginwait)
+			metrics.ObserveAccept(s.ServiceName(), start, err)
 			if err != nil {
 				_ = conn.WriteFrame(EIM.OpClose, []byte(err.Error()))
 				conn.Close()
@@ -121,6 +141,7 @@ func (s *Server) Start() error {
 			channel.SetWriteWait(s.options.writewait)
 			channel.SetReadWait(s.options.readwait)
 			s.Add(channel)
+			channelTotal.Inc()
 			log.Info("accept ", channel)
 			// 5. 循环读取消息
 			err = channel.Readloop(s.MessageListener)
@@ -129,6 +150,7 @@ func (s *Server) Start() error {
 			}
 			// 6. 如果Readloop方法返回了一个error, 说明连接已经断开, Server就需要把它从channelMap中删除
 			s.Remove(channel.ID())
+			channelTotal.Dec()
 			// 7. 调用 (kim.StateListener).Disconnect(string) 把断开事件回调给业务层
 			_ = s.Disconnect(channel.ID())
 			channel.Close()
@@ -136,6 +158,11 @@ func (s *Server) Start() error {
 	}
 }
 
+// Listening 是否已经开始监听端口
+func (s *Server) Listening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
+}
+
 // Push 参数含义: id: channelId, data: 数据
 func (s *Server) Push(id string, data []byte) error {
 	ch, ok := s.ChannelMap.Get(id)
@@ -0,0 +1,89 @@
+package EIM
+
+import "sync"
+
+// Topics 网关上channel对主题(如群)的订阅关系, 用于在网关本地扇出群消息
+type Topics interface {
+	Subscribe(topic string, channelIds ...string)
+	Unsubscribe(topic string, channelIds ...string)
+	RemoveChannel(channelId string) // channel断开时取消它的所有订阅
+	Channels(topic string) []string
+}
+
+// TopicsImpl Topics的实现
+type TopicsImpl struct {
+	sync.RWMutex
+	topics   map[string]map[string]struct{} // topic -> channelIds
+	channels map[string]map[string]struct{} // channelId -> topics
+}
+
+// NewTopics 创建TopicsImpl
+func NewTopics() *TopicsImpl {
+	return &TopicsImpl{
+		topics:   make(map[string]map[string]struct{}),
+		channels: make(map[string]map[string]struct{}),
+	}
+}
+
+// Subscribe 将channels订阅到topic
+func (t *TopicsImpl) Subscribe(topic string, channelIds ...string) {
+	t.Lock()
+	defer t.Unlock()
+	subs, ok := t.topics[topic]
+	if !ok {
+		subs = make(map[string]struct{}, len(channelIds))
+		t.topics[topic] = subs
+	}
+	for _, id := range channelIds {
+		subs[id] = struct{}{}
+		if _, ok := t.channels[id]; !ok {
+			t.channels[id] = make(map[string]struct{})
+		}
+		t.channels[id][topic] = struct{}{}
+	}
+}
+
+// Unsubscribe 取消channels对topic的订阅
+func (t *TopicsImpl) Unsubscribe(topic string, channelIds ...string) {
+	t.Lock()
+	defer t.Unlock()
+	for _, id := range channelIds {
+		t.remove(topic, id)
+	}
+}
+
+// RemoveChannel 取消channel的所有订阅
+func (t *TopicsImpl) RemoveChannel(channelId string) {
+	t.Lock()
+	defer t.Unlock()
+	for topic := range t.channels[channelId] {
+		t.remove(topic, channelId)
+	}
+}
+
+func (t *TopicsImpl) remove(topic, channelId string) {
+	if subs, ok := t.topics[topic]; ok {
+		delete(subs, channelId)
+		if len(subs) == 0 {
+			delete(t.topics, topic)
+		}
+	}
+	if topics, ok := t.channels[channelId]; ok {
+		delete(topics, topic)
+		if len(topics) == 0 {
+			delete(t.channels, channelId)
+		}
+	}
+}
+
+// Channels 返回订阅了topic的channel
+func (t *TopicsImpl) Channels(topic string) []string {
+	t.RLock()
+	defer t.RUnlock()
+	subs := t.topics[topic]
+	ids := make([]string, 0, len(subs))
+	for id := range subs {
+		ids = append(ids, id)
+	}
+	return ids
+}
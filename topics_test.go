@@ -0,0 +1,31 @@
+package EIM
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTopics(t *testing.T) {
+	topics := NewTopics()
+	topics.Subscribe("g1", "c1", "c2")
+	topics.Subscribe("g2", "c1")
+
+	ids := topics.Channels("g1")
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "c1" || ids[1] != "c2" {
+		t.Fatalf("Channels(g1) = %v", ids)
+	}
+
+	topics.Unsubscribe("g1", "c2")
+	if ids = topics.Channels("g1"); len(ids) != 1 || ids[0] != "c1" {
+		t.Fatalf("Channels(g1) = %v after unsubscribe", ids)
+	}
+
+	topics.RemoveChannel("c1")
+	if len(topics.Channels("g1")) != 0 || len(topics.Channels("g2")) != 0 {
+		t.Fatal("channel should be removed from all topics")
+	}
+	if len(topics.topics) != 0 || len(topics.channels) != 0 {
+		t.Fatalf("empty entries are not cleaned, %v %v", topics.topics, topics.channels)
+	}
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// transport 为每次http请求创建一个client span, 并把trace上下文写入请求头
+type transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport 包装base, base为nil时使用http.DefaultTransport
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+		))
+	req = req.Clone(ctx)
+	InjectHTTP(ctx, req.Header)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		EndSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		EndSpan(span, fmt.Errorf("http status %s", resp.Status))
+		return resp, nil
+	}
+	span.End()
+	return resp, nil
+}
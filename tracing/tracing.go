@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"EIM/wire/pkt"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 导出方式
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+const instrumentationName = "EIM"
+
+// Settings 链路追踪配置
+type Settings struct {
+	Exporter    string  // otlp, stdout, 为空时只生成trace id不导出
+	Endpoint    string  // OTLP/HTTP collector地址, 如http://localhost:4318
+	SampleRatio float64 // 采样率, 不在(0,1)之间时全部采样
+}
+
+// Init 初始化全局的TracerProvider与传播器, 返回的函数用于退出时刷新剩余的span
+func Init(serviceName string, settings Settings) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	sampler := sdktrace.AlwaysSample()
+	if settings.SampleRatio > 0 && settings.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(settings.SampleRatio)
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	}
+	switch settings.Exporter {
+	case ExporterNone:
+	case ExporterOTLP:
+		if settings.Endpoint == "" {
+			return nil, fmt.Errorf("endpoint of otlp exporter is required")
+		}
+		exporter, err := newOTLPExporter(settings.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", settings.Exporter)
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// newOTLPExporter 创建OTLP/HTTP exporter, endpoint为collector地址, 如http://localhost:4318
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %s", endpoint)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme != "https" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// Start 创建一个span
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// EndSpan 结束span, err不为nil时记录错误
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID 返回ctx中的trace id, 没有时返回空字符串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// packetCarrier 把trace上下文保存在LogicPkt的Meta中
+type packetCarrier struct {
+	p *pkt.LogicPkt
+}
+
+func (c packetCarrier) Get(key string) string {
+	v, ok := c.p.GetMeta(key)
+	if !ok {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func (c packetCarrier) Set(key string, value string) {
+	c.p.DelMeta(key)
+	c.p.AddStringMeta(key, value)
+}
+
+func (c packetCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.p.Meta))
+	for _, m := range c.p.Meta {
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
+
+// Inject 将ctx中的trace上下文写入消息包的Meta
+func Inject(ctx context.Context, p *pkt.LogicPkt) {
+	otel.GetTextMapPropagator().Inject(ctx, packetCarrier{p})
+}
+
+// Extract 从消息包的Meta中读取trace上下文
+func Extract(ctx context.Context, p *pkt.LogicPkt) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, packetCarrier{p})
+}
+
+// Strip 删除消息包中的trace上下文, 用于推送给客户端之前
+func Strip(p *pkt.LogicPkt) {
+	for _, key := range otel.GetTextMapPropagator().Fields() {
+		p.DelMeta(key)
+	}
+}
+
+// InjectHTTP 将ctx中的trace上下文写入http header
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHTTP 从http header中读取trace上下文
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"EIM/wire"
+	"EIM/wire/pkt"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPacketPropagation(t *testing.T) {
+	shutdown, err := Init("test", Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = shutdown(context.Background()) }()
+
+	ctx, span := Start(context.Background(), "gateway")
+	defer span.End()
+
+	p := pkt.New(wire.CommandChatUserTalk)
+	Inject(ctx, p)
+	// 经过编解码后trace上下文仍然存在
+	p2, err := pkt.MustReadLogicPkt(bytes.NewBuffer(pkt.Marshal(p)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Extract(context.Background(), p2)
+	if TraceID(got) != TraceID(ctx) {
+		t.Fatalf("trace id = %q, want %q", TraceID(got), TraceID(ctx))
+	}
+
+	Strip(p2)
+	if TraceID(Extract(context.Background(), p2)) != "" {
+		t.Fatal("trace context should be stripped")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var req coltracepb.ExportTraceServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+
+	shutdown, err := Init("test", Settings{Exporter: ExporterOTLP, Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	child.End()
+	parent.End()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err = shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", &req)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("len(spans) = %d, want 2", len(spans))
+	}
+	if spans[0].Name != "child" || !bytes.Equal(spans[0].ParentSpanId, spans[1].SpanId) || !bytes.Equal(spans[0].TraceId, spans[1].TraceId) {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+}
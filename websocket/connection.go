@@ -2,6 +2,8 @@ package websocket
 
 import (
 	"EIM"
+	"EIM/wire"
+	"io"
 	"net"
 
 	"github.com/gobwas/ws"
@@ -39,21 +41,41 @@ func (f *Frame) GetPayload() []byte {
 // WsConn 对net.Conn二次包装并实现了EIM.Conn
 type WsConn struct {
 	net.Conn
+	maxFrameSize uint32
 }
 
 // NewConn 创建一个新WsConn
 func NewConn(conn net.Conn) *WsConn {
 	return &WsConn{
-		Conn: conn,
+		Conn:         conn,
+		maxFrameSize: wire.DefaultMaxFrameSize,
 	}
 }
 
-// ReadFrame 从连接中读取一帧
+// SetMaxFrameSize 设置单帧payload的最大长度
+func (c *WsConn) SetMaxFrameSize(size uint32) {
+	if size == 0 {
+		return
+	}
+	c.maxFrameSize = size
+}
+
+// ReadFrame 从连接中读取一帧, 先读取帧头并检查长度, 再按长度读取payload
 func (c *WsConn) ReadFrame() (EIM.Frame, error) {
-	f, err := ws.ReadFrame(c.Conn)
+	h, err := ws.ReadHeader(c.Conn)
 	if err != nil {
 		return nil, err
 	}
+	if h.Length > int64(c.maxFrameSize) {
+		return nil, EIM.ErrFrameTooLarge
+	}
+	f := ws.Frame{Header: h}
+	if h.Length > 0 {
+		f.Payload = make([]byte, h.Length)
+		if _, err = io.ReadFull(c.Conn, f.Payload); err != nil {
+			return nil, err
+		}
+	}
 	return &Frame{raw: f}, nil
 }
 
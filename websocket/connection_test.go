@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"EIM"
+	"net"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestReadFrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server)
+	conn.SetMaxFrameSize(8)
+	go func() {
+		_ = wsutil.WriteClientMessage(client, ws.OpBinary, []byte("0123456789"))
+	}()
+	_, err := conn.ReadFrame()
+	if err != EIM.ErrFrameTooLarge {
+		t.Fatalf("ReadFrame() error = %v, want %v", err, EIM.ErrFrameTooLarge)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server)
+	go func() {
+		_ = wsutil.WriteClientMessage(client, ws.OpBinary, []byte("hello"))
+	}()
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.GetPayload()) != "hello" {
+		t.Fatalf("GetPayload() = %s, want hello", frame.GetPayload())
+	}
+}
+
+func TestCloseAgent(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ch := EIM.NewChannel("test", NewConn(server))
+	go func() {
+		_ = EIM.CloseAgent(ch, EIM.CloseProtocolError, "bad packet")
+	}()
+	frame, err := ws.ReadFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Header.OpCode != ws.OpClose {
+		t.Fatalf("OpCode = %v, want OpClose", frame.Header.OpCode)
+	}
+	code, reason := ws.ParseCloseFrameData(frame.Payload)
+	if uint16(code) != EIM.CloseProtocolError || reason != "bad packet" {
+		t.Fatalf("close frame = %d %s", code, reason)
+	}
+	// 发送关闭帧后连接被关闭
+	if _, err = ws.ReadFrame(client); err == nil {
+		t.Fatal("connection should be closed after the close frame")
+	}
+}
@@ -3,11 +3,15 @@ package websocket
 import (
 	"EIM"
 	"EIM/logger"
+	"EIM/metrics"
+	"EIM/wire"
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gobwas/ws"
@@ -19,6 +23,7 @@ type ServerOptions struct {
 	loginwait time.Duration // 登录超时
 	readwait  time.Duration // 读超时
 	writewait time.Duration // 写超时
+	maxframe  uint32        // 单帧payload的最大长度
 }
 
 // Server websocket的Server实现
@@ -29,8 +34,9 @@ type Server struct {
 	EIM.StateListener
 	EIM.Acceptor
 	EIM.ChannelMap
-	once    sync.Once
-	options ServerOptions
+	once      sync.Once
+	options   ServerOptions
+	listening int32
 }
 
 // NewServer 创建一个新Server
@@ -42,6 +48,7 @@ func NewServer(listen string, service EIM.ServiceRegistration) EIM.Server {
 			loginwait: EIM.DefaultLoginWait,
 			readwait:  EIM.DefaultReadWait,
 			writewait: EIM.DefaultWriteWait,
+			maxframe:  wire.DefaultMaxFrameSize,
 		},
 	}
 }
@@ -73,6 +80,7 @@ func (s *Server) Start() error {
 		s.ChannelMap = EIM.NewChannels(100)
 	}
 
+	channelTotal := metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName())
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// 1. 升级连接
 		rawconn, _, _, err := ws.UpgradeHTTP(r, w)
@@ -82,8 +90,11 @@ func (s *Server) Start() error {
 		}
 		// 2. 包装conn
 		conn := NewConn(rawconn)
+		conn.SetMaxFrameSize(s.options.maxframe)
 		// 3. 回调到上层业务完成权限认证之类的逻辑处理
+		start := time.Now()
 		id, err := s.Accept(conn, s.options.loginwait)
+		metrics.ObserveAccept(s.ServiceName(), start, err)
 		if err != nil {
 			_ = conn.WriteFrame(EIM.OpClose, []byte(err.Error()))
 			conn.Close()
@@ -100,6 +111,7 @@ func (s *Server) Start() error {
 		channel.SetWriteWait(s.options.writewait)
 		channel.SetReadWait(s.options.readwait)
 		s.Add(channel)
+		channelTotal.Inc()
 
 		// 5. 开启一个goroutine中循环读取消息
 		go func(ch EIM.Channel) {
@@ -109,6 +121,7 @@ func (s *Server) Start() error {
 			}
 			// 6. 退出
 			s.Remove(ch.ID())
+			channelTotal.Dec()
 			err = s.Disconnect(ch.ID())
 			if err != nil {
 				log.Warn(err)
@@ -119,8 +132,19 @@ func (s *Server) Start() error {
 			}
 		}(channel)
 	})
+	lst, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
 	log.Infoln("started")
-	return http.ListenAndServe(s.listen, mux)
+	return http.Serve(lst, mux)
+}
+
+// Listening 是否已经开始监听端口
+func (s *Server) Listening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
 }
 
 // Push 推送一个消息到channel
@@ -178,6 +202,14 @@ func (s *Server) SetReadWait(readwait time.Duration) {
 	s.options.readwait = readwait
 }
 
+// SetMaxFrameSize 设置单帧payload的最大长度
+func (s *Server) SetMaxFrameSize(size uint32) {
+	if size == 0 {
+		return
+	}
+	s.options.maxframe = size
+}
+
 // SetChannelMap 设置连接管理表
 func (s *Server) SetChannelMap(channelMap EIM.ChannelMap) {
 	s.ChannelMap = channelMap
@@ -1,6 +1,9 @@
 package wire
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Magic [4]byte
 
@@ -22,6 +25,12 @@ const (
 	MetaDestServer = "dest.server"
 	// MetaDestChannels 表示Meta中的value为消息将要送达的channels(消息接收方)
 	MetaDestChannels = "dest.channels"
+	// DestAllChannels 作为MetaDestChannels的值时, 表示推送给网关上的所有channel
+	DestAllChannels = "*"
+	// MetaDestTopic 表示消息推送给网关上订阅了该主题的channel, 与MetaDestChannels二选一
+	MetaDestTopic = "dest.topic"
+	// MetaExcludeChannel 按主题推送时排除的channel, 通常是消息的发送方
+	MetaExcludeChannel = "dest.exclude"
 )
 
 // Service Name 统一的服务名称
@@ -37,34 +46,162 @@ type ServiceID string
 
 type SessionID string
 
+// 群成员的角色
+const (
+	GroupRoleMember = 0
+	GroupRoleAdmin  = 1
+	GroupRoleOwner  = 2
+)
+
+// 入群方式
+const (
+	GroupJoinOpen     = 0 // 任何人可加入
+	GroupJoinApproval = 1 // 需要管理员审批
+	GroupJoinInvite   = 2 // 只能通过邀请加入
+
+	GroupInvitationExpiresIn = time.Hour * 24 * 7 // 邀请的默认有效期
+)
+
+// 在线状态
+const (
+	PresenceOffline = 0
+	PresenceOnline  = 1
+	PresenceAway    = 2 // 离开
+	PresenceBusy    = 3 // 忙碌
+)
+
 // Command的类型
 const (
 	// login
 	CommandLoginSignIn  = "login.signin"
 	CommandLoginSignOut = "login.signout"
+	// CommandLoginKeepalive 网关定时上报本地的会话, 用于刷新在线状态, 仅在服务之间使用
+	CommandLoginKeepalive = "login.keepalive"
 
 	// chat
 	CommandChatUserTalk  = "chat.user.talk"
 	CommandChatGroupTalk = "chat.group.talk"
 	CommandChatTalkAck   = "chat.talk.ack"
+	CommandChatHistory   = "chat.history"
+
+	// 瞬时消息, 只推送给在线的设备
+	CommandTransientUser  = "chat.transient.user"
+	CommandTransientGroup = "chat.transient.group"
+
+	// 会话
+	CommandConversationList   = "chat.conversation.list"
+	CommandConversationUpdate = "chat.conversation.update"
 
 	// 离线
 	CommandOfflineIndex   = "chat.offline.index"
 	CommandOfflineContent = "chat.offline.content"
 
+	// 媒体文件
+	CommandMediaToken    = "chat.media.token"
+	CommandMediaComplete = "chat.media.complete"
+	CommandMediaUrl      = "chat.media.url"
+
 	// 群管理
-	CommandGroupCreate  = "chat.group.create"
-	CommandGroupJoin    = "chat.group.join"
-	CommandGroupQuit    = "chat.group.quit"
-	CommandGroupMembers = "chat.group.members"
-	CommandGroupDetail  = "chat.group.detail"
+	CommandGroupCreate   = "chat.group.create"
+	CommandGroupJoin     = "chat.group.join"
+	CommandGroupQuit     = "chat.group.quit"
+	CommandGroupMembers  = "chat.group.members"
+	CommandGroupDetail   = "chat.group.detail"
+	CommandGroupKick     = "chat.group.kick"
+	CommandGroupTransfer = "chat.group.transfer"
+	CommandGroupSetAdmin = "chat.group.admin"
+	CommandGroupMute     = "chat.group.mute"
+	CommandGroupUpdate   = "chat.group.update"
+	CommandGroupDissolve = "chat.group.dissolve"
+	CommandGroupInvite   = "chat.group.invite"
+	CommandGroupApprove  = "chat.group.approve"
+	CommandGroupRequests = "chat.group.requests"
+
+	// 用户资料
+	CommandUserProfiles = "chat.user.profiles"
+	CommandUserUpdate   = "chat.user.update"
+
+	// 好友
+	CommandFriendAdd       = "chat.friend.add"
+	CommandFriendApprove   = "chat.friend.approve"
+	CommandFriendRequests  = "chat.friend.requests"
+	CommandFriendList      = "chat.friend.list"
+	CommandFriendRemark    = "chat.friend.remark"
+	CommandFriendDelete    = "chat.friend.delete"
+	CommandFriendBlock     = "chat.friend.block"
+	CommandFriendBlocklist = "chat.friend.blocklist"
+
+	// 在线状态
+	CommandPresenceQuery       = "chat.presence.query"
+	CommandPresenceSubscribe   = "chat.presence.subscribe"
+	CommandPresenceUnsubscribe = "chat.presence.unsubscribe"
+	CommandPresenceSet         = "chat.presence.set"
+	CommandPresenceNotify      = "chat.presence.notify" // 在线状态变化的推送
+
+	// 系统通知
+	CommandSystemNotice = "system.notice"
+
+	// 网关上的主题订阅, 仅在服务之间使用
+	CommandTopicSubscribe   = "gateway.topic.subscribe"
+	CommandTopicUnsubscribe = "gateway.topic.unsubscribe"
 )
 
+// InternalCommand 返回command是否只在服务之间使用, 登录由网关在握手时转发, login与gateway开头的指令都不接受客户端发送
+func InternalCommand(command string) bool {
+	return strings.HasPrefix(command, "login.") || strings.HasPrefix(command, "gateway.")
+}
+
 const (
 	MessageTypeText  = 1
 	MessageTypeImage = 2
 	MessageTypeVoice = 3
 	MessageTypeVideo = 4
+	// MessageTypeNotify 系统通知, Extra为产生通知的指令, Body为通知内容的json, 如入群申请与审批结果
+	MessageTypeNotify = 10
+)
+
+// 媒体文件的限制
+const (
+	MediaImageMaxSize  = 20 << 20 // 图片的最大字节数
+	MediaVoiceMaxSize  = 10 << 20 // 语音的最大字节数
+	MediaVideoMaxSize  = 200 << 20
+	MediaThumbnailSize = 240      // 缩略图的最大边长
+	MediaMaxPixels     = 40000000 // 超过这个像素数的图片不生成缩略图
+	MediaUrlMaxCount   = 100      // 一次获取下载地址的最大数量
+)
+
+// MediaMaxSize 返回媒体消息类型允许上传的最大字节数, 不是媒体消息时返回0
+func MediaMaxSize(typ int32) int64 {
+	switch typ {
+	case MessageTypeImage:
+		return MediaImageMaxSize
+	case MessageTypeVoice:
+		return MediaVoiceMaxSize
+	case MessageTypeVideo:
+		return MediaVideoMaxSize
+	}
+	return 0
+}
+
+// 会话的类型
+const (
+	ConversationUser  = 1
+	ConversationGroup = 2
+
+	ConversationPageSize = 100 // 同步会话时每页的最大数量
+)
+
+// 瞬时消息的类型, 其它值由业务自定义
+const (
+	TransientTyping = 1 // 正在输入
+	TransientSignal = 2 // 通话信令
+)
+
+// 数据包大小限制
+const (
+	DefaultMaxFrameSize  = 4 << 20 // 单帧payload的最大长度
+	DefaultMaxHeaderSize = 1 << 20 // LogicPkt中Header的最大长度
+	DefaultMaxBodySize   = 2 << 20 // LogicPkt/BasicPkt中Body的最大长度
 )
 
 const (
@@ -72,4 +209,8 @@ const (
 	OfflineSyncIndexCount     = 2000                // 单次同步消息索引的数量
 	OfflineMessageExpiresIn   = 15                  // 离线消息过期时间
 	MessageMaxCountPerPage    = 200                 // 同步消息内容时每页的最大数据
+	MessageHistoryPageSize    = 100                 // 翻取历史消息时每页的最大数量
+	MessageDedupWindow        = time.Hour * 24      // 客户端消息id的去重窗口
+	MaxClientIdLength         = 64                  // 客户端消息id的最大长度
+	MaxExtraLength            = 4096                // 消息extra的最大长度, 媒体消息的extra为元数据的json, 包含客户端的extra
 )
@@ -5,12 +5,20 @@
 package endian
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
 var Default = binary.LittleEndian
 
+// ErrTooLarge 长度前缀超过了允许的最大值
+var ErrTooLarge = errors.New("endian: length exceeds limit")
+
+// allocChunk 长度较大时按块读取, 避免根据不可信的长度前缀一次性分配内存
+const allocChunk = 64 << 10
+
 // ReadUint8 从 reader 中读取一个 uint8
 func ReadUint8(r io.Reader) (uint8, error) {
 	var bytes = make([]byte, 1)
@@ -58,26 +66,46 @@ func ReadString(r io.Reader) (string, error) {
 
 // ReadBytes 从 reader 中读取一个 []byte, reader中前4byte 必须是[]byte 的长度
 func ReadBytes(r io.Reader) ([]byte, error) {
+	return ReadBytesMax(r, 0)
+}
+
+// ReadBytesMax 与ReadBytes相同, 但长度超过max时返回ErrTooLarge, max为0表示不限制
+func ReadBytesMax(r io.Reader, max uint32) ([]byte, error) {
 	bufLen, err := ReadUint32(r)
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, bufLen)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, err
+	if max > 0 && bufLen > max {
+		return nil, ErrTooLarge
 	}
-	return buf, nil
+	return readN(r, int64(bufLen))
 }
 
-//ReadFixedBytes 读取固定长度的字节
+// ReadFixedBytes 读取固定长度的字节
 func ReadFixedBytes(len int, r io.Reader) ([]byte, error) {
-	buf := make([]byte, len)
-	_, err := io.ReadFull(r, buf)
-	if err != nil {
+	if len < 0 {
+		return nil, ErrTooLarge
+	}
+	return readN(r, int64(len))
+}
+
+// readN 从r中读取n个字节, 在真正读到数据之前最多只分配allocChunk大小的内存
+func readN(r io.Reader, n int64) ([]byte, error) {
+	if n <= allocChunk {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, allocChunk))
+	if _, err := io.CopyN(buf, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
 // WriteUint8 写一个 uint8到 writer 中
@@ -157,12 +185,7 @@ func ReadShortBytes(r io.Reader) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, bufLen)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return readN(r, int64(bufLen))
 }
 
 func ReadShortString(r io.Reader) (string, error) {
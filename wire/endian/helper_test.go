@@ -0,0 +1,77 @@
+package endian
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadBytesMax(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_ = WriteBytes(buf, []byte("hello world"))
+
+	// 长度在限制之内
+	val, err := ReadBytesMax(bytes.NewReader(buf.Bytes()), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "hello world" {
+		t.Fatalf("ReadBytesMax() = %s, want hello world", val)
+	}
+	// 长度超过限制
+	_, err = ReadBytesMax(bytes.NewReader(buf.Bytes()), 10)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("ReadBytesMax() error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestReadBytesLyingLength(t *testing.T) {
+	// 长度前缀声明了4G, 但实际只有几个字节的数据
+	buf := new(bytes.Buffer)
+	_ = WriteUint32(buf, 0xffffffff)
+	buf.WriteString("short")
+
+	_, err := ReadBytes(buf)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadBytes() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func FuzzReadBytes(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{5, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 'x'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		val, err := ReadBytesMax(bytes.NewReader(data), 1024)
+		if err != nil {
+			return
+		}
+		if len(val) > 1024 {
+			t.Fatalf("ReadBytesMax() returned %d bytes, limit is 1024", len(val))
+		}
+		// 能够解析的数据重新编码后应与原数据的前缀一致
+		buf := new(bytes.Buffer)
+		_ = WriteBytes(buf, val)
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Fatalf("round trip mismatch: %v", data)
+		}
+	})
+}
+
+func FuzzReadShortString(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{3, 0, 'a', 'b', 'c'})
+	f.Add([]byte{0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		val, err := ReadShortString(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		buf := new(bytes.Buffer)
+		_ = WriteShortBytes(buf, []byte(val))
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Fatalf("round trip mismatch: %v", data)
+		}
+	})
+}
@@ -27,6 +27,9 @@ func (p *BasicPkt) Decode(r io.Reader) error {
 	if p.Length, err = endian.ReadUint16(r); err != nil {
 		return err
 	}
+	if uint32(p.Length) > MaxBodySize() {
+		return endian.ErrTooLarge
+	}
 	if p.Length > 0 {
 		if p.Body, err = endian.ReadFixedBytes(int(p.Length), r); err != nil {
 			return err
@@ -37,6 +40,9 @@ func (p *BasicPkt) Decode(r io.Reader) error {
 
 // Encode 封包
 func (p *BasicPkt) Encode(w io.Writer) error {
+	if int(p.Length) > len(p.Body) {
+		return io.ErrShortBuffer
+	}
 	if err := endian.WriteUint16(w, p.Code); err != nil {
 		return err
 	}
@@ -44,7 +50,8 @@ func (p *BasicPkt) Encode(w io.Writer) error {
 		return err
 	}
 	if p.Length > 0 {
-		if err := endian.WriteBytes(w, p.Body); err != nil {
+		// Length已经写入, 这里直接写入Body, 与Decode中的ReadFixedBytes对应
+		if _, err := w.Write(p.Body[:p.Length]); err != nil {
 			return err
 		}
 	}
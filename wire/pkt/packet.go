@@ -1,15 +1,43 @@
 package pkt
 
 import (
+	"EIM/wire"
 	"EIM/wire/endian"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"google.golang.org/protobuf/proto"
 )
 
+// 解包时允许的最大长度
+var (
+	maxHeaderSize uint32 = wire.DefaultMaxHeaderSize
+	maxBodySize   uint32 = wire.DefaultMaxBodySize
+)
+
+// SetMaxSize 设置解包时Header和Body的最大长度, 传入0则保持原值不变
+func SetMaxSize(header, body uint32) {
+	if header > 0 {
+		atomic.StoreUint32(&maxHeaderSize, header)
+	}
+	if body > 0 {
+		atomic.StoreUint32(&maxBodySize, body)
+	}
+}
+
+// MaxHeaderSize 返回Header的最大长度
+func MaxHeaderSize() uint32 {
+	return atomic.LoadUint32(&maxHeaderSize)
+}
+
+// MaxBodySize 返回Body的最大长度
+func MaxBodySize() uint32 {
+	return atomic.LoadUint32(&maxBodySize)
+}
+
 // LogicPkt 逻辑协议消息包(网关对外的client消息结构)
 type LogicPkt struct {
 	Header
@@ -74,7 +102,7 @@ func NewForm(h *Header) *LogicPkt {
 // Decode 从r中读取若干字节到LogicPkt并解包
 func (p *LogicPkt) Decode(r io.Reader) error {
 	// 读取Header
-	headerBytes, err := endian.ReadBytes(r)
+	headerBytes, err := endian.ReadBytesMax(r, MaxHeaderSize())
 	if err != nil {
 		return err
 	}
@@ -82,7 +110,7 @@ func (p *LogicPkt) Decode(r io.Reader) error {
 		return err
 	}
 	// 读取Body
-	p.Body, err = endian.ReadBytes(r)
+	p.Body, err = endian.ReadBytesMax(r, MaxBodySize())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package pkt
+
+import (
+	"EIM/wire"
+	"EIM/wire/endian"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestLogicPktDecodeTooLarge(t *testing.T) {
+	p := New(wire.CommandChatUserTalk, WithSeq(1), WithDest("test2"))
+	p.Body = make([]byte, 1024)
+	buf := new(bytes.Buffer)
+	_ = p.Encode(buf)
+
+	SetMaxSize(0, 512)
+	defer SetMaxSize(0, wire.DefaultMaxBodySize)
+
+	err := new(LogicPkt).Decode(bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, endian.ErrTooLarge) {
+		t.Fatalf("Decode() error = %v, want %v", err, endian.ErrTooLarge)
+	}
+}
+
+func TestBasicPktEncodeDecode(t *testing.T) {
+	p := &BasicPkt{Code: CodePing, Length: 5, Body: []byte("hello")}
+	buf := new(bytes.Buffer)
+	if err := p.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	var got BasicPkt
+	if err := got.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != p.Code || !bytes.Equal(got.Body, p.Body) {
+		t.Fatalf("Decode() = %+v, want %+v", got, p)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes left after Decode", buf.Len())
+	}
+}
+
+func FuzzLogicPktDecode(f *testing.F) {
+	p := New(wire.CommandChatUserTalk, WithSeq(1), WithDest("test2"))
+	p.AddStringMeta(wire.MetaDestServer, "gate01")
+	p.WriteBody(&MessageReq{Type: 1, Body: "hello"})
+	buf := new(bytes.Buffer)
+	_ = p.Encode(buf)
+	f.Add(buf.Bytes())
+	f.Add([]byte{0xff, 0xff, 0xff, 0x7f})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p LogicPkt
+		if err := p.Decode(bytes.NewReader(data)); err != nil {
+			return
+		}
+		if uint32(len(p.Body)) > MaxBodySize() {
+			t.Fatalf("body length %d exceeds limit", len(p.Body))
+		}
+		// 解析成功的包应能重新封包
+		if err := p.Encode(new(bytes.Buffer)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package pkt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzRead(f *testing.F) {
+	f.Add(Marshal(New("chat.user.talk", WithSeq(1)).WriteBody(&MessageReq{Body: "hello"})))
+	f.Add(Marshal(&BasicPkt{Code: CodePing}))
+	f.Add(Marshal(&BasicPkt{Code: CodePong, Length: 2, Body: []byte("ok")}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		val, err := Read(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		switch p := val.(type) {
+		case *LogicPkt:
+			_ = Marshal(p)
+		case *BasicPkt:
+			if int(p.Length) != len(p.Body) {
+				t.Fatalf("BasicPkt length %d != len(body) %d", p.Length, len(p.Body))
+			}
+			_ = Marshal(p)
+		default:
+			t.Fatalf("unexpected packet type %T", val)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package token
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -32,6 +33,10 @@ func (t *Token) Valid() error {
 func Parse(key, tk string) (*Token, error) {
 	var token = new(Token)
 	_, err := jwt.ParseWithClaims(tk, token, func(token *jwt.Token) (interface{}, error) {
+		// 只接受HMAC签名, 防止alg被篡改
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
 		return []byte(key), nil
 	})
 	if err != nil {
@@ -40,8 +45,8 @@ func Parse(key, tk string) (*Token, error) {
 	return token, nil
 }
 
-// Generate 生成一个jwt token
+// Generate 生成一个jwt token, 使用HS256签名, 与Parse中的[]byte密钥对应
 func Generate(key string, token *Token) (string, error) {
-	jwtTk := jwt.NewWithClaims(jwt.SigningMethodES256, token)
+	jwtTk := jwt.NewWithClaims(jwt.SigningMethodHS256, token)
 	return jwtTk.SignedString([]byte(key))
 }
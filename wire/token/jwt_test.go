@@ -0,0 +1,37 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateAndParse(t *testing.T) {
+	tk, err := Generate(DefaultKey, &Token{
+		Account: "test1",
+		App:     "EIM",
+		Exp:     time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(DefaultKey, tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Account != "test1" || got.App != "EIM" {
+		t.Fatalf("Parse() = %+v", got)
+	}
+	if _, err = Parse("wrong-key", tk); err == nil {
+		t.Fatal("Parse() with a wrong key should fail")
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	tk, _ := Generate(DefaultKey, &Token{
+		Account: "test1",
+		Exp:     time.Now().Add(-time.Hour).Unix(),
+	})
+	if _, err := Parse(DefaultKey, tk); err == nil {
+		t.Fatal("Parse() with an expired token should fail")
+	}
+}